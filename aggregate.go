@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aggregation reduces the raw values of a period to a single value
+type aggregation struct {
+	name string
+	p    float64 // percentile 0..100
+}
+
+// parseAggregation parses avg, min, max, sum, last, diff or percentile(p)
+func parseAggregation(s string) (aggregation, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	switch s {
+	case "avg", "min", "max", "sum", "last", "diff":
+		return aggregation{name: s}, nil
+	}
+
+	if strings.HasPrefix(s, "percentile(") && strings.HasSuffix(s, ")") {
+		p, err := strconv.ParseFloat(s[len("percentile("):len(s)-1], 64)
+		if err == nil && p >= 0 && p <= 100 {
+			return aggregation{name: "percentile", p: p}, nil
+		}
+	}
+
+	return aggregation{}, fmt.Errorf("invalid aggregate: %s", s)
+}
+
+// reduce aggregates the period's values. Diff is the increase since the last
+// value of the previous period, or the period's first value if prev is NaN.
+func (a aggregation) reduce(values []float64, prev float64) float64 {
+	res := values[0]
+	switch a.name {
+	case "avg", "sum":
+		res = 0
+		for _, v := range values {
+			res += v
+		}
+		if a.name == "avg" {
+			res /= float64(len(values))
+		}
+	case "min":
+		for _, v := range values {
+			res = math.Min(res, v)
+		}
+	case "max":
+		for _, v := range values {
+			res = math.Max(res, v)
+		}
+	case "last":
+		res = values[len(values)-1]
+	case "diff":
+		if math.IsNaN(prev) {
+			prev = values[0]
+		}
+		res = values[len(values)-1] - prev
+	case "percentile":
+		res = percentile(values, a.p)
+	}
+	return res
+}
+
+// aggregateTuples reduces tuples per period as returned by period, skipping NaN values
+func aggregateTuples(tuples []Tuple, a aggregation, period func(int64) int64) []Tuple {
+	res := []Tuple{}
+
+	var values []float64
+	var current int64
+	prev, last := math.NaN(), math.NaN()
+
+	flush := func() {
+		if len(values) > 0 {
+			res = append(res, Tuple{Timestamp: current, Value: float32(a.reduce(values, prev))})
+			prev = last
+		}
+		values = values[:0]
+	}
+
+	for _, t := range tuples {
+		v := float64(t.Value)
+		if math.IsNaN(v) {
+			continue
+		}
+
+		if ts := period(t.Timestamp); len(values) == 0 || ts != current {
+			flush()
+			current = ts
+		}
+		values = append(values, v)
+		last = v
+	}
+	flush()
+
+	return res
+}
+
+// aggregationPeriod returns the period of local aggregation given by `group` or
+// `interval`, defaulting to Grafana's interval
+func (server *Server) aggregationPeriod(target Target, qr *QueryRequest) (func(int64) int64, error) {
+	if group, ok := target.Data["group"]; ok {
+		group = strings.ToLower(group)
+		switch group {
+		case "minute", "hour", "day", "week", "month", "year":
+		default:
+			return nil, fmt.Errorf("invalid group: %s", group)
+		}
+
+		loc := server.conf.location(target.Target)
+		return func(ts int64) int64 { return roundTimestampMS(ts, group, loc) }, nil
+	}
+
+	interval := time.Duration(qr.IntervalMs) * time.Millisecond
+	if s, ok := target.Data["interval"]; ok {
+		var err error
+		if interval, err = time.ParseDuration(s); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval: %s", s)
+		}
+	}
+	if interval <= 0 && qr.MaxDataPoints > 0 {
+		interval = qr.Range.To.Sub(qr.Range.From) / time.Duration(qr.MaxDataPoints)
+	}
+
+	ms := interval.Milliseconds()
+	if ms <= 0 {
+		return func(ts int64) int64 { return ts }, nil
+	}
+	return func(ts int64) int64 { return ts - ts%ms }, nil
+}
+
+// invalidAggregate is returned if the aggregate function or period is invalid
+func invalidAggregate(err error) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: err.Error(),
+		Hint:    `e.g. {"aggregate": "percentile(95)", "group": "day"} or {"aggregate": "max", "interval": "15m"}`,
+	}
+}
+
+// queryAggregate fetches raw tuples and reduces them locally with the function
+// given by `aggregate` per group period or interval
+func (server *Server) queryAggregate(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	a, err := parseAggregation(target.Data["aggregate"])
+	if err != nil {
+		return QueryResponse{}, invalidAggregate(err)
+	}
+
+	period, err := server.aggregationPeriod(target, qr)
+	if err != nil {
+		return QueryResponse{}, invalidAggregate(err)
+	}
+
+	tuples, err := server.api.getData(ctx, target.Target, qr.Range.From, qr.Range.To, "", "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	return dataResponse(target.Target, aggregateTuples(tuples, a, period), qr), nil
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxAlertEvents = 1000
+
+// GrafanaAlertNotification is the webhook payload of Grafana alerting. Unified
+// alerting sends alerts, legacy dashboard alerts send state and eval matches.
+type GrafanaAlertNotification struct {
+	Status  string         `json:"status"`
+	Title   string         `json:"title"`
+	Message string         `json:"message"`
+	Alerts  []GrafanaAlert `json:"alerts"`
+
+	RuleID      int64              `json:"ruleId"`
+	RuleName    string             `json:"ruleName"`
+	State       string             `json:"state"`
+	EvalMatches []GrafanaEvalMatch `json:"evalMatches"`
+}
+
+// GrafanaAlert is a single alert of a unified alerting notification
+type GrafanaAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+	ValueString string            `json:"valueString"`
+}
+
+// GrafanaEvalMatch is a series matching a legacy alert rule
+type GrafanaEvalMatch struct {
+	Metric string            `json:"metric"`
+	Value  float64           `json:"value"`
+	Tags   map[string]string `json:"tags"`
+}
+
+// AlertEvent is a received alert of a channel. End is zero while the alert fires.
+type AlertEvent struct {
+	UUID  string `json:"uuid"`
+	Key   string `json:"key"` // alert fingerprint or rule
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end,omitempty"`
+}
+
+// AlertResponse reports the number of alert events stored or resolved
+type AlertResponse struct {
+	Stored int `json:"stored"`
+}
+
+// alertStore keeps received alerts, optionally persisted to file
+type alertStore struct {
+	file string
+
+	mu     sync.Mutex
+	events []*AlertEvent
+}
+
+// newAlertStore loads the alerts persisted to file, if any
+func newAlertStore(file string) (*alertStore, error) {
+	s := &alertStore{file: file}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &s.events); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return s, nil
+}
+
+// save atomically writes the alerts to file, called with mu held
+func (s *alertStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(s.events)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// fire starts an alert of the channel unless it is already firing, called with mu held
+func (s *alertStore) fire(e AlertEvent) bool {
+	for _, active := range s.events {
+		if active.UUID == e.UUID && active.Key == e.Key && active.End == 0 {
+			active.Title, active.Text = e.Title, e.Text
+			return false
+		}
+	}
+
+	s.events = append(s.events, &e)
+	if len(s.events) > maxAlertEvents {
+		s.events = s.events[1:]
+	}
+	return true
+}
+
+// resolve ends the firing alert of the channel, called with mu held
+func (s *alertStore) resolve(uuid, key string, end int64) bool {
+	for _, active := range s.events {
+		if active.UUID == uuid && active.Key == key && active.End == 0 {
+			active.End = end
+			return true
+		}
+	}
+	return false
+}
+
+// eventsBetween returns the channel's alerts overlapping from..to
+func (s *alertStore) eventsBetween(uuid string, from, to int64) []AlertEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := []AlertEvent{}
+	for _, e := range s.events {
+		if (uuid == "" || e.UUID == uuid) && e.Start <= to && (e.End == 0 || e.End >= from) {
+			res = append(res, *e)
+		}
+	}
+
+	return res
+}
+
+// alertChannels returns the channels an alert fired on, given by the uuid or
+// channel label as uuid or alias
+func (server *Server) alertChannels(ctx context.Context, values ...string) []string {
+	var res []string
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if uuid, ok := server.resolveAlias(ctx, v); ok {
+			v = uuid
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+// storeAlerts records the notification's alerts, returning the number of changed events
+func (server *Server) storeAlerts(ctx context.Context, n GrafanaAlertNotification, now time.Time) int {
+	s := server.alerts
+
+	changed := 0
+	record := func(uuid, key, title, text string, firing bool, start, end time.Time) {
+		if start.IsZero() {
+			start = now
+		}
+		if !firing && end.IsZero() {
+			end = now
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if firing && s.fire(AlertEvent{UUID: uuid, Key: key, Title: title, Text: text, Start: unixMS(start)}) {
+			changed++
+		}
+		if !firing && s.resolve(uuid, key, unixMS(end)) {
+			changed++
+		}
+	}
+
+	// unified alerting
+	for _, a := range n.Alerts {
+		title := a.Labels["alertname"]
+		if title == "" {
+			title = n.Title
+		}
+
+		text := a.Annotations["summary"]
+		if text == "" {
+			text = a.Annotations["description"]
+		}
+		if a.ValueString != "" {
+			text = strings.TrimSpace(text + " " + a.ValueString)
+		}
+
+		key := a.Fingerprint
+		if key == "" {
+			key = title
+		}
+
+		for _, uuid := range server.alertChannels(ctx, a.Labels["uuid"], a.Labels["channel"]) {
+			record(uuid, key, title, text, a.Status != "resolved", a.StartsAt, a.EndsAt)
+		}
+	}
+
+	// legacy alerting, ok resolves all channels of the rule
+	if len(n.Alerts) == 0 && n.State != "" {
+		key := fmt.Sprintf("rule-%d", n.RuleID)
+		if n.State == "ok" {
+			s.mu.Lock()
+			for _, e := range s.events {
+				if e.Key == key && e.End == 0 {
+					e.End = unixMS(now)
+					changed++
+				}
+			}
+			s.mu.Unlock()
+		}
+
+		for _, m := range n.EvalMatches {
+			for _, uuid := range server.alertChannels(ctx, m.Tags["uuid"], m.Metric) {
+				text := strings.TrimSpace(fmt.Sprintf("%s %s=%g", n.Message, m.Metric, m.Value))
+				record(uuid, key, n.RuleName, text, n.State == "alerting" || n.State == "no_data", now, time.Time{})
+			}
+		}
+	}
+
+	if changed > 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if err := s.save(); err != nil {
+			log.Printf("saving alerts failed: %v", err)
+		}
+	}
+
+	return changed
+}
+
+// alertsHandler receives Grafana alert webhook notifications
+func (server *Server) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, server.alertsToken) {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusUnauthorized,
+			Code:    "unauthorized",
+			Message: "invalid token",
+		})
+		return
+	}
+
+	n := GrafanaAlertNotification{}
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	stored := server.storeAlerts(r.Context(), n, time.Now())
+	logf(r.Context(), "alert notification %q: %d events", n.Title, stored)
+
+	if err := json.NewEncoder(w).Encode(AlertResponse{Stored: stored}); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
+
+// alertAnnotations returns the received alerts of the channel as region annotations
+func (server *Server) alertAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	res := []AnnotationResponse{}
+	if server.alerts == nil {
+		return res, nil
+	}
+
+	uuid := target.Target
+	if resolved, ok := server.resolveAlias(ctx, uuid); uuid != "" && ok {
+		uuid = resolved
+	}
+
+	now := unixMS(time.Now())
+	for _, e := range server.alerts.eventsBetween(uuid, unixMS(ar.Range.From), unixMS(ar.Range.To)) {
+		end, status := e.End, "resolved"
+		if end == 0 {
+			end, status = now, "firing"
+		}
+
+		res = append(res, AnnotationResponse{
+			Annotation: ar.Annotation,
+			Time:       e.Start,
+			TimeEnd:    end,
+			IsRegion:   true,
+			Title:      e.Title,
+			Tags:       "alert," + status,
+			Text:       e.Text,
+		})
+	}
+
+	return res, nil
+}
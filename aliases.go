@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// aliasRetry is the minimum delay between refreshes triggered by unknown targets
+const aliasRetry = 30 * time.Second
+
+// aliasMap maps channel names to uuids. Names are the channel titles as listed by
+// /search and the static aliases of the config, matched case-insensitively.
+type aliasMap struct {
+	mu        sync.RWMutex
+	static    map[string]string
+	names     map[string]AliasInfo // by lower case name
+	refreshed time.Time
+}
+
+// AliasInfo describes an alias in GET /aliases
+type AliasInfo struct {
+	Alias string `json:"alias"`
+	UUID  string `json:"uuid"`
+}
+
+func newAliasMap(static map[string]string) *aliasMap {
+	m := &aliasMap{static: static}
+	m.update(nil)
+	return m
+}
+
+// update rebuilds the map from the flattened entities. Titles shared by several
+// channels are ambiguous and skipped, static aliases take precedence.
+func (m *aliasMap) update(entities []Entity) {
+	names := make(map[string]AliasInfo)
+	ambiguous := make(map[string]bool)
+
+	for _, e := range entities {
+		name := strings.ToLower(e.Title)
+		if a, ok := names[name]; ok && a.UUID != e.UUID {
+			ambiguous[name] = true
+		}
+		names[name] = AliasInfo{Alias: e.Title, UUID: e.UUID}
+	}
+
+	for name := range ambiguous {
+		log.Printf("alias %q is ambiguous, use the uuid or a static alias", names[name].Alias)
+		delete(names, name)
+	}
+
+	for alias, uuid := range m.static {
+		names[strings.ToLower(alias)] = AliasInfo{Alias: alias, UUID: uuid}
+	}
+
+	m.mu.Lock()
+	m.names = names
+	m.refreshed = time.Now()
+	m.mu.Unlock()
+}
+
+// lookup returns the uuid of name
+func (m *aliasMap) lookup(name string) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.names[strings.ToLower(name)]
+	return a.UUID, ok
+}
+
+// stale checks if an unknown name may trigger a refresh
+func (m *aliasMap) stale() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return time.Since(m.refreshed) > aliasRetry
+}
+
+// list returns the aliases sorted by name
+func (m *aliasMap) list() []AliasInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]AliasInfo, 0, len(m.names))
+	for _, a := range m.names {
+		res = append(res, a)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Alias < res[j].Alias })
+	return res
+}
+
+// resolveAlias returns the uuid of target, refreshing the aliases once if the
+// target is neither a known alias nor channel
+func (server *Server) resolveAlias(ctx context.Context, target string) (string, bool) {
+	if uuid, ok := server.aliases.lookup(target); ok {
+		return uuid, true
+	}
+
+	if _, known := server.cachedEntity(target); known || !server.aliases.stale() {
+		return "", false
+	}
+
+	server.refreshEntities(ctx)
+	return server.aliases.lookup(target)
+}
+
+// resolveAliases replaces aliased targets by their uuid, keeping the alias as name
+func (server *Server) resolveAliases(ctx context.Context, qr QueryRequest) QueryRequest {
+	targets := make([]Target, len(qr.Targets))
+	for i, target := range qr.Targets {
+		targets[i] = server.resolveTarget(ctx, target)
+	}
+
+	qr.Targets = targets
+	return qr
+}
+
+// resolveTarget replaces an aliased target by its uuid, keeping the alias as name
+func (server *Server) resolveTarget(ctx context.Context, target Target) Target {
+	_, virtual := server.conf.Virtual[target.Target]
+	if _, multisite := server.conf.Multisite[target.Target]; multisite || virtual || target.Target == "*" || strings.HasPrefix(target.Target, savedPrefix) {
+		return target
+	}
+
+	if uuid, ok := server.resolveAlias(ctx, target.Target); ok && uuid != target.Target {
+		data := TargetData{"name": target.Target}
+		for k, v := range target.Data {
+			data[k] = v
+		}
+		target.Target, target.Data = uuid, data
+	}
+	return target
+}
+
+// aliasesHandler lists the aliases at GET /aliases, POST refreshes them first
+func (server *Server) aliasesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		server.refreshEntities(r.Context())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(server.aliases.list()); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// alignSeries maps all series onto the union of their timestamps.
+// Tuples describe the interval ending at their timestamp, so each series contributes
+// the value of the interval containing the timestamp or NaN outside of its range.
+func alignSeries(series [][]Tuple) ([]int64, [][]float64) {
+	set := make(map[int64]bool)
+	for _, s := range series {
+		for _, t := range s {
+			set[t.Timestamp] = true
+		}
+	}
+
+	ts := make([]int64, 0, len(set))
+	for t := range set {
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+
+	values := make([][]float64, len(series))
+	for i, s := range series {
+		values[i] = make([]float64, len(ts))
+
+		j := 0
+		for k, t := range ts {
+			for j < len(s) && s[j].Timestamp < t {
+				j++
+			}
+
+			if j == len(s) || j == 0 && s[0].Timestamp != t {
+				values[i][k] = math.NaN()
+			} else {
+				values[i][k] = float64(s[j].Value)
+			}
+		}
+	}
+
+	return ts, values
+}
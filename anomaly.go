@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAnomalyThreshold = 3
+	defaultAnomalyGroup     = "hour"
+)
+
+// Anomaly is a value deviating significantly from expectations
+type Anomaly struct {
+	UUID      string  `json:"uuid"`
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+	Score     float64 `json:"score"`
+}
+
+// zscores returns the standard score of each value
+func zscores(values []float64) []float64 {
+	var sum, sq float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	sd := math.Sqrt(sq / float64(len(values)))
+
+	res := make([]float64, len(values))
+	for i, v := range values {
+		if sd > 0 {
+			res[i] = (v - mean) / sd
+		}
+	}
+
+	return res
+}
+
+// detectAnomalies finds values whose score exceeds threshold. With method seasonal
+// the deviation against the same time one week earlier is scored instead of the
+// values themselves.
+func (server *Server) detectAnomalies(ctx context.Context, target Target, from time.Time, to time.Time) ([]Anomaly, error) {
+	data := TargetData{"group": defaultAnomalyGroup}
+	for k, v := range target.Data {
+		data[k] = v
+	}
+
+	qr := &QueryRequest{Range: Range{From: from, To: to}}
+	tuples, err := server.getTuples(ctx, target.Target, data, qr)
+	if err != nil {
+		return nil, err
+	}
+
+	values := tupleValues(tuples)
+	if len(values) != len(tuples) || len(values) == 0 {
+		return []Anomaly{}, nil
+	}
+
+	scored := values
+	if strings.ToLower(data["method"]) == "seasonal" {
+		const week = 7 * 24 * time.Hour
+		qr.Range = Range{From: from.Add(-week), To: to.Add(-week)}
+
+		tuples, err := server.getTuples(ctx, target.Target, data, qr)
+		if err != nil {
+			return nil, err
+		}
+
+		previous := make(map[int64]float64)
+		for _, tuple := range tuples {
+			previous[tuple.Timestamp+int64(week/time.Millisecond)] = float64(tuple.Value)
+		}
+
+		scored = make([]float64, len(values))
+		for i, tuple := range tuples {
+			if prev, ok := previous[tuple.Timestamp]; ok {
+				scored[i] = values[i] - prev
+			}
+		}
+	}
+
+	threshold := data.float("threshold", defaultAnomalyThreshold)
+
+	res := []Anomaly{}
+	for i, z := range zscores(scored) {
+		if math.Abs(z) > threshold {
+			res = append(res, Anomaly{
+				UUID:      target.Target,
+				Timestamp: tuples[i].Timestamp,
+				Value:     values[i],
+				Score:     z,
+			})
+		}
+	}
+
+	return res, nil
+}
+
+// notify posts anomalies newer than those notified before to the webhook
+func (server *Server) notify(anomalies []Anomaly) {
+	if server.webhook == "" {
+		return
+	}
+
+	server.mu.Lock()
+	pending := []Anomaly{}
+	last := make(map[string]int64)
+	for _, a := range anomalies {
+		if a.Timestamp > server.notified[a.UUID] {
+			pending = append(pending, a)
+			if a.Timestamp > last[a.UUID] {
+				last[a.UUID] = a.Timestamp
+			}
+		}
+	}
+	for uuid, ts := range last {
+		server.notified[uuid] = ts
+	}
+	server.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(pending)
+	if err != nil {
+		log.Printf("json encode failed: %v", err)
+		return
+	}
+
+	resp, err := webhookClient.Post(server.webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// anomalyAnnotations returns detected anomalies as annotations
+func (server *Server) anomalyAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	anomalies, err := server.detectAnomalies(ctx, target, ar.Range.From, ar.Range.To)
+	if err != nil {
+		return nil, err
+	}
+
+	go server.notify(anomalies)
+
+	res := []AnnotationResponse{}
+	for _, a := range anomalies {
+		res = append(res, AnnotationResponse{
+			Annotation: ar.Annotation,
+			Time:       a.Timestamp,
+			Title:      "Anomaly",
+			Text:       fmt.Sprintf("%.2f (score %.1f)", a.Value, a.Score),
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNotify(t *testing.T) {
+	var posted [][]int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var anomalies []Anomaly
+		if err := json.NewDecoder(r.Body).Decode(&anomalies); err != nil {
+			t.Error(err)
+		}
+		var timestamps []int64
+		for _, a := range anomalies {
+			timestamps = append(timestamps, a.Timestamp)
+		}
+		posted = append(posted, timestamps)
+	}))
+	defer ts.Close()
+
+	server := &Server{webhook: ts.URL, notified: make(map[string]int64)}
+
+	// detection runs over overlapping windows
+	runs := [][]Anomaly{
+		{{UUID: "a", Timestamp: 2000}, {UUID: "a", Timestamp: 1000}},
+		{{UUID: "a", Timestamp: 2000}, {UUID: "a", Timestamp: 3000}, {UUID: "b", Timestamp: 1000}},
+		{{UUID: "a", Timestamp: 3000}, {UUID: "b", Timestamp: 1000}},
+	}
+	for _, anomalies := range runs {
+		server.notify(anomalies)
+	}
+
+	expected := [][]int64{{2000, 1000}, {3000, 1000}}
+	if !reflect.DeepEqual(posted, expected) {
+		t.Errorf("expected %v, got %v", expected, posted)
+	}
+	if len(server.notified) != 2 {
+		t.Errorf("expected 2 notified channels, got %d", len(server.notified))
+	}
+}
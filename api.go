@@ -2,46 +2,172 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"strings"
 	"time"
 )
 
+// maxChunkDepth limits how often a too large query is split in halves
+const maxChunkDepth = 8
+
+var errResponseTooLarge = errors.New("response too large")
+
+var (
+	// ErrMiddlewareUnavailable is returned if the middleware cannot be reached
+	ErrMiddlewareUnavailable = errors.New("middleware unavailable")
+
+	// ErrBadResponse is returned if the middleware response cannot be read or decoded
+	ErrBadResponse = errors.New("bad middleware response")
+)
+
+// apiError tags err with one of the kinds above, keeping the cause for errors.As
+type apiError struct {
+	kind error
+	err  error
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%v: %v", e.kind, e.err)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.err
+}
+
+func (e *apiError) Is(target error) bool {
+	return target == e.kind
+}
+
+// badResponse logs and tags a decode error of a middleware response
+func badResponse(ctx context.Context, err error) error {
+	logf(ctx, "json decode failed: %v", err)
+	return &apiError{ErrBadResponse, err}
+}
+
+// StatusError is returned if the middleware responds with an error status
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("middleware responded %s: %s", e.Status, middlewareMessage(e.Body))
+}
+
 type Api struct {
-	url    string
-	client http.Client
-	debug  bool
+	url     string
+	client  http.Client
+	maxBody int64
+	debug   bool
+
+	// raw data older than retention is only available aggregated by retentionGroup
+	retention      time.Duration
+	retentionGroup string
+
+	// hedge is the delay after which a duplicate request is sent
+	hedge time.Duration
+
+	// limiter bounds simultaneous requests across all queries
+	limiter limiter
+
+	// version is the middleware version reported during endpoint detection
+	version string
+
+	// fallbacks are the working groups of channels rejecting the requested group
+	fallbacks groupFallbacks
+
+	// overlay corrects entity metadata per uuid
+	overlay map[string]MetadataConfig
+
+	// tokens are the access tokens of channels that are not public
+	tokens map[string]string
+
+	// tls configures middleware connections, nil for the defaults
+	tls *tls.Config
+
+	// cache holds recent entity and data responses, nil if disabled
+	cache *responseCache
+
+	// retry retries transient request failures
+	retry retryPolicy
+
+	// compact requests delta encoded tuples if the server supports them
+	compact bool
+
+	// chunks learns per channel how large a time range a data request may cover, nil if disabled
+	chunks *chunkTuner
+
+	// standby is a read-only middleware serving reads while the primary is down
+	standby     *Api
+	primaryDown int64 // unix ns until which reads go to the standby
+
+	// skew is the measured clock offset of the middleware, nil if disabled
+	skew *clockSkew
+
+	// timeouts limit single requests by endpoint
+	timeouts requestTimeouts
 }
 
-func newAPI(url string, timeout *time.Duration, debug bool) *Api {
-	return &Api{
-		url: detectApiEndpoint(url),
+func newAPI(url string, timeout *time.Duration, transport http.RoundTripper, maxBody int64, debug bool) *Api {
+	api := &Api{
 		client: http.Client{
-			Timeout: *timeout,
+			Transport: transport,
 		},
-		debug: debug,
+		maxBody:  maxBody,
+		debug:    debug,
+		timeouts: requestTimeouts{def: *timeout},
 	}
+
+	api.url = api.detectApiEndpoint(url)
+
+	return api
 }
 
-func detectApiEndpoint(url string) string {
-	const probe = "/entity.json"
+// probe checks if url responds as middleware and records its version
+func (api *Api) probe(url string) bool {
+	ctx, cancel, _ := api.requestContext(context.Background(), url+"/entity.json")
+	defer cancel()
 
+	req, err := http.NewRequestWithContext(ctx, "GET", url+"/entity.json", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close() // close body after checking for error
+
+	if resp.StatusCode != 200 {
+		return false
+	}
+
+	er := EntityResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&er); err == nil && er.Version != "" {
+		api.version = er.Version
+		log.Printf("API version %s", er.Version)
+	}
+
+	return true
+}
+
+func (api *Api) detectApiEndpoint(url string) string {
 	url = strings.TrimRight(url, "/")
 	log.Println("Validating API endpoint")
 
-	resp, err := http.Get(url + probe)
-	if err == nil {
-		resp.Body.Close() // close body after checking for error
-
-		if resp.StatusCode == 200 {
-			log.Println("API endpoint validated")
-			return url
-		}
+	if api.probe(url) {
+		log.Println("API endpoint validated")
+		return url
 	}
 
 	if strings.HasSuffix(url, "/middleware.php") {
@@ -53,72 +179,332 @@ func detectApiEndpoint(url string) string {
 	detectedURL := url + "/middleware.php"
 	log.Println("API endpoint not responding. Trying " + detectedURL)
 
-	resp, err = http.Get(detectedURL + probe)
-	if err == nil {
-		resp.Body.Close() // close body after checking for error
-
-		if resp.StatusCode == 200 {
-			log.Println("API endpoint detected, using " + detectedURL)
-			return detectedURL
-		}
+	if api.probe(detectedURL) {
+		log.Println("API endpoint detected, using " + detectedURL)
+		return detectedURL
 	}
 
 	log.Println("API endpoint still not responding. Will keep retrying using configured uri")
 	return url
 }
 
-func (api *Api) validate() {
-	resp, err := http.Get(api.url)
-	log.Println(err)
-	log.Fatal(resp)
+// validate checks that the middleware responds
+func (api *Api) validate(ctx context.Context) error {
+	ctx, cancel, _ := api.requestContext(ctx, api.url)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", api.url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid middleware url: %w", err)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return &apiError{ErrMiddlewareUnavailable, err}
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return &StatusError{resp.StatusCode, resp.Status, ""}
+	}
+	return nil
 }
 
-func (api *Api) get(endpoint string) (io.Reader, error) {
+func (api *Api) get(ctx context.Context, endpoint string) (io.Reader, error) {
 	url := api.url + endpoint
 
+	var body []byte
+	var err error
+	if api.onStandby() {
+		body, err = api.getStandby(ctx, endpoint, ErrMiddlewareUnavailable)
+	} else {
+		body, err = api.retry.do(ctx, func() ([]byte, error) {
+			if api.hedge > 0 {
+				return api.hedged(ctx, url)
+			}
+			return api.fetch(ctx, url)
+		})
+		if err != nil && api.standby != nil && transient(err) {
+			body, err = api.getStandby(ctx, endpoint, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if api.debug {
+		logf(ctx, "%v", string(body))
+	}
+
+	return bytes.NewReader(body), nil
+}
+
+// getCached is get using the response cache if enabled. It reports if the response
+// was fetched from the middleware instead of served from the cache.
+func (api *Api) getCached(ctx context.Context, endpoint string) (io.Reader, bool, error) {
+	// standby responses are not cached to serve recent data as soon as the primary is back
+	if api.cache == nil || api.onStandby() || cacheBypassed(ctx) {
+		r, err := api.get(ctx, endpoint)
+		return r, true, err
+	}
+
+	start, fetched := time.Now(), false
+	body, err := api.cache.get(ctx, endpoint, func() ([]byte, error) {
+		fetched = true
+		sctx, standby := withStandbyMarker(ctx)
+		r, err := api.get(sctx, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(r)
+		if err == nil && standby.served() {
+			// the primary failed while fetching, keep the response out of both cache levels
+			markStandby(ctx)
+			return nil, &uncached{body}
+		}
+		return body, err
+	})
+	if err != nil {
+		return nil, fetched, err
+	}
+	if !fetched {
+		traceRequest(ctx, TracedRequest{Method: "GET", URL: api.url + endpoint, Cached: true}, start)
+	}
+
+	return bytes.NewReader(body), fetched, nil
+}
+
+// hedged sends a duplicate request if the first one did not answer within the hedge
+// delay and returns whichever succeeds first, cancelling the other one
+func (api *Api) hedged(ctx context.Context, url string) ([]byte, error) {
+	type result struct {
+		body []byte
+		err  error
+	}
+
+	results := make(chan result, 2)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	launch := func() {
+		go func() {
+			body, err := api.fetch(ctx, url)
+			results <- result{body, err}
+		}()
+	}
+	launch()
+
+	timer := time.NewTimer(api.hedge)
+	defer timer.Stop()
+
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			logf(ctx, "GET %s hedging after %v", url, api.hedge)
+			launch()
+			pending++
+		case r := <-results:
+			pending--
+			if r.err == nil || pending == 0 {
+				return r.body, r.err
+			}
+		}
+	}
+}
+
+func (api *Api) fetch(ctx context.Context, url string) ([]byte, error) {
+	release, err := api.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rctx, cancel, timeout := api.requestContext(ctx, url)
+	defer cancel()
+
 	start := time.Now()
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(rctx, "GET", url, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("invalid middleware url: %w", err)
+	}
+	if api.compact && strings.Contains(url, "/data/") {
+		req.Header.Add("Accept", deltaContentType+", application/json;q=0.9")
+	} else {
+		req.Header.Add("Accept", "application/json")
+	}
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	setChannelToken(ctx, req)
+
+	trace := func(status int, err error) {
+		tr := TracedRequest{Method: "GET", URL: url, Status: status}
+		if err != nil {
+			tr.Error = err.Error()
+		}
+		traceRequest(ctx, tr, start)
 	}
-	req.Header.Add("Accept", "application/json")
 
 	resp, err := api.client.Do(req)
 	if err != nil {
-		log.Print(err)
-		return nil, err
+		err = requestError(ctx, rctx, url, timeout, err)
+		if ctx.Err() != context.Canceled {
+			logf(ctx, "%v", err)
+		}
+		gravoMetrics.observeUpstream("GET", url, time.Since(start), err)
+		trace(0, err)
+		return nil, &apiError{ErrMiddlewareUnavailable, err}
 	}
 	defer resp.Body.Close() // close body after checking for error
 
 	duration := time.Now().Sub(start)
-	log.Printf("GET %s (%dms)", url, duration.Nanoseconds()/1e6)
+	logf(ctx, "GET %s (%dms)", url, duration.Nanoseconds()/1e6)
+	api.skew.observe(api.url, resp.Header, start, start.Add(duration))
+
+	if resp.StatusCode >= 400 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		err := &StatusError{resp.StatusCode, resp.Status, strings.TrimSpace(string(b))}
+		gravoMetrics.observeUpstream("GET", url, duration, err)
+		trace(resp.StatusCode, err)
+		return nil, err
+	}
+	gravoMetrics.observeUpstream("GET", url, duration, nil)
+	defer trace(resp.StatusCode, nil)
+
+	// read body, guarding against oversized responses
+	var reader io.Reader = resp.Body
+	if api.maxBody > 0 {
+		reader = io.LimitReader(resp.Body, api.maxBody+1)
+	}
 
-	// read body
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(reader)
 	if err != nil {
-		log.Print(err)
+		logf(ctx, "%v", err)
+		if terr := requestError(ctx, rctx, url, timeout, err); terr != err {
+			return nil, &apiError{ErrMiddlewareUnavailable, terr}
+		}
+		return nil, &apiError{ErrBadResponse, err}
 	}
 
+	if api.maxBody > 0 && int64(len(body)) > api.maxBody {
+		logf(ctx, "GET %s exceeded %d bytes", url, api.maxBody)
+		return nil, errResponseTooLarge
+	}
+
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), deltaContentType) {
+		size := len(body)
+		if body, err = deltaJSON(body); err != nil {
+			logf(ctx, "%v", err)
+			return nil, &apiError{ErrBadResponse, err}
+		}
+		if api.debug {
+			logf(ctx, "GET %s delta encoded %d bytes, %d bytes as json", url, size, len(body))
+		}
+	}
+
+	return body, nil
+}
+
+func (api *Api) post(ctx context.Context, endpoint string, v interface{}) error {
+	url := api.url + endpoint
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	release, err := api.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	rctx, cancel, timeout := api.requestContext(ctx, url)
+	defer cancel()
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(rctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Content-Type", "application/json")
+	if id := requestID(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	setChannelToken(ctx, req)
+
+	trace := func(status int, err error) {
+		tr := TracedRequest{Method: "POST", URL: url, Status: status}
+		if err != nil {
+			tr.Error = err.Error()
+		}
+		traceRequest(ctx, tr, start)
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		err = requestError(ctx, rctx, url, timeout, err)
+		logf(ctx, "%v", err)
+		gravoMetrics.observeUpstream("POST", url, time.Since(start), err)
+		trace(0, err)
+		return &apiError{ErrMiddlewareUnavailable, err}
+	}
+	defer resp.Body.Close() // close body after checking for error
+
+	duration := time.Now().Sub(start)
+	logf(ctx, "POST %s (%dms)", url, duration.Nanoseconds()/1e6)
+
+	body, _ := ioutil.ReadAll(resp.Body)
 	if api.debug {
-		log.Print(string(body))
+		logf(ctx, "%v", string(body))
 	}
 
-	return bytes.NewReader(body), nil
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := &StatusError{resp.StatusCode, resp.Status, strings.TrimSpace(string(body))}
+		gravoMetrics.observeUpstream("POST", url, duration, err)
+		trace(resp.StatusCode, err)
+		return err
+	}
+	gravoMetrics.observeUpstream("POST", url, duration, nil)
+	trace(resp.StatusCode, nil)
+
+	return nil
+}
+
+func (api *Api) getEntities(ctx context.Context) ([]Entity, error) {
+	r, _, err := api.getCached(ctx, "/entity.json")
+	if err != nil {
+		return nil, err
+	}
+
+	er := EntityResponse{}
+	if err := json.NewDecoder(r).Decode(&er); err != nil {
+		return nil, badResponse(ctx, err)
+	}
+
+	api.overlayEntities(er.Entities)
+	return er.Entities, nil
 }
 
-func (api *Api) getEntities() []Entity {
-	r, err := api.get("/entity.json")
+// groups lists the middleware aggregation levels from finest to coarsest
+var groups = []string{"", "minute", "hour", "day", "week", "month", "year"}
+
+func (api *Api) getEntity(ctx context.Context, uuid string) (Entity, error) {
+	ctx = api.withChannelToken(ctx, uuid)
+	r, err := api.get(ctx, fmt.Sprintf("/entity/%s.json", uuid))
 	if err != nil {
-		return []Entity{}
+		return Entity{}, err
 	}
 
 	er := EntityResponse{}
 	if err := json.NewDecoder(r).Decode(&er); err != nil {
-		log.Printf("json decode failed: %v", err)
-		return []Entity{}
+		return Entity{}, badResponse(ctx, err)
 	}
 
-	return er.Entities
+	api.overlayEntity(&er.Entity)
+	return er.Entity, nil
 }
 
 func getGroup(d int64) string {
@@ -138,18 +524,131 @@ func getGroup(d int64) string {
 	return ""
 }
 
-func (api *Api) getData(uuid string, from time.Time, to time.Time, group string, options string, tuples int) []Tuple {
-	f := from.Unix()
-	t := to.Unix()
+// coarserGroup returns the next coarser aggregation level
+func coarserGroup(group string) (string, bool) {
+	for i, g := range groups[:len(groups)-1] {
+		if g == group {
+			return groups[i+1], true
+		}
+	}
+	return group, false
+}
+
+func (api *Api) getData(ctx context.Context, uuid string, from time.Time, to time.Time, group string, options string, tuples int) ([]Tuple, error) {
+	// group is chosen automatically if not requested
+	auto := group == "" && tuples > 0
+	if auto {
+		period := (to.Unix() - from.Unix()) / int64(tuples)
+		group = getGroup(period)
+	}
+
+	// split raw queries at retention boundary
+	if group == "" && api.retention > 0 {
+		boundary := time.Now().Add(-api.retention)
+
+		if !to.After(boundary) {
+			return api.getData(ctx, uuid, from, to, api.retentionGroup, options, 0)
+		}
+
+		if from.Before(boundary) {
+			logf(ctx, "splitting query at retention boundary %s", boundary.Format(time.RFC3339))
+			res, err := api.getData(ctx, uuid, from, boundary, api.retentionGroup, options, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			// fetch the recent part directly, a new boundary would split it again
+			recent, err := api.fetchTuned(ctx, uuid, boundary, to, "", options, 0)
+			if err == errResponseTooLarge {
+				recent, err = api.fetchChunked(ctx, uuid, boundary, to, "", options, 0, 1)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			// skip overlap at boundary
+			for len(res) > 0 && len(recent) > 0 && recent[0].Timestamp <= res[len(res)-1].Timestamp {
+				recent = recent[1:]
+			}
+
+			return append(res, recent...), nil
+		}
+	}
+
+	if fallback, ok := api.fallbacks.get(uuid, group); ok {
+		group = fallback
+	}
+
+	res, err := api.fetchTuned(ctx, uuid, from, to, group, options, tuples)
+
+	if group != "" && groupRejected(err) {
+		res, err = api.fetchFallback(ctx, uuid, from, to, group, options, tuples)
+	}
+
+	// automatically chosen groups can be coarsened, explicit groups are fetched in chunks
+	for auto && err == errResponseTooLarge {
+		var ok bool
+		if group, ok = coarserGroup(group); !ok {
+			break
+		}
+		logf(ctx, "retrying with group %s", group)
+		res, err = api.fetchData(ctx, uuid, from, to, group, options, tuples)
+	}
+
+	if err == errResponseTooLarge {
+		res, err = api.fetchChunked(ctx, uuid, from, to, group, options, tuples, 1)
+	}
+
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			logf(ctx, "query budget exceeded for %s", uuid)
+		}
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// fetchChunked retrieves data by recursively splitting the time range in halves
+func (api *Api) fetchChunked(ctx context.Context, uuid string, from time.Time, to time.Time, group string, options string, tuples int, depth int) ([]Tuple, error) {
+	if depth > maxChunkDepth {
+		return nil, errResponseTooLarge
+	}
+
+	mid := from.Add(to.Sub(from) / 2)
+	logf(ctx, "retrying in chunks %s-%s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	res := []Tuple{}
+	for _, r := range [][2]time.Time{{from, mid}, {mid, to}} {
+		chunk, err := api.fetchData(ctx, uuid, r[0], r[1], group, options, tuples/2)
+		if err == errResponseTooLarge {
+			chunk, err = api.fetchChunked(ctx, uuid, r[0], r[1], group, options, tuples/2, depth+1)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// avoid duplicate tuple at chunk boundary
+		if len(res) > 0 && len(chunk) > 0 && chunk[0].Timestamp <= res[len(res)-1].Timestamp {
+			chunk = chunk[1:]
+		}
+		res = append(res, chunk...)
+	}
+
+	return res, nil
+}
+
+func (api *Api) fetchData(ctx context.Context, uuid string, from time.Time, to time.Time, group string, options string, tuples int) ([]Tuple, error) {
+	ctx = api.withChannelToken(ctx, uuid)
+
+	// request the window in middleware time, tuples are shifted back below
+	skew := api.skew.correction()
+	f := from.Add(skew).Unix()
+	t := to.Add(skew).Unix()
 	url := fmt.Sprintf("/data/%s.json?from=%d&to=%d", uuid, f*1000, t*1000)
 
 	if tuples > 0 {
 		url += fmt.Sprintf("&tuples=%d", tuples)
-
-		if group == "" {
-			period := (t - f) / int64(tuples)
-			group = getGroup(period)
-		}
 	}
 
 	if group != "" {
@@ -160,33 +659,88 @@ func (api *Api) getData(uuid string, from time.Time, to time.Time, group string,
 		url += "&options=" + options
 	}
 
-	r, err := api.get(url)
+	start := time.Now()
+	key := chunkKey(uuid, group)
+
+	r, fetched, err := api.getCached(ctx, url)
+	if err == errResponseTooLarge {
+		api.chunks.tooLarge(ctx, key, to.Sub(from))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// only middleware latency tunes the chunk spans, not cache hits
+	if br, ok := r.(*bytes.Reader); ok && fetched {
+		api.chunks.observe(ctx, key, to.Sub(from), time.Since(start), br.Size())
+	}
+
+	dr := DataResponse{}
+	if err := json.NewDecoder(r).Decode(&dr); err != nil {
+		return nil, badResponse(ctx, err)
+	}
+
+	if skew != 0 {
+		for i := range dr.Data.Tuples {
+			dr.Data.Tuples[i].Timestamp -= skew.Milliseconds()
+		}
+	}
+
+	return dr.Data.Tuples, nil
+}
+
+// postData writes tuples to the channel. NaN or infinite values are rejected.
+func (api *Api) postData(ctx context.Context, uuid string, tuples []Tuple) error {
+	if uuid == "" {
+		return errors.New("missing uuid")
+	}
+	if len(tuples) == 0 {
+		return nil
+	}
+
+	data := make([][]interface{}, len(tuples))
+	for i, tuple := range tuples {
+		if math.IsNaN(float64(tuple.Value)) || math.IsInf(float64(tuple.Value), 0) {
+			return fmt.Errorf("tuple %d: invalid value: %v", i+1, tuple.Value)
+		}
+		data[i] = []interface{}{tuple.Timestamp, tuple.Value}
+	}
+
+	return api.post(api.withChannelToken(ctx, uuid), fmt.Sprintf("/data/%s.json", uuid), data)
+}
+
+// getConsumption returns the consumption in Wh as calculated by the middleware
+func (api *Api) getConsumption(ctx context.Context, uuid string, from time.Time, to time.Time) (float64, error) {
+	ctx = api.withChannelToken(ctx, uuid)
+	skew := api.skew.correction()
+	url := fmt.Sprintf("/data/%s.json?from=%d&to=%d&tuples=1", uuid, from.Add(skew).Unix()*1000, to.Add(skew).Unix()*1000)
+
+	r, err := api.get(ctx, url)
 	if err != nil {
-		return []Tuple{}
+		return 0, err
 	}
 
 	dr := DataResponse{}
 	if err := json.NewDecoder(r).Decode(&dr); err != nil {
-		log.Printf("json decode failed: %v", err)
-		return []Tuple{}
+		return 0, badResponse(ctx, err)
 	}
 
-	return dr.Data.Tuples
+	return dr.Data.Consumption, nil
 }
 
-func (api *Api) getPrognosis(uuid string, period string) PrognosisStruct {
+func (api *Api) getPrognosis(ctx context.Context, uuid string, period string) (PrognosisStruct, error) {
+	ctx = api.withChannelToken(ctx, uuid)
 	url := fmt.Sprintf("/prognosis/%s.json?period=%s", uuid, period)
 
-	r, err := api.get(url)
+	r, err := api.get(ctx, url)
 	if err != nil {
-		return PrognosisStruct{}
+		return PrognosisStruct{}, err
 	}
 
 	pr := PrognosisResponse{}
 	if err := json.NewDecoder(r).Decode(&pr); err != nil {
-		log.Printf("json decode failed: %v", err)
-		return PrognosisStruct{}
+		return PrognosisStruct{}, badResponse(ctx, err)
 	}
 
-	return pr.Prognosis
+	return pr.Prognosis, nil
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetentionSplit(t *testing.T) {
+	var mu sync.Mutex
+	var groups []string
+
+	// grouped data ends with the value 2 at to, raw data starts with it at from
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/data/") {
+			fmt.Fprint(w, `{"version":"0.3"}`)
+			return
+		}
+
+		q := r.URL.Query()
+		from, _ := strconv.ParseInt(q.Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(q.Get("to"), 10, 64)
+		group := q.Get("group")
+
+		mu.Lock()
+		groups = append(groups, group)
+		mu.Unlock()
+
+		tuples := fmt.Sprintf("[%d,1,1],[%d,2,1]", from, to)
+		if group == "" {
+			tuples = fmt.Sprintf("[%d,2,1],[%d,3,1]", from, from+1000)
+		}
+		fmt.Fprintf(w, `{"version":"0.3","data":{"tuples":[%s]}}`, tuples)
+	}))
+	defer ts.Close()
+
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		groups   []string
+		values   []float32
+	}{
+		{"recent", now.Add(-time.Hour), now, []string{""}, []float32{2, 3}},
+		{"expired", now.Add(-72 * time.Hour), now.Add(-48 * time.Hour), []string{"hour"}, []float32{1, 2}},
+		// the overlapping tuple at the boundary is returned once
+		{"split", now.Add(-48 * time.Hour), now, []string{"hour", ""}, []float32{1, 2, 3}},
+	}
+
+	for _, tc := range tests {
+		groups = nil
+
+		timeout := time.Second
+		api := newAPI(ts.URL, &timeout, http.DefaultTransport, 0, false)
+		api.retention = 24 * time.Hour
+		api.retentionGroup = "hour"
+
+		res, err := api.getData(context.Background(), "uuid", tc.from, tc.to, "", "", 0)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+
+		if fmt.Sprint(groups) != fmt.Sprint(tc.groups) {
+			t.Errorf("%s: expected groups %q, got %q", tc.name, tc.groups, groups)
+		}
+
+		values := make([]float32, 0, len(res))
+		for i, tuple := range res {
+			if i > 0 && tuple.Timestamp <= res[i-1].Timestamp {
+				t.Errorf("%s: unordered tuples %v", tc.name, res)
+			}
+			values = append(values, tuple.Value)
+		}
+		if fmt.Sprint(values) != fmt.Sprint(tc.values) {
+			t.Errorf("%s: expected values %v, got %v", tc.name, tc.values, values)
+		}
+	}
+}
+
+func TestPostRequestID(t *testing.T) {
+	var ids []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `{"version":"0.3"}`)
+			return
+		}
+		ids = append(ids, r.Header.Get(requestIDHeader))
+		if strings.Contains(r.URL.Path, "fail") {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		fmt.Fprint(w, `{"version":"0.3"}`)
+	}))
+	defer ts.Close()
+
+	timeout := time.Second
+	api := newAPI(ts.URL, &timeout, http.DefaultTransport, 0, false)
+
+	ctx, trace := withTrace(withRequestID(context.Background(), "abc"))
+	if err := api.post(ctx, "/data/ok.json", []Tuple{{1000, 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.post(ctx, "/data/fail.json", []Tuple{{1000, 1}}); err == nil {
+		t.Error("expected error")
+	}
+
+	if fmt.Sprint(ids) != "[abc abc]" {
+		t.Errorf("expected request ids abc, got %q", ids)
+	}
+
+	if len(trace.requests) != 2 {
+		t.Fatalf("expected 2 traced requests, got %d", len(trace.requests))
+	}
+	for i, status := range []int{http.StatusOK, http.StatusBadRequest} {
+		if r := trace.requests[i]; r.Method != "POST" || r.Status != status {
+			t.Errorf("request %d: expected POST with status %d, got %s with %d", i, status, r.Method, r.Status)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// route groups of the server, authenticated by their own chain
+const (
+	routeQuery  = "query"
+	routeWrite  = "write"
+	routeAdmin  = "admin"
+	routeHealth = "health"
+)
+
+// routeGroup returns the route group of the request. POST /metrics lists the
+// metrics of the JSON API datasource.
+func routeGroup(method, path string) string {
+	switch {
+	case path == "/healthz" || path == "/readyz" || path == "/metrics" && method != http.MethodPost:
+		return routeHealth
+	case path == "/invalidate" || path == "/alerts" || path == "/console" || strings.HasPrefix(path, "/admin/"):
+		return routeAdmin
+	case path == "/write":
+		return routeWrite
+	}
+	return routeQuery
+}
+
+// errNoCredentials is returned by authenticators if the request carries none of their kind
+var errNoCredentials = errors.New("no credentials")
+
+// authenticator checks the credentials of a request and returns the user
+type authenticator interface {
+	authenticate(r *http.Request) (string, error)
+}
+
+// AuthenticatorConfig configures an authenticator of the server
+type AuthenticatorConfig struct {
+	Type    string            `yaml:"type"`    // basic, apikey, mtls or header
+	Users   map[string]string `yaml:"users"`   // basic: user to password, plain or sha256:<hex>
+	Keys    map[string]string `yaml:"keys"`    // apikey: name to key
+	Header  string            `yaml:"header"`  // apikey: default bearer token, header: default Remote-User
+	Proxies []string          `yaml:"proxies"` // header: addresses or networks of the trusted proxies
+	Allow   []string          `yaml:"allow"`   // mtls, header: allowed common names or users, default all
+}
+
+// newAuthenticator creates the authenticator of conf
+func newAuthenticator(conf AuthenticatorConfig) (authenticator, error) {
+	allow := make(map[string]bool, len(conf.Allow))
+	for _, user := range conf.Allow {
+		allow[user] = true
+	}
+
+	switch strings.ToLower(conf.Type) {
+	case "basic":
+		if len(conf.Users) == 0 {
+			return nil, errors.New("missing users")
+		}
+		return &basicAuth{users: conf.Users}, nil
+
+	case "apikey":
+		if len(conf.Keys) == 0 {
+			return nil, errors.New("missing keys")
+		}
+		return &apiKeyAuth{header: conf.Header, keys: conf.Keys}, nil
+
+	case "mtls":
+		return &certAuth{allow: allow}, nil
+
+	case "header":
+		if len(conf.Proxies) == 0 {
+			return nil, errors.New("missing proxies")
+		}
+		proxies, err := parseNetworks(conf.Proxies)
+		if err != nil {
+			return nil, err
+		}
+		header := conf.Header
+		if header == "" {
+			header = "Remote-User"
+		}
+		return &headerAuth{header: header, proxies: proxies, allow: allow}, nil
+	}
+
+	return nil, fmt.Errorf("invalid type: %s", conf.Type)
+}
+
+// parseNetworks parses ip addresses and cidr networks
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var res []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy: %s", s)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			res = append(res, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %s", s)
+		}
+		res = append(res, network)
+	}
+	return res, nil
+}
+
+// secretEqual compares a secret in constant time
+func secretEqual(given, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(want)) == 1
+}
+
+// basicAuth checks basic auth credentials. Passwords are plain or sha256:<hex>.
+type basicAuth struct {
+	users map[string]string
+}
+
+func (a *basicAuth) authenticate(r *http.Request) (string, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", errNoCredentials
+	}
+
+	want, ok := a.users[user]
+	if hash := strings.TrimPrefix(want, "sha256:"); ok && hash != want {
+		sum := sha256.Sum256([]byte(password))
+		password, want = hex.EncodeToString(sum[:]), strings.ToLower(hash)
+	}
+	if !ok || !secretEqual(password, want) {
+		return "", fmt.Errorf("invalid password of %s", user)
+	}
+	return user, nil
+}
+
+// apiKeyAuth checks the key given in header or as bearer token or token query parameter
+type apiKeyAuth struct {
+	header string
+	keys   map[string]string
+}
+
+func (a *apiKeyAuth) authenticate(r *http.Request) (string, error) {
+	var given string
+	if a.header != "" {
+		given = r.Header.Get(a.header)
+	} else {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			given = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if given == "" {
+			given = r.URL.Query().Get("token")
+		}
+	}
+	if given == "" {
+		return "", errNoCredentials
+	}
+
+	// compare all keys to not reveal which one matched by timing
+	var user string
+	for name, key := range a.keys {
+		if secretEqual(given, key) {
+			user = name
+		}
+	}
+	if user == "" {
+		return "", errors.New("invalid key")
+	}
+	return user, nil
+}
+
+// certAuth checks the client certificate verified by the listener's client CA
+type certAuth struct {
+	allow map[string]bool
+}
+
+func (a *certAuth) authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", errNoCredentials
+	}
+
+	user := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(a.allow) > 0 && !a.allow[user] {
+		return "", fmt.Errorf("certificate %s not allowed", user)
+	}
+	return user, nil
+}
+
+// headerAuth trusts the user header set by an authenticating proxy like Authelia,
+// but only for requests from the proxy's addresses
+type headerAuth struct {
+	header  string
+	proxies []*net.IPNet
+	allow   map[string]bool
+}
+
+func (a *headerAuth) authenticate(r *http.Request) (string, error) {
+	user := r.Header.Get(a.header)
+	if user == "" {
+		return "", errNoCredentials
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+
+	trusted := false
+	for _, network := range a.proxies {
+		if ip != nil && network.Contains(ip) {
+			trusted = true
+		}
+	}
+	if !trusted {
+		return "", fmt.Errorf("%s header from untrusted address %s", a.header, host)
+	}
+
+	if len(a.allow) > 0 && !a.allow[user] {
+		return "", fmt.Errorf("user %s not allowed", user)
+	}
+	return user, nil
+}
+
+// authChain accepts requests accepted by any of its authenticators. An empty
+// chain accepts all requests.
+type authChain struct {
+	authenticators []authenticator
+}
+
+// authenticate returns the user of the first accepting authenticator. The error
+// is that of a rejecting authenticator or errNoCredentials.
+func (c authChain) authenticate(r *http.Request) (string, error) {
+	err := errNoCredentials
+	for _, a := range c.authenticators {
+		user, aerr := a.authenticate(r)
+		if aerr == nil {
+			return user, nil
+		}
+		if aerr != errNoCredentials {
+			err = aerr
+		}
+	}
+	return "", err
+}
+
+// basic reports if the chain accepts basic auth to ask browsers for credentials
+func (c authChain) basic() bool {
+	for _, a := range c.authenticators {
+		if _, ok := a.(*basicAuth); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// authRoutes guards a handler with the auth chain of the request's route group
+type authRoutes struct {
+	next   http.Handler
+	chains map[string]authChain
+}
+
+func (h *authRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	chain := h.chains[routeGroup(r.Method, r.URL.Path)]
+
+	if len(chain.authenticators) == 0 {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	// cors preflight requests carry no credentials, they are answered here
+	// instead of reaching the guarded handler
+	if r.Method == http.MethodOptions {
+		corsHeaders(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if _, err := chain.authenticate(r); err != nil {
+		message := "authentication required"
+		if err != errNoCredentials {
+			logf(r.Context(), "%s %s: %v", r.Method, r.URL.Path, err)
+			message = "invalid credentials"
+		}
+		if chain.basic() {
+			w.Header().Set("WWW-Authenticate", `Basic realm="gravo"`)
+		}
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusUnauthorized,
+			Code:    "unauthorized",
+			Message: message,
+		})
+		return
+	}
+
+	h.next.ServeHTTP(w, r)
+}
+
+// authorized checks the bearer token or token query parameter
+func authorized(r *http.Request, token string) bool {
+	_, err := (&apiKeyAuth{keys: map[string]string{"token": token}}).authenticate(r)
+	return err == nil
+}
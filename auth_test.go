@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteGroup(t *testing.T) {
+	tests := []struct {
+		method, path, expected string
+	}{
+		{http.MethodGet, "/healthz", routeHealth},
+		{http.MethodGet, "/readyz", routeHealth},
+		{http.MethodGet, "/metrics", routeHealth},
+		{http.MethodPost, "/metrics", routeQuery},
+		{http.MethodPost, "/query", routeQuery},
+		{http.MethodGet, "/", routeQuery},
+		{http.MethodPost, "/write", routeWrite},
+		{http.MethodPost, "/invalidate", routeAdmin},
+		{http.MethodPost, "/alerts", routeAdmin},
+		{http.MethodGet, "/console", routeAdmin},
+		{http.MethodGet, "/admin/channels", routeAdmin},
+		{http.MethodGet, "/admin", routeQuery},
+		{http.MethodGet, "/administration", routeQuery},
+	}
+
+	for _, tc := range tests {
+		if res := routeGroup(tc.method, tc.path); res != tc.expected {
+			t.Errorf("%s %s: expected %s, got %s", tc.method, tc.path, tc.expected, res)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	sum := sha256.Sum256([]byte("secret"))
+	a := &basicAuth{users: map[string]string{
+		"plain":  "secret",
+		"hashed": "sha256:" + hex.EncodeToString(sum[:]),
+	}}
+
+	tests := []struct {
+		name, user, password string
+		noAuth               bool
+		err                  bool
+	}{
+		{name: "plain", user: "plain", password: "secret"},
+		{name: "plain wrong", user: "plain", password: "other", err: true},
+		{name: "plain hash given", user: "plain", password: "sha256:" + hex.EncodeToString(sum[:]), err: true},
+		{name: "hashed", user: "hashed", password: "secret"},
+		{name: "hashed wrong", user: "hashed", password: "other", err: true},
+		{name: "hashed hash given", user: "hashed", password: hex.EncodeToString(sum[:]), err: true},
+		{name: "unknown user", user: "nobody", password: "secret", err: true},
+		{name: "no credentials", noAuth: true, err: true},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if !tc.noAuth {
+			r.SetBasicAuth(tc.user, tc.password)
+		}
+
+		user, err := a.authenticate(r)
+		if tc.err != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if tc.noAuth && err != errNoCredentials {
+			t.Errorf("%s: expected errNoCredentials, got %v", tc.name, err)
+		}
+		if !tc.err && user != tc.user {
+			t.Errorf("%s: expected user %s, got %s", tc.name, tc.user, user)
+		}
+	}
+}
+
+func TestHeaderAuth(t *testing.T) {
+	a, err := newAuthenticator(AuthenticatorConfig{
+		Type:    "header",
+		Proxies: []string{"10.0.0.0/8", "192.168.1.5", "::1"},
+		Allow:   []string{"alice", "bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, remote, user string
+		err                bool
+	}{
+		{"trusted network", "10.1.2.3:4000", "alice", false},
+		{"trusted address", "192.168.1.5:4000", "bob", false},
+		{"trusted ipv6", "[::1]:4000", "alice", false},
+		{"untrusted address", "192.168.1.6:4000", "alice", true},
+		{"untrusted public", "203.0.113.1:4000", "alice", true},
+		{"invalid address", "proxy", "alice", true},
+		{"not allowed", "10.1.2.3:4000", "mallory", true},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		r.Header.Set("Remote-User", tc.user)
+
+		user, err := a.authenticate(r)
+		if tc.err != (err != nil) || err == errNoCredentials {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+			continue
+		}
+		if !tc.err && user != tc.user {
+			t.Errorf("%s: expected user %s, got %s", tc.name, tc.user, user)
+		}
+	}
+
+	// requests without the header fall through to other authenticators
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.1:4000"
+	if _, err := a.authenticate(r); err != errNoCredentials {
+		t.Errorf("expected errNoCredentials, got %v", err)
+	}
+}
+
+// staticAuth returns the user or error it is configured with
+type staticAuth struct {
+	user string
+	err  error
+}
+
+func (a staticAuth) authenticate(r *http.Request) (string, error) {
+	return a.user, a.err
+}
+
+func TestAuthChain(t *testing.T) {
+	rejected := errors.New("rejected")
+
+	tests := []struct {
+		name  string
+		chain []authenticator
+		user  string
+		err   error
+	}{
+		{"empty", nil, "", errNoCredentials},
+		{"accept", []authenticator{staticAuth{user: "alice"}}, "alice", nil},
+		{"fall through", []authenticator{staticAuth{err: errNoCredentials}, staticAuth{user: "bob"}}, "bob", nil},
+		{"first accepting", []authenticator{staticAuth{user: "alice"}, staticAuth{user: "bob"}}, "alice", nil},
+		{"none", []authenticator{staticAuth{err: errNoCredentials}, staticAuth{err: errNoCredentials}}, "", errNoCredentials},
+		{"rejected", []authenticator{staticAuth{err: rejected}, staticAuth{err: errNoCredentials}}, "", rejected},
+		{"rejected then accept", []authenticator{staticAuth{err: rejected}, staticAuth{user: "bob"}}, "bob", nil},
+	}
+
+	for _, tc := range tests {
+		user, err := authChain{tc.chain}.authenticate(httptest.NewRequest(http.MethodGet, "/", nil))
+		if user != tc.user || err != tc.err {
+			t.Errorf("%s: expected %q %v, got %q %v", tc.name, tc.user, tc.err, user, err)
+		}
+	}
+}
+
+func TestAuthRoutes(t *testing.T) {
+	basic, _ := newAuthenticator(AuthenticatorConfig{Type: "basic", Users: map[string]string{"alice": "secret"}})
+	key, _ := newAuthenticator(AuthenticatorConfig{Type: "apikey", Keys: map[string]string{"writer": "key"}})
+
+	h := &authRoutes{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		chains: map[string]authChain{
+			routeQuery: {[]authenticator{basic, key}},
+			routeWrite: {[]authenticator{key}},
+		},
+	}
+
+	tests := []struct {
+		name, method, path string
+		basic, bearer      string
+		status             int
+	}{
+		{"open health", http.MethodGet, "/healthz", "", "", http.StatusOK},
+		{"query without credentials", http.MethodPost, "/query", "", "", http.StatusUnauthorized},
+		{"query basic", http.MethodPost, "/query", "secret", "", http.StatusOK},
+		{"query key", http.MethodPost, "/query", "", "key", http.StatusOK},
+		{"query wrong password", http.MethodPost, "/query", "wrong", "", http.StatusUnauthorized},
+		{"write basic", http.MethodPost, "/write", "secret", "", http.StatusUnauthorized},
+		{"write key", http.MethodPost, "/write", "", "key", http.StatusOK},
+		{"preflight", http.MethodOptions, "/query", "", "", http.StatusNoContent},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.basic != "" {
+			r.SetBasicAuth("alice", tc.basic)
+		}
+		if tc.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.bearer)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.status, w.Code)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nightHours parses the night period from-to in hours of day, e.g. 0-5
+func nightHours(s string) (int, int) {
+	from, to := 0, 5
+	if s == "" {
+		return from, to
+	}
+
+	parts := strings.Split(s, "-")
+	if len(parts) == 2 {
+		f, err1 := strconv.Atoi(parts[0])
+		t, err2 := strconv.Atoi(parts[1])
+		if err1 == nil && err2 == nil && f >= 0 && t <= 24 {
+			return f, t
+		}
+	}
+
+	log.Printf("invalid night: %s", s)
+	return from, to
+}
+
+// nightlyBaseline returns the median of the nightly minimum power with night hours in loc
+func nightlyBaseline(tuples []Tuple, from, to int, loc *time.Location) float64 {
+	mins := make(map[string]float64)
+
+	for _, tuple := range tuples {
+		t := time.Unix(tuple.Timestamp/1000, 0).In(loc)
+		if h := t.Hour(); h < from || h >= to {
+			continue
+		}
+
+		day := t.Format("2006-01-02")
+		if min, ok := mins[day]; !ok || float64(tuple.Value) < min {
+			mins[day] = float64(tuple.Value)
+		}
+	}
+
+	values := make([]float64, 0, len(mins))
+	for _, v := range mins {
+		values = append(values, v)
+	}
+
+	return percentile(values, 50)
+}
+
+// queryBaseline estimates the standby baseline power either as median of the nightly
+// minimum or as low percentile of all values. The baseline is returned as flat
+// series or, with series value, as single value.
+func (server *Server) queryBaseline(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	tuples, err := server.getTuples(ctx, target.Target, target.Data, qr)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	var baseline float64
+	if strings.ToLower(target.Data["method"]) == "percentile" {
+		baseline = percentile(tupleValues(tuples), target.Data.float("percentile", 10))
+	} else {
+		from, to := nightHours(target.Data["night"])
+		baseline = nightlyBaseline(tuples, from, to, server.conf.location(target.Target))
+	}
+
+	res := []Tuple{}
+	if !math.IsNaN(baseline) {
+		if strings.ToLower(target.Data["series"]) != "value" {
+			res = append(res, Tuple{Timestamp: unixMS(qr.Range.From), Value: float32(baseline)})
+		}
+		res = append(res, Tuple{Timestamp: unixMS(qr.Range.To), Value: float32(baseline)})
+	}
+
+	return dataResponse(target.Target, res, qr), nil
+}
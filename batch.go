@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BatchQuery is a single channel and range of a batch request. From and to accept
+// epoch ms, ISO 8601, now or relative durations. Data holds the same keys as the
+// target's additional JSON data, e.g. context.
+type BatchQuery struct {
+	Target        string     `json:"target"`
+	From          string     `json:"from"`
+	To            string     `json:"to"`
+	Group         string     `json:"group,omitempty"`
+	MaxDataPoints int        `json:"maxDataPoints,omitempty"`
+	Data          TargetData `json:"data,omitempty"`
+}
+
+// BatchRequest encodes the queries posted to /batch
+type BatchRequest struct {
+	Queries []BatchQuery `json:"queries"`
+}
+
+// BatchResponse is the result of a single batch query, either datapoints or error
+type BatchResponse struct {
+	Target     string          `json:"target"`
+	Datapoints []ResponseTuple `json:"datapoints,omitempty"`
+	Error      *QueryError     `json:"error,omitempty"`
+}
+
+func (server *Server) batchHandler(w http.ResponseWriter, r *http.Request) {
+	br := BatchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&br); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	ctx, cancel := server.queryContext(r)
+	defer cancel()
+
+	resp := server.executeBatch(ctx, br)
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// batchTarget converts the batch query into target and query request
+func batchTarget(bq BatchQuery, loc *time.Location) (Target, *QueryRequest, error) {
+	from, err := parseTime(bq.From, loc)
+	if err != nil {
+		return Target{}, nil, err
+	}
+	to, err := parseTime(bq.To, loc)
+	if err != nil {
+		return Target{}, nil, err
+	}
+
+	data := TargetData{}
+	for k, v := range bq.Data {
+		data[k] = v
+	}
+	if bq.Group != "" {
+		data["group"] = bq.Group
+	}
+
+	target := Target{Target: bq.Target, Data: data}
+	qr := &QueryRequest{
+		Range:         Range{From: from, To: to},
+		Targets:       []Target{target},
+		MaxDataPoints: bq.MaxDataPoints,
+	}
+
+	return target, qr, nil
+}
+
+// executeBatch runs the queries concurrently. Failed queries return their error
+// without affecting the others.
+func (server *Server) executeBatch(ctx context.Context, br BatchRequest) []BatchResponse {
+	res := make([]BatchResponse, len(br.Queries))
+
+	fanOut(ctx, len(br.Queries), server.fanout, func(idx int) {
+		bq := br.Queries[idx]
+		res[idx] = BatchResponse{Target: bq.Target}
+
+		defer func() {
+			if rec := recover(); rec != nil {
+				res[idx].Error = panicError(rec)
+			}
+		}()
+
+		target, qr, err := batchTarget(bq, server.conf.defaultLocation())
+		if err != nil {
+			res[idx].Error = &QueryError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_request",
+				Message: err.Error(),
+				Target:  bq.Target,
+			}
+			return
+		}
+		target = server.resolveTarget(ctx, target)
+
+		qres, err := server.querySeries(ctx, strings.ToLower(target.Data["context"]), target, qr)
+		if err != nil {
+			logf(ctx, "batch %s failed: %v", bq.Target, err)
+			res[idx].Error = queryError(target, err)
+			return
+		}
+
+		res[idx].Datapoints = qres.Datapoints
+	}, func(idx int, err error) {
+		res[idx] = BatchResponse{Target: br.Queries[idx].Target, Error: queryError(Target{Target: br.Queries[idx].Target}, err)}
+	})
+
+	return res
+}
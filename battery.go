@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// queryBattery splits a signed battery power channel into charge and discharge energy
+// per group period. If a separate `discharge` channel is given, the target only
+// meters charging. Energy is returned in Wh, efficiency in percent.
+func (server *Server) queryBattery(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	group := "day"
+	if grp, ok := target.Data["group"]; ok {
+		group = strings.ToLower(grp)
+	}
+	if _, ok := groupIntervals[group]; !ok {
+		return QueryResponse{}, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: fmt.Sprintf("invalid group: %s", group),
+			Hint:    "group is one of minute, hour, day, week, month or year",
+		}
+	}
+
+	// energy split requires raw data
+	tuples, err := server.api.getData(ctx, target.Target, qr.Range.From, qr.Range.To, "", "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+	loc := server.conf.location(target.Target)
+	charge, discharge := bucketEnergy(tuples, group, loc)
+
+	if strings.ToLower(target.Data["charge"]) == "negative" {
+		charge, discharge = discharge, charge
+	}
+
+	if uuid, ok := target.Data["discharge"]; ok {
+		tuples, err := server.api.getData(ctx, uuid, qr.Range.From, qr.Range.To, "", "", 0)
+		if err != nil {
+			return QueryResponse{}, err
+		}
+		discharge, _ = bucketEnergy(tuples, group, loc)
+	}
+
+	var res []Tuple
+	switch strings.ToLower(target.Data["series"]) {
+	case "discharge":
+		res = discharge
+	case "efficiency":
+		res = []Tuple{}
+		if total := totalEnergy(charge); total > 0 {
+			res = append(res, Tuple{
+				Timestamp: unixMS(qr.Range.To),
+				Value:     float32(100 * totalEnergy(discharge) / total),
+			})
+		}
+		return dataResponse(target.Target, res, qr), nil
+	default:
+		res = charge
+	}
+
+	if target.Data["total"] == "true" {
+		res = []Tuple{Tuple{
+			Timestamp: unixMS(qr.Range.To),
+			Value:     float32(totalEnergy(res)),
+		}}
+	}
+
+	return dataResponse(target.Target, res, qr), nil
+}
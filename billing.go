@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// date returns the given date clamping day to the last day of month
+func date(year int, month time.Month, day int, loc *time.Location) time.Time {
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// billingPeriod returns start and end of the month or year period containing now.
+// Monthly periods start on the `billingday` of month, annual periods on the
+// `billingdate` anniversary (MM-DD), defaulting to calendar months and years.
+func billingPeriod(now time.Time, period string, data TargetData) (time.Time, time.Time) {
+	loc := now.Location()
+
+	if strings.ToLower(period) == "year" {
+		month, day := time.January, 1
+		if bd, ok := data["billingdate"]; ok {
+			if t, err := time.Parse("01-02", bd); err == nil {
+				month, day = t.Month(), t.Day()
+			} else {
+				log.Printf("invalid billingdate: %s", bd)
+			}
+		}
+
+		year := now.Year()
+		if date(year, month, day, loc).After(now) {
+			year--
+		}
+
+		return date(year, month, day, loc), date(year+1, month, day, loc)
+	}
+
+	day := 1
+	if bd, ok := data["billingday"]; ok {
+		if d, err := strconv.Atoi(bd); err == nil && d >= 1 && d <= 31 {
+			day = d
+		} else {
+			log.Printf("invalid billingday: %s", bd)
+		}
+	}
+
+	year, month := now.Year(), now.Month()
+	if date(year, month, day, loc).After(now) {
+		month--
+	}
+
+	return date(year, month, day, loc), date(year, month+1, day, loc)
+}
+
+// hasBillingPeriod checks if custom billing period boundaries are configured
+func hasBillingPeriod(data TargetData) bool {
+	_, day := data["billingday"]
+	_, date := data["billingdate"]
+	return day || date
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// queryBudget compares the consumption of the current period against a budget
+func (server *Server) queryBudget(ctx context.Context, target Target) (QueryResponse, error) {
+	qres := QueryResponse{
+		Target:     target.Target,
+		Datapoints: []ResponseTuple{},
+	}
+
+	budget := target.Data.float("budget", 0)
+	if budget <= 0 {
+		logf(ctx, "budget: missing budget for %s", target.Target)
+		return qres, nil
+	}
+
+	now := time.Now().In(server.conf.location(target.Target))
+	start, end := billingPeriod(now, target.Data["period"], target.Data)
+
+	// Wh to kWh
+	consumption, err := server.api.getConsumption(ctx, target.Target, start, now)
+	if err != nil {
+		return qres, err
+	}
+	consumption /= 1e3
+	if price := target.Data.float("price", 0); price > 0 {
+		consumption *= price
+	}
+
+	elapsed := now.Sub(start).Seconds() / end.Sub(start).Seconds()
+
+	var value float64
+	switch strings.ToLower(target.Data["series"]) {
+	case "consumption":
+		value = consumption
+	case "remaining":
+		value = budget - consumption
+	case "overrun":
+		value = consumption/elapsed - budget
+	default:
+		value = budget * elapsed
+	}
+
+	qres.Datapoints = append(qres.Datapoints, ResponseTuple{
+		Value:     float32(value),
+		Timestamp: now.Unix() * 1000,
+	})
+
+	return qres, nil
+}
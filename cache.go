@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// response cache counters exposed at /metrics
+var (
+	cacheHits   = &counter{}
+	cacheMisses = &counter{}
+)
+
+// responseCache caches middleware responses for ttl. Concurrent requests for the
+// same key wait for the pending request instead of querying again. Responses are
+// written through to the optional disk cache and promoted from it on memory misses.
+type responseCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*cacheEntry
+
+	// l2 is the persistent second level, nil if disabled
+	l2 *diskCache
+}
+
+type noCacheKey struct{}
+
+// withoutCache marks requests of ctx to bypass the response cache, e.g. of ranges
+// ending now that are never requested again
+func withoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// cacheBypassed checks if requests of ctx bypass the response cache
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(noCacheKey{}).(bool)
+	return bypass
+}
+
+// uncached is returned by fetch functions for responses that must not be cached
+type uncached struct {
+	body []byte
+}
+
+func (u *uncached) Error() string {
+	return "uncached response"
+}
+
+type cacheEntry struct {
+	done    chan struct{}
+	body    []byte
+	err     error
+	expires time.Time
+}
+
+// newResponseCache creates a cache holding up to max entries, nil if ttl is not positive
+func newResponseCache(ttl time.Duration, max int) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &responseCache{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+// evict removes expired entries and, if still full, the entry expiring first
+func (c *responseCache) evict(now time.Time) {
+	var oldest string
+	for key, e := range c.entries {
+		select {
+		case <-e.done:
+		default:
+			continue // pending
+		}
+
+		if now.After(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+
+	if c.max > 0 && len(c.entries) >= c.max && oldest != "" {
+		delete(c.entries, oldest)
+	}
+}
+
+// get returns the cached response for key or calls fetch. Errors are not cached.
+func (c *responseCache) get(ctx context.Context, key string, fetch func() ([]byte, error)) ([]byte, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok {
+		select {
+		case <-e.done:
+			if now.After(e.expires) {
+				ok = false
+			}
+		default:
+		}
+	}
+
+	if ok {
+		c.mu.Unlock()
+
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		// the pending request was cancelled by its own client
+		if errors.Is(e.err, context.Canceled) && ctx.Err() == nil {
+			return fetch()
+		}
+
+		if u, ok := e.err.(*uncached); ok {
+			return u.body, nil
+		}
+		if e.err == nil {
+			cacheHits.Add(1)
+			logf(ctx, "cache hit %s", key)
+		}
+		return e.body, e.err
+	}
+
+	if c.max > 0 && len(c.entries) >= c.max {
+		c.evict(now)
+	}
+
+	e = &cacheEntry{done: make(chan struct{})}
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	cacheMisses.Add(1)
+
+	// promote from disk, keeping the disk expiry if earlier
+	if c.l2 != nil {
+		if body, expires, ok := c.l2.get(key, now); ok {
+			logf(ctx, "disk cache hit %s", key)
+			e.body, e.expires = body, now.Add(c.ttl)
+			if expires.Before(e.expires) {
+				e.expires = expires
+			}
+			close(e.done)
+			return e.body, nil
+		}
+	}
+
+	logf(ctx, "cache miss %s", key)
+
+	e.body, e.err = fetch()
+	e.expires = time.Now().Add(c.ttl)
+
+	if e.err == nil && c.l2 != nil {
+		c.l2.set(key, e.body, time.Now())
+	}
+
+	if e.err != nil {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	close(e.done)
+
+	if u, ok := e.err.(*uncached); ok {
+		return u.body, nil
+	}
+	return e.body, e.err
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	minChunkSpan = time.Hour
+	maxChunkSpan = 366 * 24 * time.Hour
+)
+
+// chunkTuner learns per channel and group the time span a single data request may
+// cover such that responses arrive within the target latency and stay well below
+// the maximum body size
+type chunkTuner struct {
+	mu      sync.Mutex
+	target  time.Duration
+	maxBody int64
+	spans   map[string]time.Duration // 0 or missing: unchunked
+}
+
+// newChunkTuner creates a tuner, nil if target is not positive
+func newChunkTuner(target time.Duration, maxBody int64) *chunkTuner {
+	if target <= 0 {
+		return nil
+	}
+	return &chunkTuner{
+		target:  target,
+		maxBody: maxBody,
+		spans:   make(map[string]time.Duration),
+	}
+}
+
+func chunkKey(uuid, group string) string {
+	return uuid + "/" + group
+}
+
+// span returns the learned span of key, 0 if requests need not be chunked
+func (t *chunkTuner) span(key string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.spans[key]
+}
+
+// set stores the span, bounded and smoothed against the previous span
+func (t *chunkTuner) set(ctx context.Context, key string, span time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	prev := t.spans[key]
+	if prev > 0 {
+		span = (prev + span) / 2
+	}
+	if span < minChunkSpan {
+		span = minChunkSpan
+	}
+	if span > maxChunkSpan {
+		span = maxChunkSpan
+	}
+
+	// log significant changes only
+	if d := span - prev; d > prev/4 || -d > prev/4 {
+		logf(ctx, "chunk span %s: %v", key, span.Round(time.Minute))
+	}
+	t.spans[key] = span
+}
+
+// observe adapts the span of key after a request over span took latency and returned size bytes
+func (t *chunkTuner) observe(ctx context.Context, key string, span, latency time.Duration, size int64) {
+	if t == nil || span <= 0 {
+		return
+	}
+
+	load := float64(latency) / float64(t.target)
+	if t.maxBody > 0 {
+		if l := float64(size) / float64(t.maxBody/2); l > load {
+			load = l
+		}
+	}
+
+	learned := t.span(key)
+	switch {
+	case load > 1:
+		t.set(ctx, key, time.Duration(float64(span)/load))
+	case learned > 0 && span >= learned/2 && load < 0.5:
+		// only grow from requests close to the learned span
+		grow := 2.0
+		if load > 0 {
+			if g := 0.75 / load; g < grow {
+				grow = g
+			}
+		}
+		t.set(ctx, key, time.Duration(float64(learned)*grow))
+	}
+}
+
+// tooLarge halves the span of key after a request over span exceeded the maximum body size
+func (t *chunkTuner) tooLarge(ctx context.Context, key string, span time.Duration) {
+	if t == nil || span <= 0 {
+		return
+	}
+	t.mu.Lock()
+	t.spans[key] = 0 // no smoothing
+	t.mu.Unlock()
+	t.set(ctx, key, span/2)
+}
+
+// fetchTuned fetches the range in chunks of the learned span of the channel
+func (api *Api) fetchTuned(ctx context.Context, uuid string, from time.Time, to time.Time, group string, options string, tuples int) ([]Tuple, error) {
+	span := api.chunks.span(chunkKey(uuid, group))
+	if span <= 0 || to.Sub(from) <= span {
+		return api.fetchData(ctx, uuid, from, to, group, options, tuples)
+	}
+
+	n := int((to.Sub(from) + span - 1) / span)
+	logf(ctx, "fetching %s in %d chunks of %v", uuid, n, span.Round(time.Minute))
+
+	per := tuples / n
+	if tuples > 0 && per == 0 {
+		per = 1
+	}
+
+	res := []Tuple{}
+	for start := from; start.Before(to); start = start.Add(span) {
+		end := start.Add(span)
+		if end.After(to) {
+			end = to
+		}
+
+		chunk, err := api.fetchData(ctx, uuid, start, end, group, options, per)
+		if err == errResponseTooLarge {
+			chunk, err = api.fetchChunked(ctx, uuid, start, end, group, options, per, 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// avoid duplicate tuple at chunk boundary
+		if len(res) > 0 && len(chunk) > 0 && chunk[0].Timestamp <= res[len(res)-1].Timestamp {
+			chunk = chunk[1:]
+		}
+		res = append(res, chunk...)
+	}
+
+	return res, nil
+}
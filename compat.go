@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Middleware versions differ in how they encode JSON: older versions send
+// numbers as strings, timestamps with fractional milliseconds, null values for
+// missing readings and omit fields like consumption. The decoders below accept
+// all known variants so that a single gravo binary works against any version.
+
+// compatNumber decodes a json number which may also be encoded as string or null.
+// Null and empty strings decode as NaN.
+func compatNumber(raw json.RawMessage) (float64, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return math.NaN(), nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, err
+		}
+		if s == "" {
+			return math.NaN(), nil
+		}
+		return strconv.ParseFloat(s, 64)
+	}
+
+	var f float64
+	err := json.Unmarshal(raw, &f)
+	return f, err
+}
+
+// UnmarshalJSON converts volkszaehler tuple into Tuple struct
+func (t *Tuple) UnmarshalJSON(b []byte) error {
+	var a []json.RawMessage
+	if err := json.Unmarshal(b, &a); err != nil {
+		return err
+	}
+
+	if len(a) < 2 {
+		return fmt.Errorf("invalid tuple: %s", b)
+	}
+
+	ts, err := compatNumber(a[0])
+	if err != nil || math.IsNaN(ts) {
+		return fmt.Errorf("invalid tuple timestamp: %s", a[0])
+	}
+	t.Timestamp = int64(ts)
+
+	v, err := compatNumber(a[1])
+	if err != nil {
+		return fmt.Errorf("invalid tuple value: %s", a[1])
+	}
+	t.Value = float32(v)
+
+	return nil
+}
+
+// MarshalJSON encodes the tuple in middleware format, NaN values as null
+func (t Tuple) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(t.Value)) {
+		return []byte(fmt.Sprintf("[%d,null]", t.Timestamp)), nil
+	}
+	return json.Marshal([]interface{}{t.Timestamp, t.Value})
+}
+
+// UnmarshalJSON accepts data as object or, as returned for multiple channels
+// by some versions, as array containing a single object
+func (d *DataStruct) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var a []json.RawMessage
+		if err := json.Unmarshal(b, &a); err != nil {
+			return err
+		}
+		if len(a) == 0 {
+			return nil
+		}
+		b = a[0]
+	}
+
+	var data struct {
+		Consumption json.RawMessage `json:"consumption"`
+		Tuples      []Tuple         `json:"tuples"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	consumption, err := compatNumber(data.Consumption)
+	if err != nil {
+		return fmt.Errorf("invalid consumption: %s", data.Consumption)
+	}
+	if math.IsNaN(consumption) {
+		consumption = 0
+	}
+
+	d.Consumption = consumption
+	d.Tuples = data.Tuples
+	if d.Tuples == nil {
+		d.Tuples = []Tuple{}
+	}
+
+	return nil
+}
+
+// UnmarshalJSON accepts the prognosis values as number or string
+func (p *PrognosisStruct) UnmarshalJSON(b []byte) error {
+	var prognosis struct {
+		Consumption json.RawMessage `json:"consumption"`
+		Factor      json.RawMessage `json:"factor"`
+	}
+	if err := json.Unmarshal(b, &prognosis); err != nil {
+		return err
+	}
+
+	consumption, err := compatNumber(prognosis.Consumption)
+	if err != nil {
+		return fmt.Errorf("invalid prognosis: %s", prognosis.Consumption)
+	}
+	factor, err := compatNumber(prognosis.Factor)
+	if err != nil {
+		return fmt.Errorf("invalid prognosis factor: %s", prognosis.Factor)
+	}
+
+	if math.IsNaN(consumption) {
+		consumption = 0
+	}
+	if math.IsNaN(factor) {
+		factor = 0
+	}
+
+	p.Consumption, p.Fator = float32(consumption), float32(factor)
+	return nil
+}
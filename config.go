@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the gravo configuration file
+type Config struct {
+	Presets      map[string]PresetConfig     `yaml:"presets"`
+	QueryPresets map[string]string           `yaml:"queryPresets"`
+	Channels     map[string]ChannelConfig    `yaml:"channels"`
+	S3           S3Config                    `yaml:"s3"`
+	Jobs         []JobConfig                 `yaml:"jobs"`
+	Watchdog     WatchdogConfig              `yaml:"watchdog"`
+	Sync         SyncConfig                  `yaml:"sync"`
+	Timezone     string                      `yaml:"timezone"`
+	Holidays     HolidayConfig               `yaml:"holidays"`
+	WriteBack    []WriteBackConfig           `yaml:"writeback"`
+	SLA          SLAConfig                   `yaml:"sla"`
+	Prognosis    PrognosisConfig             `yaml:"prognosis"`
+	Queries      map[string]SavedQueryConfig `yaml:"queries"`
+	Middleware   MiddlewareConfig            `yaml:"middleware"`
+	Auth         AuthConfig                  `yaml:"auth"`
+	TLS          TLSConfig                   `yaml:"tls"`
+	Cache        CacheConfig                 `yaml:"cache"`
+	Logging      LoggingConfig               `yaml:"logging"`
+	Aliases      map[string]string           `yaml:"aliases"` // alias to uuid
+	Tariffs      map[string]TariffConfig     `yaml:"tariffs"`
+	Virtual      map[string]VirtualConfig    `yaml:"virtual"`
+	Events       map[string]EventConfig      `yaml:"events"`
+	Sites        map[string]SiteConfig       `yaml:"sites"`
+	Multisite    map[string]MultisiteConfig  `yaml:"multisite"`
+	Server       ServerConfig                `yaml:"server"`
+
+	calendar *calendar
+	loc      *time.Location // of Timezone
+}
+
+// HolidayConfig lists weekend days and holidays for splitting workday and holiday consumption
+type HolidayConfig struct {
+	Weekend []string `yaml:"weekend"` // default saturday, sunday
+	Dates   []string `yaml:"dates"`   // YYYY-MM-DD, recurring MM-DD or easter+/-days
+}
+
+// ChannelConfig holds per channel settings
+type ChannelConfig struct {
+	Preset     string                      `yaml:"preset"`
+	Display    DisplayConfig               `yaml:"display"`
+	Timezone   string                      `yaml:"timezone"`
+	Interval   string                      `yaml:"interval"` // expected sampling interval
+	Thresholds []ThresholdConfig           `yaml:"thresholds"`
+	Metadata   MetadataConfig              `yaml:"metadata"`
+	States     map[string]string           `yaml:"states"` // value to label, e.g. 1: on
+	Token      string                      `yaml:"token"`  // access token of channels that are not public
+	Write      WriteCheckConfig            `yaml:"write"`  // plausibility checks of POST /write
+	Units      map[string]ConversionConfig `yaml:"units"`  // conversions of the values by target unit
+
+	location *time.Location
+	interval time.Duration
+}
+
+// MetadataConfig corrects the entity metadata returned by the middleware
+type MetadataConfig struct {
+	Title string `yaml:"title"`
+	Unit  string `yaml:"unit"`
+	Type  string `yaml:"type"`
+}
+
+// ThresholdConfig is a static reference value of a channel, e.g. contracted peak power
+type ThresholdConfig struct {
+	Name    string        `yaml:"name"`
+	Value   float64       `yaml:"value"`
+	Display DisplayConfig `yaml:"display"`
+}
+
+// DisplayConfig holds display hints passed to Grafana and dashboard tools
+type DisplayConfig struct {
+	Color string `yaml:"color" json:"color,omitempty"`
+	Fill  *int   `yaml:"fill" json:"fill,omitempty"`   // 0..10
+	Style string `yaml:"style" json:"style,omitempty"` // lines, bars or points
+	Stack string `yaml:"stack" json:"stack,omitempty"` // series with same stack group are stacked
+}
+
+// JobConfig describes a scheduled export job
+type JobConfig struct {
+	Name      string   `yaml:"name"`
+	Schedule  string   `yaml:"schedule"`
+	Channels  []string `yaml:"channels"`
+	Period    string   `yaml:"period"`
+	Range     string   `yaml:"range"`
+	Group     string   `yaml:"group"`
+	Preset    string   `yaml:"preset"`
+	Query     string   `yaml:"query"` // saved query exported instead of channels
+	Locale    string   `yaml:"locale"`
+	Decimals  *int     `yaml:"decimals"`
+	Directory string   `yaml:"directory"`
+	Webhook   string   `yaml:"webhook"`
+	S3        bool     `yaml:"s3"`
+	Bundle    bool     `yaml:"bundle"` // zip archive with manifest
+}
+
+// WatchdogConfig describes the channels monitored for dead sensors
+type WatchdogConfig struct {
+	Interval string                  `yaml:"interval"`
+	Webhook  string                  `yaml:"webhook"`
+	Channels []WatchdogChannelConfig `yaml:"channels"`
+}
+
+// WatchdogChannelConfig sets the staleness and stuck value limits of a channel
+type WatchdogChannelConfig struct {
+	UUID   string  `yaml:"uuid"`
+	Stale  float64 `yaml:"stale"`
+	Stuck  string  `yaml:"stuck"`
+	Window string  `yaml:"window"`
+}
+
+// PrognosisConfig lists the channels of the bulk prognosis, defaulting to all configured channels
+type PrognosisConfig struct {
+	Period   string   `yaml:"period"`
+	Channels []string `yaml:"channels"`
+}
+
+// SLAConfig describes the channels whose data freshness and completeness over
+// window is exposed as metrics
+type SLAConfig struct {
+	Interval string             `yaml:"interval"`
+	Window   string             `yaml:"window"`
+	Channels []SLAChannelConfig `yaml:"channels"`
+}
+
+// SLAChannelConfig sets the expected interval of a channel, defaulting to the median interval
+type SLAChannelConfig struct {
+	UUID     string `yaml:"uuid"`
+	Expected string `yaml:"expected"`
+}
+
+// WriteBackConfig persists a computed query of target with data options, e.g.
+// context sum, to an existing middleware channel. Each interval the range
+// before now is computed and tuples newer than the channel's last are written.
+type WriteBackConfig struct {
+	Target   string            `yaml:"target"`
+	Data     map[string]string `yaml:"data"`
+	Channel  string            `yaml:"channel"`
+	Interval string            `yaml:"interval"`
+	Range    string            `yaml:"range"`
+}
+
+// SyncConfig describes mirroring channels to a time series database
+type SyncConfig struct {
+	Interval string              `yaml:"interval"`
+	State    string              `yaml:"state"`
+	Influx   InfluxConfig        `yaml:"influx"`
+	Sinks    []SinkConfig        `yaml:"sinks"`
+	Channels []SyncChannelConfig `yaml:"channels"`
+}
+
+// InfluxConfig selects the InfluxDB 1.x database or 2.x bucket written to
+type InfluxConfig struct {
+	URL         string `yaml:"url"`
+	Database    string `yaml:"database"`
+	Bucket      string `yaml:"bucket"`
+	Org         string `yaml:"org"`
+	Token       string `yaml:"token"`
+	Measurement string `yaml:"measurement"`
+}
+
+// SinkConfig configures an output target of sync. Type is one of influx, file,
+// mqtt, kafka or prometheus, url is the influx server, mqtt broker, kafka rest
+// proxy or prometheus pushgateway.
+type SinkConfig struct {
+	Type         string `yaml:"type"`
+	Name         string `yaml:"name"`
+	Buffer       int    `yaml:"buffer"`    // max buffered tuples
+	Queue        string `yaml:"queue"`     // file queueing data while the target is unavailable
+	QueueSize    int    `yaml:"queuesize"` // max queued tuples
+	InfluxConfig `yaml:",inline"`
+	Path         string `yaml:"path"`   // file
+	Format       string `yaml:"format"` // file: json or line
+	Topic        string `yaml:"topic"`  // mqtt, kafka
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	ClientID     string `yaml:"clientid"`
+	Job          string `yaml:"job"` // prometheus
+}
+
+// SyncChannelConfig sets what is mirrored of a channel. Group selects raw
+// data or an aggregation level, data before start or older than retention is
+// not replicated.
+type SyncChannelConfig struct {
+	UUID      string `yaml:"uuid"`
+	Group     string `yaml:"group"`
+	Start     string `yaml:"start"`
+	Retention string `yaml:"retention"`
+}
+
+// defaultLocation returns the global timezone, defaulting to the process' local timezone
+func (conf Config) defaultLocation() *time.Location {
+	if conf.loc != nil {
+		return conf.loc
+	}
+	return time.Local
+}
+
+// location returns the timezone of channel uuid, defaulting to the global timezone
+func (conf Config) location(uuid string) *time.Location {
+	if loc := conf.Channels[uuid].location; loc != nil {
+		return loc
+	}
+	return conf.defaultLocation()
+}
+
+// expectedInterval returns the configured sampling interval of the channel, zero if unknown
+func (conf Config) expectedInterval(uuid string) time.Duration {
+	return conf.Channels[uuid].interval
+}
+
+// channelIntervals returns the configured sampling intervals by uuid
+func (conf Config) channelIntervals() map[string]time.Duration {
+	res := make(map[string]time.Duration)
+	for uuid, c := range conf.Channels {
+		if c.interval > 0 {
+			res[uuid] = c.interval
+		}
+	}
+	return res
+}
+
+func loadConfig(file string) (Config, error) {
+	var conf Config
+
+	f, err := os.Open(file)
+	if err != nil {
+		return conf, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&conf); err != nil {
+		return conf, err
+	}
+
+	if conf.Timezone != "" {
+		if conf.loc, err = time.LoadLocation(conf.Timezone); err != nil {
+			return conf, fmt.Errorf("invalid timezone: %s", conf.Timezone)
+		}
+	}
+
+	if err := conf.validateSettings(); err != nil {
+		return conf, err
+	}
+
+	if conf.calendar, err = newCalendar(conf.Holidays); err != nil {
+		return conf, fmt.Errorf("holidays: %v", err)
+	}
+
+	for uuid, c := range conf.Channels {
+		switch c.Display.Style {
+		case "", "lines", "bars", "points":
+		default:
+			return conf, fmt.Errorf("channel %s: invalid style: %s", uuid, c.Display.Style)
+		}
+
+		for _, t := range c.Thresholds {
+			if t.Name == "" {
+				return conf, fmt.Errorf("channel %s: threshold without name", uuid)
+			}
+		}
+
+		if c.Timezone != "" {
+			loc, err := time.LoadLocation(c.Timezone)
+			if err != nil {
+				return conf, fmt.Errorf("channel %s: invalid timezone: %s", uuid, c.Timezone)
+			}
+			c.location = loc
+			conf.Channels[uuid] = c
+		}
+
+		for unit := range c.Units {
+			if unit == "" || strings.ToLower(unit) == "auto" {
+				return conf, fmt.Errorf("channel %s: invalid conversion unit: %q", uuid, unit)
+			}
+		}
+
+		if c.Interval != "" {
+			d, err := time.ParseDuration(c.Interval)
+			if err != nil || d <= 0 {
+				return conf, fmt.Errorf("channel %s: invalid interval: %s", uuid, c.Interval)
+			}
+			c.interval = d
+			conf.Channels[uuid] = c
+		}
+	}
+
+	for name, q := range conf.Queries {
+		if err := q.validate(); err != nil {
+			return conf, fmt.Errorf("query %s: %v", name, err)
+		}
+	}
+
+	for name, t := range conf.Tariffs {
+		if err := t.validate(); err != nil {
+			return conf, fmt.Errorf("tariff %s: %v", name, err)
+		}
+	}
+
+	if err := conf.validateVirtual(); err != nil {
+		return conf, err
+	}
+
+	if err := conf.validateEvents(); err != nil {
+		return conf, err
+	}
+
+	if err := conf.validateServer(); err != nil {
+		return conf, err
+	}
+
+	if err := conf.validateMultisite(); err != nil {
+		return conf, err
+	}
+
+	for _, job := range conf.Jobs {
+		if _, ok := conf.Queries[job.Query]; job.Query != "" && !ok {
+			return conf, fmt.Errorf("job %s: unknown query: %s", job.Name, job.Query)
+		}
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type traceKey struct{}
+
+// queryTrace records the middleware requests of a console query
+type queryTrace struct {
+	start    time.Time
+	mu       sync.Mutex
+	requests []TracedRequest
+}
+
+// TracedRequest is a middleware request of a console query
+type TracedRequest struct {
+	Method   string  `json:"method"`
+	URL      string  `json:"url"`
+	Status   int     `json:"status,omitempty"`
+	Cached   bool    `json:"cached,omitempty"`
+	Start    float64 `json:"startMs"`
+	Duration float64 `json:"durationMs"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// withTrace attaches a new trace to ctx
+func withTrace(ctx context.Context) (context.Context, *queryTrace) {
+	trace := &queryTrace{start: time.Now()}
+	return context.WithValue(ctx, traceKey{}, trace), trace
+}
+
+// traceRequest records a middleware request started at start if ctx is traced
+func traceRequest(ctx context.Context, req TracedRequest, start time.Time) {
+	trace, ok := ctx.Value(traceKey{}).(*queryTrace)
+	if !ok {
+		return
+	}
+
+	req.Start = milliseconds(start.Sub(trace.start))
+	req.Duration = milliseconds(time.Since(start))
+
+	trace.mu.Lock()
+	trace.requests = append(trace.requests, req)
+	trace.mu.Unlock()
+}
+
+// list returns the recorded requests
+func (trace *queryTrace) list() []TracedRequest {
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	return append([]TracedRequest{}, trace.requests...)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// ConsoleRequest is a query of the console. Target is a uuid, alias or the JSON of
+// a Grafana target including its data.
+type ConsoleRequest struct {
+	Target        string `json:"target"`
+	From          string `json:"from"` // default -24h
+	To            string `json:"to"`   // default now
+	MaxDataPoints int    `json:"maxDataPoints"`
+}
+
+// ConsoleTiming breaks down the time of a console query
+type ConsoleTiming struct {
+	Total      float64 `json:"totalMs"`
+	Middleware float64 `json:"middlewareMs"` // sum of all middleware requests
+	Processing float64 `json:"processingMs"` // total time without middleware requests
+}
+
+// ConsoleResponse is the result of a console query
+type ConsoleResponse struct {
+	Query    QueryRequest    `json:"query"`
+	Requests []TracedRequest `json:"requests"`
+	Timing   ConsoleTiming   `json:"timing"`
+	Series   []interface{}   `json:"series,omitempty"`
+	Error    *QueryError     `json:"error,omitempty"`
+}
+
+// consoleQuery parses the console request into a query request
+func consoleQuery(cr ConsoleRequest, loc *time.Location) (QueryRequest, error) {
+	qr := QueryRequest{MaxDataPoints: cr.MaxDataPoints}
+
+	var target Target
+	if s := strings.TrimSpace(cr.Target); strings.HasPrefix(s, "{") {
+		if err := json.Unmarshal([]byte(s), &target); err != nil {
+			return qr, fmt.Errorf("invalid target: %v", err)
+		}
+	} else {
+		target.Target = s
+	}
+	if target.Target == "" {
+		return qr, fmt.Errorf("missing target")
+	}
+	qr.Targets = []Target{target}
+
+	from, to := cr.From, cr.To
+	if from == "" {
+		from = "-24h"
+	}
+
+	var err error
+	if qr.Range.From, err = parseTime(from, loc); err != nil {
+		return qr, err
+	}
+	if qr.Range.To, err = parseTime(to, loc); err != nil {
+		return qr, err
+	}
+	if !qr.Range.From.Before(qr.Range.To) {
+		return qr, fmt.Errorf("from must be before to")
+	}
+
+	if qr.MaxDataPoints > 0 {
+		qr.IntervalMs = qr.Range.To.Sub(qr.Range.From).Milliseconds() / int64(qr.MaxDataPoints)
+	}
+
+	return qr, nil
+}
+
+// consoleHandler serves the query console page and runs its queries, returning the
+// middleware requests made, their timing and the resulting series
+func (server *Server) consoleHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, server.consoleToken) {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusUnauthorized,
+			Code:    "unauthorized",
+			Message: "invalid token",
+			Hint:    "open the console as /console?token=<token>",
+		})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, consolePage)
+		return
+	}
+
+	cr := ConsoleRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&cr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	qr, err := consoleQuery(cr, server.conf.defaultLocation())
+	if err != nil {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: err.Error(),
+			Hint:    "target is a uuid, alias or target JSON, from and to are times like -24h, now or ISO 8601",
+		})
+		return
+	}
+
+	ctx, cancel := server.queryContext(r)
+	defer cancel()
+
+	ctx, trace := withTrace(ctx)
+	series, err := server.executeQuery(ctx, qr)
+
+	resp := ConsoleResponse{
+		Query:  qr,
+		Series: series,
+	}
+	if err != nil {
+		resp.Error = queryError(qr.Targets[0], err)
+	}
+
+	resp.Requests = trace.list()
+	resp.Timing.Total = milliseconds(time.Since(trace.start))
+	for _, req := range resp.Requests {
+		resp.Timing.Middleware += req.Duration
+	}
+	if resp.Timing.Processing = resp.Timing.Total - resp.Timing.Middleware; resp.Timing.Processing < 0 {
+		// concurrent requests
+		resp.Timing.Processing = 0
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// consolePage is the query console
+const consolePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gravo console</title>
+<style>
+body { font: 14px sans-serif; margin: 1em 2em; }
+textarea { width: 100%; height: 6em; font-family: monospace; }
+table { border-collapse: collapse; margin: .5em 0; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: left; font-family: monospace; }
+.error { color: #c00; }
+#plot { border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<h1>gravo console</h1>
+<p>Target: uuid, alias or Grafana target JSON, e.g. <code>{"target": "&lt;uuid&gt;", "data": {"group": "hour"}}</code></p>
+<textarea id="target"></textarea>
+<p>
+From <input id="from" value="-24h"> To <input id="to" value="now">
+Max data points <input id="points" value="500" size="6">
+<button id="run">Run</button>
+</p>
+<div id="error" class="error"></div>
+<h2>Middleware requests</h2>
+<div id="timing"></div>
+<table id="requests"></table>
+<h2>Series</h2>
+<svg id="plot" width="900" height="300"></svg>
+<div id="series"></div>
+<script>
+const token = new URLSearchParams(location.search).get("token") || "";
+const $ = id => document.getElementById(id);
+const colors = ["#7eb26d", "#eab839", "#6ed0e0", "#ef843c", "#e24d42", "#1f78c1"];
+
+function cell(row, text, tag) {
+  const c = document.createElement(tag || "td");
+  c.textContent = text;
+  row.appendChild(c);
+}
+
+function table(el, header, rows) {
+  el.innerHTML = "";
+  const head = el.insertRow();
+  header.forEach(h => cell(head, h, "th"));
+  rows.forEach(r => { const row = el.insertRow(); r.forEach(v => cell(row, v)); });
+}
+
+function plot(series) {
+  const svg = $("plot"), w = svg.width.baseVal.value, h = svg.height.baseVal.value;
+  svg.innerHTML = "";
+  const points = series.flatMap(s => (s.datapoints || []).filter(p => p[0] !== null));
+  if (!points.length) return;
+  const ts = points.map(p => p[1]), vs = points.map(p => p[0]);
+  const t0 = Math.min(...ts), t1 = Math.max(...ts) || t0 + 1;
+  const v0 = Math.min(0, ...vs), v1 = Math.max(...vs);
+  const x = t => 40 + (w - 50) * (t - t0) / ((t1 - t0) || 1);
+  const y = v => h - 20 - (h - 30) * (v - v0) / ((v1 - v0) || 1);
+  series.forEach((s, i) => {
+    let d = "";
+    (s.datapoints || []).forEach(p => { d += p[0] === null ? " " : (d === "" || d.endsWith(" ") ? "M" : "L") + x(p[1]) + "," + y(p[0]); });
+    const path = document.createElementNS("http://www.w3.org/2000/svg", "path");
+    path.setAttribute("d", d);
+    path.setAttribute("fill", "none");
+    path.setAttribute("stroke", colors[i % colors.length]);
+    svg.appendChild(path);
+  });
+  [[v0, y(v0)], [v1, y(v1)]].forEach(([v, py]) => {
+    const t = document.createElementNS("http://www.w3.org/2000/svg", "text");
+    t.setAttribute("x", 2); t.setAttribute("y", py); t.textContent = +v.toPrecision(4);
+    svg.appendChild(t);
+  });
+}
+
+async function run() {
+  $("error").textContent = "";
+  const resp = await fetch("console", {
+    method: "POST",
+    headers: {"Authorization": "Bearer " + token, "Content-Type": "application/json"},
+    body: JSON.stringify({target: $("target").value, from: $("from").value, to: $("to").value, maxDataPoints: +$("points").value}),
+  });
+  const res = await resp.json();
+  const err = res.error || (resp.ok ? null : res);
+  if (err) $("error").textContent = err.message + (err.hint ? " (" + err.hint + ")" : "");
+  if (!res.timing) return;
+
+  $("timing").textContent = "total " + res.timing.totalMs + " ms, middleware " + res.timing.middlewareMs + " ms, processing " + res.timing.processingMs + " ms";
+  table($("requests"), ["start ms", "duration ms", "status", "url"], res.requests.map(r =>
+    [r.startMs, r.durationMs, r.cached ? "cached" : (r.error || r.status), r.method + " " + r.url]));
+
+  const series = (res.series || []).filter(s => s.datapoints);
+  plot(series);
+  $("series").innerHTML = "";
+  (res.series || []).forEach(s => {
+    const h = document.createElement("h3");
+    h.textContent = s.target || "table";
+    $("series").appendChild(h);
+    const t = document.createElement("table");
+    if (s.datapoints) {
+      table(t, ["time", "value"], s.datapoints.map(p => [new Date(p[1]).toISOString(), p[0]]));
+    } else if (s.columns) {
+      table(t, s.columns.map(c => c.text), s.rows);
+    }
+    $("series").appendChild(t);
+  });
+}
+
+$("run").onclick = run;
+$("target").onkeydown = e => { if (e.key === "Enter" && (e.ctrlKey || e.metaKey)) run(); };
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// conversion converts values to another unit as value * factor + offset
+type conversion struct {
+	factor, offset float64
+}
+
+func (c conversion) apply(v float64) float64 {
+	return v*c.factor + c.offset
+}
+
+// then returns the conversion applying c followed by next
+func (c conversion) then(next conversion) conversion {
+	return conversion{factor: c.factor * next.factor, offset: c.offset*next.factor + next.offset}
+}
+
+// inverse returns the conversion reverting c
+func (c conversion) inverse() conversion {
+	return conversion{factor: 1 / c.factor, offset: -c.offset / c.factor}
+}
+
+// decimalShift returns the digits values are shifted by if the conversion is a power of ten
+func (c conversion) decimalShift() int {
+	if c.offset != 0 || c.factor <= 0 {
+		return 0
+	}
+	e := math.Log10(c.factor)
+	if math.Abs(e-math.Round(e)) > 1e-9 {
+		return 0
+	}
+	return -int(math.Round(e))
+}
+
+// baseConversions convert between base units, their inverses are added on init
+var baseConversions = map[[2]string]conversion{
+	{"°C", "°F"}: {1.8, 32},
+	{"°C", "K"}:  {1, 273.15},
+	{"°F", "K"}:  {1 / 1.8, 273.15 - 32/1.8},
+	{"m³", "l"}:  {1000, 0},
+	{"Wh", "J"}:  {3600, 0},
+	{"VAh", "J"}: {3600, 0},
+}
+
+func init() {
+	for units, c := range baseConversions {
+		baseConversions[[2]string{units[1], units[0]}] = c.inverse()
+	}
+}
+
+// unitAliases are alternative spellings of units
+var unitAliases = map[string]string{"m3": "m³", "L": "l", "degC": "°C", "degF": "°F"}
+
+// splitUnit returns the base unit and prefix exponent of SI units, the unit itself otherwise
+func splitUnit(unit string) (string, int) {
+	if alias, ok := unitAliases[unit]; ok {
+		unit = alias
+	}
+	if base, exp, ok := parseSIUnit(unit); ok {
+		return base, exp
+	}
+	return unit, 0
+}
+
+// convertUnit returns the conversion of values in unit from to unit to, e.g.
+// kWh to MJ or °C to °F
+func convertUnit(from, to string) (conversion, bool) {
+	b1, e1 := splitUnit(from)
+	b2, e2 := splitUnit(to)
+	if b1 == "" || b2 == "" {
+		return conversion{}, false
+	}
+
+	c := conversion{factor: 1}
+	if b1 != b2 {
+		var ok bool
+		if c, ok = baseConversions[[2]string{b1, b2}]; !ok {
+			return conversion{}, false
+		}
+	}
+
+	prefix := conversion{factor: math.Pow10(e1)}
+	return prefix.then(c).then(conversion{factor: math.Pow10(-e2)}), true
+}
+
+// ConversionConfig converts a channel's values to a unit as value * factor + offset,
+// e.g. gas meter m³ to kWh
+type ConversionConfig struct {
+	Factor float64 `yaml:"factor"` // default 1
+	Offset float64 `yaml:"offset"`
+}
+
+// conversion returns the conversion of the channel's values from unit to unit to.
+// Conversions configured for the channel take precedence and can be followed by
+// a unit conversion, e.g. kWh of a gas meter to MWh.
+func (server *Server) conversion(uuid, from, to string) (conversion, bool) {
+	units := server.conf.Channels[uuid].Units
+
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if next, ok := convertUnit(name, to); ok {
+			u := units[name]
+			c := conversion{factor: u.Factor, offset: u.Offset}
+			if c.factor == 0 {
+				c.factor = 1
+			}
+			return c.then(next), true
+		}
+	}
+
+	return convertUnit(from, to)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		from, to  string
+		value     float64
+		converted float64
+	}{
+		{"W", "W", 5, 5},
+		{"W", "kW", 1500, 1.5},
+		{"kWh", "Wh", 1.5, 1500},
+		{"kWh", "MJ", 1, 3.6},
+		{"J", "Wh", 7200, 2},
+		{"°C", "°F", 100, 212},
+		{"degF", "°C", 32, 0},
+		{"K", "°C", 273.15, 0},
+		{"m3", "l", 1.5, 1500},
+		{"L", "m³", 500, 0.5},
+	}
+
+	for _, tc := range tests {
+		c, ok := convertUnit(tc.from, tc.to)
+		if !ok {
+			t.Errorf("%s to %s: no conversion", tc.from, tc.to)
+			continue
+		}
+		if v := c.apply(tc.value); math.Abs(v-tc.converted) > 1e-9 {
+			t.Errorf("%s to %s: expected %v, got %v", tc.from, tc.to, tc.converted, v)
+		}
+	}
+}
+
+func TestConvertUnitUnknown(t *testing.T) {
+	for _, units := range [][2]string{
+		{"W", "Wh"},
+		{"W", "°C"},
+		{"", "W"},
+		{"kWh", ""},
+		{"m³", "kWh"},
+	} {
+		if _, ok := convertUnit(units[0], units[1]); ok {
+			t.Errorf("%s to %s: expected no conversion", units[0], units[1])
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+)
+
+// queryCOP returns the heat pump coefficient of performance as ratio of heat output
+// (target) and electrical input. Standby periods are returned as null values.
+func (server *Server) queryCOP(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	input, ok := target.Data["input"]
+	if !ok {
+		logf(ctx, "cop: missing input channel for %s", target.Target)
+		return dataResponse(target.Target, []Tuple{}, qr), nil
+	}
+
+	data := make(TargetData)
+	for k, v := range target.Data {
+		data[k] = v
+	}
+	if strings.ToLower(data["series"]) == "daily" {
+		data["group"] = "day"
+	}
+
+	standby := target.Data.float("standby", 0)
+
+	series := make([][]Tuple, 2)
+	errs := make([]error, 2)
+	wg := &sync.WaitGroup{}
+
+	for idx, uuid := range []string{target.Target, input} {
+		wg.Add(1)
+
+		go func(idx int, uuid string) {
+			series[idx], errs[idx] = server.getTuples(ctx, uuid, data, qr)
+			wg.Done()
+		}(idx, uuid)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return QueryResponse{}, err
+		}
+	}
+
+	ts, values := alignSeries(series)
+
+	tuples := make([]Tuple, 0, len(ts))
+	for i := range ts {
+		heat, electric := values[0][i], values[1][i]
+
+		cop := math.NaN()
+		if electric > standby && electric > 0 && heat >= 0 {
+			cop = heat / electric
+		}
+
+		tuples = append(tuples, Tuple{Timestamp: ts[i], Value: float32(cop)})
+	}
+
+	return dataResponse(target.Target, tuples, qr), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed 5-field cron expression (minute hour day month weekday)
+type cronSchedule struct {
+	minute, hour, day, month, weekday map[int]bool
+	anyDay, anyWeekday                bool
+}
+
+// parseCronField parses lists, ranges and steps like 1,5-10,*/15 within min..max
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	res := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step: %s", part)
+			}
+			step, part = s, part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value: %s", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value: %s", part)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("value out of range: %s", part)
+		}
+
+		for i := lo; i <= hi; i += step {
+			res[i] = true
+		}
+	}
+
+	return res, nil
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression: %s", expr)
+	}
+
+	var err error
+	cs := &cronSchedule{
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}
+
+	if cs.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if cs.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if cs.day, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if cs.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if cs.weekday, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+
+	// sunday is 0 or 7
+	if cs.weekday[7] {
+		cs.weekday[0] = true
+	}
+
+	return cs, nil
+}
+
+// matchesDay applies cron semantics where either day or weekday must match if both are restricted
+func (cs *cronSchedule) matchesDay(t time.Time) bool {
+	day, weekday := cs.day[t.Day()], cs.weekday[int(t.Weekday())]
+
+	switch {
+	case cs.anyDay && cs.anyWeekday:
+		return true
+	case cs.anyDay:
+		return weekday
+	case cs.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// next returns the first matching time after t
+func (cs *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// search at most 5 years ahead
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !cs.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !cs.hour[t.Hour()] {
+			// next wall clock hour, zones may be offset by half an hour
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if !next.After(t) {
+				// repeated hour when clocks are turned back
+				next = t.Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if !cs.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"minutes", "*/15 * * * *", time.Date(2024, 3, 14, 10, 7, 30, 0, berlin), time.Date(2024, 3, 14, 10, 15, 0, 0, berlin)},
+		{"after match", "0 11 * * *", time.Date(2024, 3, 14, 11, 0, 0, 0, berlin), time.Date(2024, 3, 15, 11, 0, 0, 0, berlin)},
+		{"half hour offset", "0 11 * * *", time.Date(2024, 3, 14, 10, 10, 0, 0, kolkata), time.Date(2024, 3, 14, 11, 0, 0, 0, kolkata)},
+		{"half hour offset minutes", "15 * * * *", time.Date(2024, 3, 14, 10, 20, 0, 0, kolkata), time.Date(2024, 3, 14, 11, 15, 0, 0, kolkata)},
+		{"month", "0 0 1 * *", time.Date(2024, 1, 31, 12, 0, 0, 0, berlin), time.Date(2024, 2, 1, 0, 0, 0, 0, berlin)},
+		{"weekday", "0 9 * * 1", time.Date(2024, 3, 14, 12, 0, 0, 0, berlin), time.Date(2024, 3, 18, 9, 0, 0, 0, berlin)},
+		{"sunday as 7", "0 0 * * 7", time.Date(2024, 3, 14, 12, 0, 0, 0, berlin), time.Date(2024, 3, 17, 0, 0, 0, 0, berlin)},
+		// restricted day and weekday match either
+		{"day or weekday", "0 0 13 * 5", time.Date(2024, 3, 1, 0, 0, 0, 0, berlin), time.Date(2024, 3, 8, 0, 0, 0, 0, berlin)},
+		// 02:30 doesn't exist when clocks are turned forward
+		{"dst forward skipped", "30 2 * * *", time.Date(2024, 3, 31, 0, 0, 0, 0, berlin), time.Date(2024, 4, 1, 2, 30, 0, 0, berlin)},
+		{"dst forward", "0 3 * * *", time.Date(2024, 3, 31, 0, 0, 0, 0, berlin), time.Date(2024, 3, 31, 3, 0, 0, 0, berlin)},
+		{"dst back", "0 3 * * *", time.Date(2024, 10, 27, 0, 0, 0, 0, berlin), time.Date(2024, 10, 27, 3, 0, 0, 0, berlin)},
+		{"never", "0 0 31 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, berlin), time.Time{}},
+	}
+
+	for _, tc := range tests {
+		cs, err := parseCron(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if next := cs.next(tc.from); !next.Equal(tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, next)
+		}
+	}
+}
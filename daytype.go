@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// queryDayType returns the energy in Wh of workdays or, with series holiday, of weekends
+// and holidays per group period. Power is read aggregated by resolution (default hour).
+func (server *Server) queryDayType(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	group := "day"
+	if grp, ok := target.Data["group"]; ok {
+		group = strings.ToLower(grp)
+	}
+
+	resolution := "hour"
+	if res, ok := target.Data["resolution"]; ok {
+		if resolution = strings.ToLower(res); resolution == "raw" {
+			resolution = ""
+		}
+	}
+
+	tuples, err := server.api.getData(ctx, target.Target, qr.Range.From, qr.Range.To, resolution, "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	holiday := strings.ToLower(target.Data["series"]) == "holiday"
+	loc := server.conf.location(target.Target)
+	cal := server.conf.holidayCalendar()
+
+	res := []Tuple{}
+	for i := 1; i < len(tuples); i++ {
+		// each interval is assigned to the day it starts on
+		start := tuples[i-1].Timestamp
+		if cal.holiday(time.Unix(0, start*int64(time.Millisecond)).In(loc)) != holiday {
+			continue
+		}
+
+		ts := roundTimestampMS(start, group, loc)
+		if len(res) == 0 || res[len(res)-1].Timestamp != ts {
+			res = append(res, Tuple{Timestamp: ts})
+		}
+
+		res[len(res)-1].Value += float32(float64(tuples[i].Value) * float64(tuples[i].Timestamp-start) / msPerHour)
+	}
+
+	if target.Data["total"] == "true" {
+		res = []Tuple{Tuple{
+			Timestamp: unixMS(qr.Range.To),
+			Value:     float32(totalEnergy(res)),
+		}}
+	}
+
+	return dataResponse(target.Target, res, qr), nil
+}
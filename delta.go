@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// deltaContentType is the compact tuple format negotiated for data requests
+const deltaContentType = "application/x-vz-delta"
+
+// deltaMagic starts a delta encoded response
+var deltaMagic = []byte("VZD1")
+
+// decodeDelta decodes a delta encoded data response:
+//
+//	"VZD1" | decimals uint8 | consumption float64 (little endian) | count uvarint |
+//	count * (uvarint zigzag(timestamp delta ms) << 1 | null, [varint value delta])
+//
+// Values are integers scaled by 10^decimals, deltas are relative to the previous
+// tuple and to the last non-null value.
+func decodeDelta(b []byte) (DataResponse, error) {
+	dr := DataResponse{}
+
+	if !bytes.HasPrefix(b, deltaMagic) || len(b) < len(deltaMagic)+9 {
+		return dr, errors.New("delta: invalid header")
+	}
+	b = b[len(deltaMagic):]
+
+	scale := math.Pow10(int(b[0]))
+	dr.Data.Consumption = math.Float64frombits(binary.LittleEndian.Uint64(b[1:9]))
+	r := bytes.NewReader(b[9:])
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return dr, fmt.Errorf("delta: %v", err)
+	}
+	if n > uint64(r.Len()) {
+		return dr, errors.New("delta: invalid count")
+	}
+
+	dr.Data.Tuples = make([]Tuple, 0, n)
+	var ts, value int64
+	for i := uint64(0); i < n; i++ {
+		u, err := binary.ReadUvarint(r)
+		if err != nil {
+			return dr, fmt.Errorf("delta: tuple %d: %v", i, err)
+		}
+
+		null := u&1 == 1
+		u >>= 1
+		ts += int64(u>>1) ^ -int64(u&1) // zigzag
+
+		tuple := Tuple{Timestamp: ts, Value: float32(math.NaN())}
+		if !null {
+			d, err := binary.ReadVarint(r)
+			if err != nil {
+				return dr, fmt.Errorf("delta: tuple %d: %v", i, err)
+			}
+			value += d
+			tuple.Value = float32(float64(value) / scale)
+		}
+
+		dr.Data.Tuples = append(dr.Data.Tuples, tuple)
+	}
+
+	return dr, nil
+}
+
+// deltaJSON converts a delta encoded response to the middleware's json format
+func deltaJSON(b []byte) ([]byte, error) {
+	dr, err := decodeDelta(b)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(dr)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeDelta encodes tuples like a fronting service answering with deltaContentType
+func encodeDelta(decimals uint8, consumption float64, tuples []Tuple) []byte {
+	b := append([]byte{}, deltaMagic...)
+	b = append(b, decimals)
+
+	var buf [binary.MaxVarintLen64]byte
+	binary.LittleEndian.PutUint64(buf[:8], math.Float64bits(consumption))
+	b = append(b, buf[:8]...)
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(len(tuples)))]...)
+
+	scale := math.Pow10(int(decimals))
+	var ts, value int64
+	for _, t := range tuples {
+		d := t.Timestamp - ts
+		ts = t.Timestamp
+
+		u := uint64(d<<1^d>>63) << 1 // zigzag
+		null := math.IsNaN(float64(t.Value))
+		if null {
+			u |= 1
+		}
+		b = append(b, buf[:binary.PutUvarint(buf[:], u)]...)
+
+		if !null {
+			v := int64(math.Round(float64(t.Value) * scale))
+			b = append(b, buf[:binary.PutVarint(buf[:], v-value)]...)
+			value = v
+		}
+	}
+
+	return b
+}
+
+func equalTuples(a, b []Tuple) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Timestamp != b[i].Timestamp {
+			return false
+		}
+		if math.IsNaN(float64(a[i].Value)) != math.IsNaN(float64(b[i].Value)) {
+			return false
+		}
+		if !math.IsNaN(float64(a[i].Value)) && a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDecodeDelta(t *testing.T) {
+	nan := float32(math.NaN())
+
+	// 12.5 at 1000, null at 2000, 10 at 1500 with 1 decimal and consumption 1.5
+	b := unhex(t, `
+56 5a 44 31
+01
+00 00 00 00 00 00 f8 3f
+03
+	a0 1f fa 01
+	a1 1f
+	ce 0f 31`)
+
+	dr, err := decodeDelta(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dr.Data.Consumption != 1.5 {
+		t.Errorf("expected consumption 1.5, got %v", dr.Data.Consumption)
+	}
+
+	expected := []Tuple{{1000, 12.5}, {2000, nan}, {1500, 10}}
+	if !equalTuples(dr.Data.Tuples, expected) {
+		t.Errorf("expected %v, got %v", expected, dr.Data.Tuples)
+	}
+
+	if enc := encodeDelta(1, 1.5, expected); string(enc) != string(b) {
+		t.Errorf("expected %x, got %x", b, enc)
+	}
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	nan := float32(math.NaN())
+
+	tests := []struct {
+		name     string
+		decimals uint8
+		tuples   []Tuple
+	}{
+		{"empty", 0, []Tuple{}},
+		{"integers", 0, []Tuple{{1600000000000, 100}, {1600000001000, -5}, {1600000002000, 7}}},
+		{"decimals", 3, []Tuple{{1000, 1.25}, {1001, -0.5}, {5000, 230.125}}},
+		{"nulls", 1, []Tuple{{1000, nan}, {2000, 1}, {3000, nan}, {4000, 0.5}}},
+		{"unordered", 0, []Tuple{{5000, 1}, {1000, 2}, {3000, 3}}},
+	}
+
+	for _, tc := range tests {
+		dr, err := decodeDelta(encodeDelta(tc.decimals, 42, tc.tuples))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !equalTuples(dr.Data.Tuples, tc.tuples) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.tuples, dr.Data.Tuples)
+		}
+		if dr.Data.Consumption != 42 {
+			t.Errorf("%s: expected consumption 42, got %v", tc.name, dr.Data.Consumption)
+		}
+	}
+}
+
+func TestDecodeDeltaInvalid(t *testing.T) {
+	tests := []struct {
+		name, payload string
+	}{
+		{"empty", ""},
+		{"magic", "56 5a 44 32 00 0000000000000000 00"},
+		{"short header", "56 5a 44 31 00 00000000"},
+		{"count", "56 5a 44 31 00 0000000000000000 05 02 02"},
+		{"truncated tuple", "56 5a 44 31 00 0000000000000000 01 a0"},
+		{"missing value", "56 5a 44 31 00 0000000000000000 01 a0 1f"},
+	}
+	for _, tc := range tests {
+		if _, err := decodeDelta(unhex(t, tc.payload)); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestFetchDelta(t *testing.T) {
+	tuples := []Tuple{{1000, 1}, {2000, 2.5}}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept"), deltaContentType) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"version":"0.3","data":{"tuples":[[1000,1,1],[2000,2.5,1]]}}`))
+			return
+		}
+		w.Header().Set("Content-Type", deltaContentType)
+		_, _ = w.Write(encodeDelta(1, 0, tuples))
+	}))
+	defer ts.Close()
+
+	for _, compact := range []bool{false, true} {
+		timeout := time.Second
+		api := newAPI(ts.URL, &timeout, http.DefaultTransport, 0, false)
+		api.compact = compact
+
+		res, err := api.getData(context.Background(), "uuid", time.Unix(0, 0), time.Unix(3, 0), "", "", 0)
+		if err != nil {
+			t.Errorf("compact %v: %v", compact, err)
+			continue
+		}
+		if !equalTuples(res, tuples) {
+			t.Errorf("compact %v: expected %v, got %v", compact, tuples, res)
+		}
+	}
+}
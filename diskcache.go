@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// disk cache counters exposed at /metrics
+var (
+	cacheDiskHits   = &counter{}
+	cacheDiskMisses = &counter{}
+)
+
+// diskSweep is the interval expired disk cache files are removed
+const diskSweep = time.Hour
+
+// diskCache is the persistent second level of the response cache. Responses are
+// stored one file per key, prefixed by a json header line.
+type diskCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// diskHeader is the first line of a cache file
+type diskHeader struct {
+	Key     string `json:"key"`
+	Expires int64  `json:"expires"`
+}
+
+// newDiskCache creates the cache directory and starts removing expired files
+func newDiskCache(dir string, ttl time.Duration) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	c := &diskCache{dir: dir, ttl: ttl}
+	go func() {
+		for {
+			c.sweep(time.Now())
+			time.Sleep(diskSweep)
+		}
+	}()
+
+	return c, nil
+}
+
+// file returns the path of the key's cache file
+func (c *diskCache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// read returns header and body of a cache file
+func (c *diskCache) read(file string) (diskHeader, []byte, error) {
+	var h diskHeader
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return h, nil, err
+	}
+
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return h, nil, os.ErrInvalid
+	}
+	if err := json.Unmarshal(b[:i], &h); err != nil {
+		return h, nil, err
+	}
+
+	return h, b[i+1:], nil
+}
+
+// get returns the cached response of key and its expiry
+func (c *diskCache) get(key string, now time.Time) ([]byte, time.Time, bool) {
+	file := c.file(key)
+
+	h, body, err := c.read(file)
+	if err != nil || h.Key != key {
+		cacheDiskMisses.Add(1)
+		return nil, time.Time{}, false
+	}
+
+	expires := time.Unix(0, h.Expires*int64(time.Millisecond))
+	if now.After(expires) {
+		os.Remove(file)
+		cacheDiskMisses.Add(1)
+		return nil, time.Time{}, false
+	}
+
+	cacheDiskHits.Add(1)
+	return body, expires, true
+}
+
+// set atomically writes the response of key
+func (c *diskCache) set(key string, body []byte, now time.Time) {
+	header, _ := json.Marshal(diskHeader{Key: key, Expires: unixMS(now.Add(c.ttl))})
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-*")
+	if err != nil {
+		log.Printf("cache: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.Write(header)
+	w.WriteByte('\n')
+	w.Write(body)
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		log.Printf("cache: %v", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.Printf("cache: %v", err)
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), c.file(key)); err != nil {
+		log.Printf("cache: %v", err)
+	}
+}
+
+// each calls fn with the path and header of every cache file
+func (c *diskCache) each(fn func(file string, h diskHeader)) {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("cache: %v", err)
+		return
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+
+		file := filepath.Join(c.dir, fi.Name())
+		if h, _, err := c.read(file); err == nil {
+			fn(file, h)
+		}
+	}
+}
+
+// sweep removes expired cache files
+func (c *diskCache) sweep(now time.Time) {
+	c.each(func(file string, h diskHeader) {
+		if h.Expires < unixMS(now) {
+			os.Remove(file)
+		}
+	})
+}
+
+// invalidate removes all cache files of matching keys
+func (c *diskCache) invalidate(match func(key string) bool) int {
+	n := 0
+	c.each(func(file string, h diskHeader) {
+		if match(h.Key) && os.Remove(file) == nil {
+			n++
+		}
+	})
+	return n
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// durationPoint is the value exceeded for fraction of time
+type durationPoint struct {
+	Fraction float64
+	Value    float32
+}
+
+// loadDuration sorts tuples by descending value weighted by the duration of their interval
+func loadDuration(tuples []Tuple) []durationPoint {
+	if len(tuples) < 2 {
+		return []durationPoint{}
+	}
+
+	type weighted struct {
+		duration int64
+		value    float32
+	}
+
+	ws := make([]weighted, 0, len(tuples)-1)
+	var total int64
+	for i := 1; i < len(tuples); i++ {
+		d := tuples[i].Timestamp - tuples[i-1].Timestamp
+		ws = append(ws, weighted{d, tuples[i].Value})
+		total += d
+	}
+
+	if total <= 0 {
+		return []durationPoint{}
+	}
+
+	sort.SliceStable(ws, func(i, j int) bool { return ws[i].value > ws[j].value })
+
+	res := make([]durationPoint, 0, len(ws))
+	var cumulated int64
+	for _, w := range ws {
+		cumulated += w.duration
+		res = append(res, durationPoint{
+			Fraction: float64(cumulated) / float64(total),
+			Value:    w.value,
+		})
+	}
+
+	return res
+}
+
+// queryDuration returns the load duration curve spread over the query range
+func (server *Server) queryDuration(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	tuples, err := server.getTuples(ctx, target.Target, target.Data, qr)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	from := unixMS(qr.Range.From)
+	span := float64(qr.Range.To.Sub(qr.Range.From) / time.Millisecond)
+
+	curve := []Tuple{}
+	for _, p := range loadDuration(tuples) {
+		curve = append(curve, Tuple{
+			Timestamp: from + int64(p.Fraction*span),
+			Value:     p.Value,
+		})
+	}
+
+	return dataResponse(target.Target, curve, qr), nil
+}
+
+// durationTable returns the load duration curve in percent steps
+func (server *Server) durationTable(ctx context.Context, target Target, qr *QueryRequest) (TableResponse, error) {
+	table := TableResponse{
+		Columns: []TableColumn{
+			TableColumn{Text: "Percent", Type: "number"},
+			TableColumn{Text: "Value", Type: "number"},
+		},
+		Rows: [][]interface{}{},
+		Type: "table",
+	}
+
+	tuples, err := server.getTuples(ctx, target.Target, target.Data, qr)
+	if err != nil {
+		return table, err
+	}
+
+	curve := loadDuration(tuples)
+	if len(curve) == 0 {
+		return table, nil
+	}
+
+	i := 0
+	for pct := 0; pct <= 100; pct++ {
+		for i < len(curve)-1 && curve[i].Fraction < float64(pct)/100 {
+			i++
+		}
+		table.Rows = append(table.Rows, []interface{}{pct, curve[i].Value})
+	}
+
+	return table, nil
+}
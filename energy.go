@@ -0,0 +1,83 @@
+package main
+
+import "time"
+
+// msPerHour converts W*ms to Wh
+const msPerHour = 3600 * 1000
+
+// cumulativeEnergy integrates power tuples into the energy in Wh at each tuple's timestamp.
+// Each tuple's value is the average power of the interval ending at its timestamp.
+func cumulativeEnergy(tuples []Tuple) []float64 {
+	res := make([]float64, len(tuples))
+	for i := 1; i < len(tuples); i++ {
+		dt := float64(tuples[i].Timestamp - tuples[i-1].Timestamp)
+		res[i] = res[i-1] + float64(tuples[i].Value)*dt/msPerHour
+	}
+	return res
+}
+
+// rollingAverage returns the time-weighted average power over the window ending at
+// each tuple. Tuples without a full window of history are skipped.
+func rollingAverage(tuples []Tuple, window int64) []Tuple {
+	res := make([]Tuple, 0, len(tuples))
+	if len(tuples) < 2 || window <= 0 {
+		return res
+	}
+
+	energy := cumulativeEnergy(tuples)
+
+	k := 1
+	for i := 1; i < len(tuples); i++ {
+		start := tuples[i].Timestamp - window
+		if start < tuples[0].Timestamp {
+			continue
+		}
+
+		// find interval k containing window start
+		for tuples[k].Timestamp < start {
+			k++
+		}
+
+		startEnergy := energy[k-1] + float64(tuples[k].Value)*float64(start-tuples[k-1].Timestamp)/msPerHour
+
+		res = append(res, Tuple{
+			Timestamp: tuples[i].Timestamp,
+			Value:     float32((energy[i] - startEnergy) * msPerHour / float64(window)),
+		})
+	}
+
+	return res
+}
+
+// bucketEnergy integrates power tuples into energy per group period in loc, separately
+// for positive and negative power. Negative energy is returned as positive value.
+func bucketEnergy(tuples []Tuple, group string, loc *time.Location) ([]Tuple, []Tuple) {
+	pos, neg := []Tuple{}, []Tuple{}
+
+	for i := 1; i < len(tuples); i++ {
+		ts := roundTimestampMS(tuples[i].Timestamp, group, loc)
+		energy := float64(tuples[i].Value) * float64(tuples[i].Timestamp-tuples[i-1].Timestamp) / msPerHour
+
+		if len(pos) == 0 || pos[len(pos)-1].Timestamp != ts {
+			pos = append(pos, Tuple{Timestamp: ts})
+			neg = append(neg, Tuple{Timestamp: ts})
+		}
+
+		if energy > 0 {
+			pos[len(pos)-1].Value += float32(energy)
+		} else {
+			neg[len(neg)-1].Value -= float32(energy)
+		}
+	}
+
+	return pos, neg
+}
+
+// totalEnergy sums energy tuples
+func totalEnergy(tuples []Tuple) float64 {
+	var sum float64
+	for _, tuple := range tuples {
+		sum += float64(tuple.Value)
+	}
+	return sum
+}
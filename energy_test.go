@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBucketEnergy(t *testing.T) {
+	loc := time.UTC
+	h := func(d, hour int) int64 { return unixMS(time.Date(2024, 3, d, hour, 0, 0, 0, loc)) }
+
+	// charging at 1000 W and discharging at 500 W for an hour each, Monday and Tuesday
+	// of one week and the Monday of the next
+	tuples := []Tuple{
+		{h(11, 0), 0}, {h(11, 1), 1000}, {h(11, 2), -500},
+		{h(12, 0), 0}, {h(12, 1), 1000},
+		{h(18, 0), 0}, {h(18, 1), -500},
+	}
+
+	tests := []struct {
+		group     string
+		charge    []Tuple
+		discharge []Tuple
+	}{
+		{
+			group:     "week",
+			charge:    []Tuple{{h(11, 0), 2000}, {h(18, 0), 0}},
+			discharge: []Tuple{{h(11, 0), 500}, {h(18, 0), 500}},
+		},
+		{
+			group:     "year",
+			charge:    []Tuple{{unixMS(time.Date(2024, 1, 1, 0, 0, 0, 0, loc)), 2000}},
+			discharge: []Tuple{{unixMS(time.Date(2024, 1, 1, 0, 0, 0, 0, loc)), 1000}},
+		},
+		{
+			group:     "day",
+			charge:    []Tuple{{h(11, 0), 1000}, {h(12, 0), 1000}, {h(18, 0), 0}},
+			discharge: []Tuple{{h(11, 0), 500}, {h(12, 0), 0}, {h(18, 0), 500}},
+		},
+	}
+
+	for _, tc := range tests {
+		charge, discharge := bucketEnergy(tuples, tc.group, loc)
+		if !reflect.DeepEqual(charge, tc.charge) {
+			t.Errorf("%s: expected charge %v, got %v", tc.group, tc.charge, charge)
+		}
+		if !reflect.DeepEqual(discharge, tc.discharge) {
+			t.Errorf("%s: expected discharge %v, got %v", tc.group, tc.discharge, discharge)
+		}
+	}
+}
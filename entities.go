@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// entitySnapshot is the last known entity list persisted to disk
+type entitySnapshot struct {
+	Saved    time.Time `json:"saved"`
+	Entities []Entity  `json:"entities"`
+}
+
+// saveEntities atomically writes the entity list to file
+func saveEntities(file string, entities []Entity) error {
+	b, err := json.Marshal(entitySnapshot{Saved: time.Now(), Entities: entities})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadEntities reads the entity list persisted by saveEntities
+func loadEntities(file string) (entitySnapshot, error) {
+	var snapshot entitySnapshot
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return snapshot, err
+	}
+
+	err = json.Unmarshal(b, &snapshot)
+	return snapshot, err
+}
+
+// refreshEntities fetches the entity tree, rebuilds the entity cache and aliases and
+// returns the tree and flattened entities. The previous entities are kept if the
+// middleware fails.
+func (server *Server) refreshEntities(ctx context.Context) ([]Entity, []Entity) {
+	public := server.publicEntities(ctx)
+
+	server.mu.Lock()
+	if public != nil {
+		server.tree = public
+		server.entities = make([]Entity, 0)
+		server.flattenEntities(&server.entities, public, "")
+		server.populateCache(server.entities)
+		server.groups = flattenGroups(public, "")
+	}
+	tree, entities, groups := server.tree, server.entities, server.groups
+	server.mu.Unlock()
+
+	// group paths can be used as target names like channel titles, as can the
+	// titles of earlier versions
+	names := append(append([]Entity{}, entities...), groups...)
+	server.aliases.update(append(names, legacyTitles(tree, "")...))
+	return tree, entities
+}
+
+// cachedEntities returns the entity tree and flattened entities of the last refresh,
+// fetching them if not available yet
+func (server *Server) cachedEntities(ctx context.Context) ([]Entity, []Entity) {
+	server.mu.Lock()
+	tree, entities := server.tree, server.entities
+	server.mu.Unlock()
+
+	if tree == nil {
+		return server.refreshEntities(ctx)
+	}
+	return tree, entities
+}
+
+// runEntityRefresh refreshes the entities every interval
+func (server *Server) runEntityRefresh(interval time.Duration) {
+	for range time.Tick(interval) {
+		server.refreshEntities(context.Background())
+	}
+}
+
+// ReloadResponse reports the entities after POST /admin/reload-entities
+type ReloadResponse struct {
+	Entities int `json:"entities"`
+	Aliases  int `json:"aliases"`
+}
+
+// reloadHandler drops the cached entity list and fetches it again, e.g. after
+// channels were added or renamed
+func (server *Server) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, server.invalidateToken) {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusUnauthorized,
+			Code:    "unauthorized",
+			Message: "invalid token",
+		})
+		return
+	}
+
+	server.api.cache.invalidate(func(key string) bool { return key == "/entity.json" })
+	_, entities := server.refreshEntities(r.Context())
+	logf(r.Context(), "reloaded %d entities", len(entities))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ReloadResponse{Entities: len(entities), Aliases: len(server.aliases.list())}); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
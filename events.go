@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// EventConfig designates channels whose tuples are events, e.g. door openings or
+// boiler errors logged by vzlogger, shown as annotations with templated title and text
+type EventConfig struct {
+	Channels []string `yaml:"channels"` // uuids or aliases
+	Title    string   `yaml:"title"`    // template, default channel title
+	Text     string   `yaml:"text"`     // template, default label and time
+	Tags     []string `yaml:"tags"`
+	Ignore   []string `yaml:"ignore"` // values or labels not shown, e.g. "0"
+
+	title, text *template.Template
+}
+
+// eventData are the fields available in event templates
+type eventData struct {
+	Name    string
+	Channel string // channel title
+	UUID    string
+	Value   float64
+	Label   string // state label of the value, the value without states
+	Time    string
+}
+
+// parse compiles the title and text templates
+func (e *EventConfig) parse(name string) error {
+	var err error
+	if e.title, err = template.New(name + " title").Parse(e.Title); err != nil {
+		return fmt.Errorf("title: %v", err)
+	}
+	if e.text, err = template.New(name + " text").Parse(e.Text); err != nil {
+		return fmt.Errorf("text: %v", err)
+	}
+	return nil
+}
+
+// render executes t, returning def if the template is empty or fails
+func render(t *template.Template, def string, d eventData) string {
+	var sb strings.Builder
+	if err := t.Execute(&sb, d); err != nil {
+		return def
+	}
+	if s := strings.TrimSpace(sb.String()); s != "" {
+		return s
+	}
+	return def
+}
+
+// ignored checks if the event with value and label is not shown
+func (e *EventConfig) ignored(value float64, label string) bool {
+	v := strconv.FormatFloat(value, 'g', -1, 32)
+	for _, s := range e.Ignore {
+		if s == v || strings.EqualFold(s, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEvents parses the event templates
+func (conf Config) validateEvents() error {
+	for name, e := range conf.Events {
+		if len(e.Channels) == 0 {
+			return fmt.Errorf("event %s: missing channels", name)
+		}
+		if err := e.parse(name); err != nil {
+			return fmt.Errorf("event %s: %v", name, err)
+		}
+		conf.Events[name] = e
+	}
+	return nil
+}
+
+// eventStreams returns the event streams selected by `events`, or the target channel
+// with the `title` and `text` templates, defaulting to all configured streams
+func (server *Server) eventStreams(target Target) (map[string]EventConfig, error) {
+	if names, ok := target.Data["events"]; ok {
+		res := make(map[string]EventConfig)
+		for _, name := range strings.Split(names, ",") {
+			e, ok := server.conf.Events[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown events: %s", name)
+			}
+			res[strings.TrimSpace(name)] = e
+		}
+		return res, nil
+	}
+
+	if target.Target != "" {
+		e := EventConfig{Channels: []string{target.Target}, Title: target.Data["title"], Text: target.Data["text"]}
+		if s := target.Data["ignore"]; s != "" {
+			e.Ignore = strings.Split(s, ",")
+		}
+		if err := e.parse("query"); err != nil {
+			return nil, err
+		}
+		return map[string]EventConfig{"": e}, nil
+	}
+
+	return server.conf.Events, nil
+}
+
+// eventAnnotations returns the tuples of event channels as annotations tagged
+// event, the configured stream name, the label and the configured tags
+func (server *Server) eventAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	streams, err := server.eventStreams(target)
+	if err != nil {
+		return nil, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_annotation",
+			Message: err.Error(),
+			Hint:    `e.g. {"context": "events", "events": "door"} or {"target": "<uuid>", "context": "events", "text": "{{.Label}}"}`,
+		}
+	}
+
+	res := []AnnotationResponse{}
+	for name, e := range streams {
+		for _, uuid := range e.Channels {
+			if resolved, ok := server.resolveAlias(ctx, uuid); ok {
+				uuid = resolved
+			}
+
+			tuples, err := server.api.getData(ctx, uuid, ar.Range.From, ar.Range.To, "", "", 0)
+			if err != nil {
+				return nil, err
+			}
+
+			title := server.channelTitle(ctx, uuid)
+			states := server.conf.Channels[uuid].States
+
+			for _, t := range tuples {
+				if math.IsNaN(float64(t.Value)) {
+					continue
+				}
+
+				d := eventData{
+					Name:    name,
+					Channel: title,
+					UUID:    uuid,
+					Value:   float64(t.Value),
+					Label:   strconv.FormatFloat(float64(t.Value), 'g', -1, 32),
+					Time:    formatMS(t.Timestamp),
+				}
+				if len(states) > 0 {
+					d.Label = stateLabel(states, t.Value)
+				}
+				if e.ignored(d.Value, d.Label) {
+					continue
+				}
+
+				tags := []string{"event"}
+				if name != "" {
+					tags = append(tags, name)
+				}
+				tags = append(append(tags, d.Label), e.Tags...)
+
+				res = append(res, AnnotationResponse{
+					Annotation: ar.Annotation,
+					Time:       t.Timestamp,
+					Title:      render(e.title, title, d),
+					Tags:       strings.Join(tags, ","),
+					Text:       render(e.text, fmt.Sprintf("%s at %s", d.Label, d.Time), d),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Time < res[j].Time })
+
+	return res, nil
+}
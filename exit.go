@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// exit codes of the sub commands
+const (
+	exitOK          = 0
+	exitError       = 1
+	exitConfig      = 2 // invalid flags or configuration
+	exitUnreachable = 3 // middleware not reachable
+	exitPartial     = 4 // some channels failed
+	exitAuth        = 5 // middleware rejected credentials
+)
+
+var exitKinds = map[int]string{
+	exitError:       "error",
+	exitConfig:      "config",
+	exitUnreachable: "unreachable",
+	exitPartial:     "partial",
+	exitAuth:        "auth",
+}
+
+// cliError assigns an exit code to an error
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string {
+	return e.err.Error()
+}
+
+func (e *cliError) Unwrap() error {
+	return e.err
+}
+
+// configError marks err as caused by invalid flags or configuration
+func configError(format string, a ...interface{}) error {
+	return &cliError{exitConfig, fmt.Errorf(format, a...)}
+}
+
+// exitCode classifies err into one of the exit codes
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		switch se.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return exitAuth
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return exitUnreachable
+		}
+		return exitError
+	}
+
+	var ne net.Error
+	if errors.Is(err, ErrMiddlewareUnavailable) || errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) {
+		return exitUnreachable
+	}
+
+	return exitError
+}
+
+// exitWithError prints err as text or json to stderr and exits with its exit code
+func exitWithError(err error, output string) {
+	code := exitCode(err)
+	if code == exitOK {
+		return
+	}
+
+	if output == "json" {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Code    int    `json:"code"`
+			Kind    string `json:"kind"`
+			Message string `json:"message"`
+		}{code, exitKinds[code], err.Error()})
+	} else {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	}
+
+	os.Exit(code)
+}
+
+// channelError summarizes the errors of failed channels. If all channels failed
+// the first error is returned, otherwise the result is partial.
+func channelError(errs []error, total int) error {
+	switch {
+	case len(errs) == 0:
+		return nil
+	case len(errs) == total:
+		return errs[0]
+	default:
+		return &cliError{exitPartial, fmt.Errorf("%d of %d channels failed", len(errs), total)}
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvLocale describes locale specific csv formatting
+type csvLocale struct {
+	Delimiter  rune
+	Decimal    string
+	TimeFormat string
+	Header     []string
+	Unit       string // header of the unit column
+}
+
+var csvLocales = map[string]csvLocale{
+	"en": {',', ".", "2006-01-02 15:04:05", []string{"Channel", "Time", "Value"}, "Unit"},
+	"de": {';', ",", "02.01.2006 15:04:05", []string{"Kanal", "Zeit", "Wert"}, "Einheit"},
+}
+
+// sources of exported series
+const (
+	sourceChannel = "channel"
+	sourceVirtual = "virtual"
+	sourceSaved   = "saved"
+)
+
+// exportSeries is a single channel's data to export
+type exportSeries struct {
+	UUID     string
+	Title    string
+	Location *time.Location
+	Unit     string
+	Tuples   []Tuple
+	Source   string     // channel, virtual or saved
+	Data     TargetData // settings the series was read with
+}
+
+// formatValue formats v using locale's decimal separator and given decimals (-1 for shortest)
+func (l csvLocale) formatValue(v float32, decimals int) string {
+	s := strconv.FormatFloat(float64(v), 'f', decimals, 32)
+	if l.Decimal != "." {
+		s = strings.Replace(s, ".", l.Decimal, 1)
+	}
+	return s
+}
+
+// writeCSV writes series as rows of channel, time and value, followed by the unit if units is set
+func writeCSV(w io.Writer, series []exportSeries, locale csvLocale, decimals int, units bool) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = locale.Delimiter
+
+	header := locale.Header
+	if units {
+		header = append(header[:len(header):len(header)], locale.Unit)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, s := range series {
+		title := s.Title
+		if title == "" {
+			title = s.UUID
+		}
+
+		loc := s.Location
+		if loc == nil {
+			loc = time.Local
+		}
+
+		for _, tuple := range s.Tuples {
+			ts := time.Unix(0, tuple.Timestamp*int64(time.Millisecond)).In(loc)
+			row := []string{title, ts.Format(locale.TimeFormat), locale.formatValue(tuple.Value, decimals)}
+			if units {
+				row = append(row, s.Unit)
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportContentTypes are the mime types of the export formats
+var exportContentTypes = map[string]string{
+	"csv":  "text/csv",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// writeExport writes series as csv or xlsx, xlsx always includes units
+func writeExport(w io.Writer, format string, series []exportSeries, locale csvLocale, decimals int, units bool) error {
+	if format == "xlsx" {
+		return writeXLSX(w, series, append(locale.Header[:len(locale.Header):len(locale.Header)], locale.Unit), decimals)
+	}
+	return writeCSV(w, series, locale, decimals, units)
+}
+
+// exportName is the file name of an export of the range starting at from
+func exportName(from time.Time, format string) string {
+	return fmt.Sprintf("gravo-%s.%s", from.Format("20060102"), format)
+}
+
+// writeExportBundle writes the export as zip archive including the manifest
+func writeExportBundle(w io.Writer, name string, series []exportSeries, locale csvLocale, decimals int, units bool, m ExportManifest) error {
+	var buf bytes.Buffer
+	if err := writeExport(&buf, m.Format, series, locale, decimals, units); err != nil {
+		return err
+	}
+	return writeBundle(w, name, buf.Bytes(), m)
+}
+
+// exportCommand exports channel data as csv or xlsx
+func exportCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuids := fs.String("uuid", "", "comma-separated channel uuids")
+	from := fs.String("from", "-24h", "range start (epoch ms, ISO 8601, now or relative duration)")
+	to := fs.String("to", "now", "range end (epoch ms, ISO 8601, now or relative duration)")
+	group := fs.String("group", "", "middleware aggregation level")
+	preset := fs.String("preset", "", "middleware settings preset from config")
+	saved := fs.String("saved", "", "saved query from config exported instead of channels")
+	out := fs.String("out", "", "output file or s3://<key> (default stdout)")
+	configFile := fs.String("config", "", "yaml configuration file providing presets and s3 settings")
+	locale := fs.String("locale", "en", "csv locale (en, de)")
+	delimiter := fs.String("delimiter", "", "field delimiter overriding locale")
+	decimal := fs.String("decimal", "", "decimal separator overriding locale")
+	timeFormat := fs.String("timeformat", "", "go time format overriding locale")
+	decimals := fs.Int("decimals", -1, "decimal places (-1 for full precision)")
+	format := fs.String("format", "csv", "output format (csv, xlsx)")
+	units := fs.Bool("units", false, "add a unit column to csv output")
+	bundle := fs.Bool("bundle", false, "write a zip archive of the export and a manifest of its sources and settings")
+	fs.Parse(args)
+
+	if *uuids == "" && *saved == "" {
+		return configError("missing uuid or saved query")
+	}
+
+	l, ok := csvLocales[*locale]
+	if !ok {
+		return configError("invalid locale: %s", *locale)
+	}
+	if *format != "csv" && *format != "xlsx" {
+		return configError("invalid format: %s", *format)
+	}
+	if *delimiter != "" {
+		l.Delimiter = []rune(*delimiter)[0]
+	}
+	if *decimal != "" {
+		l.Decimal = *decimal
+	}
+	if *timeFormat != "" {
+		l.TimeFormat = *timeFormat
+	}
+
+	var conf Config
+	var err error
+	if *configFile != "" {
+		if conf, err = loadConfig(*configFile); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(fs, conf); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+	}
+
+	f, err := parseTime(*from, conf.defaultLocation())
+	if err != nil {
+		return configError("invalid from: %v", err)
+	}
+	t, err := parseTime(*to, conf.defaultLocation())
+	if err != nil {
+		return configError("invalid to: %v", err)
+	}
+
+	ctx, trace := withTrace(context.Background())
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+	api.overlay = conf.metadataOverlay()
+	api.tokens = conf.channelTokens()
+	server := newServer(api, conf, "", nil)
+
+	var channels []string
+	if *uuids != "" {
+		channels = strings.Split(*uuids, ",")
+	}
+	series := []exportSeries{}
+	errs := []error{}
+	var failed []string
+
+	if *saved != "" {
+		if _, ok := conf.Queries[*saved]; !ok {
+			return configError("unknown saved query: %s", *saved)
+		}
+		res, err := server.savedSeries(ctx, *saved, f, t)
+		if err != nil {
+			return err
+		}
+		series = append(series, res...)
+	}
+
+	for _, uuid := range channels {
+		uuid = strings.TrimSpace(uuid)
+		p := conf.preset(uuid, classExport, *preset)
+
+		g := p.Group
+		if *group != "" {
+			g = *group
+		}
+
+		entity, err := api.getEntity(ctx, uuid)
+		if err == nil {
+			var tuples []Tuple
+			if tuples, err = api.getData(ctx, uuid, f, t, g, p.Options, 0); err == nil {
+				series = append(series, exportSeries{
+					UUID:     uuid,
+					Title:    entity.Title,
+					Location: conf.location(uuid),
+					Unit:     entity.unit(),
+					Tuples:   tuples,
+					Source:   sourceChannel,
+					Data:     TargetData{"group": g, "options": p.Options},
+				})
+				continue
+			}
+		}
+
+		log.Printf("export %s failed: %v", uuid, err)
+		errs = append(errs, err)
+		failed = append(failed, uuid+": "+selfTestDetail(uuid, err))
+	}
+
+	// the saved query counts as one more channel
+	total := len(channels)
+	if *saved != "" {
+		total++
+	}
+
+	if len(errs) == total {
+		return errs[0]
+	}
+
+	write := func(w io.Writer) error {
+		return writeExport(w, *format, series, l, *decimals, *units)
+	}
+	contentType := exportContentTypes[*format]
+	if *bundle {
+		m := server.exportManifest(series, *saved, Range{From: f, To: t}, trace.list())
+		m.Format, m.Locale, m.Decimals = *format, *locale, *decimals
+		m.Errors = failed
+		write = func(w io.Writer) error {
+			return writeExportBundle(w, exportName(f, *format), series, l, *decimals, *units, m)
+		}
+		contentType = "application/zip"
+	}
+
+	if strings.HasPrefix(*out, "s3://") {
+		if !conf.S3.configured() {
+			return configError("s3 not configured")
+		}
+
+		var buf bytes.Buffer
+		if err := write(&buf); err != nil {
+			return err
+		}
+
+		if err := conf.S3.putObject(strings.TrimPrefix(*out, "s3://"), buf.Bytes(), contentType); err != nil {
+			return err
+		}
+		return channelError(errs, total)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := write(w); err != nil {
+		return err
+	}
+
+	return channelError(errs, total)
+}
+
+// exportHandler downloads the channels given by `uuid` (uuids, aliases or virtual
+// channels) or the `saved` query as csv or xlsx with units
+func (server *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	qr, err := savedParams(r, server.conf.defaultLocation())
+	if err == nil && q.Get("uuid") == "" && q.Get("saved") == "" {
+		err = errors.New("missing uuid or saved query")
+	}
+
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = "csv"
+	}
+	if _, ok := exportContentTypes[format]; err == nil && !ok {
+		err = fmt.Errorf("invalid format: %s", format)
+	}
+
+	locale, ok := csvLocales[q.Get("locale")]
+	if q.Get("locale") == "" {
+		locale, ok = csvLocales["en"], true
+	}
+	if err == nil && !ok {
+		err = fmt.Errorf("invalid locale: %s", q.Get("locale"))
+	}
+
+	decimals := -1
+	if s := q.Get("decimals"); s != "" && err == nil {
+		if decimals, err = strconv.Atoi(s); err != nil {
+			err = fmt.Errorf("invalid decimals: %s", s)
+		}
+	}
+
+	if err != nil {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: err.Error(),
+			Hint:    "e.g. /export?uuid=<uuid>&from=-168h&to=now&format=xlsx",
+		})
+		return
+	}
+
+	ctx, cancel := server.queryContext(r)
+	defer cancel()
+
+	ctx, trace := withTrace(ctx)
+	series, target, err := server.exportData(ctx, q, qr)
+	if err != nil {
+		writeQueryError(w, queryError(target, err))
+		return
+	}
+
+	name := exportName(qr.Range.From, format)
+	if bundle, _ := strconv.ParseBool(q.Get("bundle")); bundle {
+		m := server.exportManifest(series, q.Get("saved"), qr.Range, trace.list())
+		m.Format, m.Locale, m.Decimals = format, q.Get("locale"), decimals
+		if m.Locale == "" {
+			m.Locale = "en"
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, strings.TrimSuffix(name, "."+format)))
+		if err := writeExportBundle(w, name, series, locale, decimals, true, m); err != nil {
+			logf(ctx, "export failed: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	if err := writeExport(w, format, series, locale, decimals, true); err != nil {
+		logf(ctx, "export failed: %v", err)
+	}
+}
+
+// exportData fetches the series of the export request, returning the failed target on error
+func (server *Server) exportData(ctx context.Context, q neturl.Values, qr *QueryRequest) ([]exportSeries, Target, error) {
+	series := []exportSeries{}
+
+	if name := q.Get("saved"); name != "" {
+		target := Target{Target: savedPrefix + name}
+		if _, ok := server.conf.Queries[name]; !ok {
+			return nil, target, &QueryError{
+				Status:  http.StatusNotFound,
+				Code:    "not_found",
+				Message: fmt.Sprintf("unknown saved query: %s", name),
+			}
+		}
+
+		res, err := server.savedSeries(ctx, name, qr.Range.From, qr.Range.To)
+		if err != nil {
+			return nil, target, err
+		}
+		series = append(series, res...)
+	}
+
+	for _, uuid := range strings.Split(q.Get("uuid"), ",") {
+		if uuid = strings.TrimSpace(uuid); uuid == "" {
+			continue
+		}
+
+		title := uuid
+		if resolved, ok := server.resolveAlias(ctx, uuid); ok && resolved != uuid {
+			uuid = resolved
+		} else if entity, ok := server.cachedEntity(uuid); ok {
+			title = entity.Title
+		}
+
+		p := server.conf.preset(uuid, classExport, q.Get("preset"))
+		data := TargetData{"group": p.Group, "options": p.Options}
+		if g := q.Get("group"); g != "" {
+			data["group"] = g
+		}
+
+		tuples, err := server.getTuples(ctx, uuid, data, qr)
+		if err != nil {
+			return nil, Target{Target: uuid, Data: data}, err
+		}
+
+		source := sourceChannel
+		if _, ok := server.conf.Virtual[uuid]; ok {
+			source = sourceVirtual
+		}
+
+		series = append(series, exportSeries{
+			UUID:     uuid,
+			Title:    title,
+			Location: server.conf.location(uuid),
+			Unit:     server.entityUnit(uuid),
+			Tuples:   tuples,
+			Source:   source,
+			Data:     data,
+		})
+	}
+
+	return series, Target{}, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed arithmetic expression over named values
+type expr interface {
+	eval(vars map[string]float64) float64
+}
+
+type (
+	exprNum    float64
+	exprVar    string
+	exprNeg    struct{ x expr }
+	exprBinary struct {
+		op   byte
+		x, y expr
+	}
+	exprCall struct {
+		fn   string
+		args []expr
+	}
+)
+
+// exprFuncs are the functions available in expressions with their number of arguments, -1 for any
+var exprFuncs = map[string]int{"min": -1, "max": -1, "abs": 1}
+
+func (e exprNum) eval(vars map[string]float64) float64 { return float64(e) }
+func (e exprVar) eval(vars map[string]float64) float64 { return vars[string(e)] }
+func (e exprNeg) eval(vars map[string]float64) float64 { return -e.x.eval(vars) }
+
+func (e exprBinary) eval(vars map[string]float64) float64 {
+	x, y := e.x.eval(vars), e.y.eval(vars)
+	switch e.op {
+	case '+':
+		return x + y
+	case '-':
+		return x - y
+	case '*':
+		return x * y
+	default:
+		return x / y
+	}
+}
+
+func (e exprCall) eval(vars map[string]float64) float64 {
+	res := e.args[0].eval(vars)
+	for _, arg := range e.args[1:] {
+		switch v := arg.eval(vars); e.fn {
+		case "min":
+			res = math.Min(res, v)
+		case "max":
+			res = math.Max(res, v)
+		}
+	}
+	if e.fn == "abs" {
+		res = math.Abs(res)
+	}
+	return res
+}
+
+// exprParser is a recursive descent parser of + - * / with parentheses, unary minus,
+// numbers, variables and function calls
+type exprParser struct {
+	s    string
+	pos  int
+	vars []string
+}
+
+// parseExpr parses s and returns the expression and the names of its variables
+func parseExpr(s string) (expr, []string, error) {
+	p := &exprParser{s: s}
+	e, err := p.sum()
+	if err == nil && p.skip() < len(p.s) {
+		err = fmt.Errorf("unexpected %q at %d", p.s[p.pos], p.pos+1)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return e, p.vars, nil
+}
+
+// skip skips white space and returns the position of the next token
+func (p *exprParser) skip() int {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+	return p.pos
+}
+
+// next consumes the operator c if it is the next token
+func (p *exprParser) next(c byte) bool {
+	if p.skip() < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) sum() (expr, error) {
+	x, err := p.product()
+	for err == nil {
+		var op byte
+		if p.next('+') {
+			op = '+'
+		} else if p.next('-') {
+			op = '-'
+		} else {
+			break
+		}
+
+		var y expr
+		if y, err = p.product(); err == nil {
+			x = exprBinary{op, x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) product() (expr, error) {
+	x, err := p.unary()
+	for err == nil {
+		var op byte
+		if p.next('*') {
+			op = '*'
+		} else if p.next('/') {
+			op = '/'
+		} else {
+			break
+		}
+
+		var y expr
+		if y, err = p.unary(); err == nil {
+			x = exprBinary{op, x, y}
+		}
+	}
+	return x, err
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if p.next('-') {
+		x, err := p.unary()
+		return exprNeg{x}, err
+	}
+	return p.operand()
+}
+
+func (p *exprParser) operand() (expr, error) {
+	if p.next('(') {
+		x, err := p.sum()
+		if err == nil && !p.next(')') {
+			err = fmt.Errorf("missing ) at %d", p.pos+1)
+		}
+		return x, err
+	}
+
+	start := p.skip()
+	if start == len(p.s) {
+		return nil, fmt.Errorf("unexpected end")
+	}
+
+	c := rune(p.s[start])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.s) && (unicode.IsDigit(rune(p.s[p.pos])) || p.s[p.pos] == '.') {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", p.s[start:p.pos])
+		}
+		return exprNum(f), nil
+
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.s) && (unicode.IsLetter(rune(p.s[p.pos])) || unicode.IsDigit(rune(p.s[p.pos])) || p.s[p.pos] == '_') {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+
+		if !p.next('(') {
+			p.vars = append(p.vars, name)
+			return exprVar(name), nil
+		}
+
+		fn := strings.ToLower(name)
+		n, ok := exprFuncs[fn]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", name)
+		}
+
+		var args []expr
+		for {
+			arg, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.next(')') {
+				break
+			}
+			if !p.next(',') {
+				return nil, fmt.Errorf("missing ) at %d", p.pos+1)
+			}
+		}
+		if n >= 0 && len(args) != n {
+			return nil, fmt.Errorf("%s expects %d argument", fn, n)
+		}
+		return exprCall{fn, args}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", c, start+1)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// fanOut calls fn for indexes 0..n-1 with at most limit calls running concurrently
+// and waits for all of them. Unlimited if limit is not positive. Indexes not started
+// before ctx is done are passed to cancelled instead.
+func fanOut(ctx context.Context, n, limit int, fn func(idx int), cancelled func(idx int, err error)) {
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+
+	wg := &sync.WaitGroup{}
+	for idx := 0; idx < n; idx++ {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				cancelled(idx, ctx.Err())
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			fn(idx)
+		}(idx)
+	}
+	wg.Wait()
+}
+
+// targetErrors combines the errors of several failed targets into a single error
+// using status and code of the first
+func targetErrors(errs []*QueryError) *QueryError {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+
+	messages := make([]string, 0, len(errs))
+	for _, qe := range errs {
+		messages = append(messages, qe.Target+": "+qe.Message)
+	}
+
+	status := errs[0].Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	return &QueryError{
+		Status:  status,
+		Code:    errs[0].Code,
+		Message: strings.Join(messages, "; "),
+		Hint:    errs[0].Hint,
+		Errors:  errs,
+	}
+}
+
+// seriesError returns the error of a series returned by errorSeries, nil otherwise
+func seriesError(qres QueryResponse) error {
+	if qres.Meta == nil {
+		return nil
+	}
+	for _, n := range qres.Meta.Notices {
+		if n.Severity == "error" {
+			return fmt.Errorf("%v: %s", qres.Target, n.Text)
+		}
+	}
+	return nil
+}
+
+// errorSeries returns an empty series of the failed target carrying its error as
+// Grafana notice, such that the remaining targets of the query are still shown
+func errorSeries(qe *QueryError) QueryResponse {
+	text := qe.Message
+	if qe.Hint != "" {
+		text += " (" + qe.Hint + ")"
+	}
+	return QueryResponse{
+		Target:     qe.Target,
+		Datapoints: []ResponseTuple{},
+		Meta: &ResponseMeta{
+			Notices: []ResponseNotice{{Severity: "error", Text: text}},
+			Custom:  map[string]interface{}{"code": qe.Code},
+		},
+	}
+}
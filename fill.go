@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// fillMaxPoints limits the datapoints of a filled series, coarsening the fill interval
+const fillMaxPoints = 10000
+
+// groupIntervals are the longest periods of middleware groups, shorter gaps are not filled
+var groupIntervals = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    25 * time.Hour,
+	"week":   7*24*time.Hour + time.Hour,
+	"month":  31 * 24 * time.Hour,
+	"year":   366 * 24 * time.Hour,
+}
+
+// validFills are the `fill` modes of sparse series
+var validFills = map[string]bool{"null": true, "previous": true, "zero": true, "linear": true}
+
+// invalidFill is the error of unknown fill modes or gaps
+func invalidFill(format string, a ...interface{}) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: fmt.Sprintf(format, a...),
+		Hint:    "fill is one of null, previous, zero or linear, maxgap and interval are durations like 15m",
+	}
+}
+
+// fillInterval returns the spacing of filled datapoints given by `interval`, or the
+// longest of the channel's sampling interval, the group period and the Grafana
+// interval or the range divided by the max data points
+func fillInterval(data TargetData, qr *QueryRequest, expected time.Duration) (int64, error) {
+	interval := time.Duration(qr.IntervalMs) * time.Millisecond
+	if interval <= 0 && qr.MaxDataPoints > 0 {
+		interval = qr.Range.To.Sub(qr.Range.From) / time.Duration(qr.MaxDataPoints)
+	}
+	if expected > interval {
+		interval = expected
+	}
+	if d := groupIntervals[strings.ToLower(data["group"])]; d > interval {
+		interval = d
+	}
+
+	if s, ok := data["interval"]; ok {
+		var err error
+		if interval, err = time.ParseDuration(s); err != nil || interval <= 0 {
+			return 0, invalidFill("invalid interval: %s", s)
+		}
+	}
+
+	if min := qr.Range.To.Sub(qr.Range.From) / fillMaxPoints; interval < min {
+		interval = min
+	}
+	return interval.Milliseconds(), nil
+}
+
+// fillSeries fills gaps of the series exceeding the fill interval according to `fill`:
+// null marks them as missing, previous holds the last value, zero inserts zeros and
+// linear interpolates. Gaps longer than `maxgap`, default 5 times the channel's
+// sampling interval, are marked missing in all modes, with null only those.
+// previous holds the last value until the end of the range.
+func fillSeries(data TargetData, qr *QueryRequest, expected time.Duration, qres *QueryResponse) error {
+	mode := strings.ToLower(data["fill"])
+	if !validFills[mode] {
+		return invalidFill("invalid fill: %s", mode)
+	}
+
+	maxGap := defaultGapFactor * expected.Milliseconds()
+	if s, ok := data["maxgap"]; ok {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return invalidFill("invalid maxgap: %s", s)
+		}
+		maxGap = d.Milliseconds()
+	}
+
+	step, err := fillInterval(data, qr, expected)
+	if err != nil || step <= 0 {
+		return err
+	}
+
+	dps := qres.Datapoints
+	if len(dps) == 0 {
+		return nil
+	}
+
+	null := float32(math.NaN())
+	res := make([]ResponseTuple, 0, len(dps))
+
+	// fill appends the datapoints between a and the timestamp to
+	fill := func(a ResponseTuple, to int64, next *ResponseTuple) {
+		gap := to - a.Timestamp
+		if gap <= step {
+			return
+		}
+
+		// null tolerates gaps up to maxgap
+		missing := maxGap > 0 && gap > maxGap
+		if mode == "null" {
+			if maxGap > 0 && !missing {
+				return
+			}
+			missing = true
+		}
+
+		if missing {
+			res = append(res, ResponseTuple{Timestamp: a.Timestamp + step, Value: null})
+			return
+		}
+
+		for ts := a.Timestamp + step; ts < to; ts += step {
+			v := a.Value
+			switch mode {
+			case "zero":
+				v = 0
+			case "linear":
+				v = a.Value + (next.Value-a.Value)*float32(ts-a.Timestamp)/float32(gap)
+			}
+			res = append(res, ResponseTuple{Timestamp: ts, Value: v})
+		}
+	}
+
+	for i, dp := range dps {
+		res = append(res, dp)
+		if i+1 < len(dps) {
+			fill(dp, dps[i+1].Timestamp, &dps[i+1])
+		}
+	}
+
+	// the last value of channels logging on change holds until now
+	if mode == "previous" {
+		end := qr.Range.To
+		if now := time.Now(); now.Before(end) {
+			end = now
+		}
+
+		last := dps[len(dps)-1]
+		if to := unixMS(end); to > last.Timestamp {
+			fill(last, to, nil)
+			if tail := res[len(res)-1]; !math.IsNaN(float64(tail.Value)) {
+				res = append(res, ResponseTuple{Timestamp: to, Value: last.Value})
+			}
+		}
+	}
+
+	qres.Datapoints = res
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func equalDatapoints(a, b []ResponseTuple) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Timestamp != b[i].Timestamp || math.IsNaN(float64(a[i].Value)) != math.IsNaN(float64(b[i].Value)) {
+			return false
+		}
+		if !math.IsNaN(float64(a[i].Value)) && math.Abs(float64(a[i].Value-b[i].Value)) > 1e-6 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFillSeries(t *testing.T) {
+	null := float32(math.NaN())
+
+	// 10s Grafana interval over 40s, a 30s gap
+	qr := &QueryRequest{Range: Range{From: time.Unix(0, 0), To: time.Unix(40, 0)}, IntervalMs: 10000}
+	dps := []ResponseTuple{{1, 0}, {4, 30000}}
+
+	tests := []struct {
+		name     string
+		data     TargetData
+		expected time.Duration
+		res      []ResponseTuple
+	}{
+		{"zero", TargetData{"fill": "zero"}, 0, []ResponseTuple{{1, 0}, {0, 10000}, {0, 20000}, {4, 30000}}},
+		{"linear", TargetData{"fill": "linear"}, 0, []ResponseTuple{{1, 0}, {2, 10000}, {3, 20000}, {4, 30000}}},
+		// previous holds the last value until the end of the range
+		{"previous", TargetData{"fill": "previous"}, 0, []ResponseTuple{{1, 0}, {1, 10000}, {1, 20000}, {4, 30000}, {4, 40000}}},
+		{"null", TargetData{"fill": "null"}, 0, []ResponseTuple{{1, 0}, {null, 10000}, {4, 30000}}},
+		{"null within maxgap", TargetData{"fill": "null", "maxgap": "1m"}, 0, dps},
+		{"null within default maxgap", TargetData{"fill": "null"}, 10 * time.Second, dps},
+		{"exceeding maxgap", TargetData{"fill": "linear", "maxgap": "20s"}, 0, []ResponseTuple{{1, 0}, {null, 10000}, {4, 30000}}},
+		{"interval", TargetData{"fill": "zero", "interval": "15s"}, 0, []ResponseTuple{{1, 0}, {0, 15000}, {4, 30000}}},
+		{"sampling interval", TargetData{"fill": "zero", "maxgap": "0s"}, 15 * time.Second, []ResponseTuple{{1, 0}, {0, 15000}, {4, 30000}}},
+		{"group", TargetData{"fill": "zero", "group": "minute"}, 0, dps},
+	}
+
+	for _, tc := range tests {
+		qres := QueryResponse{Datapoints: append([]ResponseTuple{}, dps...)}
+		if err := fillSeries(tc.data, qr, tc.expected, &qres); err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !equalDatapoints(qres.Datapoints, tc.res) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.res, qres.Datapoints)
+		}
+	}
+}
+
+func TestFillSeriesInvalid(t *testing.T) {
+	qr := &QueryRequest{Range: Range{From: time.Unix(0, 0), To: time.Unix(40, 0)}, IntervalMs: 10000}
+
+	for _, data := range []TargetData{
+		{"fill": "spline"},
+		{"fill": "zero", "maxgap": "long"},
+		{"fill": "zero", "maxgap": "-1m"},
+		{"fill": "zero", "interval": "0s"},
+	} {
+		qres := QueryResponse{Datapoints: []ResponseTuple{{1, 0}, {4, 30000}}}
+		if err := fillSeries(data, qr, 0, &qres); err == nil {
+			t.Errorf("%v: expected error", data)
+		}
+	}
+}
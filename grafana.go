@@ -1,7 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
 	"time"
 )
 
@@ -19,6 +24,10 @@ type AnnotationResponse struct {
 	Annotation Annotation `json:"annotation"`
 	// Time since UNIX Epoch in milliseconds. (required)
 	Time int64 `json:"time"`
+	// End time of region annotations in milliseconds. (optional)
+	TimeEnd int64 `json:"timeEnd,omitempty"`
+	// Marks the annotation as region. (optional)
+	IsRegion bool `json:"isRegion,omitempty"`
 	// The title for the annotation tooltip. (required)
 	Title string `json:"title"`
 	// Tags for the annotation. (optional)
@@ -59,6 +68,11 @@ type TagKeyResponse struct {
 	Text string `json:"text"`
 }
 
+// TagValuesRequest selects the tag key in /tag-values
+type TagValuesRequest struct {
+	Key string `json:"key"`
+}
+
 // TagValueResponse encodes additional query option values
 type TagValueResponse struct {
 	Text string `json:"text"`
@@ -82,6 +96,32 @@ type QueryRequest struct {
 type QueryResponse struct {
 	Target     interface{}     `json:"target"`
 	Datapoints []ResponseTuple `json:"datapoints"`
+	Meta       *ResponseMeta   `json:"meta,omitempty"`
+}
+
+// ResponseMeta is passed by Grafana to the data frame's meta data
+type ResponseMeta struct {
+	Custom  interface{}      `json:"custom,omitempty"`
+	Notices []ResponseNotice `json:"notices,omitempty"`
+}
+
+// ResponseNotice is shown by Grafana on the panel
+type ResponseNotice struct {
+	Severity string `json:"severity"` // info, warning or error
+	Text     string `json:"text"`
+}
+
+// TableResponse contains information to render a table.
+type TableResponse struct {
+	Columns []TableColumn   `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	Type    string          `json:"type"`
+}
+
+// TableColumn describes a table column
+type TableColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
 }
 
 // ResponseTuple is a single data point as Grafana understands
@@ -90,10 +130,15 @@ type ResponseTuple struct {
 	Timestamp int64
 }
 
-// MarshalJSON converts ResponseTuple to json
+// MarshalJSON converts ResponseTuple to json. NaN values are encoded as null.
 func (t *ResponseTuple) MarshalJSON() ([]byte, error) {
+	var value interface{} = t.Value
+	if math.IsNaN(float64(t.Value)) {
+		value = nil
+	}
+
 	a := []interface{}{
-		t.Value,
+		value,
 		t.Timestamp,
 	}
 	return json.Marshal(a)
@@ -101,14 +146,72 @@ func (t *ResponseTuple) MarshalJSON() ([]byte, error) {
 
 // Target describes a query target
 type Target struct {
-	Target string     `json:"target"`
-	RefID  string     `json:"refId"`
-	Type   string     `json:"type"`
-	Data   TargetData `json:"data,omitempty"`
+	Target  string          `json:"target"`
+	RefID   string          `json:"refId"`
+	Type    string          `json:"type"`
+	Data    TargetData      `json:"data,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"` // data of JSON API datasource targets
 }
 
+// TargetData holds the additional JSON data of a target
 type TargetData map[string]string
 
+// UnmarshalJSON accepts non-string values like numbers or booleans
+func (d *TargetData) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return err
+	}
+
+	*d = make(TargetData, len(m))
+	for k, v := range m {
+		switch v := v.(type) {
+		case string:
+			(*d)[k] = v
+		case json.Number, bool:
+			(*d)[k] = fmt.Sprint(v)
+		case nil:
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			(*d)[k] = string(b)
+		}
+	}
+
+	return nil
+}
+
+// float returns the numeric value of key or def if not set
+func (d TargetData) float(key string, def float64) float64 {
+	s, ok := d[key]
+	if !ok {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		log.Printf("invalid %s: %s", key, s)
+		return def
+	}
+
+	return f
+}
+
+// with returns a copy of the data with key set to value
+func (d TargetData) with(key, value string) TargetData {
+	res := make(TargetData, len(d)+1)
+	for k, v := range d {
+		res[k] = v
+	}
+	res[key] = value
+	return res
+}
+
 // Filter is a compontent of adhoc filters
 type Filter struct {
 	Key      string `json:"key"`
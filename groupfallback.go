@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// groupFallbacks remembers the group working for a channel if the requested one was rejected
+type groupFallbacks struct {
+	mu     sync.Mutex
+	groups map[string]string
+}
+
+func (gf *groupFallbacks) get(uuid, group string) (string, bool) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	g, ok := gf.groups[uuid+"/"+group]
+	return g, ok
+}
+
+func (gf *groupFallbacks) set(uuid, group, fallback string) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	if gf.groups == nil {
+		gf.groups = make(map[string]string)
+	}
+	gf.groups[uuid+"/"+group] = fallback
+}
+
+// groupExceptions are the messages of the middleware exceptions refusing a group
+var groupExceptions = map[string]bool{
+	"unknown group": true,
+	"invalid group": true,
+}
+
+// groupRejected checks if the middleware refused the group for the channel type.
+// Other bad requests, e.g. of unknown channels or options, are not retried.
+func groupRejected(err error) bool {
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		return false
+	}
+
+	message := strings.TrimSuffix(strings.TrimSpace(middlewareMessage(se.Body)), ".")
+	return groupExceptions[strings.ToLower(message)]
+}
+
+// fallbackCandidates lists the coarser groups followed by finer ones, excluding raw data
+func fallbackCandidates(group string) []string {
+	var coarser, finer []string
+	found := false
+	for _, g := range groups[1:] {
+		switch {
+		case g == group:
+			found = true
+		case found:
+			coarser = append(coarser, g)
+		default:
+			finer = append([]string{g}, finer...)
+		}
+	}
+	return append(coarser, finer...)
+}
+
+// fetchFallback retries a rejected group with the next valid coarser or finer group
+func (api *Api) fetchFallback(ctx context.Context, uuid string, from time.Time, to time.Time, group string, options string, tuples int) ([]Tuple, error) {
+	var err error
+	for _, g := range fallbackCandidates(group) {
+		logf(ctx, "group %s rejected for %s, retrying with group %s", group, uuid, g)
+
+		var res []Tuple
+		if res, err = api.fetchData(ctx, uuid, from, to, g, options, tuples); err == nil {
+			api.fallbacks.set(uuid, group, g)
+			return res, nil
+		}
+
+		if !groupRejected(err) {
+			break
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestGroupRejected(t *testing.T) {
+	exception := func(message string) string {
+		return fmt.Sprintf(`{"version":"0.3","exception":{"message":%q,"type":"Exception","code":0}}`, message)
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		rejected bool
+	}{
+		{"unknown group", &StatusError{StatusCode: http.StatusBadRequest, Body: exception("Unknown group")}, true},
+		{"invalid group", &StatusError{StatusCode: http.StatusBadRequest, Body: exception("invalid group.")}, true},
+		{"wrapped", fmt.Errorf("data: %w", &StatusError{StatusCode: http.StatusBadRequest, Body: exception("Unknown group")}), true},
+		{"other bad request", &StatusError{StatusCode: http.StatusBadRequest, Body: exception("Invalid UUID")}, false},
+		{"message mentioning group", &StatusError{StatusCode: http.StatusBadRequest, Body: exception("Entity is not a group")}, false},
+		{"server error", &StatusError{StatusCode: http.StatusInternalServerError, Body: exception("Unknown group")}, false},
+		{"other error", errors.New("unknown group"), false},
+		{"no error", nil, false},
+	}
+
+	for _, tc := range tests {
+		if rejected := groupRejected(tc.err); rejected != tc.rejected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.rejected, rejected)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// groupSeparator joins the titles of nested groups and channels, e.g. House / Heating / Flow temp
+const groupSeparator = " / "
+
+// groupPath returns title prefixed by the path of its parent groups
+func groupPath(parent, title string) string {
+	if parent == "" {
+		return title
+	}
+	return parent + groupSeparator + title
+}
+
+// flattenGroups returns the groups of the entity tree titled by their path
+func flattenGroups(entities []Entity, parent string) []Entity {
+	var res []Entity
+	for _, entity := range entities {
+		if entity.Type != "group" {
+			continue
+		}
+
+		path := groupPath(parent, entity.Title)
+		group := entity
+		group.Title = path
+		res = append(append(res, group), flattenGroups(entity.Children, path)...)
+	}
+	return res
+}
+
+// legacyTitles returns the channels of groups titled by their parent group like in
+// earlier versions, e.g. Flow temp (Heating), such that existing targets keep working
+func legacyTitles(entities []Entity, parent string) []Entity {
+	var res []Entity
+	for _, entity := range entities {
+		if entity.Type == "group" {
+			res = append(res, legacyTitles(entity.Children, entity.Title)...)
+		} else if parent != "" {
+			entity.Title = fmt.Sprintf("%s (%s)", entity.Title, parent)
+			res = append(res, entity)
+		}
+	}
+	return res
+}
+
+// queryChildren returns a series per channel of the group, including the channels of
+// nested groups, queried with the target's data
+func (server *Server) queryChildren(ctx context.Context, target Target, qr *QueryRequest) ([]interface{}, error) {
+	entity, err := server.api.getEntity(ctx, target.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	if entity.Type != "group" {
+		logf(ctx, "children: %s is not a group", target.Target)
+		return []interface{}{}, nil
+	}
+
+	children := make([]Entity, 0)
+	server.flattenEntities(&children, entity.Children, "")
+
+	// children are queried as plain channels named by their path
+	data := make(TargetData)
+	for k, v := range target.Data {
+		if k != "context" && k != "name" {
+			data[k] = v
+		}
+	}
+
+	res := make([]interface{}, len(children))
+	errs := make([]error, len(children))
+
+	fanOut(ctx, len(children), server.fanout, func(idx int) {
+		child := children[idx]
+
+		// a malformed child must not take down the server
+		defer func() {
+			if rec := recover(); rec != nil {
+				errs[idx] = panicError(rec)
+			}
+		}()
+
+		t := Target{Target: child.UUID, Type: target.Type, Data: data}
+		qres, err := server.querySeries(ctx, "", t, qr)
+		qres.Target = groupPath(entity.Title, child.Title)
+		res[idx], errs[idx] = qres, err
+	}, func(idx int, err error) {
+		errs[idx] = err
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
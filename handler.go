@@ -5,15 +5,28 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"runtime/debug"
+	"strings"
 	"time"
 )
 
+// corsHeaders sets the headers required such that direct access works
+func corsHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Headers", "accept, authorization, content-type, x-request-id, x-grafana-timeout")
+	w.Header().Set("Access-Control-Expose-Headers", "x-request-id")
+	w.Header().Set("Access-Control-Allow-Methods", "POST")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+}
+
 // cors adds required headers to responses such that direct access works.
+// Preflight requests are answered without calling the handler.
 func cors(f http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Headers", "accept, content-type")
-		w.Header().Set("Access-Control-Allow-Methods", "POST")
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		corsHeaders(w)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		f(w, r)
 	}
 }
@@ -26,7 +39,7 @@ func allowed(f http.HandlerFunc, methods ...string) http.HandlerFunc {
 				return
 			}
 		}
-		http.Error(w, "Bad method; supported OPTIONS, POST", http.StatusBadRequest)
+		http.Error(w, "Bad method; supported "+strings.Join(methods, ", "), http.StatusBadRequest)
 	}
 }
 
@@ -52,7 +65,7 @@ func logger(f http.HandlerFunc, debug bool) http.HandlerFunc {
 		f(w, r)
 
 		duration := time.Now().Sub(start)
-		log.Printf("%v %v (%dms)", r.Method, r.URL.Path, duration.Nanoseconds()/1e6)
+		logf(r.Context(), "%v %v (%dms)", r.Method, r.URL.Path, duration.Nanoseconds()/1e6)
 
 		if debug {
 			log.Println("Request:\n" + string(body))
@@ -61,6 +74,33 @@ func logger(f http.HandlerFunc, debug bool) http.HandlerFunc {
 	}
 }
 
+// panicError logs a recovered panic with stack and returns an error carrying a
+// reference id that can be found in the log
+func panicError(rec interface{}) *QueryError {
+	ref := newID()
+
+	log.Printf("panic %s: %v\n%s", ref, rec, debug.Stack())
+
+	return &QueryError{
+		Status:  http.StatusInternalServerError,
+		Code:    "internal_error",
+		Message: "internal error, reference " + ref,
+	}
+}
+
+// recoverer turns handler panics into internal server errors
+func recoverer(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				writeQueryError(w, panicError(rec))
+			}
+		}()
+
+		f(w, r)
+	}
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	body []byte
@@ -77,11 +117,24 @@ func (w loggingResponseWriter) Write(b []byte) (int, error) {
 
 // handler builds inbound request processing stack
 func handler(f http.HandlerFunc, debug bool) http.HandlerFunc {
-	return cors(
+	return cors(metered(
 		allowed(
-			logger(
-				f,
-				debug),
+			requestIDs(
+				logger(
+					recoverer(f),
+					debug)),
 			http.MethodOptions, http.MethodPost),
-	)
+	))
+}
+
+// readHandler is handler additionally allowing GET requests
+func readHandler(f http.HandlerFunc, debug bool) http.HandlerFunc {
+	return cors(metered(
+		allowed(
+			requestIDs(
+				logger(
+					recoverer(f),
+					debug)),
+			http.MethodOptions, http.MethodGet, http.MethodPost),
+	))
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// readyTimeout bounds the middleware check of readiness probes
+const readyTimeout = 5 * time.Second
+
+// healthCheck is the status of a single readiness check
+type healthCheck struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency,omitempty"`
+	Fetched string `json:"fetched,omitempty"`
+}
+
+// healthResponse is returned by /healthz and /readyz
+type healthResponse struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// started is the process start time reported as uptime
+var started = time.Now()
+
+// entitiesFetched records a successful fetch of the entity list
+func (server *Server) entitiesFetched() {
+	server.mu.Lock()
+	server.fetched = time.Now()
+	server.mu.Unlock()
+}
+
+// writeHealth writes res with 200 if ok, 503 otherwise
+func writeHealth(w http.ResponseWriter, res healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if res.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
+
+// healthHandler reports the process alive regardless of the middleware
+func (server *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthResponse{Status: "ok", Uptime: time.Since(started).Round(time.Second).String()})
+}
+
+// readyHandler reports ready if the middleware is reachable and the entity list was
+// fetched within -ready-max-age, so probes can tell a middleware outage from gravo failing
+func (server *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	res := healthResponse{
+		Status: "ok",
+		Uptime: time.Since(started).Round(time.Second).String(),
+		Checks: make(map[string]healthCheck),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	start := time.Now()
+	middleware := healthCheck{Status: "ok"}
+	if err := server.api.validate(ctx); err != nil {
+		middleware = healthCheck{Status: "unavailable", Error: err.Error()}
+	}
+	middleware.Latency = time.Since(start).Round(time.Millisecond).String()
+	res.Checks["middleware"] = middleware
+
+	server.mu.Lock()
+	fetched := server.fetched
+	server.mu.Unlock()
+
+	entities := healthCheck{Status: "ok"}
+	switch {
+	case fetched.IsZero():
+		entities = healthCheck{Status: "unavailable", Error: "entity list not fetched yet"}
+	case server.readyMaxAge > 0 && time.Since(fetched) > server.readyMaxAge:
+		entities.Status = "stale"
+		entities.Error = "entity list older than " + server.readyMaxAge.String()
+	}
+	if !fetched.IsZero() {
+		entities.Fetched = fetched.Format(time.RFC3339)
+	}
+	res.Checks["entities"] = entities
+
+	for _, check := range res.Checks {
+		if check.Status != "ok" {
+			res.Status = "unavailable"
+		}
+	}
+
+	writeHealth(w, res)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// calendar classifies days as workdays or weekends and holidays
+type calendar struct {
+	weekend   map[time.Weekday]bool
+	dates     map[string]bool // 2006-01-02
+	recurring map[string]bool // 01-02
+	easter    map[int]bool    // days relative to easter sunday
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// newCalendar parses the holiday config. Dates are given as YYYY-MM-DD, recurring
+// as MM-DD or relative to easter sunday, e.g. easter-2 for good friday.
+func newCalendar(c HolidayConfig) (*calendar, error) {
+	cal := &calendar{
+		weekend:   map[time.Weekday]bool{time.Saturday: true, time.Sunday: true},
+		dates:     make(map[string]bool),
+		recurring: make(map[string]bool),
+		easter:    make(map[int]bool),
+	}
+
+	if c.Weekend != nil {
+		cal.weekend = make(map[time.Weekday]bool)
+		for _, s := range c.Weekend {
+			day, ok := weekdays[strings.ToLower(s)]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday: %s", s)
+			}
+			cal.weekend[day] = true
+		}
+	}
+
+	for _, s := range c.Dates {
+		switch {
+		case strings.HasPrefix(s, "easter"):
+			offset := 0
+			if rest := strings.TrimPrefix(s, "easter"); rest != "" {
+				var err error
+				if offset, err = strconv.Atoi(rest); err != nil {
+					return nil, fmt.Errorf("invalid holiday: %s", s)
+				}
+			}
+			cal.easter[offset] = true
+		case len(s) == len("01-02"):
+			if _, err := time.Parse("01-02", s); err != nil {
+				return nil, fmt.Errorf("invalid holiday: %s", s)
+			}
+			cal.recurring[s] = true
+		default:
+			if _, err := time.Parse("2006-01-02", s); err != nil {
+				return nil, fmt.Errorf("invalid holiday: %s", s)
+			}
+			cal.dates[s] = true
+		}
+	}
+
+	return cal, nil
+}
+
+// easterSunday returns the date of easter sunday of year (gregorian calendar)
+func easterSunday(year int, loc *time.Location) time.Time {
+	a := year % 19
+	b, c := year/100, year%100
+	d, e := b/4, b%4
+	f := (b + 8) / 25
+	g := (b - f + 1) / 3
+	h := (19*a + b - d - g + 15) % 30
+	i, k := c/4, c%4
+	l := (32 + 2*e + 2*i - h - k) % 7
+	m := (a + 11*h + 22*l) / 451
+	month := (h + l - 7*m + 114) / 31
+	day := (h+l-7*m+114)%31 + 1
+
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+}
+
+// holiday checks if the day of t is a weekend day or holiday
+func (cal *calendar) holiday(t time.Time) bool {
+	if cal.weekend[t.Weekday()] || cal.dates[t.Format("2006-01-02")] || cal.recurring[t.Format("01-02")] {
+		return true
+	}
+
+	if len(cal.easter) > 0 {
+		offset := t.YearDay() - easterSunday(t.Year(), t.Location()).YearDay()
+		return cal.easter[offset]
+	}
+
+	return false
+}
+
+// holidayCalendar returns the configured calendar, defaulting to weekends only
+func (conf Config) holidayCalendar() *calendar {
+	if conf.calendar != nil {
+		return conf.calendar
+	}
+	cal, _ := newCalendar(HolidayConfig{})
+	return cal
+}
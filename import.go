@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columnIndex resolves a column given by index or header name
+func columnIndex(column string, header []string) (int, error) {
+	if i, err := strconv.Atoi(column); err == nil {
+		if i < 0 {
+			return 0, fmt.Errorf("invalid column: %s", column)
+		}
+		return i, nil
+	}
+
+	for i, h := range header {
+		if strings.TrimSpace(h) == column {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("column not found: %s", column)
+}
+
+// readCSVRows reads time and value fields from a csv file
+func readCSVRows(file string, delimiter rune, header bool, timeCol, valueCol string) ([][2]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if header && len(records) > 0 {
+		names, records = records[0], records[1:]
+	}
+
+	ti, err := columnIndex(timeCol, names)
+	if err != nil {
+		return nil, err
+	}
+	vi, err := columnIndex(valueCol, names)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][2]string, 0, len(records))
+	for i, rec := range records {
+		if ti >= len(rec) || vi >= len(rec) {
+			return nil, fmt.Errorf("row %d: missing column", i+1)
+		}
+		rows = append(rows, [2]string{rec[ti], rec[vi]})
+	}
+
+	return rows, nil
+}
+
+// readJSONRows reads time and value fields from a json array of arrays or objects
+func readJSONRows(file string, timeKey, valueKey string) ([][2]string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []interface{}
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, err
+	}
+
+	field := func(rec interface{}, key string) (string, error) {
+		switch rec := rec.(type) {
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(rec) {
+				return "", fmt.Errorf("invalid index: %s", key)
+			}
+			return fmt.Sprint(rec[i]), nil
+		case map[string]interface{}:
+			v, ok := rec[key]
+			if !ok {
+				return "", fmt.Errorf("missing key: %s", key)
+			}
+			return fmt.Sprint(v), nil
+		}
+		return "", fmt.Errorf("invalid record: %v", rec)
+	}
+
+	rows := make([][2]string, 0, len(records))
+	for i, rec := range records {
+		t, err := field(rec, timeKey)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+1, err)
+		}
+		v, err := field(rec, valueKey)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+1, err)
+		}
+		rows = append(rows, [2]string{t, v})
+	}
+
+	return rows, nil
+}
+
+// parseImportTime parses ts as epoch ms, epoch s, ISO 8601 or using the go time format
+func parseImportTime(ts string, format string, loc *time.Location) (int64, error) {
+	switch format {
+	case "iso":
+		t, err := parseISOTime(ts, loc)
+		if err != nil {
+			return 0, err
+		}
+		return unixMS(t), nil
+	case "ms", "s":
+		f, err := strconv.ParseFloat(ts, 64)
+		if err != nil {
+			return 0, err
+		}
+		if format == "s" {
+			f *= 1000
+		}
+		return int64(f), nil
+	default:
+		t, err := time.ParseInLocation(format, ts, loc)
+		if err != nil {
+			return 0, err
+		}
+		return unixMS(t), nil
+	}
+}
+
+// readState returns the number of rows already imported
+func readState(file string) int {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		log.Printf("invalid state file %s", file)
+		return 0
+	}
+
+	return n
+}
+
+// importCommand imports csv or json files into a channel
+func importCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuid := fs.String("uuid", "", "channel uuid")
+	file := fs.String("file", "", "csv or json file to import")
+	format := fs.String("format", "", "file format csv or json (default by extension)")
+	timeCol := fs.String("time", "0", "time column index, header name or json key")
+	valueCol := fs.String("value", "1", "value column index, header name or json key")
+	timeFormat := fs.String("timeformat", "ms", "time format: ms, s, iso or go time format")
+	delimiter := fs.String("delimiter", ",", "csv field delimiter")
+	decimal := fs.String("decimal", ".", "decimal separator")
+	header := fs.Bool("header", true, "csv file has header row")
+	batch := fs.Int("batch", 1000, "tuples per request")
+	state := fs.String("state", "", "resume state file (default <file>.state)")
+	fs.Parse(args)
+
+	if *uuid == "" || *file == "" {
+		return configError("missing uuid or file")
+	}
+	if *batch <= 0 {
+		return configError("invalid batch: %d", *batch)
+	}
+	delim := []rune(*delimiter)
+	if len(delim) != 1 {
+		return configError("invalid delimiter: %q", *delimiter)
+	}
+
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+	if *state == "" {
+		*state = *file + ".state"
+	}
+
+	var rows [][2]string
+	var err error
+	switch *format {
+	case "csv":
+		rows, err = readCSVRows(*file, delim[0], *header, *timeCol, *valueCol)
+	case "json":
+		rows, err = readJSONRows(*file, *timeCol, *valueCol)
+	default:
+		return configError("invalid format: %s", *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	tuples := make([]Tuple, 0, len(rows))
+	for i, row := range rows {
+		ts, err := parseImportTime(row[0], *timeFormat, time.Local)
+		if err != nil {
+			return fmt.Errorf("row %d: invalid time: %v", i+1, err)
+		}
+
+		v, err := strconv.ParseFloat(strings.Replace(row[1], *decimal, ".", 1), 32)
+		if err != nil {
+			return fmt.Errorf("row %d: invalid value: %v", i+1, err)
+		}
+
+		tuples = append(tuples, Tuple{Timestamp: ts, Value: float32(v)})
+	}
+
+	done := readState(*state)
+	if done > 0 {
+		log.Printf("resuming after %d of %d tuples", done, len(tuples))
+	}
+
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+
+	for done < len(tuples) {
+		end := done + *batch
+		if end > len(tuples) {
+			end = len(tuples)
+		}
+
+		if err := api.postData(context.Background(), *uuid, tuples[done:end]); err != nil {
+			return fmt.Errorf("import failed after %d tuples: %w", done, err)
+		}
+
+		done = end
+		if err := ioutil.WriteFile(*state, []byte(strconv.Itoa(done)), 0644); err != nil {
+			return err
+		}
+
+		log.Printf("imported %d of %d tuples", done, len(tuples))
+	}
+
+	os.Remove(*state)
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestColumnIndex(t *testing.T) {
+	header := []string{"time", " value "}
+
+	tests := []struct {
+		column string
+		index  int
+		ok     bool
+	}{
+		{"0", 0, true},
+		{"3", 3, true}, // checked per row
+		{"time", 0, true},
+		{"value", 1, true},
+		{"-1", 0, false},
+		{"missing", 0, false},
+	}
+
+	for _, tc := range tests {
+		i, err := columnIndex(tc.column, header)
+		if (err == nil) != tc.ok || i != tc.index {
+			t.Errorf("%s: expected %d (ok %v), got %d (%v)", tc.column, tc.index, tc.ok, i, err)
+		}
+	}
+}
+
+func TestReadRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gravo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	csvFile := filepath.Join(dir, "data.csv")
+	jsonFile := filepath.Join(dir, "data.json")
+	if err := ioutil.WriteFile(csvFile, []byte("time;value\n1000;1.5\n2000;2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(jsonFile, []byte(`[[1000,1.5],[2000,2]]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][2]string{{"1000", "1.5"}, {"2000", "2"}}
+
+	tests := []struct {
+		name        string
+		read        func(timeCol, valueCol string) ([][2]string, error)
+		time, value string
+		ok          bool
+	}{
+		{"csv", func(tc, vc string) ([][2]string, error) { return readCSVRows(csvFile, ';', true, tc, vc) }, "time", "value", true},
+		{"csv index", func(tc, vc string) ([][2]string, error) { return readCSVRows(csvFile, ';', true, tc, vc) }, "0", "1", true},
+		{"csv negative", func(tc, vc string) ([][2]string, error) { return readCSVRows(csvFile, ';', true, tc, vc) }, "-1", "1", false},
+		{"csv missing column", func(tc, vc string) ([][2]string, error) { return readCSVRows(csvFile, ';', true, tc, vc) }, "0", "2", false},
+		{"json", func(tc, vc string) ([][2]string, error) { return readJSONRows(jsonFile, tc, vc) }, "0", "1", true},
+		{"json negative", func(tc, vc string) ([][2]string, error) { return readJSONRows(jsonFile, tc, vc) }, "-1", "1", false},
+		{"json missing index", func(tc, vc string) ([][2]string, error) { return readJSONRows(jsonFile, tc, vc) }, "0", "2", false},
+	}
+
+	for _, tc := range tests {
+		rows, err := tc.read(tc.time, tc.value)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, expected, rows)
+		}
+	}
+}
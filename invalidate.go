@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidateRequest selects the cached responses to drop. Without uuids all
+// responses are dropped, without range all responses of the channels.
+type InvalidateRequest struct {
+	UUIDs []string `json:"uuids"`
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+}
+
+// InvalidateResponse reports the number of dropped responses
+type InvalidateResponse struct {
+	Invalidated int `json:"invalidated"`
+}
+
+// invalidate drops all entries matching the key, including pending ones and disk entries
+func (c *responseCache) invalidate(match func(key string) bool) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key := range c.entries {
+		if match(key) {
+			delete(c.entries, key)
+			n++
+		}
+	}
+
+	if c.l2 != nil {
+		n += c.l2.invalidate(match)
+	}
+	return n
+}
+
+// dataKeyRange returns uuid and range in ms of a cached data request
+func dataKeyRange(key string) (string, int64, int64, bool) {
+	u, err := neturl.Parse(key)
+	if err != nil || !strings.HasPrefix(u.Path, "/data/") {
+		return "", 0, 0, false
+	}
+
+	uuid := strings.TrimSuffix(strings.TrimPrefix(u.Path, "/data/"), ".json")
+	from, _ := strconv.ParseInt(u.Query().Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(u.Query().Get("to"), 10, 64)
+
+	return uuid, from, to, true
+}
+
+// invalidateMatcher matches the cached responses selected by the request. The entity
+// list is always dropped as titles may have changed.
+func invalidateMatcher(ir InvalidateRequest, from, to time.Time) func(string) bool {
+	uuids := make(map[string]bool, len(ir.UUIDs))
+	for _, uuid := range ir.UUIDs {
+		uuids[uuid] = true
+	}
+
+	return func(key string) bool {
+		uuid, f, t, ok := dataKeyRange(key)
+		if !ok {
+			return true
+		}
+		if len(uuids) > 0 && !uuids[uuid] {
+			return false
+		}
+		if ir.From != "" && t < unixMS(from) {
+			return false
+		}
+		if ir.To != "" && f > unixMS(to) {
+			return false
+		}
+		return true
+	}
+}
+
+// invalidateHandler drops cached responses, e.g. called by middleware hooks after
+// historical data was corrected
+func (server *Server) invalidateHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, server.invalidateToken) {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusUnauthorized,
+			Code:    "unauthorized",
+			Message: "invalid token",
+		})
+		return
+	}
+
+	ir := InvalidateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&ir); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	var from, to time.Time
+	var err error
+	if ir.From != "" {
+		from, err = parseTime(ir.From, server.conf.defaultLocation())
+	}
+	if err == nil && ir.To != "" {
+		to, err = parseTime(ir.To, server.conf.defaultLocation())
+	}
+	if err != nil {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	n := server.api.cache.invalidate(invalidateMatcher(ir, from, to))
+	logf(r.Context(), "invalidated %d cached responses", n)
+
+	// titles may have changed
+	server.refreshEntities(r.Context())
+
+	if err := json.NewEncoder(w).Encode(InvalidateResponse{Invalidated: n}); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Grafana datasource protocols. The JSON API protocol is served in addition to
+// SimpleJSON to migrate dashboards one by one.
+const (
+	protocolSimpleJSON = "simplejson" // grafana-simple-json-datasource
+	protocolJSONAPI    = "jsonapi"    // simpod-json-datasource with payloads
+)
+
+var validProtocols = map[string]bool{protocolSimpleJSON: true, protocolJSONAPI: true}
+
+// jsonAPI reports if the server speaks the JSON API datasource protocol
+func (server *Server) jsonAPI() bool {
+	return server.protocol == protocolJSONAPI
+}
+
+// JSONMetric is a metric of the JSON API datasource with the payloads it accepts
+type JSONMetric struct {
+	Label    string        `json:"label"`
+	Value    string        `json:"value"`
+	Payloads []JSONPayload `json:"payloads"`
+}
+
+// JSONPayload describes a query option shown by the JSON API datasource's editor
+type JSONPayload struct {
+	Label        string       `json:"label"`
+	Name         string       `json:"name"`
+	Type         string       `json:"type"` // select, multi-select or input
+	Placeholder  string       `json:"placeholder,omitempty"`
+	ReloadMetric bool         `json:"reloadMetric,omitempty"`
+	Options      []JSONOption `json:"options,omitempty"`
+}
+
+// JSONOption is an option of a select payload
+type JSONOption struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// MetricsRequest is sent by the JSON API datasource to list metrics
+type MetricsRequest struct {
+	Metric  string          `json:"metric"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// PayloadOptionsRequest asks for the options of the payload name
+type PayloadOptionsRequest struct {
+	Metric  string          `json:"metric"`
+	Payload json.RawMessage `json:"payload"`
+	Name    string          `json:"name"`
+}
+
+// VariableRequest is sent by the JSON API datasource for query variables
+type VariableRequest struct {
+	Payload struct {
+		Target string `json:"target"`
+	} `json:"payload"`
+}
+
+// VariableResponse is a value of a query variable
+type VariableResponse struct {
+	Text  string `json:"__text"`
+	Value string `json:"__value"`
+}
+
+// queryContexts are the derived queries selectable as `context`
+var queryContexts = []string{
+	"sum", "prognosis", "budget", "peak", "duration", "cop", "battery", "sessions", "baseline",
+	"meter", "daytype", "freshness", "completeness", "tariff", "last", "children",
+}
+
+// selectOptions returns the sorted keys as options
+func selectOptions(keys map[string]bool) []JSONOption {
+	res := make([]JSONOption, 0, len(keys))
+	for key := range keys {
+		res = append(res, JSONOption{Label: key, Value: key})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Value < res[j].Value })
+	return res
+}
+
+// jsonPayloads returns the query options offered by the JSON API datasource's editor.
+// Other options can be given as JSON payload in code mode.
+func jsonPayloads() []JSONPayload {
+	contexts := make([]JSONOption, 0, len(queryContexts))
+	for _, c := range queryContexts {
+		contexts = append(contexts, JSONOption{Label: c, Value: c})
+	}
+
+	groups := []JSONOption{}
+	for _, g := range []string{"minute", "hour", "day", "week", "month", "year"} {
+		groups = append(groups, JSONOption{Label: g, Value: g})
+	}
+
+	return []JSONPayload{
+		{Label: "Context", Name: "context", Type: "select", Placeholder: "raw data", Options: contexts},
+		{Label: "Group", Name: "group", Type: "select", Placeholder: "none", Options: groups},
+		{Label: "Unit", Name: "unit", Type: "input", Placeholder: "auto, kW, °F"},
+		{Label: "Stat", Name: "stat", Type: "select", Options: selectOptions(validStats)},
+		{Label: "Fill", Name: "fill", Type: "select", Options: selectOptions(validFills)},
+		{Label: "Transform", Name: "transform", Type: "select", Options: selectOptions(validTransforms)},
+		{Label: "Split", Name: "split", Type: "select", Placeholder: "none", Options: selectOptions(validSplits)},
+		{Label: "Name", Name: "name", Type: "input", Placeholder: "series name"},
+		{Label: "Decimals", Name: "decimals", Type: "input"},
+	}
+}
+
+// jsonMetricsHandler lists the metrics with their payloads at POST /metrics, only
+// the requested metric if given
+func (server *Server) jsonMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	mr := MetricsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&mr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	payloads := jsonPayloads()
+	res := []JSONMetric{}
+	for _, sr := range server.executeSearch(r.Context(), SearchRequest{}) {
+		if mr.Metric == "" || mr.Metric == sr.UUID {
+			res = append(res, JSONMetric{Label: sr.Text, Value: sr.UUID, Payloads: payloads})
+		}
+	}
+
+	// metrics not listed, e.g. private channels, can still be queried
+	if mr.Metric != "" && len(res) == 0 {
+		res = append(res, JSONMetric{Label: mr.Metric, Value: mr.Metric, Payloads: payloads})
+	}
+
+	writeJSON(w, res)
+}
+
+// payloadOptionsHandler returns the options of a select payload at POST /metric-payload-options
+func (server *Server) payloadOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	pr := PayloadOptionsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	res := []JSONOption{}
+	for _, p := range jsonPayloads() {
+		if p.Name == pr.Name {
+			res = append(res, p.Options...)
+		}
+	}
+
+	writeJSON(w, res)
+}
+
+// variableHandler returns the values of query variables at POST /variable. The
+// target is a search like in /search, e.g. an ad-hoc filter.
+func (server *Server) variableHandler(w http.ResponseWriter, r *http.Request) {
+	vr := VariableRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&vr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	res := []VariableResponse{}
+	for _, sr := range server.executeSearch(r.Context(), SearchRequest{Target: vr.Payload.Target}) {
+		res = append(res, VariableResponse{Text: sr.Text, Value: sr.UUID})
+	}
+
+	writeJSON(w, res)
+}
+
+// writeJSON encodes the response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// payloadData decodes the payload of a JSON API target, given as object or, from
+// the datasource's code editor, as JSON string
+func payloadData(raw json.RawMessage) (TargetData, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		raw = json.RawMessage(s)
+	}
+
+	var data TargetData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	return data, nil
+}
+
+// payloadTargets merges the payloads of JSON API targets into their data
+func payloadTargets(qr QueryRequest) (QueryRequest, error) {
+	targets := make([]Target, 0, len(qr.Targets))
+	for _, t := range qr.Targets {
+		payload, err := payloadData(t.Payload)
+		if err != nil {
+			return qr, err
+		}
+
+		if len(payload) > 0 {
+			data := make(TargetData, len(t.Data)+len(payload))
+			for k, v := range t.Data {
+				data[k] = v
+			}
+			for k, v := range payload {
+				data[k] = v
+			}
+			t.Data = data
+		}
+		t.Payload = nil
+
+		targets = append(targets, t)
+	}
+
+	qr.Targets = targets
+	return qr, nil
+}
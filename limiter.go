@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+)
+
+// upstream request gauges exposed at /metrics
+var (
+	upstreamActive = &counter{}
+	upstreamQueued = &counter{}
+)
+
+// limiter bounds the number of simultaneous middleware requests
+type limiter chan struct{}
+
+// newLimiter creates a limiter allowing max requests, nil if unlimited
+func newLimiter(max int) limiter {
+	if max <= 0 {
+		return nil
+	}
+	return make(limiter, max)
+}
+
+// acquire waits for a free slot until ctx is done. The returned function releases the slot.
+func (l limiter) acquire(ctx context.Context) (func(), error) {
+	upstreamQueued.Add(1)
+	defer upstreamQueued.Add(-1)
+
+	if l != nil {
+		select {
+		case l <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	upstreamActive.Add(1)
+
+	return func() {
+		upstreamActive.Add(-1)
+		if l != nil {
+			<-l
+		}
+	}, nil
+}
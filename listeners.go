@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ServerConfig configures the authenticators and listeners of the server
+type ServerConfig struct {
+	Authenticators map[string]AuthenticatorConfig `yaml:"authenticators"`
+	Listeners      []ListenerConfig               `yaml:"listeners"` // default -url without auth
+}
+
+// ListenerConfig is an address served with its own auth chain per route group
+type ListenerConfig struct {
+	Listen string              `yaml:"listen"`
+	Auth   []string            `yaml:"auth"`   // authenticators of all route groups
+	Routes map[string][]string `yaml:"routes"` // authenticators by route group: query, write, admin or health
+	TLS    ListenerTLSConfig   `yaml:"tls"`
+}
+
+// ListenerTLSConfig enables https and with clientCA client certificates for mtls
+type ListenerTLSConfig struct {
+	Cert     string `yaml:"cert"`
+	Key      string `yaml:"key"`
+	ClientCA string `yaml:"clientCA"`
+}
+
+// validRoutes are the route groups of the server
+var validRoutes = map[string]bool{routeQuery: true, routeWrite: true, routeAdmin: true, routeHealth: true}
+
+// validateServer checks that listeners refer to configured authenticators
+func (conf Config) validateServer() error {
+	for name, a := range conf.Server.Authenticators {
+		if _, err := newAuthenticator(a); err != nil {
+			return fmt.Errorf("server.authenticators.%s: %v", name, err)
+		}
+	}
+
+	for i, l := range conf.Server.Listeners {
+		if l.Listen == "" {
+			return fmt.Errorf("server.listeners[%d]: missing listen", i)
+		}
+		if (l.TLS.Cert == "") != (l.TLS.Key == "") || l.TLS.ClientCA != "" && l.TLS.Cert == "" {
+			return fmt.Errorf("server.listeners[%d]: tls needs cert and key", i)
+		}
+
+		chains := map[string][]string{"": l.Auth}
+		for route, names := range l.Routes {
+			if !validRoutes[route] {
+				return fmt.Errorf("server.listeners[%d]: invalid route group: %s", i, route)
+			}
+			chains[route] = names
+		}
+
+		for _, names := range chains {
+			for _, name := range names {
+				a, ok := conf.Server.Authenticators[name]
+				if !ok {
+					return fmt.Errorf("server.listeners[%d]: unknown authenticator: %s", i, name)
+				}
+				if strings.EqualFold(a.Type, "mtls") && l.TLS.ClientCA == "" {
+					return fmt.Errorf("server.listeners[%d]: authenticator %s needs tls.clientCA", i, name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// authenticated checks if all listeners authenticate the route group, false if
+// the server listens without auth
+func (conf ServerConfig) authenticated(route string) bool {
+	for _, l := range conf.Listeners {
+		names, ok := l.Routes[route]
+		if !ok {
+			names = l.Auth
+		}
+		if len(names) == 0 {
+			return false
+		}
+	}
+	return len(conf.Listeners) > 0
+}
+
+// authChains returns the auth chain of each route group of the listener
+func (l ListenerConfig) authChains(authenticators map[string]authenticator) map[string]authChain {
+	res := make(map[string]authChain)
+	for route := range validRoutes {
+		names, ok := l.Routes[route]
+		if !ok {
+			names = l.Auth
+		}
+
+		var chain authChain
+		for _, name := range names {
+			chain.authenticators = append(chain.authenticators, authenticators[name])
+		}
+		res[route] = chain
+	}
+	return res
+}
+
+// tlsConfig returns the listener's server TLS settings, nil for http
+func (l ListenerConfig) tlsConfig() (*tls.Config, error) {
+	if l.TLS.Cert == "" {
+		return nil, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(l.TLS.Cert, l.TLS.Key)
+	if err != nil {
+		return nil, fmt.Errorf("tls cert: %v", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{pair}}
+
+	if l.TLS.ClientCA != "" {
+		b, err := ioutil.ReadFile(l.TLS.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("tls client ca: %v", err)
+		}
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("tls client ca: no certificates found in %s", l.TLS.ClientCA)
+		}
+		// other authenticators may accept requests without certificate
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return conf, nil
+}
+
+// serve serves handler on the configured listeners, or on addr without auth if none
+// are configured, and returns the first listener's error
+func (conf ServerConfig) serve(addr string, handler http.Handler) error {
+	if len(conf.Listeners) == 0 {
+		return http.ListenAndServe(addr, handler)
+	}
+
+	authenticators := make(map[string]authenticator, len(conf.Authenticators))
+	for name, a := range conf.Authenticators {
+		authenticators[name], _ = newAuthenticator(a) // validated on load
+	}
+
+	errs := make(chan error, len(conf.Listeners))
+	for _, l := range conf.Listeners {
+		tlsConf, err := l.tlsConfig()
+		if err != nil {
+			return fmt.Errorf("listener %s: %v", l.Listen, err)
+		}
+
+		srv := &http.Server{
+			Addr:      l.Listen,
+			Handler:   &authRoutes{next: handler, chains: l.authChains(authenticators)},
+			TLSConfig: tlsConf,
+		}
+
+		go func(srv *http.Server) {
+			log.Printf("listening on %s", srv.Addr)
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			errs <- fmt.Errorf("listener %s: %v", srv.Addr, err)
+		}(srv)
+	}
+
+	return <-errs
+}
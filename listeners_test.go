@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestServerAuthenticated(t *testing.T) {
+	tests := []struct {
+		name      string
+		listeners []ListenerConfig
+		expected  bool
+	}{
+		{"no listeners", nil, false},
+		{"all routes", []ListenerConfig{{Auth: []string{"basic"}}}, true},
+		{"write route", []ListenerConfig{{Routes: map[string][]string{routeWrite: {"key"}}}}, true},
+		{"write route open", []ListenerConfig{{Auth: []string{"basic"}, Routes: map[string][]string{routeWrite: {}}}}, false},
+		{"other route", []ListenerConfig{{Routes: map[string][]string{routeAdmin: {"key"}}}}, false},
+		{"open listener", []ListenerConfig{{Auth: []string{"basic"}}, {Listen: ":8002"}}, false},
+	}
+
+	for _, tc := range tests {
+		conf := ServerConfig{Listeners: tc.listeners}
+		if res := conf.authenticated(routeWrite); res != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, res)
+		}
+	}
+}
+
+func TestValidateServer(t *testing.T) {
+	authenticators := map[string]AuthenticatorConfig{
+		"basic": {Type: "basic", Users: map[string]string{"alice": "secret"}},
+		"certs": {Type: "mtls"},
+	}
+	tlsConfig := ListenerTLSConfig{Cert: "cert.pem", Key: "key.pem"}
+	mtlsConfig := ListenerTLSConfig{Cert: "cert.pem", Key: "key.pem", ClientCA: "ca.pem"}
+
+	tests := []struct {
+		name           string
+		authenticators map[string]AuthenticatorConfig
+		listeners      []ListenerConfig
+		err            bool
+	}{
+		{"basic", authenticators, []ListenerConfig{{Listen: ":8001", Auth: []string{"basic"}}}, false},
+		{"mtls", authenticators, []ListenerConfig{{Listen: ":8001", Auth: []string{"certs"}, TLS: mtlsConfig}}, false},
+		{"mtls without tls", authenticators, []ListenerConfig{{Listen: ":8001", Auth: []string{"certs"}}}, true},
+		{"mtls without clientCA", authenticators, []ListenerConfig{{Listen: ":8001", Auth: []string{"certs"}, TLS: tlsConfig}}, true},
+		{"mtls route without clientCA", authenticators, []ListenerConfig{{Listen: ":8001", Routes: map[string][]string{routeAdmin: {"certs"}}, TLS: tlsConfig}}, true},
+		{"clientCA without cert", authenticators, []ListenerConfig{{Listen: ":8001", TLS: ListenerTLSConfig{ClientCA: "ca.pem"}}}, true},
+		{"cert without key", authenticators, []ListenerConfig{{Listen: ":8001", TLS: ListenerTLSConfig{Cert: "cert.pem"}}}, true},
+		{"missing listen", authenticators, []ListenerConfig{{Auth: []string{"basic"}}}, true},
+		{"unknown authenticator", authenticators, []ListenerConfig{{Listen: ":8001", Auth: []string{"ldap"}}}, true},
+		{"invalid route", authenticators, []ListenerConfig{{Listen: ":8001", Routes: map[string][]string{"export": {"basic"}}}}, true},
+		{"invalid authenticator", map[string]AuthenticatorConfig{"basic": {Type: "basic"}}, nil, true},
+	}
+
+	for _, tc := range tests {
+		conf := Config{Server: ServerConfig{Authenticators: tc.authenticators, Listeners: tc.listeners}}
+		if err := conf.validateServer(); tc.err != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		}
+	}
+}
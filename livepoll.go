@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	// livePollHistory is the period read on the first poll to learn a channel's update rate
+	livePollHistory = time.Hour
+
+	// livePollSamples is the number of recent tuples the update rate is learned from
+	livePollSamples = 16
+
+	// livePollSlack is added to the expected time of the next tuple to allow for
+	// logger buffering and middleware latency
+	livePollSlack = 2 * time.Second
+
+	// livePollTimeout is the time budget of a poll
+	livePollTimeout = 30 * time.Second
+)
+
+// livePoll is the polling state of a channel
+type livePoll struct {
+	last     int64   // timestamp of the latest tuple, 0 before the first poll
+	recent   []Tuple // latest tuples the update rate is learned from
+	interval time.Duration
+	next     time.Time
+}
+
+// livePoller reads new tuples of all channels into the live store at intervals
+// adapted to each channel's update rate instead of polling at a fixed interval
+type livePoller struct {
+	api      *Api
+	store    *pushSubscriber
+	channels func() []string
+	expected map[string]time.Duration // configured sampling intervals
+	min, max time.Duration
+
+	polls map[string]*livePoll
+}
+
+func newLivePoller(api *Api, store *pushSubscriber, channels func() []string, expected map[string]time.Duration, min, max time.Duration) *livePoller {
+	return &livePoller{
+		api:      api,
+		store:    store,
+		channels: channels,
+		expected: expected,
+		min:      min,
+		max:      max,
+		polls:    make(map[string]*livePoll),
+	}
+}
+
+// clamp limits d to the poller's min..max
+func (p *livePoller) clamp(d time.Duration) time.Duration {
+	if d < p.min {
+		return p.min
+	}
+	if d > p.max {
+		return p.max
+	}
+	return d
+}
+
+// rate returns the update interval of the channel, the configured sampling interval
+// or the median interval of its recent tuples, zero if unknown
+func (p *livePoller) rate(uuid string, c *livePoll) time.Duration {
+	if d := p.expected[uuid]; d > 0 {
+		return d
+	}
+	return time.Duration(medianInterval(c.recent)) * time.Millisecond
+}
+
+// update schedules the next poll of the channel after new tuples were read at now.
+// Channels are polled shortly after their next tuple is due, polls finding no new
+// tuples back off up to the maximum interval.
+func (p *livePoller) update(uuid string, c *livePoll, tuples []Tuple, now time.Time) {
+	fresh := len(tuples) > 0
+	for _, t := range tuples {
+		c.last = t.Timestamp
+		c.recent = append(c.recent, t)
+	}
+	if n := len(c.recent); n > livePollSamples {
+		c.recent = append([]Tuple{}, c.recent[n-livePollSamples:]...)
+	}
+
+	rate := p.rate(uuid, c)
+	switch {
+	case rate == 0:
+		c.interval = p.max
+	case fresh:
+		c.interval = p.clamp(rate)
+	default:
+		c.interval = p.clamp(2 * c.interval)
+	}
+
+	c.next = now.Add(c.interval)
+	if fresh && rate > 0 {
+		// the next tuple is due one update interval after the latest
+		due := time.Unix(0, c.last*int64(time.Millisecond)).Add(rate + livePollSlack)
+		if due.After(now.Add(p.min)) && due.Before(c.next) {
+			c.next = due
+		}
+	}
+}
+
+// poll reads the new tuples of the channel into the live store
+func (p *livePoller) poll(ctx context.Context, uuid string, c *livePoll, now time.Time) {
+	from := now.Add(-livePollHistory)
+	if c.last > 0 {
+		from = time.Unix(0, c.last*int64(time.Millisecond))
+	}
+
+	// every poll requests a new range, caching would only evict other responses
+	tuples, err := p.api.getData(withoutCache(ctx), uuid, from, now, "", "", 0)
+	if err != nil {
+		log.Printf("live poll %s: %v", uuid, err)
+		c.interval = p.clamp(2 * c.interval)
+		c.next = now.Add(c.interval)
+		return
+	}
+
+	// the range includes the latest tuple already stored
+	fresh := make([]Tuple, 0, len(tuples))
+	for _, t := range tuples {
+		if t.Timestamp > c.last {
+			fresh = append(fresh, t)
+		}
+	}
+
+	p.update(uuid, c, fresh, now)
+	p.store.add(pushData{UUID: uuid, Tuples: fresh})
+}
+
+// due returns the channels due at now in order of their due time and the time of
+// the next poll after them
+func (p *livePoller) due(now time.Time) ([]string, time.Time) {
+	channels := make(map[string]bool)
+	for _, uuid := range p.channels() {
+		channels[uuid] = true
+		if _, ok := p.polls[uuid]; !ok {
+			p.polls[uuid] = &livePoll{interval: p.min, next: now}
+		}
+	}
+
+	res := []string{}
+	next := now.Add(p.max)
+	for uuid, c := range p.polls {
+		if !channels[uuid] {
+			delete(p.polls, uuid)
+			continue
+		}
+		if !c.next.After(now) {
+			res = append(res, uuid)
+		} else if c.next.Before(next) {
+			next = c.next
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return p.polls[res[i]].next.Before(p.polls[res[j]].next) })
+	return res, next
+}
+
+// run polls the channels as they become due
+func (p *livePoller) run() {
+	for {
+		now := time.Now()
+		uuids, next := p.due(now)
+
+		for _, uuid := range uuids {
+			ctx, cancel := context.WithTimeout(context.Background(), livePollTimeout)
+			p.poll(ctx, uuid, p.polls[uuid], time.Now())
+			cancel()
+
+			if c := p.polls[uuid]; c.next.Before(next) {
+				next = c.next
+			}
+		}
+
+		time.Sleep(time.Until(next))
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "math"
+
+// lttb downsamples tuples to threshold points using the Largest-Triangle-Three-Buckets
+// algorithm which preserves the visual shape of the series.
+// https://skemman.is/bitstream/1946/15343/3/SS_MSthesis.pdf
+func lttb(tuples []Tuple, threshold int) []Tuple {
+	if threshold >= len(tuples) || threshold < 3 {
+		return tuples
+	}
+
+	res := make([]Tuple, 0, threshold)
+
+	// bucket size, first and last point are kept as is
+	every := float64(len(tuples)-2) / float64(threshold-2)
+
+	a := 0
+	res = append(res, tuples[a])
+
+	for i := 0; i < threshold-2; i++ {
+		// average of next bucket as third triangle point
+		avgStart := int(math.Floor(float64(i+1)*every)) + 1
+		avgEnd := int(math.Floor(float64(i+2)*every)) + 1
+		if avgEnd > len(tuples) {
+			avgEnd = len(tuples)
+		}
+
+		var avgX, avgY float64
+		for j := avgStart; j < avgEnd; j++ {
+			avgX += float64(tuples[j].Timestamp)
+			avgY += float64(tuples[j].Value)
+		}
+		avgLen := float64(avgEnd - avgStart)
+		avgX /= avgLen
+		avgY /= avgLen
+
+		// current bucket
+		rangeStart := int(math.Floor(float64(i)*every)) + 1
+		rangeEnd := int(math.Floor(float64(i+1)*every)) + 1
+
+		ax := float64(tuples[a].Timestamp)
+		ay := float64(tuples[a].Value)
+
+		maxArea := -1.0
+		next := rangeStart
+		for j := rangeStart; j < rangeEnd; j++ {
+			area := math.Abs((ax-avgX)*(float64(tuples[j].Value)-ay) -
+				(ax-float64(tuples[j].Timestamp))*(avgY-ay))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+
+		res = append(res, tuples[next])
+		a = next
+	}
+
+	res = append(res, tuples[len(tuples)-1])
+
+	return res
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLTTB(t *testing.T) {
+	// 100 points between the endpoints, buckets of 10 with a spike of alternating
+	// sign in the middle of each
+	tuples := make([]Tuple, 102)
+	for i := range tuples {
+		tuples[i] = Tuple{Timestamp: int64(i) * 1000}
+	}
+	var spikes []Tuple
+	for i := 0; i < 10; i++ {
+		idx := 10*i + 6
+		tuples[idx].Value = 100 + float32(i)
+		if i%2 == 1 {
+			tuples[idx].Value = -tuples[idx].Value
+		}
+		spikes = append(spikes, tuples[idx])
+	}
+
+	res := lttb(tuples, 12)
+	if len(res) != 12 {
+		t.Fatalf("expected 12 points, got %d", len(res))
+	}
+	if res[0] != tuples[0] || res[11] != tuples[101] {
+		t.Errorf("expected endpoints %v and %v, got %v and %v", tuples[0], tuples[101], res[0], res[11])
+	}
+	if !reflect.DeepEqual(res[1:11], spikes) {
+		t.Errorf("expected spikes %v, got %v", spikes, res[1:11])
+	}
+}
+
+func TestLTTBThreshold(t *testing.T) {
+	tuples := []Tuple{{0, 1}, {1000, 5}, {2000, 2}, {3000, 8}, {4000, 3}, {5000, 1}}
+
+	tests := []struct {
+		threshold int
+		length    int
+	}{
+		{0, 6},  // disabled
+		{2, 6},  // too small
+		{6, 6},  // no reduction
+		{10, 6}, // no reduction
+		{3, 3},
+		{4, 4},
+		{5, 5},
+	}
+
+	for _, tc := range tests {
+		res := lttb(tuples, tc.threshold)
+		if len(res) != tc.length {
+			t.Errorf("threshold %d: expected %d points, got %d", tc.threshold, tc.length, len(res))
+			continue
+		}
+		if res[0] != tuples[0] || res[len(res)-1] != tuples[len(tuples)-1] {
+			t.Errorf("threshold %d: endpoints not kept: %v", tc.threshold, res)
+		}
+		for i := 1; i < len(res); i++ {
+			if res[i].Timestamp <= res[i-1].Timestamp {
+				t.Errorf("threshold %d: unordered points %v", tc.threshold, res)
+			}
+		}
+	}
+}
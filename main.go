@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net/http"
@@ -8,13 +9,239 @@ import (
 	"time"
 )
 
-var apiURL = flag.String("api", "https://demo.volkszaehler.org/middleware.php", "volkszaehler api url")
-var apiTimeout = flag.Duration("timeout", 30*time.Second, "volkszaehler api request timeout")
+// apiFlags are the volkszaehler api settings shared by all commands
+type apiFlags struct {
+	url            *string
+	standby        *string
+	timeout        *time.Duration
+	timeoutEntity  *time.Duration
+	timeoutData    *time.Duration
+	timeoutProg    *time.Duration
+	maxBody        *int64
+	retention      *time.Duration
+	retentionGroup *string
+	hedge          *time.Duration
+	maxRequests    *int
+	resolver       *string
+	hosts          *string
+	credentials    *credentialFlags
+	cacheTTL       *time.Duration
+	retries        *int
+	retryBackoff   *time.Duration
+	retryJitter    *float64
+	cacheSize      *int
+	cacheDir       *string
+	cacheDiskTTL   *time.Duration
+	chunkLatency   *time.Duration
+	compact        *bool
+	skewThreshold  *time.Duration
+	skewCorrect    *bool
+	tlsCA          *string
+	tlsCert        *string
+	tlsKey         *string
+	tlsInsecure    *bool
+	verbose        *bool
+}
+
+type credentialFlags struct {
+	username *string
+	password *string
+	token    *string
+}
+
+func registerCredentialFlags(fs *flag.FlagSet) *credentialFlags {
+	return &credentialFlags{
+		username: fs.String("username", "", "volkszaehler api basic auth username"),
+		password: fs.String("password", "", "volkszaehler api basic auth password (default $GRAVO_PASSWORD)"),
+		token:    fs.String("token", "", "volkszaehler api bearer token (default $GRAVO_TOKEN)"),
+	}
+}
+
+// credentials returns the flag values falling back to the environment for secrets
+func (f *credentialFlags) credentials() credentials {
+	creds := credentials{username: *f.username, password: *f.password, token: *f.token}
+	if creds.password == "" && creds.username != "" {
+		creds.password = os.Getenv("GRAVO_PASSWORD")
+	}
+	if creds.token == "" && creds.username == "" {
+		creds.token = os.Getenv("GRAVO_TOKEN")
+	}
+	return creds
+}
+
+func registerAPIFlags(fs *flag.FlagSet) *apiFlags {
+	return &apiFlags{
+		url:            fs.String("api", "https://demo.volkszaehler.org/middleware.php", "volkszaehler api url"),
+		standby:        fs.String("standby", "", "read-only volkszaehler api url serving reads while the primary is down, e.g. of a database replica"),
+		timeout:        fs.Duration("timeout", 30*time.Second, "volkszaehler api request timeout"),
+		timeoutEntity:  fs.Duration("timeout-entities", 0, "timeout of entity requests (default -timeout)"),
+		timeoutData:    fs.Duration("timeout-data", 0, "timeout of data requests (default -timeout)"),
+		timeoutProg:    fs.Duration("timeout-prognosis", 0, "timeout of prognosis requests (default -timeout)"),
+		maxBody:        fs.Int64("maxbody", 32<<20, "maximum volkszaehler api response size in bytes (0 for unlimited)"),
+		retention:      fs.Duration("retention", 0, "age after which the middleware only keeps aggregated data"),
+		retentionGroup: fs.String("retention-group", "hour", "aggregation level of data older than retention"),
+		hedge:          fs.Duration("hedge", 0, "send a duplicate request if no response arrived after this delay (0 to disable)"),
+		maxRequests:    fs.Int("max-requests", 8, "maximum simultaneous volkszaehler api requests, further requests are queued (0 for unlimited)"),
+		resolver:       fs.String("resolver", "", "dns server used for resolving the volkszaehler api host"),
+		hosts:          fs.String("hosts", "", "comma-separated static host to ip mappings, e.g. vz.local=192.168.1.10"),
+		credentials:    registerCredentialFlags(fs),
+		cacheTTL:       fs.Duration("cache-ttl", 0, "cache identical entity and data requests for this duration (0 to disable)"),
+		cacheSize:      fs.Int("cache-size", 1000, "maximum number of cached responses"),
+		cacheDir:       fs.String("cache-dir", "", "directory persisting cached responses as second cache level"),
+		cacheDiskTTL:   fs.Duration("cache-disk-ttl", 0, "duration responses are kept in -cache-dir (default -cache-ttl)"),
+		retries:        fs.Int("retries", 3, "maximum attempts of volkszaehler api requests failing with network or server errors"),
+		retryBackoff:   fs.Duration("retry-backoff", 500*time.Millisecond, "delay before the first retry, doubled for each further retry"),
+		retryJitter:    fs.Float64("retry-jitter", 0.2, "random fraction retry delays are varied by"),
+		chunkLatency:   fs.Duration("chunk-latency", 5*time.Second, "target data request latency, slower channels are fetched in smaller chunks (0 to disable)"),
+		compact:        fs.Bool("compact", false, "request delta encoded tuples (application/x-vz-delta) from servers supporting it"),
+		skewThreshold:  fs.Duration("skew-threshold", 30*time.Second, "warn if the volkszaehler api clock differs by more than this (0 to disable)"),
+		skewCorrect:    fs.Bool("skew-correct", false, "shift query windows by the volkszaehler api clock skew exceeding -skew-threshold"),
+		tlsCA:          fs.String("tls-ca", "", "pem file with root certificates verifying the volkszaehler api"),
+		tlsCert:        fs.String("tls-cert", "", "pem file with client certificate sent to the volkszaehler api"),
+		tlsKey:         fs.String("tls-key", "", "pem file with client key"),
+		tlsInsecure:    fs.Bool("tls-insecure", false, "skip verifying the volkszaehler api certificate"),
+		verbose:        fs.Bool("verbose", false, "verbose logging"),
+	}
+}
+
+// transport returns the transport with the resolver and TLS settings
+func (f *apiFlags) transport() (*http.Transport, error) {
+	hosts, err := parseHosts(*f.hosts)
+	if err != nil {
+		return nil, &cliError{exitConfig, err}
+	}
+
+	base := newTransport(*f.resolver, hosts)
+	if base.TLSClientConfig, err = clientTLS(*f.tlsCA, *f.tlsCert, *f.tlsKey, *f.tlsInsecure); err != nil {
+		return nil, &cliError{exitConfig, err}
+	}
+	return base, nil
+}
+
+func (f *apiFlags) api() (*Api, error) {
+	base, err := f.transport()
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := withCredentials(base, *f.url, f.credentials.credentials())
+	if err != nil {
+		return nil, &cliError{exitConfig, err}
+	}
+
+	api := newAPI(*f.url, f.timeout, transport, *f.maxBody, *f.verbose)
+	api.tls = base.TLSClientConfig
+	api.retention = *f.retention
+	api.retentionGroup = *f.retentionGroup
+	api.hedge = *f.hedge
+	api.limiter = newLimiter(*f.maxRequests)
+	api.cache = newResponseCache(*f.cacheTTL, *f.cacheSize)
+	if api.cache != nil && *f.cacheDir != "" {
+		ttl := *f.cacheDiskTTL
+		if ttl <= 0 {
+			ttl = *f.cacheTTL
+		}
+		if api.cache.l2, err = newDiskCache(*f.cacheDir, ttl); err != nil {
+			return nil, &cliError{exitConfig, err}
+		}
+	}
+	api.retry = retryPolicy{attempts: *f.retries, backoff: *f.retryBackoff, jitter: *f.retryJitter}
+	api.chunks = newChunkTuner(*f.chunkLatency, *f.maxBody)
+	api.compact = *f.compact
+	api.skew = f.clockSkew()
+	api.timeouts = f.requestTimeouts()
+
+	if *f.standby != "" {
+		// the standby shares the credentials of the primary
+		transport, err := withCredentials(base, *f.standby, f.credentials.credentials())
+		if err != nil {
+			return nil, &cliError{exitConfig, err}
+		}
+		standby := newAPI(*f.standby, f.timeout, transport, *f.maxBody, *f.verbose)
+		standby.limiter = newLimiter(*f.maxRequests)
+		standby.retry = retryPolicy{attempts: 1}
+		standby.compact = api.compact
+		standby.skew = f.clockSkew()
+		standby.timeouts = api.timeouts
+		api.standby = standby
+	}
+
+	return api, nil
+}
+
+// requestTimeouts returns the request timeouts by endpoint
+func (f *apiFlags) requestTimeouts() requestTimeouts {
+	return requestTimeouts{def: *f.timeout, entities: *f.timeoutEntity, data: *f.timeoutData, prognosis: *f.timeoutProg}
+}
+
+// clockSkew returns a clock skew tracker, nil if disabled
+func (f *apiFlags) clockSkew() *clockSkew {
+	if *f.skewThreshold <= 0 {
+		return nil
+	}
+	return &clockSkew{threshold: *f.skewThreshold, correct: *f.skewCorrect}
+}
+
+// commands are the available sub commands. Without command the server is started.
+// Commands parse their flags from fs which provides the shared output flag.
+var commands = map[string]func(fs *flag.FlagSet, args []string) error{
+	"quality":   qualityCommand,
+	"export":    exportCommand,
+	"import":    importCommand,
+	"ping":      pingCommand,
+	"prognosis": prognosisCommand,
+	"push":      pushCommand,
+	"selftest":  selftestCommand,
+	"snapshot":  snapshotCommand,
+	"sync":      syncCommand,
+	"tail":      tailCommand,
+	"tui":       tuiCommand,
+	"update":    updateCommand,
+	"version":   versionCommand,
+}
+
+var apiOptions = registerAPIFlags(flag.CommandLine)
+var configFile = flag.String("config", "", "yaml configuration file")
 var url = flag.String("url", "0.0.0.0:8000", "listning address")
-var verbose = flag.Bool("verbose", false, "verbose logging")
+var webhook = flag.String("webhook", "", "webhook url receiving detected anomalies")
+var decimals = flag.String("decimals", "", "comma-separated uuid or entity type to decimals mapping, e.g. power=0,temperature=1")
+var queryTimeout = flag.Duration("query-timeout", time.Minute, "total time budget of a query including retries and chunked requests")
+var grafanaTimeout = flag.Duration("grafana-timeout", 30*time.Second, "grafana data proxy timeout, queries are answered slightly before (0 to disable)")
+var entityFile = flag.String("entities", "", "file persisting the last known entities for startup while the middleware is down")
+var aliasRefresh = flag.Duration("alias-refresh", 5*time.Minute, "interval of refreshing the entity list served by search and the channel name to uuid mapping (0 to disable)")
+var protocol = flag.String("protocol", protocolSimpleJSON, "Grafana datasource protocol: simplejson or jsonapi (JSON API datasource with payloads, including simplejson)")
+var autoscale = flag.Bool("autoscale", false, "scale series with SI units like W or Wh to prefixes matching their magnitude, e.g. kW")
+var fanout = flag.Int("fanout", 8, "maximum targets of a query fetched concurrently (0 for unlimited)")
+var snapshotFile = flag.String("snapshot", "", "serve a snapshot file read-only instead of the volkszaehler api")
+var write = flag.Bool("write", false, "enable POST /write forwarding tuples to the middleware")
+var writeToken = flag.String("write-token", "", "token required by POST /write unless the listeners authenticate the write routes (default $GRAVO_WRITE_TOKEN)")
+var invalidateToken = flag.String("invalidate-token", "", "token enabling POST /invalidate dropping cached responses (default $GRAVO_INVALIDATE_TOKEN)")
+var alertsFile = flag.String("alerts", "", "file storing Grafana alert notifications received at POST /alerts for annotations")
+var consoleToken = flag.String("console-token", "", "token enabling the /console query console (default $GRAVO_CONSOLE_TOKEN)")
+var alertsToken = flag.String("alerts-token", "", "token required by POST /alerts, mandatory with -alerts (default $GRAVO_ALERTS_TOKEN)")
+var pushURL = flag.String("push", "", "volkszaehler push server websocket url, e.g. ws://vz.local:8082, serving live tuples")
+var livePollMax = flag.Duration("live-poll", 0, "poll live tuples of all channels at intervals adapted to their update rate, up to this interval, instead of using a push server (0 to disable)")
+var livePollMinimum = flag.Duration("live-poll-min", 5*time.Second, "minimum interval of polling live tuples")
+var readyMaxAge = flag.Duration("ready-max-age", 15*time.Minute, "maximum age of the entity list for /readyz (0 to disable)")
+var selfTestStartup = flag.Bool("selftest", false, "run the self-test on startup and log its report")
+var selfTestFatal = flag.Bool("selftest-fatal", false, "exit if the startup self-test fails")
 var help = flag.Bool("help", false, "help")
 
 func main() {
+	// serve is the default command
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+			output := fs.String("output", "text", "error output format (text, json)")
+			exitWithError(cmd(fs, os.Args[2:]), *output)
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if *help {
@@ -22,17 +249,183 @@ func main() {
 		os.Exit(0)
 	}
 
-	api := newAPI(*apiURL, apiTimeout, *verbose)
-	server := newServer(api)
+	precision, err := parseDecimals(*decimals)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var conf Config
+	if *configFile != "" {
+		if conf, err = loadConfig(*configFile); err != nil {
+			log.Fatalf("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(flag.CommandLine, conf); err != nil {
+			log.Fatalf("config %s: %v", *configFile, err)
+		}
+	}
 
-	http.HandleFunc("/", handler(server.rootHandler, *verbose))
-	http.HandleFunc("/query", handler(server.queryHandler, *verbose))
-	http.HandleFunc("/search", handler(server.searchHandler, *verbose))
-	http.HandleFunc("/annotations", handler(server.annotationsHandler, *verbose))
-	http.HandleFunc("/tag-keys", handler(server.tagKeysHandler, *verbose))
-	http.HandleFunc("/tag-values", handler(server.tagValuesHandler, *verbose))
+	verbose := *apiOptions.verbose
+	var api *Api
+	if *snapshotFile != "" {
+		api, err = snapshotAPI(*snapshotFile, conf.location, verbose)
+	} else {
+		api, err = apiOptions.api()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.overlay = conf.metadataOverlay()
+	api.tokens = conf.channelTokens()
+
+	server := newServer(api, conf, *webhook, precision)
+	server.queryTimeout = *queryTimeout
+	server.grafanaTimeout = *grafanaTimeout
+	server.fanout = *fanout
+	server.autoscale = *autoscale
+	if server.protocol = *protocol; !validProtocols[server.protocol] {
+		log.Fatalf("invalid protocol: %s", *protocol)
+	}
+	server.entityFile = *entityFile
+	server.readyMaxAge = *readyMaxAge
+
+	if server.sites, err = apiOptions.siteAPIs(conf.Sites); err != nil {
+		log.Fatal(err)
+	}
+
+	if *selfTestStartup || *selfTestFatal {
+		ctx, cancel := context.WithTimeout(context.Background(), *queryTimeout)
+		steps := selfTest(ctx, api, conf)
+		cancel()
+
+		logSelfTest(steps)
+		if err := selfTestError(steps); err != nil && *selfTestFatal {
+			log.Fatal(err)
+		}
+	}
+
+	// get entity map on startup
+	server.refreshEntities(context.Background())
+	if *aliasRefresh > 0 {
+		go server.runEntityRefresh(*aliasRefresh)
+	}
+
+	if *pushURL != "" && *livePollMax > 0 {
+		log.Fatal("-push and -live-poll are exclusive")
+	}
+	if *livePollMax > 0 && (*livePollMinimum <= 0 || *livePollMinimum > *livePollMax) {
+		log.Fatalf("invalid live-poll-min: %v", *livePollMinimum)
+	}
+
+	if *pushURL != "" {
+		server.push = newPushSubscriber(*pushURL, api.tls, server.pushChannels)
+		go server.push.run()
+	} else if *livePollMax > 0 {
+		server.push = newPushSubscriber("", nil, server.pushChannels)
+		go newLivePoller(api, server.push, server.pushChannels, conf.channelIntervals(), *livePollMinimum, *livePollMax).run()
+	}
+	if server.push != nil {
+		http.HandleFunc("/stream", cors(metered(allowed(requestIDs(server.streamHandler), http.MethodGet))))
+	}
+
+	if err := startScheduler(api, conf); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(conf.Watchdog.Channels) > 0 {
+		if server.watchdog, err = newWatchdog(api, conf.Watchdog, conf.channelIntervals(), *webhook); err != nil {
+			log.Fatal(err)
+		}
+		go server.watchdog.run()
+	}
+
+	if server.sla, err = newSLAMonitor(api, conf.SLA, conf.channelIntervals()); err != nil {
+		log.Fatal(err)
+	}
+	go server.sla.run()
+
+	writeBacks, err := newWriteBacks(server, conf.WriteBack)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, wb := range writeBacks {
+		go wb.run()
+	}
+
+	http.HandleFunc("/", handler(server.rootHandler, verbose))
+	http.HandleFunc("/query", handler(server.queryHandler, verbose))
+	http.HandleFunc("/search", handler(server.searchHandler, verbose))
+	http.HandleFunc("/annotations", handler(server.annotationsHandler, verbose))
+	http.HandleFunc("/tag-keys", handler(server.tagKeysHandler, verbose))
+	http.HandleFunc("/tag-values", handler(server.tagValuesHandler, verbose))
+	http.HandleFunc("/batch", handler(server.batchHandler, verbose))
+	http.HandleFunc("/saved", readHandler(server.savedHandler, verbose))
+	http.HandleFunc("/saved/", readHandler(server.savedHandler, verbose))
+	http.HandleFunc("/aliases", readHandler(server.aliasesHandler, verbose))
+	http.HandleFunc("/export", readHandler(server.exportHandler, verbose))
+	http.HandleFunc("/api/v1/read", handler(server.remoteReadHandler, verbose))
+	if !server.jsonAPI() {
+		http.HandleFunc("/metrics", server.metricsHandler)
+	} else {
+		jsonMetrics := handler(server.jsonMetricsHandler, verbose)
+		http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost || r.Method == http.MethodOptions {
+				jsonMetrics(w, r)
+				return
+			}
+			server.metricsHandler(w, r)
+		})
+		http.HandleFunc("/metric-payload-options", handler(server.payloadOptionsHandler, verbose))
+		http.HandleFunc("/variable", handler(server.variableHandler, verbose))
+	}
+	http.HandleFunc("/healthz", server.healthHandler)
+	http.HandleFunc("/readyz", server.readyHandler)
+
+	if *invalidateToken == "" {
+		*invalidateToken = os.Getenv("GRAVO_INVALIDATE_TOKEN")
+	}
+	if *invalidateToken != "" {
+		server.invalidateToken = *invalidateToken
+		http.HandleFunc("/invalidate", handler(server.invalidateHandler, verbose))
+		http.HandleFunc("/admin/reload-entities", handler(server.reloadHandler, verbose))
+	}
+
+	if server.consoleToken = *consoleToken; server.consoleToken == "" {
+		server.consoleToken = os.Getenv("GRAVO_CONSOLE_TOKEN")
+	}
+	if server.consoleToken != "" {
+		http.HandleFunc("/console", readHandler(server.consoleHandler, verbose))
+	}
+
+	if *alertsFile != "" {
+		if server.alerts, err = newAlertStore(*alertsFile); err != nil {
+			log.Fatal(err)
+		}
+		if server.alertsToken = *alertsToken; server.alertsToken == "" {
+			server.alertsToken = os.Getenv("GRAVO_ALERTS_TOKEN")
+		}
+		// anyone reaching gravo could insert annotations otherwise
+		if server.alertsToken == "" {
+			log.Fatal("-alerts requires -alerts-token or $GRAVO_ALERTS_TOKEN")
+		}
+		http.HandleFunc("/alerts", handler(server.alertsHandler, verbose))
+	}
+
+	if *write {
+		if *snapshotFile != "" {
+			log.Fatal("-write is not supported with -snapshot")
+		}
+		if server.writeToken = *writeToken; server.writeToken == "" {
+			server.writeToken = os.Getenv("GRAVO_WRITE_TOKEN")
+		}
+		// anyone reaching gravo could write to the middleware otherwise
+		if server.writeToken == "" && !conf.Server.authenticated(routeWrite) {
+			log.Fatal("-write requires -write-token, $GRAVO_WRITE_TOKEN or listeners authenticating the write routes")
+		}
+		server.writes = newWriteChecker(conf)
+		http.HandleFunc("/write", handler(server.writeHandler, verbose))
+	}
 
-	if err := http.ListenAndServe(*url, nil); err != nil {
+	if err := conf.Server.serve(*url, http.DefaultServeMux); err != nil {
 		log.Fatal(err)
 	}
 }
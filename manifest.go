@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	neturl "net/url"
+	"sort"
+	"time"
+)
+
+// manifestName is the name of the manifest in export bundles
+const manifestName = "manifest.json"
+
+// ExportManifest describes how the data of an export bundle was produced to audit
+// and reproduce it
+type ExportManifest struct {
+	Gravo      string           `json:"gravo"`
+	Created    time.Time        `json:"created"`
+	Middleware string           `json:"middleware"`
+	Range      ManifestRange    `json:"range"`
+	Format     string           `json:"format"`
+	Locale     string           `json:"locale"`
+	Decimals   int              `json:"decimals"` // -1 for full precision
+	File       ManifestFile     `json:"file"`
+	Series     []ManifestSeries `json:"series"`
+	Saved      *ManifestSaved   `json:"saved,omitempty"`
+	Requests   []TracedRequest  `json:"requests"`         // middleware requests the data was read from
+	Errors     []string         `json:"errors,omitempty"` // channels missing from the export
+}
+
+// ManifestRange is the exported time range
+type ManifestRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// ManifestFile identifies the exported data file of the bundle
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestSeries is the pipeline of an exported series
+type ManifestSeries struct {
+	Name       string            `json:"name"`
+	UUID       string            `json:"uuid,omitempty"`
+	Source     string            `json:"source"` // channel, virtual or saved
+	Unit       string            `json:"unit,omitempty"`
+	Timezone   string            `json:"timezone,omitempty"`
+	Rows       int               `json:"rows"`
+	Data       TargetData        `json:"data,omitempty"` // settings the channel was read with
+	Expression string            `json:"expression,omitempty"`
+	Channels   map[string]string `json:"channels,omitempty"` // variables of virtual channels
+}
+
+// ManifestSaved is the saved query of the export with its effective range
+type ManifestSaved struct {
+	Name    string        `json:"name"`
+	Period  string        `json:"period,omitempty"`
+	Range   ManifestRange `json:"range"`
+	Targets []Target      `json:"targets"`
+}
+
+// exportManifest describes the export of series read in the range. Saved is the
+// exported saved query if any, requests are the traced middleware requests.
+func (server *Server) exportManifest(series []exportSeries, saved string, r Range, requests []TracedRequest) ExportManifest {
+	m := ExportManifest{
+		Gravo:      version,
+		Created:    time.Now().UTC(),
+		Middleware: redactURL(server.api.url),
+		Range:      ManifestRange{From: r.From, To: r.To},
+		Requests:   make([]TracedRequest, 0, len(requests)),
+	}
+
+	for _, s := range series {
+		ms := ManifestSeries{
+			Name:   s.Title,
+			UUID:   s.UUID,
+			Source: s.Source,
+			Unit:   s.Unit,
+			Rows:   len(s.Tuples),
+		}
+		for k, v := range s.Data {
+			if v == "" {
+				continue
+			}
+			if ms.Data == nil {
+				ms.Data = TargetData{}
+			}
+			ms.Data[k] = v
+		}
+		if s.Location != nil {
+			ms.Timezone = s.Location.String()
+		}
+		switch s.Source {
+		case sourceVirtual:
+			v := server.conf.Virtual[s.UUID]
+			ms.Expression, ms.Channels = v.Expression, v.Channels
+		case sourceSaved:
+			// see the targets of the saved query
+			ms.UUID = ""
+		}
+		m.Series = append(m.Series, ms)
+	}
+
+	if saved != "" {
+		if sqr, err := server.savedRequest(saved, &QueryRequest{Range: r}); err == nil {
+			m.Saved = &ManifestSaved{
+				Name:    saved,
+				Period:  server.conf.Queries[saved].Period,
+				Range:   ManifestRange{From: sqr.Range.From, To: sqr.Range.To},
+				Targets: sqr.Targets,
+			}
+		}
+	}
+
+	for _, req := range requests {
+		req.URL = redactURL(req.URL)
+		if req.Status != 0 {
+			// middleware error bodies are not part of the audit trail, the status is
+			req.Error = ""
+		}
+		m.Requests = append(m.Requests, req)
+	}
+	sort.SliceStable(m.Requests, func(i, j int) bool {
+		return m.Requests[i].Start < m.Requests[j].Start
+	})
+
+	return m
+}
+
+// redactURL masks credentials of the url
+func redactURL(s string) string {
+	u, err := neturl.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = neturl.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// writeBundle writes a zip archive of the data file named name and the manifest
+func writeBundle(w io.Writer, name string, data []byte, m ExportManifest) error {
+	sum := sha256.Sum256(data)
+	m.File = ManifestFile{Name: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])}
+
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, part := range []struct {
+		name string
+		b    []byte
+	}{{name, data}, {manifestName, manifest}} {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: part.name, Method: zip.Deflate, Modified: m.Created})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, bytes.NewReader(part.b)); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// queryMeter reconstructs the absolute meter reading by accumulating the channel's
+// per-interval consumption onto the `initial` reading at time `since`. With input
+// power the consumption is integrated from power in W first. Consumption is
+// multiplied by `scale` to match the unit of the initial reading.
+func (server *Server) queryMeter(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	since, err := parseTime(target.Data["since"], server.conf.location(target.Target))
+	if err != nil || since.After(qr.Range.From) {
+		logf(ctx, "meter: invalid since for %s", target.Target)
+		return dataResponse(target.Target, []Tuple{}, qr), nil
+	}
+
+	initial := target.Data.float("initial", 0)
+	scale := target.Data.float("scale", 1)
+
+	tuples, err := server.api.getData(ctx, target.Target, since, qr.Range.To, "", "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	var deltas []float64
+	if strings.ToLower(target.Data["input"]) == "power" {
+		energy := cumulativeEnergy(tuples)
+		deltas = make([]float64, len(energy))
+		for i := 1; i < len(energy); i++ {
+			deltas[i] = energy[i] - energy[i-1]
+		}
+	} else {
+		deltas = make([]float64, len(tuples))
+		for i, tuple := range tuples {
+			deltas[i] = float64(tuple.Value)
+		}
+	}
+
+	from := unixMS(qr.Range.From)
+	reading := initial
+
+	res := []Tuple{}
+	for i, tuple := range tuples {
+		reading += deltas[i] * scale
+		if tuple.Timestamp >= from {
+			res = append(res, Tuple{Timestamp: tuple.Timestamp, Value: float32(reading)})
+		}
+	}
+
+	return dataResponse(target.Target, res, qr), nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// histogram counts observations per latency bucket
+type histogram struct {
+	counts []int64 // per bucket, not cumulative
+	count  int64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// counter is a metric value updated atomically, Set is used by gauges
+type counter struct {
+	v int64
+}
+
+func (c *counter) Add(delta int64) {
+	atomic.AddInt64(&c.v, delta)
+}
+
+func (c *counter) Set(v int64) {
+	atomic.StoreInt64(&c.v, v)
+}
+
+func (c *counter) Value() int64 {
+	return atomic.LoadInt64(&c.v)
+}
+
+// sinkCounters are the tuples written, dropped, buffered and queued and the failed
+// flushes of a sink
+type sinkCounters struct {
+	written, failed, dropped counter
+	buffered, queued         counter
+}
+
+// processMetrics collects request statistics of gravo and its middleware requests
+type processMetrics struct {
+	mu       sync.Mutex
+	requests map[[2]string]int64      // path, status
+	latency  map[[2]string]*histogram // path
+	upstream map[[2]string]*histogram // method, endpoint
+	failures map[[2]string]int64      // method, endpoint
+	rejected map[[2]string]int64      // uuid, reason
+	skews    map[string]float64       // seconds by middleware url
+	sinks    map[string]*sinkCounters
+
+	inFlight int64
+}
+
+var gravoMetrics = &processMetrics{
+	requests: make(map[[2]string]int64),
+	latency:  make(map[[2]string]*histogram),
+	upstream: make(map[[2]string]*histogram),
+	failures: make(map[[2]string]int64),
+	rejected: make(map[[2]string]int64),
+	skews:    make(map[string]float64),
+	sinks:    make(map[string]*sinkCounters),
+}
+
+// metricPath returns the registered pattern serving r to bound label cardinality
+func metricPath(r *http.Request) string {
+	_, pattern := http.DefaultServeMux.Handler(r)
+	if pattern != "/" {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
+
+// upstreamEndpoint returns the middleware endpoint of url, e.g. data or entity
+func upstreamEndpoint(url string) string {
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url = url[:i]
+	}
+	for _, endpoint := range []string{"data", "entity", "prognosis", "capabilities"} {
+		if strings.Contains(url, "/"+endpoint+"/") || strings.Contains(url, "/"+endpoint+".json") {
+			return endpoint
+		}
+	}
+	return "other"
+}
+
+// observeRequest records a served request
+func (m *processMetrics) observeRequest(path string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[[2]string{path, fmt.Sprint(status)}]++
+
+	key := [2]string{path, ""}
+	if m.latency[key] == nil {
+		m.latency[key] = &histogram{}
+	}
+	m.latency[key].observe(d.Seconds())
+}
+
+// observeUpstream records a middleware request, failed if err is not nil
+func (m *processMetrics) observeUpstream(method, url string, d time.Duration, err error) {
+	key := [2]string{method, upstreamEndpoint(url)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.upstream[key] == nil {
+		m.upstream[key] = &histogram{}
+	}
+	m.upstream[key].observe(d.Seconds())
+
+	// requests cancelled by the client or hedging did not fail
+	if err != nil && !errors.Is(err, context.Canceled) {
+		m.failures[key]++
+	}
+}
+
+// observeRejected records a tuple rejected by POST /write
+func (m *processMetrics) observeRejected(uuid, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rejected[[2]string{uuid, reason}]++
+}
+
+// observeSkew records the measured clock skew of a middleware
+func (m *processMetrics) observeSkew(url string, offset time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.skews[url] = offset.Seconds()
+}
+
+// sink returns the counters of the named sink
+func (m *processMetrics) sink(name string) *sinkCounters {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.sinks[name] == nil {
+		m.sinks[name] = &sinkCounters{}
+	}
+	return m.sinks[name]
+}
+
+// statusWriter records the response status
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush supports streaming responses
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// metered counts requests by path and status and tracks requests in flight
+func metered(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&gravoMetrics.inFlight, 1)
+		defer atomic.AddInt64(&gravoMetrics.inFlight, -1)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		f(sw, r)
+
+		gravoMetrics.observeRequest(metricPath(r), sw.status, time.Since(start))
+	}
+}
+
+// writeHistogram writes h in Prometheus text format
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	var cumulative int64
+	for i, le := range latencyBuckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, labels, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, strings.TrimSuffix(labels, ","), h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, strings.TrimSuffix(labels, ","), h.count)
+}
+
+// sortPairs sorts label pairs for stable output
+func sortPairs(keys [][2]string) [][2]string {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	return keys
+}
+
+// sortedKeys returns the keys of m in stable order
+func sortedKeys(m map[[2]string]*histogram) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return sortPairs(keys)
+}
+
+// write writes the process metrics in Prometheus text format
+func (m *processMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP gravo_http_requests_total Requests served by path and status.")
+	fmt.Fprintln(w, "# TYPE gravo_http_requests_total counter")
+	keys := make([][2]string, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	for _, key := range sortPairs(keys) {
+		fmt.Fprintf(w, "gravo_http_requests_total{path=\"%s\",status=\"%s\"} %d\n", promLabel(key[0]), key[1], m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_http_request_duration_seconds Duration of served requests by path.")
+	fmt.Fprintln(w, "# TYPE gravo_http_request_duration_seconds histogram")
+	for _, key := range sortedKeys(m.latency) {
+		writeHistogram(w, "gravo_http_request_duration_seconds", fmt.Sprintf("path=\"%s\",", promLabel(key[0])), m.latency[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_http_requests_in_flight Requests currently served.")
+	fmt.Fprintln(w, "# TYPE gravo_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "gravo_http_requests_in_flight %d\n", atomic.LoadInt64(&m.inFlight))
+
+	fmt.Fprintln(w, "# HELP gravo_middleware_request_duration_seconds Duration of middleware requests by method and endpoint.")
+	fmt.Fprintln(w, "# TYPE gravo_middleware_request_duration_seconds histogram")
+	for _, key := range sortedKeys(m.upstream) {
+		writeHistogram(w, "gravo_middleware_request_duration_seconds", fmt.Sprintf("method=\"%s\",endpoint=\"%s\",", key[0], key[1]), m.upstream[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_middleware_request_errors_total Failed middleware requests by method and endpoint.")
+	fmt.Fprintln(w, "# TYPE gravo_middleware_request_errors_total counter")
+	for _, key := range sortedKeys(m.upstream) {
+		fmt.Fprintf(w, "gravo_middleware_request_errors_total{method=\"%s\",endpoint=\"%s\"} %d\n", key[0], key[1], m.failures[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_write_rejected_total Tuples rejected by POST /write by channel and reason.")
+	fmt.Fprintln(w, "# TYPE gravo_write_rejected_total counter")
+	keys = keys[:0]
+	for key := range m.rejected {
+		keys = append(keys, key)
+	}
+	for _, key := range sortPairs(keys) {
+		fmt.Fprintf(w, "gravo_write_rejected_total{uuid=\"%s\",reason=\"%s\"} %d\n", promLabel(key[0]), key[1], m.rejected[key])
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_middleware_requests_in_flight Middleware requests currently sent.")
+	fmt.Fprintln(w, "# TYPE gravo_middleware_requests_in_flight gauge")
+	fmt.Fprintf(w, "gravo_middleware_requests_in_flight %d\n", upstreamActive.Value())
+
+	fmt.Fprintln(w, "# HELP gravo_middleware_requests_queued Middleware requests waiting for a free slot.")
+	fmt.Fprintln(w, "# TYPE gravo_middleware_requests_queued gauge")
+	fmt.Fprintf(w, "gravo_middleware_requests_queued %d\n", upstreamQueued.Value())
+
+	fmt.Fprintln(w, "# HELP gravo_middleware_clock_skew_seconds Measured offset of the middleware clock against gravo.")
+	fmt.Fprintln(w, "# TYPE gravo_middleware_clock_skew_seconds gauge")
+	urls := make([]string, 0, len(m.skews))
+	for url := range m.skews {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		fmt.Fprintf(w, "gravo_middleware_clock_skew_seconds{url=\"%s\"} %g\n", promLabel(url), m.skews[url])
+	}
+
+	names := make([]string, 0, len(m.sinks))
+	for name := range m.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, metric := range []struct {
+		name, kind, help string
+		value            func(*sinkCounters) int64
+	}{
+		{"gravo_sink_written_total", "counter", "Tuples delivered by sink.", func(c *sinkCounters) int64 { return c.written.Value() }},
+		{"gravo_sink_failures_total", "counter", "Failed deliveries by sink.", func(c *sinkCounters) int64 { return c.failed.Value() }},
+		{"gravo_sink_dropped_total", "counter", "Tuples dropped by sink as buffer or queue were full.", func(c *sinkCounters) int64 { return c.dropped.Value() }},
+		{"gravo_sink_buffered", "gauge", "Tuples buffered in memory by sink.", func(c *sinkCounters) int64 { return c.buffered.Value() }},
+		{"gravo_sink_queued", "gauge", "Tuples queued on disk by sink.", func(c *sinkCounters) int64 { return c.queued.Value() }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s{sink=\"%s\"} %d\n", metric.name, promLabel(name), metric.value(m.sinks[name]))
+		}
+	}
+
+	levels := []struct {
+		name         string
+		hits, misses int64
+	}{
+		{"memory", cacheHits.Value(), cacheMisses.Value()},
+		{"disk", cacheDiskHits.Value(), cacheDiskMisses.Value()},
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_cache_hits_total Middleware responses served from the cache level.")
+	fmt.Fprintln(w, "# TYPE gravo_cache_hits_total counter")
+	for _, l := range levels {
+		fmt.Fprintf(w, "gravo_cache_hits_total{level=\"%s\"} %d\n", l.name, l.hits)
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_cache_misses_total Middleware responses not found in the cache level.")
+	fmt.Fprintln(w, "# TYPE gravo_cache_misses_total counter")
+	for _, l := range levels {
+		fmt.Fprintf(w, "gravo_cache_misses_total{level=\"%s\"} %d\n", l.name, l.misses)
+	}
+
+	fmt.Fprintln(w, "# HELP gravo_cache_hit_ratio Share of lookups answered by the cache level since start.")
+	fmt.Fprintln(w, "# TYPE gravo_cache_hit_ratio gauge")
+	for _, l := range levels {
+		var ratio float64
+		if total := l.hits + l.misses; total > 0 {
+			ratio = float64(l.hits) / float64(total)
+		}
+		fmt.Fprintf(w, "gravo_cache_hit_ratio{level=\"%s\"} %g\n", l.name, ratio)
+	}
+}
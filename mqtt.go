@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// mqttSink publishes json records to <topic>/<uuid> using MQTT 3.1.1 with QoS 0
+type mqttSink struct {
+	broker   string
+	topic    string
+	username string
+	password string
+	clientID string
+}
+
+// mqttString encodes a length-prefixed MQTT string
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
+
+// mqttPacket encodes a control packet with variable length header
+func mqttPacket(typ byte, payload []byte) []byte {
+	res := []byte{typ}
+	n := len(payload)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		res = append(res, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(res, payload...)
+}
+
+// connect opens a session with the broker
+func (s *mqttSink) connect(ctx context.Context) (net.Conn, error) {
+	addr := s.broker
+	if u, err := neturl.Parse(s.broker); err == nil && u.Host != "" {
+		addr = u.Host
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "1883")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	clientID := s.clientID
+	if clientID == "" {
+		clientID = "gravo-" + newID()
+	}
+
+	flags := byte(0x02) // clean session
+	payload := mqttString(clientID)
+	if s.username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(s.username)...)
+		if s.password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(s.password)...)
+		}
+	}
+
+	header := append(mqttString("MQTT"), 4, flags, 0, 60)
+	if _, err := conn.Write(mqttPacket(0x10, append(header, payload...))); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if ack[0] != 0x20 || ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("mqtt connect refused: code %d", ack[3])
+	}
+
+	return conn, nil
+}
+
+func (s *mqttSink) send(ctx context.Context, batches []SinkBatch) error {
+	recs := records(batches)
+	if len(recs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	for _, rec := range recs {
+		b, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+
+		topic := strings.TrimRight(s.topic, "/") + "/" + rec.UUID
+		w.Write(mqttPacket(0x30, append(mqttString(topic), b...)))
+	}
+	w.Write(mqttPacket(0xe0, nil))
+
+	return w.Flush()
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// localSite names the primary middleware in multisite channels
+const localSite = "local"
+
+// SiteConfig is a further middleware, e.g. of another property
+type SiteConfig struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+// MultisiteConfig is a logical channel like grid power available at several sites,
+// returned as one series per site or their sum
+type MultisiteConfig struct {
+	Channels map[string]string `yaml:"channels"` // site to uuid, local for the primary middleware
+	Mode     string            `yaml:"mode"`     // series (default) or sum
+	Unit     string            `yaml:"unit"`
+}
+
+// validateMultisite checks that multisite channels refer to configured sites
+func (conf Config) validateMultisite() error {
+	for name, site := range conf.Sites {
+		if name == localSite || site.URL == "" {
+			return fmt.Errorf("site %s: invalid name or missing url", name)
+		}
+	}
+
+	for name, m := range conf.Multisite {
+		if name == "*" || strings.HasPrefix(name, savedPrefix) {
+			return fmt.Errorf("multisite %s: invalid name", name)
+		}
+		if len(m.Channels) == 0 {
+			return fmt.Errorf("multisite %s: missing channels", name)
+		}
+		for site := range m.Channels {
+			if _, ok := conf.Sites[site]; !ok && site != localSite {
+				return fmt.Errorf("multisite %s: unknown site: %s", name, site)
+			}
+		}
+		if mode := strings.ToLower(m.Mode); mode != "" && mode != "series" && mode != "sum" {
+			return fmt.Errorf("multisite %s: invalid mode: %s", name, m.Mode)
+		}
+	}
+
+	return nil
+}
+
+// multisiteNames returns the sorted names of the multisite channels
+func (conf Config) multisiteNames() []string {
+	res := make([]string, 0, len(conf.Multisite))
+	for name := range conf.Multisite {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// siteAPIs creates the apis of the configured sites with the transport settings of
+// the primary middleware but their own credentials
+func (f *apiFlags) siteAPIs(sites map[string]SiteConfig) (map[string]*Api, error) {
+	res := make(map[string]*Api, len(sites))
+	for name, site := range sites {
+		base, err := f.transport()
+		if err != nil {
+			return nil, err
+		}
+
+		transport, err := withCredentials(base, site.URL, credentials{username: site.Username, password: site.Password, token: site.Token})
+		if err != nil {
+			return nil, &cliError{exitConfig, fmt.Errorf("site %s: %v", name, err)}
+		}
+
+		api := newAPI(site.URL, f.timeout, transport, *f.maxBody, *f.verbose)
+		api.tls = base.TLSClientConfig
+		api.limiter = newLimiter(*f.maxRequests)
+		api.cache = newResponseCache(*f.cacheTTL, *f.cacheSize)
+		api.retry = retryPolicy{attempts: *f.retries, backoff: *f.retryBackoff, jitter: *f.retryJitter}
+		api.chunks = newChunkTuner(*f.chunkLatency, *f.maxBody)
+		api.compact = *f.compact
+		api.timeouts = f.requestTimeouts()
+		res[name] = api
+	}
+	return res, nil
+}
+
+// queryMultisite fetches the multisite channel from all sites concurrently and returns
+// a series per site named by site, or with `mode` sum their sum where all sites have data
+func (server *Server) queryMultisite(ctx context.Context, m MultisiteConfig, target Target, qr *QueryRequest) ([]interface{}, error) {
+	mode := strings.ToLower(m.Mode)
+	if s, ok := target.Data["mode"]; ok {
+		mode = strings.ToLower(s)
+	}
+	if mode != "" && mode != "series" && mode != "sum" {
+		return nil, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: fmt.Sprintf("invalid mode: %s", mode),
+			Hint:    "mode is series or sum",
+		}
+	}
+
+	sites := make([]string, 0, len(m.Channels))
+	for site := range m.Channels {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+
+	group, options := strings.ToLower(target.Data["group"]), strings.ToLower(target.Data["options"])
+
+	series := make([][]Tuple, len(sites))
+	errs := make([]error, len(sites))
+
+	fanOut(ctx, len(sites), server.fanout, func(idx int) {
+		// a failing site must not take down the server
+		defer func() {
+			if rec := recover(); rec != nil {
+				errs[idx] = panicError(rec)
+			}
+		}()
+
+		api, uuid := server.api, m.Channels[sites[idx]]
+		if sites[idx] != localSite {
+			api = server.sites[sites[idx]]
+		}
+
+		tuples, err := api.getData(ctx, uuid, qr.Range.From, qr.Range.To, group, options, qr.MaxDataPoints)
+		if err != nil {
+			errs[idx] = fmt.Errorf("site %s: %w", sites[idx], err)
+			return
+		}
+
+		// align grouped tuples of sites reporting different timestamps
+		if group != "" {
+			for i := range tuples {
+				tuples[i].Timestamp = roundTimestampMS(tuples[i].Timestamp, group, server.conf.location(uuid))
+			}
+		}
+		series[idx] = tuples
+	}, func(idx int, err error) {
+		errs[idx] = fmt.Errorf("site %s: %w", sites[idx], err)
+	})
+
+	// sites that answered are returned with a notice of the missing ones
+	var answered []string
+	var answeredSeries [][]Tuple
+	var notices []ResponseNotice
+	for idx, err := range errs {
+		if err != nil {
+			logf(ctx, "multisite %s: %v", target.Target, err)
+			notices = append(notices, ResponseNotice{Severity: "warning", Text: err.Error()})
+			continue
+		}
+		answered = append(answered, sites[idx])
+		answeredSeries = append(answeredSeries, series[idx])
+	}
+	if len(answered) == 0 && len(errs) > 0 {
+		return nil, errs[0]
+	}
+	sites, series = answered, answeredSeries
+
+	name := target.Target
+	if s, ok := target.Data["name"]; ok {
+		name = s
+	}
+
+	var meta *ResponseMeta
+	if m.Unit != "" || len(notices) > 0 {
+		meta = &ResponseMeta{Notices: notices}
+	}
+	if m.Unit != "" {
+		meta.Custom = map[string]interface{}{"unit": m.Unit}
+	}
+
+	if mode != "sum" {
+		res := make([]interface{}, 0, len(sites))
+		for idx, site := range sites {
+			qres := dataResponse(fmt.Sprintf("%s (%s)", name, site), series[idx], qr)
+			qres.Meta = meta
+			res = append(res, qres)
+		}
+		return res, nil
+	}
+
+	ts, values := alignSeries(series)
+
+	tuples := make([]Tuple, 0, len(ts))
+	for i := range ts {
+		var sum float64
+		for idx := range sites {
+			sum += values[idx][i]
+		}
+		if !math.IsNaN(sum) {
+			tuples = append(tuples, Tuple{Timestamp: ts[i], Value: float32(sum)})
+		}
+	}
+
+	qres := dataResponse(name, tuples, qr)
+	qres.Meta = meta
+	return []interface{}{qres}, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+// overlayEntities applies the configured metadata corrections to entities and their children
+func (api *Api) overlayEntities(entities []Entity) {
+	for i := range entities {
+		api.overlayEntity(&entities[i])
+	}
+}
+
+// overlayEntity applies the configured metadata corrections to entity and its children
+func (api *Api) overlayEntity(entity *Entity) {
+	if o, ok := api.overlay[entity.UUID]; ok {
+		if o.Title != "" {
+			entity.Title = o.Title
+		}
+		if o.Unit != "" {
+			entity.Unit = o.Unit
+		}
+		if o.Type != "" {
+			entity.Type = o.Type
+		}
+	}
+
+	api.overlayEntities(entity.Children)
+}
+
+// metadataOverlay collects the channels' metadata corrections
+func (conf Config) metadataOverlay() map[string]MetadataConfig {
+	res := make(map[string]MetadataConfig)
+	for uuid, c := range conf.Channels {
+		if c.Metadata != (MetadataConfig{}) {
+			res[uuid] = c.Metadata
+		}
+	}
+	return res
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// defaultPeakWindow is the averaging period commonly used for billing peak demand
+const defaultPeakWindow = 15 * time.Minute
+
+// queryPeak returns the rolling average power over the peak demand window or,
+// with series peak, its maximum as single value at the time of the peak.
+func (server *Server) queryPeak(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	window := defaultPeakWindow
+	if w, ok := target.Data["window"]; ok {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			logf(ctx, "invalid window: %s", w)
+		} else {
+			window = d
+		}
+	}
+
+	// peaks require raw data
+	tuples, err := server.api.getData(ctx, target.Target, qr.Range.From.Add(-window), qr.Range.To, "", "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	avg := rollingAverage(tuples, int64(window/time.Millisecond))
+
+	if strings.ToLower(target.Data["series"]) != "peak" {
+		return dataResponse(target.Target, avg, qr), nil
+	}
+
+	peak := []Tuple{}
+	for _, tuple := range avg {
+		if len(peak) == 0 || tuple.Value > peak[0].Value {
+			peak = []Tuple{tuple}
+		}
+	}
+
+	return dataResponse(target.Target, peak, qr), nil
+}
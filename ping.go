@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// pingCommand checks if gravo or the middleware responds and exits 0 if healthy, 1 otherwise.
+// Unlike other commands failures are not classified to keep container health checks simple.
+func pingCommand(fs *flag.FlagSet, args []string) error {
+	url := fs.String("url", "http://localhost:8000/healthz", "gravo url to check")
+	middleware := fs.String("middleware", "", "check volkszaehler api url instead of gravo")
+	timeout := fs.Duration("timeout", 5*time.Second, "request timeout")
+	quiet := fs.Bool("quiet", false, "no output")
+	creds := registerCredentialFlags(fs)
+	tlsCA := fs.String("tls-ca", "", "pem file with root certificates verifying the volkszaehler api")
+	tlsCert := fs.String("tls-cert", "", "pem file with client certificate sent to the volkszaehler api")
+	tlsKey := fs.String("tls-key", "", "pem file with client key")
+	tlsInsecure := fs.Bool("tls-insecure", false, "skip verifying the volkszaehler api certificate")
+	fs.Parse(args)
+
+	target := *url
+	client := http.Client{Timeout: *timeout}
+
+	if *middleware != "" {
+		target = strings.TrimRight(*middleware, "/") + "/entity.json"
+
+		base := http.DefaultTransport.(*http.Transport).Clone()
+		var err error
+		if base.TLSClientConfig, err = clientTLS(*tlsCA, *tlsCert, *tlsKey, *tlsInsecure); err != nil {
+			return &cliError{exitConfig, err}
+		}
+
+		transport, err := withCredentials(base, target, creds.credentials())
+		if err != nil {
+			return &cliError{exitConfig, err}
+		}
+		client.Transport = transport
+	}
+
+	resp, err := client.Get(target)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+
+	if err != nil {
+		if *quiet {
+			os.Exit(exitError)
+		}
+		return &cliError{exitError, fmt.Errorf("%s: %v", target, err)}
+	}
+
+	if !*quiet {
+		fmt.Printf("%s: ok\n", target)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseDecimals parses a comma-separated list of uuid or entity type to decimals
+// mappings, e.g. power=0,temperature=1
+func parseDecimals(s string) (map[string]int, error) {
+	res := make(map[string]int)
+	if s == "" {
+		return res, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid decimals: %s", kv)
+		}
+
+		d, err := strconv.Atoi(parts[1])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid decimals: %s", kv)
+		}
+
+		res[strings.TrimSpace(parts[0])] = d
+	}
+
+	return res, nil
+}
+
+// decimals returns the precision for the target from its data, uuid or entity type
+func (server *Server) decimals(target Target) (int, bool) {
+	if d, ok := target.Data["decimals"]; ok {
+		if i, err := strconv.Atoi(d); err == nil && i >= 0 {
+			return i, true
+		}
+	}
+
+	if d, ok := server.precision[target.Target]; ok {
+		return d, true
+	}
+
+	if entity, ok := server.cachedEntity(target.Target); ok {
+		if d, ok := server.precision[entity.Type]; ok {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
+
+// round rounds v to decimals places
+func round(v float32, decimals int) float32 {
+	pow := math.Pow(10, float64(decimals))
+	return float32(math.Round(float64(v)*pow) / pow)
+}
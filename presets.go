@@ -0,0 +1,36 @@
+package main
+
+import "log"
+
+// query classes selecting default presets
+const (
+	classQuery  = "query"
+	classExport = "export"
+)
+
+// PresetConfig is a named set of middleware data settings
+type PresetConfig struct {
+	Options string `yaml:"options"`
+	Group   string `yaml:"group"`
+}
+
+// preset resolves the middleware settings for uuid. An explicitly named preset takes
+// precedence over the channel's preset which takes precedence over the query class preset.
+func (conf Config) preset(uuid string, class string, name string) PresetConfig {
+	if name == "" {
+		name = conf.Channels[uuid].Preset
+	}
+	if name == "" {
+		name = conf.QueryPresets[class]
+	}
+	if name == "" {
+		return PresetConfig{}
+	}
+
+	preset, ok := conf.Presets[name]
+	if !ok {
+		log.Printf("unknown preset: %s", name)
+	}
+
+	return preset
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// prognosisRow compares the projected consumption of the current period in kWh
+// with the consumption of the previous period
+type prognosisRow struct {
+	UUID      string   `json:"uuid"`
+	Title     string   `json:"title"`
+	Period    string   `json:"period"`
+	Forecast  float64  `json:"forecast"`
+	Reference float64  `json:"reference"`
+	Deviation *float64 `json:"deviation"` // percent, nil without reference
+}
+
+// prognosisChannels returns the configured prognosis channels, defaulting to all configured channels
+func (conf Config) prognosisChannels() []string {
+	if len(conf.Prognosis.Channels) > 0 {
+		return conf.Prognosis.Channels
+	}
+
+	res := make([]string, 0, len(conf.Channels))
+	for uuid := range conf.Channels {
+		res = append(res, uuid)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// prognosisRow projects the consumption of uuid for the current billing period
+func (server *Server) prognosisRow(ctx context.Context, uuid string, period string, data TargetData) (prognosisRow, error) {
+	row := prognosisRow{UUID: uuid, Title: uuid, Period: period}
+
+	forecast, err := server.billingPrognosis(ctx, uuid, period, data)
+	if err != nil {
+		return row, err
+	}
+	row.Forecast = float64(forecast) / 1e3
+
+	now := time.Now().In(server.conf.location(uuid))
+	start, _ := billingPeriod(now, period, data)
+	prevStart, prevEnd := billingPeriod(start.Add(-time.Second), period, data)
+
+	reference, err := server.api.getConsumption(ctx, uuid, prevStart, prevEnd)
+	if err != nil {
+		return row, err
+	}
+	row.Reference = reference / 1e3
+
+	if row.Reference > 0 {
+		deviation := 100 * (row.Forecast - row.Reference) / row.Reference
+		row.Deviation = &deviation
+	}
+
+	if entity, err := server.api.getEntity(ctx, uuid); err == nil && entity.Title != "" {
+		row.Title = entity.Title
+	}
+
+	return row, nil
+}
+
+// prognoses projects all prognosis channels concurrently. Failed channels are omitted.
+func (server *Server) prognoses(ctx context.Context, period string, data TargetData) ([]prognosisRow, []error) {
+	uuids := server.conf.prognosisChannels()
+	rows := make([]prognosisRow, len(uuids))
+	errs := make([]error, len(uuids))
+	wg := &sync.WaitGroup{}
+
+	for idx, uuid := range uuids {
+		wg.Add(1)
+
+		go func(idx int, uuid string) {
+			rows[idx], errs[idx] = server.prognosisRow(ctx, uuid, period, data)
+			wg.Done()
+		}(idx, uuid)
+	}
+	wg.Wait()
+
+	res := []prognosisRow{}
+	var failed []error
+	for idx, err := range errs {
+		if err != nil {
+			logf(ctx, "prognosis %s failed: %v", uuids[idx], err)
+			failed = append(failed, err)
+			continue
+		}
+		res = append(res, rows[idx])
+	}
+
+	return res, failed
+}
+
+// prognosisPeriod returns the target's period, defaulting to the configured period or month
+func (server *Server) prognosisPeriod(data TargetData) string {
+	period := strings.ToLower(data["period"])
+	if period == "" {
+		period = strings.ToLower(server.conf.Prognosis.Period)
+	}
+	if period != "year" {
+		period = "month"
+	}
+	return period
+}
+
+// prognosisTable returns the prognosis of the target or, with target `*`, of all prognosis channels
+func (server *Server) prognosisTable(ctx context.Context, target Target) (TableResponse, error) {
+	period := server.prognosisPeriod(target.Data)
+
+	var rows []prognosisRow
+	if target.Target == "*" {
+		var errs []error
+		if rows, errs = server.prognoses(ctx, period, target.Data); len(rows) == 0 && len(errs) > 0 {
+			return TableResponse{}, errs[0]
+		}
+	} else {
+		row, err := server.prognosisRow(ctx, target.Target, period, target.Data)
+		if err != nil {
+			return TableResponse{}, err
+		}
+		rows = append(rows, row)
+	}
+
+	table := TableResponse{
+		Columns: []TableColumn{
+			TableColumn{Text: "Channel", Type: "string"},
+			TableColumn{Text: "Period", Type: "string"},
+			TableColumn{Text: "Forecast", Type: "number"},
+			TableColumn{Text: "Reference", Type: "number"},
+			TableColumn{Text: "Deviation", Type: "number"},
+		},
+		Rows: [][]interface{}{},
+		Type: "table",
+	}
+
+	for _, row := range rows {
+		var deviation interface{}
+		if row.Deviation != nil {
+			deviation = *row.Deviation
+		}
+		table.Rows = append(table.Rows, []interface{}{row.Title, row.Period, row.Forecast, row.Reference, deviation})
+	}
+
+	return table, nil
+}
+
+// prognosisCommand prints the prognosis of all configured channels
+func prognosisCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	configFile := fs.String("config", "", "yaml configuration file providing prognosis channels")
+	uuids := fs.String("uuid", "", "comma-separated channel uuids instead of configured channels")
+	period := fs.String("period", "", "billing period (month, year)")
+	asJSON := fs.Bool("json", false, "json output")
+	fs.Parse(args)
+
+	var conf Config
+	if *configFile != "" {
+		var err error
+		if conf, err = loadConfig(*configFile); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(fs, conf); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+	}
+
+	if *uuids != "" {
+		conf.Prognosis.Channels = strings.Split(*uuids, ",")
+	}
+
+	channels := conf.prognosisChannels()
+	if len(channels) == 0 {
+		return configError("missing uuid or config")
+	}
+
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+	api.overlay = conf.metadataOverlay()
+	api.tokens = conf.channelTokens()
+
+	server := newServer(api, conf, "", nil)
+	data := TargetData{"period": *period}
+	rows, errs := server.prognoses(context.Background(), server.prognosisPeriod(data), data)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "channel\tperiod\tforecast kWh\treference kWh\tdeviation\t")
+		for _, row := range rows {
+			deviation := "-"
+			if row.Deviation != nil {
+				deviation = fmt.Sprintf("%+.1f%%", *row.Deviation)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.1f\t%.1f\t%s\t\n", row.Title, row.Period, row.Forecast, row.Reference, deviation)
+		}
+		if err := w.Flush(); err != nil {
+			log.Print(err)
+		}
+	}
+
+	return channelError(errs, len(channels))
+}
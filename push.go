@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPushTuples is the number of live tuples kept per channel
+	maxPushTuples = 3600
+
+	// pushRetry is the initial delay before reconnecting, doubled up to pushMaxRetry
+	pushRetry    = 5 * time.Second
+	pushMaxRetry = time.Minute
+
+	// pushPing is the interval of pings keeping the connection alive, a connection
+	// receiving no frame within pushTimeout is re-established
+	pushPing    = 30 * time.Second
+	pushTimeout = 75 * time.Second
+)
+
+// pushData is a channel update published by the push server
+type pushData struct {
+	UUID   string  `json:"uuid"`
+	Tuples []Tuple `json:"tuples"`
+}
+
+// pushBuffer holds the live tuples of a channel received since since
+type pushBuffer struct {
+	since  int64
+	tuples []Tuple
+}
+
+// pushSubscriber maintains the live tuples published by the volkszaehler push server
+type pushSubscriber struct {
+	url      string
+	tls      *tls.Config     // wss settings, shared with the middleware
+	channels func() []string // uuids subscribed via wamp
+
+	mu        sync.Mutex
+	buffers   map[string]*pushBuffer
+	listeners map[chan pushData]bool
+}
+
+func newPushSubscriber(url string, tls *tls.Config, channels func() []string) *pushSubscriber {
+	return &pushSubscriber{
+		url:       url,
+		tls:       tls,
+		channels:  channels,
+		buffers:   make(map[string]*pushBuffer),
+		listeners: make(map[chan pushData]bool),
+	}
+}
+
+// decodePush decodes plain json updates ({"data": ...} with a channel object or array)
+// and WAMP v1 events ([8, topic, {"data": ...}])
+func decodePush(b []byte) ([]pushData, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var msg []json.RawMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return nil, err
+		}
+
+		var typ int
+		if len(msg) == 0 || json.Unmarshal(msg[0], &typ) != nil || typ != 8 || len(msg) < 3 {
+			return nil, nil // welcome and other wamp messages
+		}
+		b = bytes.TrimSpace(msg[2])
+	}
+
+	var msg struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, err
+	}
+
+	data := bytes.TrimSpace(msg.Data)
+	if len(data) > 0 && data[0] == '[' {
+		var res []pushData
+		err := json.Unmarshal(data, &res)
+		return res, err
+	}
+
+	var pd pushData
+	if err := json.Unmarshal(data, &pd); err != nil {
+		return nil, err
+	}
+	return []pushData{pd}, nil
+}
+
+// reset restarts the buffers when the connection is established or lost, as tuples
+// may have been missed while disconnected and the last tuples would be served stale
+func (s *pushSubscriber) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buffers = make(map[string]*pushBuffer)
+}
+
+// add stores the update and notifies listeners
+func (s *pushSubscriber) add(pd pushData) {
+	if pd.UUID == "" || len(pd.Tuples) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf, ok := s.buffers[pd.UUID]
+	if !ok {
+		buf = &pushBuffer{since: pd.Tuples[0].Timestamp}
+		s.buffers[pd.UUID] = buf
+	}
+
+	for _, t := range pd.Tuples {
+		if n := len(buf.tuples); n > 0 && t.Timestamp <= buf.tuples[n-1].Timestamp {
+			continue
+		}
+		buf.tuples = append(buf.tuples, t)
+	}
+	if n := len(buf.tuples); n > maxPushTuples {
+		buf.tuples = append([]Tuple{}, buf.tuples[n-maxPushTuples:]...)
+		buf.since = buf.tuples[0].Timestamp
+	}
+
+	for l := range s.listeners {
+		select {
+		case l <- pd:
+		default: // slow listeners miss updates
+		}
+	}
+}
+
+// latest returns the last received tuple of the channel
+func (s *pushSubscriber) latest(uuid string) (Tuple, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf, ok := s.buffers[uuid]
+	if !ok || len(buf.tuples) == 0 {
+		return Tuple{}, false
+	}
+	return buf.tuples[len(buf.tuples)-1], true
+}
+
+// between returns the live tuples of from..to if the buffer covers the range
+func (s *pushSubscriber) between(uuid string, from, to int64) ([]Tuple, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf, ok := s.buffers[uuid]
+	if !ok || buf.since > from {
+		return nil, false
+	}
+
+	res := []Tuple{}
+	for _, t := range buf.tuples {
+		if t.Timestamp >= from && t.Timestamp <= to {
+			res = append(res, t)
+		}
+	}
+	return res, true
+}
+
+// listen registers a channel receiving all updates until cancel is called
+func (s *pushSubscriber) listen() (chan pushData, func()) {
+	l := make(chan pushData, 64)
+
+	s.mu.Lock()
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	return l, func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}
+}
+
+// subscribe reads updates until the connection fails
+func (s *pushSubscriber) subscribe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	conn, err := wsDial(ctx, s.url, "wamp", s.tls)
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	defer s.reset()
+
+	log.Printf("push: connected to %s", s.url)
+	s.reset()
+
+	conn.timeout = pushTimeout
+	done := make(chan struct{})
+	defer close(done)
+	go conn.keepalive(pushPing, done)
+
+	// wamp v1 servers publish channels as topics, plain servers ignore subscriptions
+	for _, uuid := range s.channels() {
+		b, _ := json.Marshal([]interface{}{5, uuid})
+		if err := conn.write(wsText, b); err != nil {
+			return err
+		}
+	}
+
+	for {
+		msg, err := conn.readMessage()
+		if err != nil {
+			return err
+		}
+
+		updates, err := decodePush(msg)
+		if err != nil {
+			log.Printf("push: invalid message: %v", err)
+			continue
+		}
+		for _, pd := range updates {
+			s.add(pd)
+		}
+	}
+}
+
+// run keeps the subscription alive, reconnecting with backoff
+func (s *pushSubscriber) run() {
+	delay := pushRetry
+	for {
+		start := time.Now()
+		err := s.subscribe()
+		log.Printf("push: %v", err)
+
+		if time.Since(start) > pushMaxRetry {
+			delay = pushRetry
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > pushMaxRetry {
+			delay = pushMaxRetry
+		}
+	}
+}
+
+// pushChannels returns the channels of the live store, subscribed at the push server or polled
+func (server *Server) pushChannels() []string {
+	var res []string
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for uuid := range server.entityCache {
+		res = append(res, uuid)
+	}
+	for uuid := range server.conf.Channels {
+		if _, ok := server.entityCache[uuid]; !ok {
+			res = append(res, uuid)
+		}
+	}
+	return res
+}
+
+// queryLast returns the latest value of the channel, from the push server if
+// available or the last tuple of the requested range otherwise
+func (server *Server) queryLast(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	if server.push != nil {
+		if t, ok := server.push.latest(target.Target); ok {
+			return dataResponse(target.Target, []Tuple{t}, qr), nil
+		}
+	}
+
+	tuples, err := server.api.getData(ctx, target.Target, qr.Range.From, qr.Range.To, "", "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	for i := len(tuples) - 1; i >= 0; i-- {
+		if !math.IsNaN(float64(tuples[i].Value)) {
+			return dataResponse(target.Target, tuples[i:i+1], qr), nil
+		}
+	}
+
+	return dataResponse(target.Target, nil, qr), nil
+}
+
+// streamHandler streams live tuples of the channels given by `uuid` as server-sent events
+func (server *Server) streamHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || server.push == nil {
+		http.Error(w, "streaming not supported", http.StatusNotImplemented)
+		return
+	}
+
+	uuids := make(map[string]bool)
+	for _, uuid := range strings.Split(r.URL.Query().Get("uuid"), ",") {
+		if uuid = strings.TrimSpace(uuid); uuid != "" {
+			if resolved, ok := server.resolveAlias(r.Context(), uuid); ok {
+				uuid = resolved
+			}
+			uuids[uuid] = true
+		}
+	}
+
+	updates, cancel := server.push.listen()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// send the latest values first
+	for uuid := range uuids {
+		if t, ok := server.push.latest(uuid); ok {
+			b, _ := json.Marshal(pushData{UUID: uuid, Tuples: []Tuple{t}})
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case pd := <-updates:
+			if len(uuids) > 0 && !uuids[pd.UUID] {
+				continue
+			}
+			b, _ := json.Marshal(pd)
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultGapFactor is the multiple of the sampling interval considered a gap
+const defaultGapFactor = 5
+
+// QualityIssue describes a single data quality problem
+type QualityIssue struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float32 `json:"value"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// QualityReport summarizes the data quality of a channel
+type QualityReport struct {
+	UUID           string         `json:"uuid"`
+	From           time.Time      `json:"from"`
+	To             time.Time      `json:"to"`
+	Tuples         int            `json:"tuples"`
+	MedianInterval time.Duration  `json:"medianInterval"`
+	Gaps           []QualityIssue `json:"gaps"`
+	Duplicates     []QualityIssue `json:"duplicates"`
+	OutOfOrder     []QualityIssue `json:"outOfOrder"`
+	Implausible    []QualityIssue `json:"implausible"`
+}
+
+// analyzeQuality reports gaps exceeding gapFactor times the expected or median interval,
+// duplicate and out-of-order timestamps and values outside min..max
+func analyzeQuality(tuples []Tuple, gapFactor float64, expected time.Duration, min float64, max float64) QualityReport {
+	qr := QualityReport{
+		Tuples:      len(tuples),
+		Gaps:        []QualityIssue{},
+		Duplicates:  []QualityIssue{},
+		OutOfOrder:  []QualityIssue{},
+		Implausible: []QualityIssue{},
+	}
+
+	median := medianInterval(tuples)
+	qr.MedianInterval = time.Duration(median) * time.Millisecond
+	interval := sampleInterval(tuples, expected)
+
+	seen := make(map[int64]bool)
+	for i, tuple := range tuples {
+		if v := float64(tuple.Value); math.IsNaN(v) || math.IsInf(v, 0) || v < min || v > max {
+			qr.Implausible = append(qr.Implausible, QualityIssue{Timestamp: tuple.Timestamp, Value: tuple.Value})
+		}
+
+		if seen[tuple.Timestamp] {
+			qr.Duplicates = append(qr.Duplicates, QualityIssue{Timestamp: tuple.Timestamp, Value: tuple.Value})
+		}
+		seen[tuple.Timestamp] = true
+
+		if i == 0 {
+			continue
+		}
+
+		d := tuple.Timestamp - tuples[i-1].Timestamp
+		if d < 0 {
+			qr.OutOfOrder = append(qr.OutOfOrder, QualityIssue{Timestamp: tuple.Timestamp, Value: tuple.Value})
+		} else if interval > 0 && float64(d) > gapFactor*float64(interval) {
+			qr.Gaps = append(qr.Gaps, QualityIssue{
+				Timestamp: tuples[i-1].Timestamp,
+				Value:     tuples[i-1].Value,
+				Detail:    (time.Duration(d) * time.Millisecond).String(),
+			})
+		}
+	}
+
+	return qr
+}
+
+// writeText writes the report in human-readable form
+func (qr QualityReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Channel %s\n", qr.UUID)
+	fmt.Fprintf(w, "  range:           %s - %s\n", qr.From.Format(time.RFC3339), qr.To.Format(time.RFC3339))
+	fmt.Fprintf(w, "  tuples:          %d\n", qr.Tuples)
+	fmt.Fprintf(w, "  median interval: %s\n", qr.MedianInterval)
+
+	sections := []struct {
+		title  string
+		issues []QualityIssue
+	}{
+		{"gaps", qr.Gaps},
+		{"duplicates", qr.Duplicates},
+		{"out of order", qr.OutOfOrder},
+		{"implausible", qr.Implausible},
+	}
+
+	for _, s := range sections {
+		fmt.Fprintf(w, "  %-16s %d\n", s.title+":", len(s.issues))
+		for _, issue := range s.issues {
+			fmt.Fprintf(w, "    %s %v %s\n", formatMS(issue.Timestamp), issue.Value, issue.Detail)
+		}
+	}
+}
+
+// qualityCommand reports data quality problems of channels
+func qualityCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuids := fs.String("uuid", "", "comma-separated channel uuids")
+	from := fs.String("from", "-24h", "range start (epoch ms, ISO 8601, now or relative duration)")
+	to := fs.String("to", "now", "range end (epoch ms, ISO 8601, now or relative duration)")
+	configFile := fs.String("config", "", "yaml configuration file providing the channels' sampling intervals")
+	gap := fs.Float64("gap", defaultGapFactor, "report gaps exceeding this factor of the configured or median interval")
+	min := fs.Float64("min", math.Inf(-1), "minimum plausible value")
+	max := fs.Float64("max", math.Inf(1), "maximum plausible value")
+	asJSON := fs.Bool("json", false, "json output")
+	fs.Parse(args)
+
+	if *uuids == "" {
+		return configError("missing uuid")
+	}
+
+	var conf Config
+	var err error
+	if *configFile != "" {
+		if conf, err = loadConfig(*configFile); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(fs, conf); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+	}
+
+	f, err := parseTime(*from, conf.defaultLocation())
+	if err != nil {
+		return configError("invalid from: %v", err)
+	}
+	t, err := parseTime(*to, conf.defaultLocation())
+	if err != nil {
+		return configError("invalid to: %v", err)
+	}
+
+	ctx := context.Background()
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+
+	channels := strings.Split(*uuids, ",")
+	reports := []QualityReport{}
+	errs := []error{}
+	for _, uuid := range channels {
+		uuid = strings.TrimSpace(uuid)
+		tuples, err := api.getData(ctx, uuid, f, t, "", "", 0)
+		if err != nil {
+			log.Printf("quality %s failed: %v", uuid, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		report := analyzeQuality(tuples, *gap, conf.expectedInterval(uuid), *min, *max)
+		report.UUID, report.From, report.To = uuid, f, t
+		reports = append(reports, report)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	} else {
+		for _, report := range reports {
+			report.writeText(os.Stdout)
+		}
+	}
+
+	return channelError(errs, len(channels))
+}
+
+// gapAnnotations returns periods without data exceeding `gap` (default 5) times the
+// channel's sampling interval as region annotations
+func (server *Server) gapAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	tuples, err := server.api.getData(ctx, target.Target, ar.Range.From, ar.Range.To, "", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report := analyzeQuality(tuples, target.Data.float("gap", defaultGapFactor), server.conf.expectedInterval(target.Target), math.Inf(-1), math.Inf(1))
+	title := server.channelTitle(ctx, target.Target)
+
+	res := []AnnotationResponse{}
+	for _, g := range report.Gaps {
+		d, _ := time.ParseDuration(g.Detail)
+		res = append(res, AnnotationResponse{
+			Annotation: ar.Annotation,
+			Time:       g.Timestamp,
+			TimeEnd:    g.Timestamp + d.Milliseconds(),
+			IsRegion:   true,
+			Title:      fmt.Sprintf("%s not reporting", title),
+			Tags:       "gap",
+			Text:       fmt.Sprintf("no data for %s, last value %g", g.Detail, g.Value),
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+)
+
+// QueryError is returned to Grafana if a query fails. Code is stable and can
+// be matched by clients, message and hint are meant for the user.
+type QueryError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+	Target  string `json:"target,omitempty"`
+
+	// Errors lists the individual errors if several targets failed
+	Errors []*QueryError `json:"errors,omitempty"`
+
+	// RequestID is taken from the response header set by the request id middleware
+	RequestID string `json:"requestId,omitempty"`
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// middlewareMessage extracts the exception message from a middleware error body
+func middlewareMessage(body string) string {
+	var res struct {
+		Exception struct {
+			Message string `json:"message"`
+		} `json:"exception"`
+	}
+
+	if err := json.Unmarshal([]byte(body), &res); err == nil && res.Exception.Message != "" {
+		return res.Exception.Message
+	}
+
+	return body
+}
+
+// queryError classifies the error of target into a query error with hint
+func queryError(target Target, err error) *QueryError {
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return qe
+	}
+
+	qe = &QueryError{
+		Status:  http.StatusInternalServerError,
+		Code:    "query_failed",
+		Message: err.Error(),
+		Target:  target.Target,
+	}
+
+	var se *StatusError
+	var te *timeoutError
+	var ne net.Error
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// nginx' non-standard client closed request
+		qe.Status, qe.Code = 499, "cancelled"
+		qe.Message = "client disconnected"
+
+	case errors.Is(err, context.DeadlineExceeded):
+		qe.Status, qe.Code = http.StatusGatewayTimeout, "timeout"
+		qe.Message = "query timed out"
+		qe.Hint = "reduce the time range, set a coarser group or increase -query-timeout"
+
+	case errors.As(err, &te):
+		qe.Status, qe.Code = http.StatusGatewayTimeout, "timeout"
+		qe.Hint = "set a coarser group or increase -timeout or -timeout-data"
+
+	case errors.Is(err, errResponseTooLarge):
+		qe.Status, qe.Code = http.StatusBadGateway, "response_too_large"
+		qe.Message = "middleware response too large"
+		qe.Hint = "set a coarser group or increase -maxbody"
+
+	case errors.As(err, &se):
+		qe.Status = http.StatusBadGateway
+		qe.Message = middlewareMessage(se.Body)
+
+		switch se.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			qe.Code = "unauthorized"
+			qe.Hint = "check the middleware credentials"
+		case http.StatusNotFound:
+			qe.Code = "not_found"
+			qe.Hint = fmt.Sprintf("channel '%s' does not exist or is not public", target.Target)
+		case http.StatusBadRequest:
+			qe.Code = "invalid_query"
+			if group, ok := target.Data["group"]; ok {
+				qe.Hint = fmt.Sprintf("group '%s' may not be supported for this channel type", group)
+			}
+		default:
+			qe.Code = "middleware_error"
+		}
+
+	case errors.Is(err, ErrMiddlewareUnavailable), errors.As(err, &ne):
+		qe.Status, qe.Code = http.StatusServiceUnavailable, "unreachable"
+		qe.Hint = "check the -api url and that the middleware is running"
+		if certificateError(err) {
+			qe.Hint = "middleware certificate not trusted, set -tls-ca to the internal CA"
+		}
+
+	case errors.Is(err, ErrBadResponse):
+		qe.Status, qe.Code = http.StatusBadGateway, "bad_response"
+		qe.Hint = "check that the -api url points to the volkszaehler middleware"
+	}
+
+	return qe
+}
+
+// writeQueryError sends err as json error response
+func writeQueryError(w http.ResponseWriter, err *QueryError) {
+	err.RequestID = w.Header().Get(requestIDHeader)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status)
+
+	if err := json.NewEncoder(w).Encode(err); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
+
+// invalidRequest is returned if the request body cannot be decoded
+func invalidRequest(err error) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: fmt.Sprintf("json decode failed: %v", err),
+	}
+}
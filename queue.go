@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// diskQueue persists unsent batches as json lines, bounded to max tuples
+type diskQueue struct {
+	file  string
+	max   int
+	count int
+}
+
+// openQueue opens the queue file and counts the queued tuples. A partially
+// written line, e.g. after a crash, is truncated so that appended batches are
+// replayed.
+func openQueue(file string, max int) (*diskQueue, error) {
+	q := &diskQueue{file: file, max: max}
+
+	batches, torn, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	if torn {
+		log.Printf("queue %s: dropping partially written batch", file)
+		return q, q.rewrite(batches)
+	}
+	for _, batch := range batches {
+		q.count += len(batch.Tuples)
+	}
+
+	return q, nil
+}
+
+// load reads all queued batches in order
+func (q *diskQueue) load() ([]SinkBatch, error) {
+	batches, _, err := q.read()
+	return batches, err
+}
+
+// read reads the queued batches up to the first line that can't be decoded and
+// reports if there was one
+func (q *diskQueue) read() ([]SinkBatch, bool, error) {
+	f, err := os.Open(q.file)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var res []SinkBatch
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var batch SinkBatch
+		if err := dec.Decode(&batch); err != nil {
+			// keep what was read before a partially written line
+			return res, true, nil
+		}
+		res = append(res, batch)
+	}
+
+	return res, false, nil
+}
+
+// append adds batches to the queue. If the queue is full the oldest tuples
+// are dropped and their number is returned.
+func (q *diskQueue) append(batches []SinkBatch) (int, error) {
+	n := 0
+	for _, batch := range batches {
+		n += len(batch.Tuples)
+	}
+
+	if q.count+n <= q.max {
+		f, err := os.OpenFile(q.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := writeBatches(f, batches); err != nil {
+			f.Close()
+			return 0, err
+		}
+		if err := f.Close(); err != nil {
+			return 0, err
+		}
+
+		q.count += n
+		return 0, nil
+	}
+
+	queued, err := q.load()
+	if err != nil {
+		return 0, err
+	}
+
+	queued, dropped := trimBatches(append(queued, batches...), q.max)
+	if err := q.rewrite(queued); err != nil {
+		return 0, err
+	}
+
+	return dropped, nil
+}
+
+// rewrite atomically replaces the queue with batches
+func (q *diskQueue) rewrite(batches []SinkBatch) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(q.file), filepath.Base(q.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeBatches(tmp, batches); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), q.file); err != nil {
+		return err
+	}
+
+	q.count = 0
+	for _, batch := range batches {
+		q.count += len(batch.Tuples)
+	}
+
+	return nil
+}
+
+// clear empties the queue after it was replayed
+func (q *diskQueue) clear() error {
+	q.count = 0
+	if err := os.Remove(q.file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func writeBatches(f *os.File, batches []SinkBatch) error {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, batch := range batches {
+		if err := enc.Encode(batch); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// trimBatches drops the oldest tuples exceeding max and returns their number
+func trimBatches(batches []SinkBatch, max int) ([]SinkBatch, int) {
+	count := 0
+	for _, batch := range batches {
+		count += len(batch.Tuples)
+	}
+
+	dropped := 0
+	for count > max && len(batches) > 0 {
+		first := &batches[0]
+		drop := count - max
+		if drop >= len(first.Tuples) {
+			drop = len(first.Tuples)
+			batches = batches[1:]
+		} else {
+			first.Tuples = first.Tuples[drop:]
+		}
+		count -= drop
+		dropped += drop
+	}
+
+	return batches, dropped
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gravo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a crash left a partially written batch behind
+	file := filepath.Join(dir, "queue.jsonl")
+	content := `{"uuid":"a","tuples":[[1000,1,1],[2000,2,1]]}` + "\n" + `{"uuid":"b","tup`
+	if err := ioutil.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := openQueue(file, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.count != 2 {
+		t.Errorf("expected 2 queued tuples, got %d", q.count)
+	}
+
+	if dropped, err := q.append([]SinkBatch{{UUID: "c", Tuples: []Tuple{{3000, 3}}}}); err != nil || dropped != 0 {
+		t.Fatalf("append: dropped %d, %v", dropped, err)
+	}
+
+	// batches appended after the torn line are replayed
+	batches, err := q.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SinkBatch{
+		{UUID: "a", Tuples: []Tuple{{1000, 1}, {2000, 2}}},
+		{UUID: "c", Tuples: []Tuple{{3000, 3}}},
+	}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("expected %v, got %v", expected, batches)
+	}
+
+	// reopening counts the same tuples
+	if q, err = openQueue(file, 10); err != nil || q.count != 3 {
+		t.Errorf("reopen: expected 3 queued tuples, got %d (%v)", q.count, err)
+	}
+
+	if err := q.clear(); err != nil {
+		t.Fatal(err)
+	}
+	if batches, err := q.load(); err != nil || len(batches) != 0 {
+		t.Errorf("expected empty queue, got %v (%v)", batches, err)
+	}
+}
+
+func TestQueueTrim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gravo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := openQueue(filepath.Join(dir, "queue.jsonl"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		batch   SinkBatch
+		dropped int
+		queued  []SinkBatch
+	}{
+		{
+			batch:  SinkBatch{UUID: "a", Tuples: []Tuple{{1000, 1}, {2000, 2}}},
+			queued: []SinkBatch{{UUID: "a", Tuples: []Tuple{{1000, 1}, {2000, 2}}}},
+		},
+		{
+			// the oldest tuple is dropped
+			batch:   SinkBatch{UUID: "b", Tuples: []Tuple{{3000, 3}, {4000, 4}}},
+			dropped: 1,
+			queued:  []SinkBatch{{UUID: "a", Tuples: []Tuple{{2000, 2}}}, {UUID: "b", Tuples: []Tuple{{3000, 3}, {4000, 4}}}},
+		},
+		{
+			// whole batches are dropped
+			batch:   SinkBatch{UUID: "c", Tuples: []Tuple{{5000, 5}, {6000, 6}}},
+			dropped: 2,
+			queued:  []SinkBatch{{UUID: "b", Tuples: []Tuple{{4000, 4}}}, {UUID: "c", Tuples: []Tuple{{5000, 5}, {6000, 6}}}},
+		},
+	}
+
+	for i, tc := range tests {
+		dropped, err := q.append([]SinkBatch{tc.batch})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dropped != tc.dropped {
+			t.Errorf("%d: expected %d dropped, got %d", i, tc.dropped, dropped)
+		}
+
+		queued, err := q.load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(queued, tc.queued) {
+			t.Errorf("%d: expected %v, got %v", i, tc.queued, queued)
+		}
+		if q.count != 3 && i > 0 {
+			t.Errorf("%d: expected 3 queued tuples, got %d", i, q.count)
+		}
+	}
+}
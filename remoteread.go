@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// remoteReadMaxBody is the maximum size of compressed remote read requests
+const remoteReadMaxBody = 1 << 20
+
+// remote read label matcher types
+const (
+	matchEqual = iota
+	matchNotEqual
+	matchRegexp
+	matchNotRegexp
+)
+
+// remoteMatcher is a label matcher of a remote read query
+type remoteMatcher struct {
+	typ         int
+	name, value string
+	re          *regexp.Regexp
+}
+
+// matches checks the label value, missing labels have the empty value
+func (m remoteMatcher) matches(value string) bool {
+	switch m.typ {
+	case matchNotEqual:
+		return value != m.value
+	case matchRegexp:
+		return m.re.MatchString(value)
+	case matchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return value == m.value
+}
+
+// remoteQuery is a query of a remote read request
+type remoteQuery struct {
+	start, end int64 // ms
+	step       int64 // ms, from the read hints
+	matchers   []remoteMatcher
+}
+
+// remoteLabel is a label of a remote read time series
+type remoteLabel struct {
+	name, value string
+}
+
+// remoteSeries is a channel exposed to remote read with its labels sorted by name
+type remoteSeries struct {
+	uuid   string
+	labels []remoteLabel
+}
+
+func (s remoteSeries) label(name string) string {
+	for _, l := range s.labels {
+		if l.name == name {
+			return l.value
+		}
+	}
+	return ""
+}
+
+// remoteResult is a time series of a remote read response
+type remoteResult struct {
+	labels []remoteLabel
+	tuples []Tuple
+}
+
+// protoField reads the next field number, wire type and value of a protobuf message.
+// Varint and fixed values are returned as number, length-delimited values as bytes.
+func protoField(b []byte) (field int, wire int, num uint64, val []byte, rest []byte, err error) {
+	key, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, 0, nil, nil, errors.New("protobuf: invalid key")
+	}
+	b = b[n:]
+	field, wire = int(key>>3), int(key&7)
+
+	switch wire {
+	case 0:
+		if num, n = binary.Uvarint(b); n <= 0 {
+			return 0, 0, 0, nil, nil, errors.New("protobuf: invalid varint")
+		}
+		b = b[n:]
+	case 1:
+		if len(b) < 8 {
+			return 0, 0, 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		num, b = binary.LittleEndian.Uint64(b), b[8:]
+	case 2:
+		l, n := binary.Uvarint(b)
+		if n <= 0 || l > uint64(len(b)-n) {
+			return 0, 0, 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		val, b = b[n:n+int(l)], b[n+int(l):]
+	case 5:
+		if len(b) < 4 {
+			return 0, 0, 0, nil, nil, io.ErrUnexpectedEOF
+		}
+		num, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+	default:
+		return 0, 0, 0, nil, nil, fmt.Errorf("protobuf: unsupported wire type %d", wire)
+	}
+
+	return field, wire, num, val, b, nil
+}
+
+// decodeReadRequest decodes the queries of a prometheus.ReadRequest
+func decodeReadRequest(b []byte) ([]remoteQuery, error) {
+	var res []remoteQuery
+	for len(b) > 0 {
+		field, wire, _, val, rest, err := protoField(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+
+		if field == 1 && wire == 2 {
+			q, err := decodeRemoteQuery(val)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, q)
+		}
+	}
+	return res, nil
+}
+
+// decodeRemoteQuery decodes a prometheus.Query with its matchers and step hint
+func decodeRemoteQuery(b []byte) (remoteQuery, error) {
+	q := remoteQuery{}
+	for len(b) > 0 {
+		field, wire, num, val, rest, err := protoField(b)
+		if err != nil {
+			return q, err
+		}
+		b = rest
+
+		switch {
+		case field == 1 && wire == 0:
+			q.start = int64(num)
+		case field == 2 && wire == 0:
+			q.end = int64(num)
+		case field == 3 && wire == 2:
+			m, err := decodeRemoteMatcher(val)
+			if err != nil {
+				return q, err
+			}
+			q.matchers = append(q.matchers, m)
+		case field == 4 && wire == 2:
+			// read hints, step_ms is field 1
+			for len(val) > 0 {
+				f, w, n, _, r, err := protoField(val)
+				if err != nil {
+					return q, err
+				}
+				if f == 1 && w == 0 {
+					q.step = int64(n)
+				}
+				val = r
+			}
+		}
+	}
+	return q, nil
+}
+
+// decodeRemoteMatcher decodes a prometheus.LabelMatcher, regular expressions are
+// anchored like in PromQL
+func decodeRemoteMatcher(b []byte) (remoteMatcher, error) {
+	m := remoteMatcher{}
+	for len(b) > 0 {
+		field, wire, num, val, rest, err := protoField(b)
+		if err != nil {
+			return m, err
+		}
+		b = rest
+
+		switch {
+		case field == 1 && wire == 0:
+			m.typ = int(num)
+		case field == 2 && wire == 2:
+			m.name = string(val)
+		case field == 3 && wire == 2:
+			m.value = string(val)
+		}
+	}
+
+	switch m.typ {
+	case matchEqual, matchNotEqual:
+	case matchRegexp, matchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.value + ")$")
+		if err != nil {
+			return m, invalidMatcher("invalid regexp %q: %v", m.value, err)
+		}
+		m.re = re
+	default:
+		return m, invalidMatcher("invalid matcher type: %d", m.typ)
+	}
+	return m, nil
+}
+
+// invalidMatcher is the error of matchers that cannot be applied
+func invalidMatcher(format string, a ...interface{}) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: fmt.Sprintf(format, a...),
+		Hint:    "metric names are channel aliases or titles, labels are title, type, unit and uuid",
+	}
+}
+
+// appendUvarint appends v as protobuf varint
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendFixed64 appends v little endian like protobuf fixed64 and double values
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// protoKey appends the key of field with wire type
+func protoKey(b []byte, field, wire int) []byte {
+	return appendUvarint(b, uint64(field<<3|wire))
+}
+
+// protoBytes appends a length-delimited field
+func protoBytes(b []byte, field int, val []byte) []byte {
+	b = protoKey(b, field, 2)
+	b = appendUvarint(b, uint64(len(val)))
+	return append(b, val...)
+}
+
+// encodeReadResponse encodes a prometheus.ReadResponse of samples with a result
+// per query
+func encodeReadResponse(results [][]remoteResult) []byte {
+	var res []byte
+	for _, series := range results {
+		var qr []byte
+		for _, s := range series {
+			var ts []byte
+			for _, l := range s.labels {
+				var label []byte
+				label = protoBytes(label, 1, []byte(l.name))
+				label = protoBytes(label, 2, []byte(l.value))
+				ts = protoBytes(ts, 1, label)
+			}
+			for _, t := range s.tuples {
+				var sample []byte
+				sample = protoKey(sample, 1, 1)
+				sample = appendFixed64(sample, math.Float64bits(float64(t.Value)))
+				sample = protoKey(sample, 2, 0)
+				sample = appendUvarint(sample, uint64(t.Timestamp))
+				ts = protoBytes(ts, 2, sample)
+			}
+			qr = protoBytes(qr, 1, ts)
+		}
+		res = protoBytes(res, 1, qr)
+	}
+	return res
+}
+
+// remoteMetricName returns the metric name of an alias, e.g. heat_pump for "Heat pump"
+func remoteMetricName(alias string) string {
+	var sb strings.Builder
+	for i, r := range strings.ToLower(alias) {
+		switch {
+		case r >= 'a' && r <= 'z' || r == '_' || r == ':':
+			sb.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				sb.WriteRune('_')
+			}
+			sb.WriteRune(r)
+		case unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) || r > unicode.MaxASCII:
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// remoteChannels returns the channels exposed to remote read, named by their static
+// alias or title and labeled with their entity properties
+func (server *Server) remoteChannels(ctx context.Context) []remoteSeries {
+	names := make(map[string]string) // uuid to static alias
+	aliases := make([]string, 0, len(server.conf.Aliases))
+	for alias := range server.conf.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if uuid := server.conf.Aliases[alias]; names[uuid] == "" {
+			names[uuid] = alias
+		}
+	}
+
+	_, entities := server.cachedEntities(ctx)
+
+	res := []remoteSeries{}
+	seen := make(map[string]bool)
+	for _, e := range entities {
+		if seen[e.UUID] {
+			continue
+		}
+		seen[e.UUID] = true
+
+		name := names[e.UUID]
+		if name == "" {
+			name = e.Title
+		}
+		labels := []remoteLabel{{"__name__", remoteMetricName(name)}}
+		for _, l := range []remoteLabel{{"title", e.Title}, {"type", e.Type}, {"unit", server.entityUnit(e.UUID)}, {"uuid", e.UUID}} {
+			if l.value != "" {
+				labels = append(labels, l)
+			}
+		}
+		res = append(res, remoteSeries{uuid: e.UUID, labels: labels})
+	}
+
+	// aliased channels that are not public
+	for _, alias := range aliases {
+		if uuid := server.conf.Aliases[alias]; !seen[uuid] {
+			seen[uuid] = true
+			res = append(res, remoteSeries{uuid: uuid, labels: []remoteLabel{{"__name__", remoteMetricName(alias)}, {"uuid", uuid}}})
+		}
+	}
+
+	return res
+}
+
+// selectSeries returns the channels matching all matchers
+func selectSeries(channels []remoteSeries, matchers []remoteMatcher) []remoteSeries {
+	res := []remoteSeries{}
+	for _, s := range channels {
+		ok := true
+		for _, m := range matchers {
+			if !m.matches(s.label(m.name)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// remoteRead returns the series of the query. With a step hint the middleware
+// aggregates to roughly one tuple per step, raw tuples are read otherwise.
+func (server *Server) remoteRead(ctx context.Context, channels []remoteSeries, q remoteQuery) ([]remoteResult, error) {
+	qr := &QueryRequest{
+		Range: Range{
+			From: time.Unix(0, q.start*int64(time.Millisecond)),
+			To:   time.Unix(0, q.end*int64(time.Millisecond)),
+		},
+	}
+	if q.step > 0 {
+		qr.MaxDataPoints = int((q.end - q.start) / q.step)
+	}
+
+	res := []remoteResult{}
+	for _, s := range selectSeries(channels, q.matchers) {
+		tuples, err := server.getTuples(ctx, s.uuid, TargetData{}, qr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.uuid, err)
+		}
+
+		// nulls are gaps, NaN would be read as value
+		values := make([]Tuple, 0, len(tuples))
+		for _, t := range tuples {
+			if !math.IsNaN(float64(t.Value)) && t.Timestamp >= q.start && t.Timestamp <= q.end {
+				values = append(values, t)
+			}
+		}
+		if len(values) > 0 {
+			res = append(res, remoteResult{labels: s.labels, tuples: values})
+		}
+	}
+	return res, nil
+}
+
+// remoteReadHandler serves Prometheus remote read requests at POST /api/v1/read.
+// Channels are selected by metric name and entity labels and returned as samples.
+func (server *Server) remoteReadHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, remoteReadMaxBody))
+	if err == nil {
+		body, err = snappyDecode(body)
+	}
+	var queries []remoteQuery
+	if err == nil {
+		queries, err = decodeReadRequest(body)
+	}
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		writeQueryError(w, qe)
+		return
+	}
+	if err != nil {
+		log.Printf("remote read decode failed: %v", err)
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: err.Error(),
+			Hint:    "expected a snappy compressed prometheus.ReadRequest",
+		})
+		return
+	}
+
+	ctx, cancel := server.queryContext(r)
+	defer cancel()
+
+	channels := server.remoteChannels(ctx)
+
+	results := make([][]remoteResult, 0, len(queries))
+	for _, q := range queries {
+		res, err := server.remoteRead(ctx, channels, q)
+		if err != nil {
+			writeQueryError(w, queryError(Target{}, err))
+			return
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Header().Set("Content-Encoding", "snappy")
+	if _, err := w.Write(snappyEncode(encodeReadResponse(results))); err != nil {
+		log.Printf("remote read write failed: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// readRequest is a prometheus.ReadRequest as sent by Prometheus for
+// up{job=~"vz.*"} from 1000 to 2000 with step 60s and accepted response type SAMPLES
+const readRequest = `
+0a 2b
+	08 e8 07
+	10 d0 0f
+	1a 0e 12 08 5f5f6e616d655f5f 1a 02 7570
+	1a 0d 08 02 12 03 6a6f62 1a 04 767a2e2a
+	22 04 08 e0 d4 03
+12 01 00`
+
+func TestDecodeReadRequest(t *testing.T) {
+	queries, err := decodeReadRequest(unhex(t, readRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(queries))
+	}
+
+	q := queries[0]
+	if q.start != 1000 || q.end != 2000 || q.step != 60000 {
+		t.Errorf("unexpected range %d-%d step %d", q.start, q.end, q.step)
+	}
+	if len(q.matchers) != 2 {
+		t.Fatalf("expected 2 matchers, got %d", len(q.matchers))
+	}
+
+	tests := []struct {
+		matcher remoteMatcher
+		value   string
+		matches bool
+	}{
+		{q.matchers[0], "up", true},
+		{q.matchers[0], "upper", false},
+		{q.matchers[1], "vz", true},
+		{q.matchers[1], "vzlogger", true},
+		{q.matchers[1], "node", false},
+	}
+	for _, tc := range tests {
+		if m := tc.matcher.matches(tc.value); m != tc.matches {
+			t.Errorf("%s %q: expected %v, got %v", tc.matcher.name, tc.value, tc.matches, m)
+		}
+	}
+}
+
+func TestDecodeReadRequestInvalid(t *testing.T) {
+	tests := []struct {
+		name, payload string
+	}{
+		{"truncated", "0a 35 08 e8 07"},
+		{"invalid key", "80"},
+		{"matcher type", "0a 06 1a 04 08 07 12 00"},
+		{"regexp", "0a 09 1a 07 08 02 1a 03 28 2a 29"},
+	}
+	for _, tc := range tests {
+		if _, err := decodeReadRequest(unhex(t, tc.payload)); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestEncodeReadResponse(t *testing.T) {
+	results := [][]remoteResult{{{
+		labels: []remoteLabel{{"__name__", "up"}},
+		tuples: []Tuple{{Timestamp: 1000, Value: 1}},
+	}}}
+
+	// prometheus.ReadResponse of a single sample 1 at 1000
+	expected := unhex(t, `
+0a 20
+	0a 1e
+		0a 0e 0a 08 5f5f6e616d655f5f 12 02 7570
+		12 0c 09 000000000000f03f 10 e8 07`)
+
+	if b := encodeReadResponse(results); !bytes.Equal(b, expected) {
+		t.Errorf("expected %x, got %x", expected, b)
+	}
+}
+
+func TestSnappyDecode(t *testing.T) {
+	tests := []struct {
+		name, payload, expected string
+	}{
+		{"empty", "00", ""},
+		{"literal", "05 10 68656c6c6f", "hello"},
+		// copy with 1 byte offset: "abc" followed by a copy of 9 at offset 3
+		{"overlapping copy", "0c 08 616263 15 03", "abcabcabcabc"},
+		// copy with 2 byte offset
+		{"copy 2", "08 0c 77786a6b 0e 0400", "wxjkwxjk"},
+		// copy with 4 byte offset
+		{"copy 4", "08 0c 77786a6b 0f 04000000", "wxjkwxjk"},
+	}
+	for _, tc := range tests {
+		b, err := snappyDecode(unhex(t, tc.payload))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if string(b) != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, b)
+		}
+	}
+}
+
+func TestSnappyDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name, payload string
+	}{
+		{"missing length", ""},
+		{"short literal", "05 10 6865"},
+		{"length mismatch", "06 10 68656c6c6f"},
+		{"offset beyond output", "0c 08 616263 15 05"},
+		{"output beyond declared length", "04 08 616263 15 03"},
+		// declares 256 MiB without providing the data
+		{"declared length", "80808080 01 00 61"},
+	}
+	for _, tc := range tests {
+		if _, err := snappyDecode(unhex(t, tc.payload)); err == nil {
+			t.Errorf("%s: expected error", tc.name)
+		}
+	}
+}
+
+func TestSnappyRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 59, 60, 255, 256, 1 << 16, 1<<16 + 1, 3 << 16} {
+		src := make([]byte, n)
+		for i := range src {
+			src[i] = byte(i * 7)
+		}
+
+		b, err := snappyDecode(snappyEncode(src))
+		if err != nil {
+			t.Errorf("%d bytes: %v", n, err)
+			continue
+		}
+		if !bytes.Equal(b, src) {
+			t.Errorf("%d bytes: round trip mismatch", n)
+		}
+	}
+
+	// request and response payloads survive compression
+	for _, payload := range []string{readRequest} {
+		src := unhex(t, payload)
+		b, err := snappyDecode(snappyEncode(src))
+		if err != nil || !bytes.Equal(b, src) {
+			t.Errorf("payload round trip failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// requestIDHeader identifies a request across gravo's and the middleware's logs
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// newID returns a random hex id
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withRequestID attaches the request id to ctx
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the request id of ctx or empty string
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs prefixed with the request id of ctx if available
+func logf(ctx context.Context, format string, v ...interface{}) {
+	if id := requestID(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Output(2, fmt.Sprintf(format, v...))
+}
+
+// requestIDs accepts or generates the request id and returns it with the response
+func requestIDs(f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		f(w, r.WithContext(withRequestID(r.Context(), id)))
+	}
+}
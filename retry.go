@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// retryPolicy retries transient middleware failures with exponential backoff
+type retryPolicy struct {
+	attempts int           // total attempts, 1 or less disables retries
+	backoff  time.Duration // delay before the first retry, doubled per retry
+	jitter   float64       // random fraction the delay is varied by
+}
+
+// transient checks if err is worth retrying: network errors and server errors
+// other than middleware exceptions, which are deterministic
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode >= 500 && middlewareMessage(se.Body) == se.Body
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// delay returns the backoff before retry n (0-based)
+func (p retryPolicy) delay(n int) time.Duration {
+	d := p.backoff << n
+	if p.jitter > 0 {
+		d = time.Duration(float64(d) * (1 + p.jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// do calls fn until it succeeds, fails permanently or attempts are exhausted
+func (p retryPolicy) do(ctx context.Context, fn func() ([]byte, error)) ([]byte, error) {
+	for n := 0; ; n++ {
+		body, err := fn()
+		if err == nil || n+1 >= p.attempts || !transient(err) {
+			return body, err
+		}
+
+		d := p.delay(n)
+
+		// give up if the retry can't finish within the deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return body, err
+		}
+
+		logf(ctx, "retrying in %v: %v", d.Round(time.Millisecond), err)
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// savedPrefix selects a saved query as Grafana target, e.g. saved:monthly_cost_overview
+const savedPrefix = "saved:"
+
+// SavedQueryConfig is a named set of targets queried together
+type SavedQueryConfig struct {
+	Description string              `yaml:"description"`
+	Targets     []SavedTargetConfig `yaml:"targets"`
+	Period      string              `yaml:"period"` // day, month or year up to the end of the requested range
+	Range       string              `yaml:"range"`  // duration before the end of the requested range
+}
+
+// SavedTargetConfig is a target of a saved query using the same data as Grafana targets
+type SavedTargetConfig struct {
+	Target string            `yaml:"target"`
+	Type   string            `yaml:"type"` // timeserie or table
+	Data   map[string]string `yaml:"data"`
+}
+
+// SavedQueryInfo describes a saved query in GET /saved
+type SavedQueryInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Targets     int    `json:"targets"`
+}
+
+// validate checks the saved query config
+func (sq SavedQueryConfig) validate() error {
+	if len(sq.Targets) == 0 {
+		return fmt.Errorf("missing targets")
+	}
+
+	for _, t := range sq.Targets {
+		if t.Target == "" {
+			return fmt.Errorf("missing target")
+		}
+		if strings.HasPrefix(t.Target, savedPrefix) {
+			return fmt.Errorf("saved queries cannot be nested: %s", t.Target)
+		}
+	}
+
+	switch strings.ToLower(sq.Period) {
+	case "", "day", "month", "year":
+	default:
+		return fmt.Errorf("invalid period: %s", sq.Period)
+	}
+
+	if sq.Range != "" {
+		if d, err := time.ParseDuration(sq.Range); err != nil || d <= 0 {
+			return fmt.Errorf("invalid range: %s", sq.Range)
+		}
+	}
+
+	return nil
+}
+
+// queryRange returns the range of the saved query for the requested range, periods
+// starting in loc
+func (sq SavedQueryConfig) queryRange(r Range, loc *time.Location) Range {
+	to := r.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	local := to.In(loc)
+
+	switch strings.ToLower(sq.Period) {
+	case "day":
+		return Range{From: time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc), To: to}
+	case "month":
+		return Range{From: time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc), To: to}
+	case "year":
+		return Range{From: time.Date(local.Year(), 1, 1, 0, 0, 0, 0, loc), To: to}
+	}
+
+	if d, err := time.ParseDuration(sq.Range); err == nil {
+		return Range{From: to.Add(-d), To: to}
+	}
+
+	return r
+}
+
+// savedRequest builds the query request of the named saved query
+func (server *Server) savedRequest(name string, qr *QueryRequest) (QueryRequest, error) {
+	sq, ok := server.conf.Queries[name]
+	if !ok {
+		return QueryRequest{}, &QueryError{
+			Status:  http.StatusNotFound,
+			Code:    "not_found",
+			Message: fmt.Sprintf("unknown saved query: %s", name),
+			Target:  savedPrefix + name,
+		}
+	}
+
+	res := QueryRequest{
+		Range:         sq.queryRange(qr.Range, server.conf.defaultLocation()),
+		IntervalMs:    qr.IntervalMs,
+		MaxDataPoints: qr.MaxDataPoints,
+	}
+
+	for _, t := range sq.Targets {
+		data := TargetData{}
+		for k, v := range t.Data {
+			data[k] = v
+		}
+		res.Targets = append(res.Targets, Target{Target: t.Target, Type: t.Type, Data: data})
+	}
+
+	return res, nil
+}
+
+// querySaved runs the named saved query, returning a response per target
+func (server *Server) querySaved(ctx context.Context, name string, qr *QueryRequest) ([]interface{}, error) {
+	sqr, err := server.savedRequest(name, qr)
+	if err != nil {
+		return nil, err
+	}
+	return server.executeQuery(ctx, sqr)
+}
+
+// savedNames returns the sorted names of the saved queries
+func (conf Config) savedNames() []string {
+	res := make([]string, 0, len(conf.Queries))
+	for name := range conf.Queries {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// savedHandler lists the saved queries at /saved and runs them at /saved/<name>.
+// The range is given by `from` and `to` query parameters.
+func (server *Server) savedHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/saved"), "/")
+
+	var resp interface{}
+	if name == "" {
+		list := []SavedQueryInfo{}
+		for _, name := range server.conf.savedNames() {
+			sq := server.conf.Queries[name]
+			list = append(list, SavedQueryInfo{Name: name, Description: sq.Description, Targets: len(sq.Targets)})
+		}
+		resp = list
+	} else {
+		qr, err := savedParams(r, server.conf.defaultLocation())
+		if err != nil {
+			writeQueryError(w, &QueryError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_request",
+				Message: err.Error(),
+				Target:  savedPrefix + name,
+			})
+			return
+		}
+
+		ctx, cancel := server.queryContext(r)
+		defer cancel()
+
+		if resp, err = server.querySaved(ctx, name, qr); err != nil {
+			writeQueryError(w, queryError(Target{Target: savedPrefix + name}, err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
+
+// savedParams parses range and max data points from the url query
+func savedParams(r *http.Request, loc *time.Location) (*QueryRequest, error) {
+	q := r.URL.Query()
+
+	from := q.Get("from")
+	if from == "" {
+		from = "-24h"
+	}
+	f, err := parseTime(from, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from: %v", err)
+	}
+
+	t, err := parseTime(q.Get("to"), loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to: %v", err)
+	}
+
+	qr := &QueryRequest{Range: Range{From: f, To: t}}
+	if s := q.Get("maxDataPoints"); s != "" {
+		if _, err := fmt.Sscan(s, &qr.MaxDataPoints); err != nil {
+			return nil, fmt.Errorf("invalid maxDataPoints: %s", s)
+		}
+	}
+
+	return qr, nil
+}
+
+// savedSeries runs the named saved query for export. Table targets are not supported.
+func (server *Server) savedSeries(ctx context.Context, name string, from, to time.Time) ([]exportSeries, error) {
+	res, err := server.querySaved(ctx, name, &QueryRequest{Range: Range{From: from, To: to}})
+	if err != nil {
+		return nil, err
+	}
+
+	series := make([]exportSeries, 0, len(res))
+	for _, r := range res {
+		qres, ok := r.(QueryResponse)
+		if !ok {
+			return nil, fmt.Errorf("saved query %s: table targets cannot be exported", name)
+		}
+		// exports fail with any target instead of missing its series
+		if err := seriesError(qres); err != nil {
+			return nil, fmt.Errorf("saved query %s: %v", name, err)
+		}
+
+		tuples := make([]Tuple, 0, len(qres.Datapoints))
+		for _, dp := range qres.Datapoints {
+			if !math.IsNaN(float64(dp.Value)) {
+				tuples = append(tuples, Tuple{Timestamp: dp.Timestamp, Value: dp.Value})
+			}
+		}
+
+		title := fmt.Sprint(qres.Target)
+		series = append(series, exportSeries{UUID: title, Title: title, Tuples: tuples, Source: sourceSaved})
+	}
+
+	return series, nil
+}
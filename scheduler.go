@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// jobRange returns the range exported by a job running at now. Periods day, month
+// and year export the previous full period, otherwise range is a duration before now.
+func jobRange(job JobConfig, now time.Time) (time.Time, time.Time, error) {
+	loc := now.Location()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	switch strings.ToLower(job.Period) {
+	case "day":
+		return today.AddDate(0, 0, -1), today, nil
+	case "month":
+		to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+		return to.AddDate(0, -1, 0), to, nil
+	case "year":
+		to := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, loc)
+		return to.AddDate(-1, 0, 0), to, nil
+	case "":
+		d, err := time.ParseDuration(job.Range)
+		if err != nil {
+			return now, now, fmt.Errorf("invalid range: %s", job.Range)
+		}
+		return now.Add(-d), now, nil
+	default:
+		return now, now, fmt.Errorf("invalid period: %s", job.Period)
+	}
+}
+
+// runJob exports the job's saved query and channels as csv, with bundle as zip
+// archive including the manifest, and delivers the file
+func runJob(ctx context.Context, api *Api, job JobConfig, conf Config, now time.Time) error {
+	from, to, err := jobRange(job, now)
+	if err != nil {
+		return err
+	}
+
+	locale, ok := csvLocales[job.Locale]
+	if !ok {
+		locale = csvLocales["en"]
+	}
+
+	decimals := -1
+	if job.Decimals != nil {
+		decimals = *job.Decimals
+	}
+
+	ctx, trace := withTrace(ctx)
+	server := newServer(api, conf, "", nil)
+
+	series := []exportSeries{}
+	if job.Query != "" {
+		if series, err = server.savedSeries(ctx, job.Query, from, to); err != nil {
+			return err
+		}
+	}
+
+	for _, uuid := range job.Channels {
+		preset := conf.preset(uuid, classExport, job.Preset)
+
+		group := preset.Group
+		if job.Group != "" {
+			group = job.Group
+		}
+
+		entity, err := api.getEntity(ctx, uuid)
+		if err != nil {
+			return err
+		}
+
+		tuples, err := api.getData(ctx, uuid, from, to, group, preset.Options, 0)
+		if err != nil {
+			return err
+		}
+
+		series = append(series, exportSeries{
+			UUID:     uuid,
+			Title:    entity.Title,
+			Location: conf.location(uuid),
+			Tuples:   tuples,
+			Source:   sourceChannel,
+			Data:     TargetData{"group": group, "options": preset.Options},
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, series, locale, decimals, false); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.csv", job.Name, from.Format("20060102-1504"))
+	contentType := "text/csv"
+
+	if job.Bundle {
+		m := server.exportManifest(series, job.Query, Range{From: from, To: to}, trace.list())
+		m.Format, m.Locale, m.Decimals = "csv", job.Locale, decimals
+		if _, ok := csvLocales[m.Locale]; !ok {
+			m.Locale = "en"
+		}
+
+		data := buf.Bytes()
+		buf = bytes.Buffer{}
+		if err := writeBundle(&buf, name, data, m); err != nil {
+			return err
+		}
+		name, contentType = strings.TrimSuffix(name, ".csv")+".zip", "application/zip"
+	}
+
+	if job.Directory != "" {
+		if err := ioutil.WriteFile(filepath.Join(job.Directory, name), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+
+	if job.S3 {
+		if err := conf.S3.putObject(name, buf.Bytes(), contentType); err != nil {
+			return err
+		}
+	}
+
+	if job.Webhook != "" {
+		req, err := http.NewRequest(http.MethodPost, job.Webhook, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+
+		resp, err := uploadClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook failed: %s", resp.Status)
+		}
+	}
+
+	return nil
+}
+
+// schedule runs the job whenever its cron schedule matches
+func schedule(api *Api, job JobConfig, conf Config, cs *cronSchedule) {
+	for {
+		next := cs.next(time.Now().In(conf.defaultLocation()))
+		if next.IsZero() {
+			log.Printf("job %s: schedule never matches", job.Name)
+			return
+		}
+
+		time.Sleep(time.Until(next))
+
+		log.Printf("job %s: running", job.Name)
+		if err := runJob(context.Background(), api, job, conf, next); err != nil {
+			log.Printf("job %s: %v", job.Name, err)
+		}
+	}
+}
+
+// startScheduler validates the jobs and starts their schedules
+func startScheduler(api *Api, conf Config) error {
+	for _, job := range conf.Jobs {
+		if job.S3 && !conf.S3.configured() {
+			return fmt.Errorf("job %s: s3 not configured", job.Name)
+		}
+
+		cs, err := parseCron(job.Schedule)
+		if err != nil {
+			return fmt.Errorf("job %s: %v", job.Name, err)
+		}
+
+		if _, _, err := jobRange(job, time.Now().In(conf.defaultLocation())); err != nil {
+			return fmt.Errorf("job %s: %v", job.Name, err)
+		}
+
+		go schedule(api, job, conf, cs)
+	}
+
+	return nil
+}
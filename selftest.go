@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// selfTestWindow is the time range of the sample data queries
+const selfTestWindow = time.Hour
+
+// selfTestKey is the cache key written by the cache check
+const selfTestKey = "/selftest"
+
+// errSkipped marks self-test steps that did not run
+var errSkipped = errors.New("skipped")
+
+// SelfTestStep is the result of a single self-test check
+type SelfTestStep struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"` // pass, fail or skip
+	Detail   string `json:"detail,omitempty"`
+	Duration string `json:"duration"`
+
+	err error
+}
+
+// selfTest checks the middleware setup step by step: endpoint detection, credentials,
+// entity list, a sample data query of each configured channel and the cache
+func selfTest(ctx context.Context, api *Api, conf Config) []SelfTestStep {
+	var res []SelfTestStep
+
+	step := func(name, target string, fn func() (string, error)) bool {
+		start := time.Now()
+		detail, err := fn()
+
+		s := SelfTestStep{Name: name, Status: "pass", Detail: detail, err: err}
+		switch {
+		case err == errSkipped:
+			s.Status, s.err = "skip", nil
+		case err != nil:
+			s.Status, s.Detail = "fail", selfTestDetail(target, err)
+		}
+		s.Duration = time.Since(start).Round(time.Millisecond).String()
+
+		res = append(res, s)
+		return err == nil
+	}
+
+	// detection fails on rejected credentials, auth tells them apart
+	step("endpoint", "", func() (string, error) {
+		if api.version == "" && !api.probe(api.url) {
+			var se *StatusError
+			if _, err := api.checkAuth(ctx); errors.As(err, &se) && (se.StatusCode == http.StatusUnauthorized || se.StatusCode == http.StatusForbidden) {
+				return "not detected, credentials rejected", errSkipped
+			}
+			return "", &apiError{ErrMiddlewareUnavailable, fmt.Errorf("no middleware detected at %s", api.url)}
+		}
+		return fmt.Sprintf("%s, version %s", api.url, api.version), nil
+	})
+
+	auth := step("auth", "", func() (string, error) {
+		return api.checkAuth(ctx)
+	})
+
+	step("entities", "", func() (string, error) {
+		if !auth {
+			return "middleware not accessible", errSkipped
+		}
+		public, err := api.getEntities(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d public entities", len(public)), nil
+	})
+
+	to := time.Now()
+	for _, uuid := range conf.selfTestChannels() {
+		uuid := uuid
+		step("data "+uuid, uuid, func() (string, error) {
+			if !auth {
+				return "middleware not accessible", errSkipped
+			}
+			tuples, err := api.getData(ctx, uuid, to.Add(-selfTestWindow), to, "", "", 60)
+			if err != nil {
+				return "", err
+			}
+			if len(tuples) == 0 {
+				return fmt.Sprintf("no tuples within the last %v", selfTestWindow), nil
+			}
+			return fmt.Sprintf("%d tuples, last at %s", len(tuples), formatMS(tuples[len(tuples)-1].Timestamp)), nil
+		})
+	}
+
+	step("cache", "", func() (string, error) {
+		return api.cache.check()
+	})
+
+	return res
+}
+
+// selfTestDetail describes the error of a failed step with its hint. Error pages
+// of web servers are reduced to their status.
+func selfTestDetail(target string, err error) string {
+	qe := queryError(Target{Target: target}, err)
+
+	msg := qe.Message
+	var se *StatusError
+	if errors.As(err, &se) && msg == se.Body {
+		msg = "middleware responded " + se.Status
+	}
+
+	if qe.Hint != "" {
+		msg += " (" + qe.Hint + ")"
+	}
+	return msg
+}
+
+// checkAuth checks that the middleware accepts the credentials
+func (api *Api) checkAuth(ctx context.Context) (string, error) {
+	url := api.url + "/entity.json"
+	ctx, cancel, _ := api.requestContext(ctx, url)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return "", &apiError{ErrMiddlewareUnavailable, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", &StatusError{resp.StatusCode, resp.Status, string(b)}
+	}
+	return "credentials accepted", nil
+}
+
+// check writes and reads back an entry of the disk cache
+func (c *responseCache) check() (string, error) {
+	if c == nil {
+		return "disabled", errSkipped
+	}
+	if c.l2 == nil {
+		return fmt.Sprintf("memory, ttl %v", c.ttl), nil
+	}
+
+	now := time.Now()
+	body := []byte(now.Format(time.RFC3339Nano))
+	c.l2.set(selfTestKey, body, now)
+	defer c.l2.invalidate(func(key string) bool { return key == selfTestKey })
+
+	if b, _, ok := c.l2.get(selfTestKey, now); !ok || !bytes.Equal(b, body) {
+		return "", fmt.Errorf("disk cache %s not writable", c.l2.dir)
+	}
+	return fmt.Sprintf("memory and disk %s, ttl %v", c.l2.dir, c.ttl), nil
+}
+
+// selfTestChannels returns the sorted configured channels and aliased uuids
+func (conf Config) selfTestChannels() []string {
+	seen := make(map[string]bool)
+	for uuid := range conf.Channels {
+		seen[uuid] = true
+	}
+	for _, uuid := range conf.Aliases {
+		seen[uuid] = true
+	}
+
+	res := make([]string, 0, len(seen))
+	for uuid := range seen {
+		res = append(res, uuid)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// selfTestError returns the error of the first failed step, classified by its cause
+func selfTestError(steps []SelfTestStep) error {
+	for _, s := range steps {
+		if s.err != nil {
+			return &cliError{exitCode(s.err), fmt.Errorf("self-test %s failed", s.Name)}
+		}
+	}
+	return nil
+}
+
+// printSelfTest writes the report as table
+func printSelfTest(w io.Writer, steps []SelfTestStep) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range steps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Status, s.Name, s.Duration, s.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		log.Print(err)
+	}
+}
+
+// logSelfTest logs the report on startup
+func logSelfTest(steps []SelfTestStep) {
+	for _, s := range steps {
+		log.Printf("self-test %s: %s %s", s.Name, s.Status, s.Detail)
+	}
+}
+
+// selftestCommand runs the self-test and exits non-zero if a step failed
+func selftestCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	configFile := fs.String("config", "", "yaml configuration file providing channels to query")
+	deadline := fs.Duration("deadline", time.Minute, "time budget of the self-test")
+	asJSON := fs.Bool("json", false, "json output")
+	fs.Parse(args)
+
+	var conf Config
+	if *configFile != "" {
+		var err error
+		if conf, err = loadConfig(*configFile); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(fs, conf); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+	}
+
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+	api.overlay = conf.metadataOverlay()
+	api.tokens = conf.channelTokens()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *deadline)
+	defer cancel()
+
+	steps := selfTest(ctx, api, conf)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(steps); err != nil {
+			return err
+		}
+	} else {
+		printSelfTest(os.Stdout, steps)
+	}
+
+	return selfTestError(steps)
+}
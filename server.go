@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -13,18 +16,80 @@ import (
 // Server is the http endpoint used by Grafana's SimpleJson plugin
 type Server struct {
 	api         *Api
-	entityCache map[string]string
+	conf        Config
+	entityCache map[string]Entity
+	webhook     string
+	precision   map[string]int
+
+	// queryTimeout is the total time budget of a single query
+	queryTimeout time.Duration
+
+	// grafanaTimeout is Grafana's data proxy timeout unless sent per request
+	grafanaTimeout time.Duration
+
+	// watchdog monitors configured channels for dead sensors
+	watchdog *watchdog
+
+	// fanout limits the targets of a query fetched concurrently, unlimited if not positive
+	fanout int
+
+	// sla evaluates data freshness and completeness of configured channels
+	sla *slaMonitor
+
+	// invalidateToken authenticates cache invalidation requests
+	invalidateToken string
+
+	// alerts holds Grafana alert notifications, nil if disabled
+	alerts      *alertStore
+	alertsToken string
+
+	// consoleToken authenticates the query console
+	consoleToken string
+
+	// protocol is the Grafana datasource protocol: simplejson or jsonapi
+	protocol string
+
+	// autoscale scales series with SI units to a prefix matching their magnitude
+	autoscale bool
+
+	// push holds live tuples of the push server, nil if disabled
+	push *pushSubscriber
+
+	// aliases maps channel names to uuids
+	aliases *aliasMap
+
+	// entityFile persists the last known entities for startup while the middleware is down
+	entityFile string
+
+	// readyMaxAge is the maximum age of the entity list for readiness, unchecked if not positive
+	readyMaxAge time.Duration
+
+	// writes checks tuples of POST /write, nil if disabled
+	writes     *writeChecker
+	writeToken string // empty if the listeners authenticate writes
+
+	// sites are the further middlewares of multisite channels
+	sites map[string]*Api
+
+	mu       sync.Mutex
+	notified map[string]int64 // timestamp of the last notified anomaly by channel
+	fetched  time.Time        // last successful fetch of the entity list
+	tree     []Entity         // entity tree of the last refresh
+	entities []Entity         // flattened entities of the last refresh
+	groups   []Entity         // groups of the last refresh titled by path
 }
 
-func newServer(api *Api) *Server {
+func newServer(api *Api, conf Config, webhook string, precision map[string]int) *Server {
 	server := &Server{
 		api:         api,
-		entityCache: make(map[string]string),
+		conf:        conf,
+		entityCache: make(map[string]Entity),
+		webhook:     webhook,
+		precision:   precision,
+		notified:    make(map[string]int64),
+		aliases:     newAliasMap(conf.Aliases),
 	}
 
-	// get entity map on startup
-	server.getPublicEntites()
-
 	return server
 }
 
@@ -35,11 +100,18 @@ func (server *Server) rootHandler(w http.ResponseWriter, r *http.Request) {
 func (server *Server) annotationsHandler(w http.ResponseWriter, r *http.Request) {
 	ar := AnnotationsRequest{}
 	if err := json.NewDecoder(r.Body).Decode(&ar); err != nil {
-		http.Error(w, fmt.Sprintf("json decode failed: %v", err), http.StatusBadRequest)
+		writeQueryError(w, invalidRequest(err))
 		return
 	}
 
-	resp := []AnnotationResponse{}
+	ctx, cancel := server.queryContext(r)
+	defer cancel()
+
+	resp, err := server.executeAnnotations(ctx, ar)
+	if err != nil {
+		writeQueryError(w, queryError(Target{}, err))
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("json encode failed: %v", err)
@@ -48,34 +120,57 @@ func (server *Server) annotationsHandler(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-func (server *Server) tagKeysHandler(w http.ResponseWriter, r *http.Request) {
-	resp := []TagKeyResponse{
-		TagKeyResponse{
-			Type: "string",
-			Text: "group"},
-		// TagKeyResponse{
-		// 	Type: "string",
-		// 	Text: "mode"}
+// parseAnnotationQuery decodes the annotation query which uses the same keys
+// as the target's additional JSON data, with `target` selecting the channel
+func parseAnnotationQuery(query string) (Target, error) {
+	data := TargetData{}
+	if err := json.Unmarshal([]byte(query), &data); err != nil {
+		return Target{}, err
 	}
 
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		log.Printf("json encode failed: %v", err)
-		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
-		return
-	}
+	return Target{Target: data["target"], Data: data}, nil
 }
 
-func (server *Server) tagValuesHandler(w http.ResponseWriter, r *http.Request) {
-	resp := []TagValueResponse{
-		TagValueResponse{"Current"},
-		TagValueResponse{"Consumption"},
+func (server *Server) executeAnnotations(ctx context.Context, ar AnnotationsRequest) ([]AnnotationResponse, error) {
+	target, err := parseAnnotationQuery(ar.Annotation.Query)
+	if err != nil {
+		logf(ctx, "invalid annotation query: %v", err)
+		return nil, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_annotation",
+			Message: fmt.Sprintf("invalid annotation query: %v", err),
+			Hint:    `annotation query must be a json object, e.g. {"target": "<uuid>", "context": "sessions"}`,
+		}
 	}
 
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		log.Printf("json encode failed: %v", err)
-		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
-		return
+	var res []AnnotationResponse
+	switch strings.ToLower(target.Data["context"]) {
+	case "sessions":
+		res, err = server.sessionAnnotations(ctx, target, &ar)
+	case "anomalies":
+		res, err = server.anomalyAnnotations(ctx, target, &ar)
+	case "watchdog":
+		res, err = server.watchdogAnnotations(ctx, target, &ar)
+	case "states":
+		res, err = server.stateAnnotations(ctx, target, &ar)
+	case "crossings":
+		res, err = server.crossingAnnotations(ctx, target, &ar)
+	case "gaps":
+		res, err = server.gapAnnotations(ctx, target, &ar)
+	case "alerts":
+		res, err = server.alertAnnotations(ctx, target, &ar)
+	case "events":
+		res, err = server.eventAnnotations(ctx, target, &ar)
+	default:
+		return []AnnotationResponse{}, nil
+	}
+
+	if err != nil {
+		logf(ctx, "annotation query failed: %v", err)
+		return nil, queryError(target, err)
 	}
+
+	return res, nil
 }
 
 func (server *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
@@ -86,7 +181,7 @@ func (server *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := server.executeSearch(sr)
+	resp := server.executeSearch(r.Context(), sr)
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("json encode failed: %v", err)
@@ -95,43 +190,78 @@ func (server *Server) searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// flattenEntities appends the channels of the entity tree, titled by their group path
 func (server *Server) flattenEntities(result *[]Entity, entities []Entity, parent string) {
 	for _, entity := range entities {
 		if entity.Type == "group" {
-			server.flattenEntities(result, entity.Children, entity.Title)
+			server.flattenEntities(result, entity.Children, groupPath(parent, entity.Title))
 		} else {
-			if parent != "" {
-				entity.Title = fmt.Sprintf("%s (%s)", entity.Title, parent)
-			}
+			entity.Title = groupPath(parent, entity.Title)
 			*result = append(*result, entity)
 		}
 	}
 }
 
+// populateCache replaces the entity cache, server.mu must be held
 func (server *Server) populateCache(entities []Entity) {
-	if len(entities) > 0 {
-		server.entityCache = make(map[string]string)
+	if len(entities) == 0 {
+		return
 	}
 
-	// add to cache
+	// build the new cache before replacing it, queries read the cache concurrently
+	cache := make(map[string]Entity, len(entities))
 	for _, entity := range entities {
-		if _, ok := server.entityCache[entity.UUID]; !ok {
-			server.entityCache[entity.UUID] = entity.Title
+		if _, ok := cache[entity.UUID]; !ok {
+			cache[entity.UUID] = entity
 		}
 	}
+	server.entityCache = cache
 }
 
-func (server *Server) getPublicEntites() []Entity {
-	entities := make([]Entity, 0)
-	server.flattenEntities(&entities, server.api.getEntities(), "")
-	server.populateCache(entities)
-	return entities
+// cachedEntity returns the cached entity of the uuid
+func (server *Server) cachedEntity(uuid string) (Entity, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	entity, ok := server.entityCache[uuid]
+	return entity, ok
 }
 
-func (server *Server) executeSearch(sr SearchRequest) []SearchResponse {
-	entities := server.getPublicEntites()
+// publicEntities returns the entity tree, falling back to the saved entity file
+func (server *Server) publicEntities(ctx context.Context) []Entity {
+	public, err := server.api.getEntities(ctx)
+	if err == nil {
+		server.entitiesFetched()
+	}
+	if err == nil && server.entityFile != "" {
+		if err := saveEntities(server.entityFile, public); err != nil {
+			log.Printf("saving entities failed: %v", err)
+		}
+	}
 
+	if err != nil && server.entityFile != "" {
+		if snapshot, err := loadEntities(server.entityFile); err == nil {
+			log.Printf("middleware unavailable, using entities saved %s", snapshot.Saved.Format(time.RFC3339))
+			public = snapshot.Entities
+			server.api.overlayEntities(public)
+		}
+	}
+
+	return public
+}
+
+// executeSearch returns all channels or, if the target is a filter like type=power,
+// the matching channels
+func (server *Server) executeSearch(ctx context.Context, sr SearchRequest) []SearchResponse {
 	res := []SearchResponse{}
+
+	if filters, ok := parseFilters(sr.Target); ok {
+		for _, e := range server.filteredEntities(ctx, filters) {
+			res = append(res, SearchResponse{Text: e.Title, UUID: e.UUID})
+		}
+		return res
+	}
+
+	_, entities := server.cachedEntities(ctx)
 	for _, entity := range entities {
 		res = append(res, SearchResponse{
 			Text: entity.Title,
@@ -139,6 +269,32 @@ func (server *Server) executeSearch(sr SearchRequest) []SearchResponse {
 		})
 	}
 
+	server.mu.Lock()
+	groups := server.groups
+	server.mu.Unlock()
+	for _, group := range groups {
+		res = append(res, SearchResponse{Text: group.Title, UUID: group.UUID})
+	}
+
+	for _, alias := range server.conf.aliasNames() {
+		res = append(res, SearchResponse{Text: alias, UUID: alias})
+	}
+
+	for _, name := range server.conf.virtualNames() {
+		res = append(res, SearchResponse{Text: name, UUID: name})
+	}
+
+	for _, name := range server.conf.multisiteNames() {
+		res = append(res, SearchResponse{Text: name, UUID: name})
+	}
+
+	for _, name := range server.conf.savedNames() {
+		res = append(res, SearchResponse{
+			Text: savedPrefix + name,
+			UUID: savedPrefix + name,
+		})
+	}
+
 	return res
 }
 
@@ -146,11 +302,26 @@ func (server *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
 	qr := QueryRequest{}
 	if err := json.NewDecoder(r.Body).Decode(&qr); err != nil {
 		log.Printf("json decode failed: %v", err)
-		http.Error(w, fmt.Sprintf("json decode failed: %v", err), http.StatusBadRequest)
+		writeQueryError(w, invalidRequest(err))
 		return
 	}
 
-	resp := server.executeQuery(qr)
+	if server.jsonAPI() {
+		var err error
+		if qr, err = payloadTargets(qr); err != nil {
+			writeQueryError(w, invalidRequest(err))
+			return
+		}
+	}
+
+	ctx, cancel := server.queryContext(r)
+	defer cancel()
+
+	resp, err := server.executeQuery(ctx, qr)
+	if err != nil {
+		writeQueryError(w, queryError(Target{}, err))
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("json encode failed: %v", err)
@@ -159,67 +330,353 @@ func (server *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func roundTimestampMS(ts int64, group string) int64 {
-	t := time.Unix(ts/1000, 0)
+// grafanaTimeoutHeader can be configured as custom header of the datasource to
+// announce Grafana's timeout, either in seconds or as duration
+const grafanaTimeoutHeader = "X-Grafana-Timeout"
+
+// clientTimeout returns the time after which Grafana gives up on the request
+func (server *Server) clientTimeout(r *http.Request) time.Duration {
+	if h := r.Header.Get(grafanaTimeoutHeader); h != "" {
+		if s, err := strconv.ParseFloat(h, 64); err == nil {
+			return time.Duration(s * float64(time.Second))
+		}
+		if d, err := time.ParseDuration(h); err == nil {
+			return d
+		}
+		logf(r.Context(), "invalid %s: %s", grafanaTimeoutHeader, h)
+	}
+
+	return server.grafanaTimeout
+}
+
+// queryContext limits the total time spent on all upstream requests of a query
+// including retries and chunked requests. The deadline is set slightly below
+// Grafana's timeout to respond with a timeout error before Grafana cancels.
+// The context is cancelled if the client disconnects.
+func (server *Server) queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := r.Context()
+
+	timeout := server.queryTimeout
+	if ct := server.clientTimeout(r); ct > 0 {
+		if ct -= ct / 10; timeout <= 0 || ct < timeout {
+			timeout = ct
+		}
+	}
+
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// roundTimestampMS truncates ts to the start of the group period in loc, weeks
+// start on Monday
+func roundTimestampMS(ts int64, group string, loc *time.Location) int64 {
+	t := time.Unix(ts/1000, 0).In(loc)
 
 	switch group {
+	case "minute":
+		t = t.Truncate(time.Minute)
 	case "hour":
-		t.Truncate(time.Hour)
+		// wall clock hours, zones may be offset by half an hour
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
 	case "day":
-		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		t = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
 	case "month":
-		t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+		t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+	case "year":
+		t = time.Date(t.Year(), 1, 1, 0, 0, 0, 0, loc)
 	}
 
 	return t.Unix() * 1000
 }
 
-func (server *Server) executeQuery(qr QueryRequest) []QueryResponse {
-	res := make([]QueryResponse, len(qr.Targets))
-	wg := &sync.WaitGroup{}
+// executeQuery runs all targets concurrently. Failed targets are returned as error
+// series while other targets succeeded, if all fail their errors are returned as
+// *QueryError.
+func (server *Server) executeQuery(ctx context.Context, qr QueryRequest) ([]interface{}, error) {
+	qr = server.expandFilters(ctx, server.resolveAliases(ctx, qr))
+
+	res := make([]interface{}, len(qr.Targets))
+	thresholds := make([][]QueryResponse, len(qr.Targets))
+	expanded := make([][]interface{}, len(qr.Targets))
+	errs := make([]*QueryError, len(qr.Targets))
 
-	for idx, target := range qr.Targets {
-		wg.Add(1)
+	fanOut(ctx, len(qr.Targets), server.fanout, func(idx int) {
+		target := qr.Targets[idx]
 
-		go func(idx int, target Target) {
-			var context string
-			if ctx, ok := target.Data["context"]; ok {
-				context = strings.ToLower(ctx)
+		// a malformed target must not take down the server
+		defer func() {
+			if rec := recover(); rec != nil {
+				errs[idx] = panicError(rec)
 			}
+		}()
 
+		var kind string
+		if c, ok := target.Data["context"]; ok {
+			kind = strings.ToLower(c)
+		}
+
+		tctx, standby := withStandbyMarker(ctx)
+
+		var err error
+		if strings.HasPrefix(target.Target, savedPrefix) {
+			expanded[idx], err = server.querySaved(tctx, strings.TrimPrefix(target.Target, savedPrefix), &qr)
+		} else if kind == "children" {
+			expanded[idx], err = server.queryChildren(tctx, target, &qr)
+		} else if m, ok := server.conf.Multisite[target.Target]; ok {
+			expanded[idx], err = server.queryMultisite(tctx, m, target, &qr)
+		} else if strings.ToLower(target.Type) == "table" {
+			res[idx], err = server.queryTable(tctx, kind, target, &qr)
+		} else if _, ok := target.Data["split"]; ok && kind == "" {
+			var parts []QueryResponse
+			if parts, err = server.querySplit(tctx, target, &qr); err == nil {
+				expanded[idx] = make([]interface{}, 0, len(parts))
+				for _, qres := range parts {
+					if standby.served() {
+						qres = withStandbyNotice(qres)
+					}
+					expanded[idx] = append(expanded[idx], qres)
+				}
+			}
+		} else {
 			var qres QueryResponse
-			if context == "prognosis" {
-				qres = server.queryPrognosis(target)
-			} else {
-				qres = server.queryData(target, &qr)
+			if qres, err = server.querySeries(tctx, kind, target, &qr); err == nil {
+				if standby.served() {
+					qres = withStandbyNotice(qres)
+				}
+				res[idx] = qres
+				thresholds[idx] = server.thresholdSeries(target, qres, &qr)
 			}
+		}
 
-			// substitute name
-			if text, ok := server.entityCache[qres.Target.(string)]; ok {
-				qres.Target = text
+		if err != nil {
+			if ctx.Err() != context.Canceled {
+				logf(ctx, "query %s failed: %v", target.Target, err)
 			}
+			errs[idx] = queryError(target, err)
+		}
+	}, func(idx int, err error) {
+		errs[idx] = queryError(qr.Targets[idx], err)
+	})
 
-			if name, ok := target.Data["name"]; ok {
-				qres.Target = name
-			}
+	if ctx.Err() == context.Canceled {
+		logf(ctx, "client disconnected, query cancelled")
+	}
 
-			res[idx] = qres
-			wg.Done()
-		}(idx, target)
+	var failed []*QueryError
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) == len(errs) && len(failed) > 0 {
+		return nil, targetErrors(failed)
 	}
-	wg.Wait()
 
-	return res
+	// threshold series follow their target, saved queries and multisite channels are expanded,
+	// failed targets are returned as empty series with their error while others succeeded
+	out := make([]interface{}, 0, len(res))
+	for idx := range res {
+		if errs[idx] != nil {
+			out = append(out, errorSeries(errs[idx]))
+			continue
+		}
+		if expanded[idx] != nil {
+			out = append(out, expanded[idx]...)
+			continue
+		}
+		out = append(out, res[idx])
+		for _, qres := range thresholds[idx] {
+			out = append(out, qres)
+		}
+	}
+
+	return out, nil
 }
 
-func (server *Server) queryData(target Target, qr *QueryRequest) QueryResponse {
-	qres := QueryResponse{
-		Target:     target.Target,
-		Datapoints: []ResponseTuple{},
+func (server *Server) querySeries(ctx context.Context, kind string, target Target, qr *QueryRequest) (QueryResponse, error) {
+	var qres QueryResponse
+	var err error
+	switch kind {
+	case "prognosis":
+		qres, err = server.queryPrognosis(ctx, target)
+	case "sum":
+		qres, err = server.querySum(ctx, target, qr)
+	case "budget":
+		qres, err = server.queryBudget(ctx, target)
+	case "peak":
+		qres, err = server.queryPeak(ctx, target, qr)
+	case "duration":
+		qres, err = server.queryDuration(ctx, target, qr)
+	case "cop":
+		qres, err = server.queryCOP(ctx, target, qr)
+	case "battery":
+		qres, err = server.queryBattery(ctx, target, qr)
+	case "sessions":
+		qres, err = server.querySessions(ctx, target, qr)
+	case "baseline":
+		qres, err = server.queryBaseline(ctx, target, qr)
+	case "meter":
+		qres, err = server.queryMeter(ctx, target, qr)
+	case "daytype":
+		qres, err = server.queryDayType(ctx, target, qr)
+	case "freshness", "completeness":
+		qres, err = server.querySLA(ctx, kind, target, qr)
+	case "tariff":
+		qres, err = server.queryTariff(ctx, target, qr)
+	case "last":
+		qres, err = server.queryLast(ctx, target, qr)
+	default:
+		qres, err = server.queryData(ctx, target, qr)
+	}
+	if err != nil {
+		return qres, err
+	}
+
+	return server.finishSeries(kind, target, qr, qres)
+}
+
+// finishSeries applies the target's fill, stat, scaling, precision and naming to
+// the queried series
+func (server *Server) finishSeries(kind string, target Target, qr *QueryRequest, qres QueryResponse) (QueryResponse, error) {
+	if _, ok := target.Data["fill"]; ok {
+		if err := fillSeries(target.Data, qr, server.conf.expectedInterval(target.Target), &qres); err != nil {
+			return qres, err
+		}
+	}
+
+	energy, total := server.totalUnit(kind, target)
+	if stat, ok := target.Data["stat"]; ok {
+		if err := reduceStat(stat, &qres); err != nil {
+			return qres, err
+		}
+	}
+	if total {
+		// scale the consumption returned for power channels as energy
+		target.Data = target.Data.with("baseunit", energy)
+	}
+	derived, transformed := server.transformUnit(kind, target)
+	if _, ok := target.Data["transform"]; ok && kind == "" && !total {
+		// transformed series are scaled in their own unit, if known
+		if transformed {
+			target.Data = target.Data.with("baseunit", derived)
+		} else {
+			target.Data = target.Data.with("unit", "none")
+		}
 	}
 
-	var group, options string
-	data := target.Data
+	unit, shift, scaled := server.scaleSeries(kind, target, &qres)
+
+	if decimals, ok := server.decimals(target); ok {
+		// default precision refers to the unscaled unit
+		if _, explicit := target.Data["decimals"]; !explicit && shift > 0 {
+			decimals += shift
+		}
+		for i, dp := range qres.Datapoints {
+			qres.Datapoints[i].Value = round(dp.Value, decimals)
+		}
+	}
+
+	// substitute name
+	if entity, ok := server.cachedEntity(qres.Target.(string)); ok {
+		qres.Target = entity.Title
+	}
+
+	if name, ok := target.Data["name"]; ok {
+		qres.Target = name
+	}
+
+	if scaled {
+		qres.Target = fmt.Sprintf("%v (%s)", qres.Target, unit)
+	}
+
+	custom := make(map[string]interface{})
+	if display := server.conf.Channels[target.Target].Display; display != (DisplayConfig{}) {
+		custom["display"] = display
+	}
+
+	// derived queries have their own units
+	if entity, ok := server.cachedEntity(target.Target); ok && entity.Unit != "" && kind == "" {
+		custom["unit"] = entity.Unit
+	}
+	if v, ok := server.conf.Virtual[target.Target]; ok && v.Unit != "" && kind == "" {
+		custom["unit"] = v.Unit
+	}
+	if _, ok := target.Data["transform"]; ok && kind == "" {
+		// transformed series have their own units, if known
+		delete(custom, "unit")
+	}
+	if transformed {
+		custom["unit"] = derived
+	}
+	if total {
+		custom["unit"] = energy
+	}
+	if scaled {
+		custom["unit"] = unit
+	}
+
+	if len(custom) > 0 {
+		qres.Meta = &ResponseMeta{Custom: custom}
+	}
+
+	return qres, nil
+}
+
+func (server *Server) queryTable(ctx context.Context, kind string, target Target, qr *QueryRequest) (TableResponse, error) {
+	switch kind {
+	case "duration":
+		return server.durationTable(ctx, target, qr)
+	case "sessions":
+		return server.sessionsTable(ctx, target, qr)
+	case "prognosis":
+		return server.prognosisTable(ctx, target)
+	case "tariff":
+		return server.tariffTable(ctx, target, qr)
+	default:
+		qres, err := server.querySeries(ctx, kind, target, qr)
+		if err != nil {
+			return TableResponse{}, err
+		}
+		return seriesTable(qres), nil
+	}
+}
+
+// seriesTable converts a time series into a table
+func seriesTable(qres QueryResponse) TableResponse {
+	table := TableResponse{
+		Columns: []TableColumn{
+			TableColumn{Text: "Time", Type: "time"},
+			TableColumn{Text: fmt.Sprint(qres.Target), Type: "number"},
+		},
+		Rows: [][]interface{}{},
+		Type: "table",
+	}
+
+	for _, tuple := range qres.Datapoints {
+		var value interface{} = tuple.Value
+		if math.IsNaN(float64(tuple.Value)) {
+			value = nil
+		}
+		table.Rows = append(table.Rows, []interface{}{tuple.Timestamp, value})
+	}
+
+	return table
+}
+
+// getTuples retrieves the data of uuid honoring the target's preset, group and options settings
+func (server *Server) getTuples(ctx context.Context, uuid string, data TargetData, qr *QueryRequest) ([]Tuple, error) {
+	if v, ok := server.conf.Virtual[uuid]; ok {
+		return server.virtualTuples(ctx, v, data, qr)
+	}
+
+	preset := server.conf.preset(uuid, classQuery, data["preset"])
+
+	group, options := preset.Group, preset.Options
 	if grp, ok := data["group"]; ok {
 		group = strings.ToLower(grp)
 	}
@@ -227,19 +684,48 @@ func (server *Server) queryData(target Target, qr *QueryRequest) QueryResponse {
 		options = strings.ToLower(opt)
 	}
 
-	tuples := server.api.getData(
-		target.Target,
+	// recent raw data is served from live tuples of the push server
+	if server.push != nil && group == "" && options == "" {
+		if tuples, ok := server.push.between(uuid, unixMS(qr.Range.From), unixMS(qr.Range.To)); ok {
+			logf(ctx, "serving %s from push server", uuid)
+			return tuples, nil
+		}
+	}
+
+	tuples, err := server.api.getData(ctx,
+		uuid,
 		qr.Range.From,
 		qr.Range.To,
 		group,
 		options,
 		qr.MaxDataPoints)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, tuple := range tuples {
-		if group != "" {
-			tuple.Timestamp = roundTimestampMS(tuple.Timestamp, group)
+	if group != "" {
+		loc := server.conf.location(uuid)
+		for i := range tuples {
+			tuples[i].Timestamp = roundTimestampMS(tuples[i].Timestamp, group, loc)
 		}
+	}
+
+	return tuples, nil
+}
+
+// dataResponse converts tuples into the target's query response
+func dataResponse(target string, tuples []Tuple, qr *QueryRequest) QueryResponse {
+	qres := QueryResponse{
+		Target:     target,
+		Datapoints: []ResponseTuple{},
+	}
+
+	// middleware may still return more tuples than requested even with coarsest group
+	if qr.MaxDataPoints > 0 && len(tuples) > qr.MaxDataPoints {
+		tuples = lttb(tuples, qr.MaxDataPoints)
+	}
 
+	for _, tuple := range tuples {
 		qres.Datapoints = append(qres.Datapoints, ResponseTuple{
 			Timestamp: tuple.Timestamp,
 			Value:     tuple.Value,
@@ -249,20 +735,67 @@ func (server *Server) queryData(target Target, qr *QueryRequest) QueryResponse {
 	return qres
 }
 
-func (server *Server) queryPrognosis(target Target) QueryResponse {
+func (server *Server) queryData(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	if _, ok := target.Data["aggregate"]; ok {
+		return server.queryAggregate(ctx, target, qr)
+	}
+	if _, ok := server.totalUnit("", target); ok {
+		return server.queryTotal(ctx, target, qr)
+	}
+
+	tuples, err := server.getTuples(ctx, target.Target, target.Data, qr)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	if _, ok := target.Data["transform"]; ok {
+		if tuples, err = transformTuples(target.Data, tuples); err != nil {
+			return QueryResponse{}, err
+		}
+	}
+	return dataResponse(target.Target, tuples, qr), nil
+}
+
+func (server *Server) queryPrognosis(ctx context.Context, target Target) (QueryResponse, error) {
 	qres := QueryResponse{
 		Target:     target.Target,
 		Datapoints: []ResponseTuple{},
 	}
 
 	if period, ok := target.Data["period"]; ok {
-		pr := server.api.getPrognosis(target.Target, period)
+		var consumption float32
+		if hasBillingPeriod(target.Data) {
+			var err error
+			if consumption, err = server.billingPrognosis(ctx, target.Target, period, target.Data); err != nil {
+				return qres, err
+			}
+		} else {
+			prognosis, err := server.api.getPrognosis(ctx, target.Target, period)
+			if err != nil {
+				return qres, err
+			}
+			consumption = prognosis.Consumption
+		}
 
 		qres.Datapoints = append(qres.Datapoints, ResponseTuple{
-			Value:     pr.Consumption,
+			Value:     consumption,
 			Timestamp: time.Now().Unix(),
 		})
 	}
 
-	return qres
+	return qres, nil
+}
+
+// billingPrognosis extrapolates the consumption of the current billing period
+func (server *Server) billingPrognosis(ctx context.Context, uuid string, period string, data TargetData) (float32, error) {
+	now := time.Now().In(server.conf.location(uuid))
+	start, end := billingPeriod(now, period, data)
+
+	consumption, err := server.api.getConsumption(ctx, uuid, start, now)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := now.Sub(start).Seconds() / end.Sub(start).Seconds()
+
+	return float32(consumption / elapsed), nil
 }
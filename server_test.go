@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundTimestampMS(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Thursday 2024-03-14 15:47:12 in Berlin
+	ts := time.Date(2024, 3, 14, 15, 47, 12, 0, berlin)
+
+	tests := []struct {
+		group    string
+		loc      *time.Location
+		ts       time.Time
+		expected time.Time
+	}{
+		{"", berlin, ts, ts},
+		{"minute", berlin, ts, time.Date(2024, 3, 14, 15, 47, 0, 0, berlin)},
+		{"hour", berlin, ts, time.Date(2024, 3, 14, 15, 0, 0, 0, berlin)},
+		{"day", berlin, ts, time.Date(2024, 3, 14, 0, 0, 0, 0, berlin)},
+		{"week", berlin, ts, time.Date(2024, 3, 11, 0, 0, 0, 0, berlin)},
+		{"month", berlin, ts, time.Date(2024, 3, 1, 0, 0, 0, 0, berlin)},
+		{"year", berlin, ts, time.Date(2024, 1, 1, 0, 0, 0, 0, berlin)},
+		// Sunday belongs to the week starting on Monday before
+		{"week", berlin, time.Date(2024, 3, 17, 23, 0, 0, 0, berlin), time.Date(2024, 3, 11, 0, 0, 0, 0, berlin)},
+		{"week", berlin, time.Date(2024, 3, 18, 0, 0, 0, 0, berlin), time.Date(2024, 3, 18, 0, 0, 0, 0, berlin)},
+		// weeks across the DST change start at local midnight
+		{"week", berlin, time.Date(2024, 4, 3, 12, 0, 0, 0, berlin), time.Date(2024, 4, 1, 0, 0, 0, 0, berlin)},
+		{"hour", kolkata, time.Date(2024, 3, 14, 11, 10, 0, 0, kolkata), time.Date(2024, 3, 14, 11, 0, 0, 0, kolkata)},
+		{"day", kolkata, time.Date(2024, 3, 14, 0, 10, 0, 0, kolkata), time.Date(2024, 3, 14, 0, 0, 0, 0, kolkata)},
+	}
+
+	for _, tc := range tests {
+		res := roundTimestampMS(unixMS(tc.ts), tc.group, tc.loc)
+		if res != unixMS(tc.expected) {
+			t.Errorf("%s %v: expected %v, got %v", tc.group, tc.ts, tc.expected, time.Unix(res/1000, 0).In(tc.loc))
+		}
+	}
+}
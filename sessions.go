@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultSessionThreshold   = 1000
+	defaultSessionMinDuration = 5 * time.Minute
+)
+
+// Session is a continuous period of power above threshold
+type Session struct {
+	Start  int64
+	End    int64
+	Energy float64 // Wh
+	Cost   float64
+}
+
+// detectSessions finds periods with power above threshold lasting at least minDuration
+func detectSessions(tuples []Tuple, threshold float64, minDuration time.Duration, price float64) []Session {
+	res := []Session{}
+	min := int64(minDuration / time.Millisecond)
+
+	var session *Session
+	for i := 1; i < len(tuples); i++ {
+		if float64(tuples[i].Value) > threshold {
+			if session == nil {
+				session = &Session{Start: tuples[i-1].Timestamp}
+			}
+			session.End = tuples[i].Timestamp
+			session.Energy += float64(tuples[i].Value) * float64(tuples[i].Timestamp-tuples[i-1].Timestamp) / msPerHour
+			continue
+		}
+
+		if session != nil && session.End-session.Start >= min {
+			res = append(res, *session)
+		}
+		session = nil
+	}
+
+	if session != nil && session.End-session.Start >= min {
+		res = append(res, *session)
+	}
+
+	for i := range res {
+		res[i].Cost = res[i].Energy / 1e3 * price
+	}
+
+	return res
+}
+
+// getSessions detects charging sessions of the target within range
+func (server *Server) getSessions(ctx context.Context, target Target, from time.Time, to time.Time) ([]Session, error) {
+	minDuration := defaultSessionMinDuration
+	if md, ok := target.Data["minduration"]; ok {
+		d, err := time.ParseDuration(md)
+		if err != nil {
+			logf(ctx, "invalid minduration: %s", md)
+		} else {
+			minDuration = d
+		}
+	}
+
+	// session detection requires raw data
+	tuples, err := server.api.getData(ctx, target.Target, from, to, "", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return detectSessions(tuples,
+		target.Data.float("threshold", defaultSessionThreshold),
+		minDuration,
+		target.Data.float("price", 0)), nil
+}
+
+// querySessions returns the energy of each session in kWh at session start
+func (server *Server) querySessions(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	sessions, err := server.getSessions(ctx, target, qr.Range.From, qr.Range.To)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	tuples := []Tuple{}
+	for _, session := range sessions {
+		tuples = append(tuples, Tuple{
+			Timestamp: session.Start,
+			Value:     float32(session.Energy / 1e3),
+		})
+	}
+
+	return dataResponse(target.Target, tuples, qr), nil
+}
+
+// sessionsTable returns start, end, energy and cost of each session
+func (server *Server) sessionsTable(ctx context.Context, target Target, qr *QueryRequest) (TableResponse, error) {
+	table := TableResponse{
+		Columns: []TableColumn{
+			TableColumn{Text: "Start", Type: "time"},
+			TableColumn{Text: "End", Type: "time"},
+			TableColumn{Text: "Energy (kWh)", Type: "number"},
+			TableColumn{Text: "Cost", Type: "number"},
+		},
+		Rows: [][]interface{}{},
+		Type: "table",
+	}
+
+	sessions, err := server.getSessions(ctx, target, qr.Range.From, qr.Range.To)
+	if err != nil {
+		return table, err
+	}
+
+	for _, session := range sessions {
+		table.Rows = append(table.Rows, []interface{}{
+			session.Start,
+			session.End,
+			session.Energy / 1e3,
+			session.Cost,
+		})
+	}
+
+	return table, nil
+}
+
+// sessionAnnotations returns sessions as region annotations
+func (server *Server) sessionAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	sessions, err := server.getSessions(ctx, target, ar.Range.From, ar.Range.To)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []AnnotationResponse{}
+	for _, session := range sessions {
+		text := fmt.Sprintf("%.2f kWh", session.Energy/1e3)
+		if session.Cost > 0 {
+			text += fmt.Sprintf(", %.2f", session.Cost)
+		}
+
+		res = append(res, AnnotationResponse{
+			Annotation: ar.Annotation,
+			Time:       session.Start,
+			TimeEnd:    session.End,
+			IsRegion:   true,
+			Title:      "Charging session",
+			Text:       text,
+		})
+	}
+
+	return res, nil
+}
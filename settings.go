@@ -0,0 +1,214 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MiddlewareConfig holds the volkszaehler api settings
+type MiddlewareConfig struct {
+	URL          string            `yaml:"url"`
+	Standby      string            `yaml:"standby"`
+	Timeout      string            `yaml:"timeout"`
+	Timeouts     TimeoutsConfig    `yaml:"timeouts"`
+	MaxBody      *int64            `yaml:"maxbody"`
+	MaxRequests  *int              `yaml:"maxRequests"`
+	Retries      *int              `yaml:"retries"`
+	RetryBackoff string            `yaml:"retryBackoff"`
+	Hedge        string            `yaml:"hedge"`
+	Compact      *bool             `yaml:"compact"`
+	Resolver     string            `yaml:"resolver"`
+	Hosts        map[string]string `yaml:"hosts"` // host name to ip
+}
+
+// TimeoutsConfig overrides the middleware timeout by endpoint
+type TimeoutsConfig struct {
+	Entities  string `yaml:"entities"`
+	Data      string `yaml:"data"`
+	Prognosis string `yaml:"prognosis"`
+}
+
+// AuthConfig holds the credentials sent with middleware requests
+type AuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+// TLSConfig holds the client tls settings of middleware requests
+type TLSConfig struct {
+	CA       string `yaml:"ca"`   // pem file with root certificates
+	Cert     string `yaml:"cert"` // pem file with client certificate
+	Key      string `yaml:"key"`  // pem file with client key
+	Insecure bool   `yaml:"insecure"`
+}
+
+// CacheConfig holds the response cache settings
+type CacheConfig struct {
+	TTL     string `yaml:"ttl"`
+	Size    *int   `yaml:"size"`
+	Dir     string `yaml:"dir"`
+	DiskTTL string `yaml:"diskTtl"`
+}
+
+// LoggingConfig holds the log settings
+type LoggingConfig struct {
+	Verbose bool `yaml:"verbose"`
+}
+
+// setting is a config file value applied to a flag
+type setting struct {
+	key   string // config key for error messages
+	flag  string
+	value string
+}
+
+// settings returns the config values of the middleware, auth, tls, cache and logging sections
+func (conf Config) settings() []setting {
+	var res []setting
+	add := func(key, flag, value string) {
+		if value != "" {
+			res = append(res, setting{key, flag, value})
+		}
+	}
+
+	m := conf.Middleware
+	add("middleware.url", "api", m.URL)
+	add("middleware.standby", "standby", m.Standby)
+	add("middleware.timeout", "timeout", m.Timeout)
+	add("middleware.timeouts.entities", "timeout-entities", m.Timeouts.Entities)
+	add("middleware.timeouts.data", "timeout-data", m.Timeouts.Data)
+	add("middleware.timeouts.prognosis", "timeout-prognosis", m.Timeouts.Prognosis)
+	add("middleware.retryBackoff", "retry-backoff", m.RetryBackoff)
+	add("middleware.hedge", "hedge", m.Hedge)
+	add("middleware.resolver", "resolver", m.Resolver)
+	if m.MaxBody != nil {
+		add("middleware.maxbody", "maxbody", fmt.Sprint(*m.MaxBody))
+	}
+	if m.MaxRequests != nil {
+		add("middleware.maxRequests", "max-requests", fmt.Sprint(*m.MaxRequests))
+	}
+	if m.Retries != nil {
+		add("middleware.retries", "retries", fmt.Sprint(*m.Retries))
+	}
+	if m.Compact != nil {
+		add("middleware.compact", "compact", fmt.Sprint(*m.Compact))
+	}
+	if len(m.Hosts) > 0 {
+		hosts := make([]string, 0, len(m.Hosts))
+		for host, ip := range m.Hosts {
+			hosts = append(hosts, host+"="+ip)
+		}
+		sort.Strings(hosts)
+		add("middleware.hosts", "hosts", strings.Join(hosts, ","))
+	}
+
+	add("auth.username", "username", conf.Auth.Username)
+	add("auth.password", "password", conf.Auth.Password)
+	add("auth.token", "token", conf.Auth.Token)
+
+	add("tls.ca", "tls-ca", conf.TLS.CA)
+	add("tls.cert", "tls-cert", conf.TLS.Cert)
+	add("tls.key", "tls-key", conf.TLS.Key)
+	if conf.TLS.Insecure {
+		add("tls.insecure", "tls-insecure", "true")
+	}
+
+	add("cache.ttl", "cache-ttl", conf.Cache.TTL)
+	if conf.Cache.Size != nil {
+		add("cache.size", "cache-size", fmt.Sprint(*conf.Cache.Size))
+	}
+	add("cache.dir", "cache-dir", conf.Cache.Dir)
+	add("cache.diskTtl", "cache-disk-ttl", conf.Cache.DiskTTL)
+
+	if conf.Logging.Verbose {
+		add("logging.verbose", "verbose", "true")
+	}
+
+	return res
+}
+
+// validateSettings checks the middleware, auth, tls, cache and alias sections
+func (conf Config) validateSettings() error {
+	for _, u := range []struct{ key, url string }{
+		{"middleware.url", conf.Middleware.URL},
+		{"middleware.standby", conf.Middleware.Standby},
+	} {
+		if u.url == "" {
+			continue
+		}
+		if parsed, err := neturl.Parse(u.url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%s: invalid url %q, expected e.g. http://host/middleware.php", u.key, u.url)
+		}
+	}
+
+	for _, d := range []struct{ key, value string }{
+		{"middleware.timeout", conf.Middleware.Timeout},
+		{"middleware.timeouts.entities", conf.Middleware.Timeouts.Entities},
+		{"middleware.timeouts.data", conf.Middleware.Timeouts.Data},
+		{"middleware.timeouts.prognosis", conf.Middleware.Timeouts.Prognosis},
+		{"middleware.retryBackoff", conf.Middleware.RetryBackoff},
+		{"middleware.hedge", conf.Middleware.Hedge},
+		{"cache.ttl", conf.Cache.TTL},
+		{"cache.diskTtl", conf.Cache.DiskTTL},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v < 0 {
+			return fmt.Errorf("%s: invalid duration %q, expected e.g. 30s", d.key, d.value)
+		}
+	}
+
+	if conf.Auth.Token != "" && conf.Auth.Username != "" {
+		return fmt.Errorf("auth: either username or token can be set")
+	}
+
+	if (conf.TLS.Cert == "") != (conf.TLS.Key == "") {
+		return fmt.Errorf("tls: cert and key must be set together")
+	}
+
+	for alias, uuid := range conf.Aliases {
+		if alias == "" || uuid == "" {
+			return fmt.Errorf("aliases: alias and uuid must not be empty")
+		}
+		if strings.HasPrefix(alias, savedPrefix) || alias == "*" {
+			return fmt.Errorf("aliases: reserved alias: %s", alias)
+		}
+	}
+
+	return nil
+}
+
+// applySettings sets the flags of fs configured in conf unless given on the command line
+func applySettings(fs *flag.FlagSet, conf Config) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for _, s := range conf.settings() {
+		if given[s.flag] || fs.Lookup(s.flag) == nil {
+			continue
+		}
+		if err := fs.Set(s.flag, s.value); err != nil {
+			return fmt.Errorf("%s: %v", s.key, err)
+		}
+	}
+
+	return nil
+}
+
+// aliasNames returns the sorted configured aliases
+func (conf Config) aliasNames() []string {
+	res := make([]string, 0, len(conf.Aliases))
+	for alias := range conf.Aliases {
+		res = append(res, alias)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSinkBuffer = 100000
+	defaultSinkQueue  = 1000000
+	sinkTimeout       = 30 * time.Second
+	sinkRetryMin      = 5 * time.Second
+	sinkRetryMax      = 5 * time.Minute
+)
+
+// SinkBatch holds new tuples of a synced channel
+type SinkBatch struct {
+	UUID   string  `json:"uuid"`
+	Title  string  `json:"title,omitempty"`
+	Group  string  `json:"group,omitempty"`
+	Tuples []Tuple `json:"tuples"`
+}
+
+// Sink is an output target of synced data. Write only buffers, Flush delivers
+// the buffered data to the target.
+type Sink interface {
+	Start(ctx context.Context) error
+	Write(batch SinkBatch) error
+	Flush(ctx context.Context) error
+	Close() error
+}
+
+// sinkWriter delivers batches to a target. Writers may implement Start and Close
+// for setting up and releasing resources.
+type sinkWriter interface {
+	send(ctx context.Context, batches []SinkBatch) error
+}
+
+// bufferedSink implements Sink for a sinkWriter with a bounded buffer and
+// retries with exponential backoff. With queue, data that could not be sent
+// is moved to disk and replayed first once the target recovers.
+type bufferedSink struct {
+	mu       sync.Mutex
+	name     string
+	writer   sinkWriter
+	queue    *diskQueue
+	max      int
+	pending  []SinkBatch
+	count    int
+	attempts int
+	retryAt  time.Time
+	metrics  *sinkCounters
+}
+
+func newBufferedSink(name string, writer sinkWriter, max int, queue *diskQueue) *bufferedSink {
+	if max <= 0 {
+		max = defaultSinkBuffer
+	}
+
+	metrics := gravoMetrics.sink(name)
+	if queue != nil {
+		metrics.queued.Set(int64(queue.count))
+	}
+
+	return &bufferedSink{
+		name:    name,
+		writer:  writer,
+		queue:   queue,
+		max:     max,
+		metrics: metrics,
+	}
+}
+
+// Start starts the writer
+func (s *bufferedSink) Start(ctx context.Context) error {
+	if w, ok := s.writer.(interface{ Start(context.Context) error }); ok {
+		return w.Start(ctx)
+	}
+	return nil
+}
+
+// Write buffers the batch. If the buffer is full the oldest tuples are dropped.
+func (s *bufferedSink) Write(batch SinkBatch) error {
+	if len(batch.Tuples) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = append(s.pending, batch)
+	s.count += len(batch.Tuples)
+
+	var dropped int
+	s.pending, dropped = trimBatches(s.pending, s.max)
+	s.count -= dropped
+
+	if dropped > 0 {
+		log.Printf("sink %s: buffer full, dropped %d tuples", s.name, dropped)
+		s.metrics.dropped.Add(int64(dropped))
+	}
+	s.metrics.buffered.Set(int64(s.count))
+
+	return nil
+}
+
+// Flush sends queued and buffered data. After a failure the data stays buffered
+// or is moved to the queue, and sending is retried on the next flush after the
+// backoff elapsed. An error is returned if data is neither sent nor queued.
+func (s *bufferedSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 && (s.queue == nil || s.queue.count == 0) {
+		return nil
+	}
+
+	if time.Now().Before(s.retryAt) {
+		return s.spill(fmt.Errorf("sink %s: waiting for retry at %s", s.name, s.retryAt.Format(time.RFC3339)))
+	}
+
+	batches := s.pending
+	if s.queue != nil && s.queue.count > 0 {
+		queued, err := s.queue.load()
+		if err != nil {
+			return fmt.Errorf("sink %s: %v", s.name, err)
+		}
+		batches = append(queued, s.pending...)
+	}
+
+	if err := s.writer.send(ctx, batches); err != nil {
+		backoff := sinkRetryMin << s.attempts
+		if backoff > sinkRetryMax || backoff <= 0 {
+			backoff = sinkRetryMax
+		}
+		s.attempts++
+		s.retryAt = time.Now().Add(backoff)
+
+		s.metrics.failed.Add(1)
+		return s.spill(fmt.Errorf("sink %s: %w", s.name, err))
+	}
+
+	written := s.count
+	if s.queue != nil {
+		written += s.queue.count
+		if err := s.queue.clear(); err != nil {
+			log.Printf("sink %s: clearing queue failed: %v", s.name, err)
+		}
+		s.metrics.queued.Set(0)
+	}
+
+	s.metrics.written.Add(int64(written))
+	s.metrics.buffered.Set(0)
+
+	s.pending = nil
+	s.count = 0
+	s.attempts = 0
+	s.retryAt = time.Time{}
+
+	return nil
+}
+
+// spill moves buffered data to the queue after sending failed with err.
+// Err is returned if there is no queue or writing the queue failed.
+func (s *bufferedSink) spill(err error) error {
+	if s.queue == nil {
+		return err
+	}
+
+	if len(s.pending) > 0 {
+		dropped, qerr := s.queue.append(s.pending)
+		if qerr != nil {
+			return fmt.Errorf("%v, queueing failed: %v", err, qerr)
+		}
+
+		if dropped > 0 {
+			log.Printf("sink %s: queue full, dropped %d tuples", s.name, dropped)
+			s.metrics.dropped.Add(int64(dropped))
+		}
+
+		s.pending = nil
+		s.count = 0
+		s.metrics.buffered.Set(0)
+	}
+
+	log.Printf("%v, %d tuples queued", err, s.queue.count)
+	s.metrics.queued.Set(int64(s.queue.count))
+
+	return nil
+}
+
+// Close flushes remaining data ignoring the backoff and closes the writer
+func (s *bufferedSink) Close() error {
+	s.mu.Lock()
+	s.retryAt = time.Time{}
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+
+	err := s.Flush(ctx)
+
+	if w, ok := s.writer.(interface{ Close() error }); ok {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// newSink creates a buffered sink from config
+func newSink(c SinkConfig) (*bufferedSink, error) {
+	var writer sinkWriter
+
+	switch strings.ToLower(c.Type) {
+	case "influx":
+		if c.URL == "" || c.Database == "" && c.Bucket == "" {
+			return nil, fmt.Errorf("missing influx url and database or bucket")
+		}
+		if c.Measurement == "" {
+			c.Measurement = defaultSyncMeasurement
+		}
+		writer = c.InfluxConfig
+
+	case "file":
+		if c.Path == "" {
+			return nil, fmt.Errorf("missing path")
+		}
+		format := strings.ToLower(c.Format)
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "line" {
+			return nil, fmt.Errorf("invalid format: %s", c.Format)
+		}
+		if c.Measurement == "" {
+			c.Measurement = defaultSyncMeasurement
+		}
+		writer = &fileSink{path: c.Path, format: format, measurement: c.Measurement}
+
+	case "mqtt":
+		if c.URL == "" {
+			return nil, fmt.Errorf("missing broker url")
+		}
+		if c.Topic == "" {
+			c.Topic = defaultSyncMeasurement
+		}
+		writer = &mqttSink{broker: c.URL, topic: c.Topic, username: c.Username, password: c.Password, clientID: c.ClientID}
+
+	case "kafka":
+		if c.URL == "" || c.Topic == "" {
+			return nil, fmt.Errorf("missing rest proxy url or topic")
+		}
+		writer = &kafkaSink{url: c.URL, topic: c.Topic}
+
+	case "prometheus":
+		if c.URL == "" {
+			return nil, fmt.Errorf("missing pushgateway url")
+		}
+		if c.Job == "" {
+			c.Job = "gravo"
+		}
+		if c.Measurement == "" {
+			c.Measurement = defaultSyncMeasurement
+		}
+		writer = &prometheusSink{url: c.URL, job: c.Job, measurement: c.Measurement, latest: make(map[string]promSample)}
+
+	default:
+		return nil, fmt.Errorf("invalid type: %s", c.Type)
+	}
+
+	var queue *diskQueue
+	if c.Queue != "" {
+		size := c.QueueSize
+		if size <= 0 {
+			size = defaultSinkQueue
+		}
+
+		var err error
+		if queue, err = openQueue(c.Queue, size); err != nil {
+			return nil, fmt.Errorf("queue: %v", err)
+		}
+	}
+
+	return newBufferedSink(c.Name, writer, c.Buffer, queue), nil
+}
+
+// newSinks creates the configured sinks. The influx config is used as sink for compatibility.
+func newSinks(sc SyncConfig) ([]*bufferedSink, error) {
+	configs := sc.Sinks
+	if sc.Influx.URL != "" {
+		configs = append([]SinkConfig{{Type: "influx", InfluxConfig: sc.Influx}}, configs...)
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("sync: no sinks")
+	}
+
+	names := make(map[string]bool)
+	sinks := make([]*bufferedSink, 0, len(configs))
+	for i, c := range configs {
+		if c.Name == "" {
+			c.Name = strings.ToLower(c.Type)
+		}
+		if names[c.Name] {
+			c.Name = fmt.Sprintf("%s%d", c.Name, i)
+		}
+		names[c.Name] = true
+
+		sink, err := newSink(c)
+		if err != nil {
+			return nil, fmt.Errorf("sync sink %s: %v", c.Name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return sinks, nil
+}
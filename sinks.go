@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// sinkRecord is the json representation of a synced tuple
+type sinkRecord struct {
+	UUID      string  `json:"uuid"`
+	Title     string  `json:"title,omitempty"`
+	Group     string  `json:"group,omitempty"`
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// records converts batches to records. NaN values are skipped.
+func records(batches []SinkBatch) []sinkRecord {
+	res := []sinkRecord{}
+	for _, batch := range batches {
+		for _, tuple := range batch.Tuples {
+			if math.IsNaN(float64(tuple.Value)) {
+				continue
+			}
+			res = append(res, sinkRecord{batch.UUID, batch.Title, batch.Group, tuple.Timestamp, float64(tuple.Value)})
+		}
+	}
+	return res
+}
+
+// httpSend sends body and fails for non-2xx responses
+func httpSend(ctx context.Context, method, url, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+
+	return nil
+}
+
+// fileSink appends json lines or influx line protocol to a file
+type fileSink struct {
+	path        string
+	format      string
+	measurement string
+	f           *os.File
+}
+
+func (s *fileSink) Start(ctx context.Context) error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	s.f = f
+	return nil
+}
+
+func (s *fileSink) send(ctx context.Context, batches []SinkBatch) error {
+	w := bufio.NewWriter(s.f)
+
+	if s.format == "line" {
+		for _, batch := range batches {
+			w.Write(lineProtocol(s.measurement, batch))
+		}
+	} else {
+		enc := json.NewEncoder(w)
+		for _, rec := range records(batches) {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return s.f.Sync()
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// kafkaSink produces records to a topic using the Kafka REST proxy
+// keyed by channel uuid
+type kafkaSink struct {
+	url   string
+	topic string
+}
+
+func (s *kafkaSink) send(ctx context.Context, batches []SinkBatch) error {
+	type kafkaRecord struct {
+		Key   string     `json:"key"`
+		Value sinkRecord `json:"value"`
+	}
+
+	recs := records(batches)
+	if len(recs) == 0 {
+		return nil
+	}
+
+	req := struct {
+		Records []kafkaRecord `json:"records"`
+	}{}
+	for _, rec := range recs {
+		req.Records = append(req.Records, kafkaRecord{rec.UUID, rec})
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(s.url, "/") + "/topics/" + s.topic
+	return httpSend(ctx, http.MethodPost, url, "application/vnd.kafka.json.v2+json", body, nil)
+}
+
+// promSample is the latest value of a channel
+type promSample struct {
+	batch     SinkBatch
+	timestamp int64
+	value     float64
+}
+
+// prometheusSink pushes the latest value of each channel to a Prometheus pushgateway
+type prometheusSink struct {
+	url         string
+	job         string
+	measurement string
+	latest      map[string]promSample
+}
+
+// promLabel escapes prometheus label values
+func promLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func (s *prometheusSink) send(ctx context.Context, batches []SinkBatch) error {
+	for _, rec := range records(batches) {
+		key := rec.UUID + "/" + rec.Group
+		if rec.Timestamp >= s.latest[key].timestamp {
+			s.latest[key] = promSample{SinkBatch{UUID: rec.UUID, Title: rec.Title, Group: rec.Group}, rec.Timestamp, rec.Value}
+		}
+	}
+
+	keys := make([]string, 0, len(s.latest))
+	for key := range s.latest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// all channels are pushed as the pushgateway replaces metrics of the same name
+	var buf bytes.Buffer
+	for _, metric := range []string{"value", "timestamp_seconds"} {
+		fmt.Fprintf(&buf, "# TYPE %s_%s gauge\n", s.measurement, metric)
+		for _, key := range keys {
+			sample := s.latest[key]
+			v := sample.value
+			if metric == "timestamp_seconds" {
+				v = float64(sample.timestamp) / 1e3
+			}
+			fmt.Fprintf(&buf, "%s_%s{uuid=\"%s\",title=\"%s\",group=\"%s\"} %v\n", s.measurement, metric,
+				promLabel(sample.batch.UUID), promLabel(sample.batch.Title), promLabel(sample.batch.Group), v)
+		}
+	}
+
+	url := strings.TrimRight(s.url, "/") + "/metrics/job/" + s.job
+	return httpSend(ctx, http.MethodPut, url, "text/plain; version=0.0.4", buf.Bytes(), nil)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clockSkew tracks the offset of a middleware's clock against the local clock,
+// measured from the Date header of its responses
+type clockSkew struct {
+	mu        sync.Mutex
+	offset    time.Duration // middleware time minus local time
+	measured  bool
+	warned    time.Duration // offset of the last warning
+	threshold time.Duration // offsets below are considered noise
+	correct   bool          // shift query windows by the offset
+}
+
+// observe updates the offset from the Date header of a response received after
+// the request was sent at start
+func (s *clockSkew) observe(url string, header http.Header, start time.Time, received time.Time) {
+	if s == nil {
+		return
+	}
+
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return
+	}
+
+	// Date has second resolution, compare against the middle of the round trip
+	offset := date.Sub(start.Add(received.Sub(start) / 2)).Round(time.Second)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.measured {
+		offset = (s.offset + offset) / 2
+	}
+	s.offset, s.measured = offset, true
+
+	gravoMetrics.observeSkew(url, offset)
+
+	if abs(offset-s.warned) >= s.threshold {
+		if abs(offset) >= s.threshold {
+			log.Printf("clock skew of %s detected: %v", url, offset)
+		} else {
+			log.Printf("clock skew of %s resolved: %v", url, offset)
+		}
+		s.warned = offset
+	}
+}
+
+// correction returns the offset query windows are shifted by, zero if
+// correction is disabled or the skew is insignificant
+func (s *clockSkew) correction() time.Duration {
+	if s == nil || !s.correct {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if abs(s.offset) < s.threshold {
+		return 0
+	}
+	return s.offset
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
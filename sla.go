@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSLAInterval = 5 * time.Minute
+	defaultSLAWindow   = 24 * time.Hour
+)
+
+// channelSLA is the data freshness and completeness of a channel
+type channelSLA struct {
+	uuid         string
+	title        string
+	freshness    time.Duration
+	completeness float64
+}
+
+// computeSLA returns the age of the newest tuple and the fraction of expected intervals
+// in the window before now containing at least one tuple. Without expected interval
+// the median interval is used. Without data freshness is the window.
+func computeSLA(tuples []Tuple, now time.Time, window, expected time.Duration) (time.Duration, float64) {
+	if len(tuples) == 0 {
+		return window, 0
+	}
+
+	freshness := now.Sub(time.Unix(0, tuples[len(tuples)-1].Timestamp*int64(time.Millisecond)))
+	if freshness < 0 {
+		freshness = 0
+	}
+
+	step := sampleInterval(tuples, expected)
+	if step <= 0 {
+		return freshness, 0
+	}
+
+	from := unixMS(now.Add(-window))
+	slots := make(map[int64]bool)
+	for _, tuple := range tuples {
+		if tuple.Timestamp > from {
+			slots[(tuple.Timestamp-from-1)/step] = true
+		}
+	}
+
+	completeness := float64(len(slots)) / float64((int64(window/time.Millisecond)+step-1)/step)
+	if completeness > 1 {
+		completeness = 1
+	}
+
+	return freshness, completeness
+}
+
+// slaMonitor periodically evaluates the configured channels for the metrics endpoint
+type slaMonitor struct {
+	api      *Api
+	interval time.Duration
+	window   time.Duration
+	expected map[string]time.Duration
+	uuids    []string
+
+	mu      sync.Mutex
+	results map[string]channelSLA
+}
+
+// newSLAMonitor validates the config. Channels default to their configured sampling interval.
+func newSLAMonitor(api *Api, conf SLAConfig, intervals map[string]time.Duration) (*slaMonitor, error) {
+	m := &slaMonitor{
+		api:      api,
+		interval: defaultSLAInterval,
+		window:   defaultSLAWindow,
+		expected: make(map[string]time.Duration),
+		results:  make(map[string]channelSLA),
+	}
+
+	if conf.Interval != "" {
+		d, err := time.ParseDuration(conf.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("sla: invalid interval: %s", conf.Interval)
+		}
+		m.interval = d
+	}
+
+	if conf.Window != "" {
+		d, err := time.ParseDuration(conf.Window)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("sla: invalid window: %s", conf.Window)
+		}
+		m.window = d
+	}
+
+	for _, c := range conf.Channels {
+		if c.UUID == "" {
+			return nil, fmt.Errorf("sla: missing uuid")
+		}
+
+		m.expected[c.UUID] = intervals[c.UUID]
+		if c.Expected != "" {
+			d, err := time.ParseDuration(c.Expected)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("sla %s: invalid expected: %s", c.UUID, c.Expected)
+			}
+			m.expected[c.UUID] = d
+		}
+
+		m.uuids = append(m.uuids, c.UUID)
+	}
+
+	return m, nil
+}
+
+// check evaluates a single channel
+func (m *slaMonitor) check(ctx context.Context, uuid string, now time.Time) error {
+	tuples, err := m.api.getData(ctx, uuid, now.Add(-m.window), now, "", "", 0)
+	if err != nil {
+		return err
+	}
+
+	res := channelSLA{uuid: uuid, title: uuid}
+	if entity, err := m.api.getEntity(ctx, uuid); err == nil && entity.Title != "" {
+		res.title = entity.Title
+	}
+	res.freshness, res.completeness = computeSLA(tuples, now, m.window, m.expected[uuid])
+
+	m.mu.Lock()
+	m.results[uuid] = res
+	m.mu.Unlock()
+
+	return nil
+}
+
+// run evaluates all channels on every interval
+func (m *slaMonitor) run() {
+	for {
+		for _, uuid := range m.uuids {
+			ctx, cancel := context.WithTimeout(context.Background(), m.interval)
+			if err := m.check(ctx, uuid, time.Now()); err != nil {
+				log.Printf("sla %s: %v", uuid, err)
+			}
+			cancel()
+		}
+
+		time.Sleep(m.interval)
+	}
+}
+
+// writeMetrics writes the results in Prometheus text format
+func (m *slaMonitor) writeMetrics(w http.ResponseWriter) {
+	m.mu.Lock()
+	results := make([]channelSLA, 0, len(m.results))
+	for _, res := range m.results {
+		results = append(results, res)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].uuid < results[j].uuid })
+
+	fmt.Fprintln(w, "# HELP gravo_channel_freshness_seconds Age of the newest tuple.")
+	fmt.Fprintln(w, "# TYPE gravo_channel_freshness_seconds gauge")
+	for _, res := range results {
+		fmt.Fprintf(w, "gravo_channel_freshness_seconds{uuid=\"%s\",title=\"%s\"} %v\n", promLabel(res.uuid), promLabel(res.title), res.freshness.Seconds())
+	}
+
+	fmt.Fprintf(w, "# HELP gravo_channel_completeness_ratio Fraction of expected intervals with data over the last %v.\n", m.window)
+	fmt.Fprintln(w, "# TYPE gravo_channel_completeness_ratio gauge")
+	for _, res := range results {
+		fmt.Fprintf(w, "gravo_channel_completeness_ratio{uuid=\"%s\",title=\"%s\"} %v\n", promLabel(res.uuid), promLabel(res.title), res.completeness)
+	}
+}
+
+// metricsHandler serves Prometheus metrics
+func (server *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	gravoMetrics.write(w)
+	if server.sla != nil {
+		server.sla.writeMetrics(w)
+	}
+}
+
+// querySLA returns the freshness in seconds or completeness in percent of the
+// target at the end of the range over the window before
+func (server *Server) querySLA(ctx context.Context, kind string, target Target, qr *QueryRequest) (QueryResponse, error) {
+	window := defaultSLAWindow
+	expected := server.conf.expectedInterval(target.Target)
+	if server.sla != nil {
+		window = server.sla.window
+		if d := server.sla.expected[target.Target]; d > 0 {
+			expected = d
+		}
+	}
+
+	if s, ok := target.Data["window"]; ok {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			window = d
+		} else {
+			logf(ctx, "%s: invalid window: %s", kind, s)
+		}
+	}
+
+	if s, ok := target.Data["expected"]; ok {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			expected = d
+		} else {
+			logf(ctx, "%s: invalid expected: %s", kind, s)
+		}
+	}
+
+	now := qr.Range.To
+	if now.IsZero() || now.After(time.Now()) {
+		now = time.Now()
+	}
+
+	tuples, err := server.api.getData(ctx, target.Target, now.Add(-window), now, "", "", 0)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	freshness, completeness := computeSLA(tuples, now, window, expected)
+
+	value := freshness.Seconds()
+	if strings.ToLower(kind) == "completeness" {
+		value = 100 * completeness
+	}
+
+	return dataResponse(target.Target, []Tuple{Tuple{Timestamp: unixMS(now), Value: float32(value)}}, qr), nil
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotURL is the api url used when serving a snapshot
+const snapshotURL = "http://snapshot"
+
+// snapshotEntry is the name of the snapshot inside zip files
+const snapshotEntry = "snapshot.json"
+
+// Snapshot is a self-contained bundle of channel data and metadata
+type Snapshot struct {
+	Created  time.Time         `json:"created"`
+	From     int64             `json:"from"`
+	To       int64             `json:"to"`
+	Group    string            `json:"group,omitempty"`
+	Channels []SnapshotChannel `json:"channels"`
+}
+
+// SnapshotChannel is a single channel's entity and data
+type SnapshotChannel struct {
+	Entity Entity  `json:"entity"`
+	Tuples []Tuple `json:"tuples"`
+}
+
+// channel returns the snapshot channel of uuid
+func (s *Snapshot) channel(uuid string) (SnapshotChannel, bool) {
+	for _, c := range s.Channels {
+		if c.Entity.UUID == uuid {
+			return c, true
+		}
+	}
+	return SnapshotChannel{}, false
+}
+
+// encodeSnapshot encodes s as json or, if name ends with .zip, as zip archive
+func encodeSnapshot(name string, s Snapshot) ([]byte, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(strings.ToLower(name), ".zip") {
+		return b, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(snapshotEntry)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeSnapshot writes s as json or, if file ends with .zip, as zip archive
+func writeSnapshot(file string, s Snapshot) error {
+	b, err := encodeSnapshot(file, s)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// loadSnapshot reads a snapshot written by writeSnapshot
+func loadSnapshot(file string) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// zip magic
+	if bytes.HasPrefix(b, []byte("PK")) {
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := zr.Open(snapshotEntry)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		if b, err = ioutil.ReadAll(f); err != nil {
+			return nil, err
+		}
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// snapshotTransport answers middleware requests from a snapshot
+type snapshotTransport struct {
+	snapshot *Snapshot
+	location func(uuid string) *time.Location
+}
+
+func (t *snapshotTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.respond(req, http.StatusForbidden, map[string]interface{}{
+			"exception": map[string]string{"message": "snapshot is read-only"},
+		})
+	}
+
+	path := strings.TrimSuffix(req.URL.Path, ".json")
+
+	switch {
+	case path == "/entity":
+		entities := make([]Entity, 0, len(t.snapshot.Channels))
+		for _, c := range t.snapshot.Channels {
+			entities = append(entities, c.Entity)
+		}
+		return t.respond(req, http.StatusOK, EntityResponse{Version: "snapshot", Entities: entities})
+
+	case strings.HasPrefix(path, "/entity/"):
+		if c, ok := t.snapshot.channel(strings.TrimPrefix(path, "/entity/")); ok {
+			return t.respond(req, http.StatusOK, EntityResponse{Version: "snapshot", Entity: c.Entity})
+		}
+
+	case strings.HasPrefix(path, "/data/"):
+		if c, ok := t.snapshot.channel(strings.TrimPrefix(path, "/data/")); ok {
+			return t.respond(req, http.StatusOK, DataResponse{Version: "snapshot", Data: t.data(c, req)})
+		}
+	}
+
+	return t.respond(req, http.StatusNotFound, map[string]interface{}{
+		"exception": map[string]string{"message": "not in snapshot: " + req.URL.Path},
+	})
+}
+
+// data filters and aggregates the channel's tuples like the middleware would
+func (t *snapshotTransport) data(c SnapshotChannel, req *http.Request) DataStruct {
+	q := req.URL.Query()
+	from, _ := strconv.ParseInt(q.Get("from"), 10, 64)
+	to, _ := strconv.ParseInt(q.Get("to"), 10, 64)
+
+	tuples := []Tuple{}
+	for _, tuple := range c.Tuples {
+		if tuple.Timestamp >= from && (to == 0 || tuple.Timestamp <= to) {
+			tuples = append(tuples, tuple)
+		}
+	}
+
+	energy := cumulativeEnergy(tuples)
+	res := DataStruct{Tuples: tuples}
+	if len(energy) > 0 {
+		res.Consumption = energy[len(energy)-1]
+	}
+
+	if group := q.Get("group"); group != "" {
+		res.Tuples = averageByGroup(tuples, group, t.location(c.Entity.UUID))
+	}
+
+	if n, err := strconv.Atoi(q.Get("tuples")); err == nil && n > 0 && len(res.Tuples) > n {
+		res.Tuples = lttb(res.Tuples, n)
+	}
+
+	return res
+}
+
+func (t *snapshotTransport) respond(req *http.Request, status int, v interface{}) (*http.Response, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+// averageByGroup averages tuples per group period in loc
+func averageByGroup(tuples []Tuple, group string, loc *time.Location) []Tuple {
+	res := []Tuple{}
+	var count int
+
+	for _, tuple := range tuples {
+		ts := roundTimestampMS(tuple.Timestamp, group, loc)
+		if len(res) == 0 || res[len(res)-1].Timestamp != ts {
+			res = append(res, Tuple{Timestamp: ts})
+			count = 0
+		}
+
+		last := &res[len(res)-1]
+		count++
+		last.Value += (tuple.Value - last.Value) / float32(count)
+	}
+
+	return res
+}
+
+// snapshotAPI creates an api serving the snapshot file read-only, grouping in the channel's timezone
+func snapshotAPI(file string, location func(uuid string) *time.Location, verbose bool) (*Api, error) {
+	s, err := loadSnapshot(file)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("serving snapshot %s with %d channels created %s", file, len(s.Channels), s.Created.Format(time.RFC3339))
+
+	timeout := time.Minute
+	return newAPI(snapshotURL, &timeout, &snapshotTransport{s, location}, 0, verbose), nil
+}
+
+// snapshotCommand captures channels over a range into a snapshot file
+func snapshotCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuids := fs.String("uuid", "", "comma-separated channel uuids")
+	from := fs.String("from", "-24h", "range start (epoch ms, ISO 8601, now or relative duration)")
+	to := fs.String("to", "now", "range end (epoch ms, ISO 8601, now or relative duration)")
+	group := fs.String("group", "", "middleware aggregation level (default raw data)")
+	out := fs.String("out", "snapshot.json", "output file, .zip for zip archive, s3://<key> for upload")
+	configFile := fs.String("config", "", "yaml configuration file providing api and s3 settings")
+	fs.Parse(args)
+
+	if *uuids == "" {
+		return configError("missing uuid")
+	}
+
+	var conf Config
+	var err error
+	if *configFile != "" {
+		if conf, err = loadConfig(*configFile); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(fs, conf); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+	}
+
+	key := strings.TrimPrefix(*out, "s3://")
+	upload := key != *out
+	if upload && !conf.S3.configured() {
+		return configError("s3 not configured")
+	}
+
+	f, err := parseTime(*from, conf.defaultLocation())
+	if err != nil {
+		return configError("invalid from: %v", err)
+	}
+	t, err := parseTime(*to, conf.defaultLocation())
+	if err != nil {
+		return configError("invalid to: %v", err)
+	}
+
+	ctx := context.Background()
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+
+	s := Snapshot{
+		Created: time.Now(),
+		From:    unixMS(f),
+		To:      unixMS(t),
+		Group:   *group,
+	}
+
+	for _, uuid := range strings.Split(*uuids, ",") {
+		uuid = strings.TrimSpace(uuid)
+
+		entity, err := api.getEntity(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		if entity.UUID == "" {
+			entity.UUID = uuid
+		}
+
+		tuples, err := api.getData(ctx, uuid, f, t, *group, "", 0)
+		if err != nil {
+			return err
+		}
+
+		s.Channels = append(s.Channels, SnapshotChannel{Entity: entity, Tuples: tuples})
+	}
+
+	if upload {
+		b, err := encodeSnapshot(key, s)
+		if err != nil {
+			return err
+		}
+
+		contentType := "application/json"
+		if strings.HasSuffix(strings.ToLower(key), ".zip") {
+			contentType = "application/zip"
+		}
+
+		if err := conf.S3.putObject(key, b, contentType); err != nil {
+			return err
+		}
+	} else if err := writeSnapshot(*out, s); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "%d channels written to %s\n", len(s.Channels), *out)
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAverageByGroup(t *testing.T) {
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// 18:00 and 19:00 UTC fall on different days in Kolkata (23:30 and 00:30)
+	ts := func(h int) int64 { return time.Date(2024, 1, 1, h, 0, 0, 0, time.UTC).UnixNano() / 1e6 }
+	tuples := []Tuple{{ts(17), 1}, {ts(18), 3}, {ts(19), 8}}
+
+	tests := []struct {
+		loc      *time.Location
+		expected []Tuple
+	}{
+		{time.UTC, []Tuple{{ts(0), 4}}},
+		{kolkata, []Tuple{
+			{time.Date(2024, 1, 1, 0, 0, 0, 0, kolkata).UnixNano() / 1e6, 2},
+			{time.Date(2024, 1, 2, 0, 0, 0, 0, kolkata).UnixNano() / 1e6, 8},
+		}},
+	}
+
+	for _, tc := range tests {
+		if res := averageByGroup(tuples, "day", tc.loc); !equalTuples(res, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.loc, tc.expected, res)
+		}
+	}
+}
+
+func TestSnapshotUpload(t *testing.T) {
+	vz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/entity/u1.json":
+			_, _ = w.Write([]byte(`{"version":"0.3","entity":{"uuid":"u1","type":"power"}}`))
+		case "/data/u1.json":
+			_, _ = w.Write([]byte(`{"version":"0.3","data":{"tuples":[[1000,1,1],[2000,2,1]]}}`))
+		default:
+			_, _ = w.Write([]byte(`{"version":"0.3"}`))
+		}
+	}))
+	defer vz.Close()
+
+	var path, contentType string
+	var body []byte
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer s3.Close()
+
+	config := filepath.Join(t.TempDir(), "gravo.yaml")
+	if err := ioutil.WriteFile(config, []byte("s3:\n  endpoint: "+s3.URL+"\n  bucket: archive\n  prefix: gravo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-api", vz.URL, "-uuid", "u1", "-from", "0", "-to", "3000", "-config", config, "-out", "s3://jan.zip"}
+	if err := snapshotCommand(flag.NewFlagSet("snapshot", flag.ContinueOnError), args); err != nil {
+		t.Fatal(err)
+	}
+
+	if path != "/archive/gravo/jan.zip" || contentType != "application/zip" {
+		t.Errorf("unexpected upload %s %s", path, contentType)
+	}
+
+	file := filepath.Join(t.TempDir(), "jan.zip")
+	if err := ioutil.WriteFile(file, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := loadSnapshot(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Channels) != 1 || len(s.Channels[0].Tuples) != 2 {
+		t.Errorf("unexpected snapshot %+v", s)
+	}
+
+	// uploads require s3 settings
+	args = []string{"-api", vz.URL, "-uuid", "u1", "-out", "s3://jan.zip"}
+	if err := snapshotCommand(flag.NewFlagSet("snapshot", flag.ContinueOnError), args); err == nil {
+		t.Error("expected error without s3 config")
+	}
+
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// validSplits are the `split` modes
+var validSplits = map[string]bool{"sign": true}
+
+// maxSplitTuples bounds the raw tuples read to split a series
+const maxSplitTuples = 1000000
+
+// invalidSplit is the error of unknown split modes or names
+func invalidSplit(format string, a ...interface{}) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: fmt.Sprintf(format, a...),
+		Hint:    `e.g. {"split": "sign", "splitnames": "import,export", "group": "hour"}`,
+	}
+}
+
+// signPart sums the positive and negative parts of a period's values weighted by
+// the time they apply to. Counts and plain sums are used if no time is known.
+type signPart struct {
+	ts                 int64
+	pos, neg, ms       float64 // value * ms, ms
+	posSum, negSum, nv float64
+}
+
+// value returns the average of the part, positive or negative
+func (p signPart) value(positive bool) float64 {
+	v, sum := p.neg, p.negSum
+	if positive {
+		v, sum = p.pos, p.posSum
+	}
+	if p.ms > 0 {
+		return v / p.ms
+	}
+	return sum / p.nv
+}
+
+// energy returns the integral of the part by the hour, e.g. Wh of W
+func (p signPart) energy(positive bool) float64 {
+	if positive {
+		return p.pos / float64(time.Hour.Milliseconds())
+	}
+	return p.neg / float64(time.Hour.Milliseconds())
+}
+
+// splitSign sums the positive and negative parts of the tuples per period. Like
+// middleware power values each value applies to the interval since the previous
+// tuple, the first value after a gap to the interval until the next.
+func splitSign(tuples []Tuple, period func(int64) int64) []signPart {
+	res := []signPart{}
+
+	valid := func(i int) bool {
+		return i >= 0 && i < len(tuples) && !math.IsNaN(float64(tuples[i].Value))
+	}
+
+	for i, t := range tuples {
+		if !valid(i) {
+			continue
+		}
+
+		var ms float64
+		if valid(i - 1) {
+			ms = float64(t.Timestamp - tuples[i-1].Timestamp)
+		} else if valid(i + 1) {
+			ms = float64(tuples[i+1].Timestamp - t.Timestamp)
+		}
+
+		ts := period(t.Timestamp)
+		if n := len(res); n == 0 || res[n-1].ts != ts {
+			res = append(res, signPart{ts: ts})
+		}
+		p := &res[len(res)-1]
+
+		v := float64(t.Value)
+		if v >= 0 {
+			p.pos += v * ms
+			p.posSum += v
+		} else {
+			p.neg += v * ms
+			p.negSum += v
+		}
+		p.ms += ms
+		p.nv++
+	}
+
+	return res
+}
+
+// splitNames returns the names of the positive and negative series given by
+// `splitnames`, default the target's name followed by positive and negative
+func splitNames(data TargetData, name string) ([2]string, error) {
+	s, ok := data["splitnames"]
+	if !ok {
+		return [2]string{name + " positive", name + " negative"}, nil
+	}
+
+	names := strings.Split(s, ",")
+	if len(names) != 2 || strings.TrimSpace(names[0]) == "" || strings.TrimSpace(names[1]) == "" {
+		return [2]string{}, invalidSplit("invalid splitnames: %s", s)
+	}
+	return [2]string{strings.TrimSpace(names[0]), strings.TrimSpace(names[1])}, nil
+}
+
+// querySplit returns the positive and negative parts of a signed series, e.g. grid
+// import and export. The parts are split from raw tuples before they are averaged
+// per `group` or interval, so that opposite flows within a period don't cancel out.
+// With `stat` `total` the energy of each part is returned.
+func (server *Server) querySplit(ctx context.Context, target Target, qr *QueryRequest) ([]QueryResponse, error) {
+	if mode := strings.ToLower(target.Data["split"]); !validSplits[mode] {
+		return nil, invalidSplit("invalid split: %s", mode)
+	}
+	if _, ok := target.Data["aggregate"]; ok {
+		return nil, invalidSplit("split cannot be combined with aggregate")
+	}
+
+	name := target.Target
+	if entity, ok := server.cachedEntity(target.Target); ok {
+		name = entity.Title
+	}
+	if n, ok := target.Data["name"]; ok {
+		name = n
+	}
+	names, err := splitNames(target.Data, name)
+	if err != nil {
+		return nil, err
+	}
+
+	period, err := server.aggregationPeriod(target, qr)
+	if err != nil {
+		return nil, invalidSplit("%v", err)
+	}
+	_, total := server.totalUnit("", target)
+	if total {
+		now := unixMS(time.Now())
+		period = func(int64) int64 { return now }
+	}
+
+	// raw tuples, groups are applied to the parts
+	raw := *qr
+	raw.MaxDataPoints = 0
+	if d := server.conf.expectedInterval(target.Target); d > 0 && qr.Range.To.Sub(qr.Range.From)/d > maxSplitTuples {
+		return nil, invalidSplit("range too long to split at %v sampling interval, split a shorter range", d)
+	}
+
+	// too large responses are fetched in chunks
+	tuples, err := server.getTuples(ctx, target.Target, target.Data.with("group", ""), &raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tuples) > maxSplitTuples {
+		return nil, invalidSplit("range too long to split with %d raw tuples, split a shorter range", len(tuples))
+	}
+	if _, ok := target.Data["transform"]; ok {
+		if tuples, err = transformTuples(target.Data, tuples); err != nil {
+			return nil, err
+		}
+	}
+
+	parts := splitSign(tuples, period)
+
+	res := make([]QueryResponse, 0, 2)
+	for i, positive := range []bool{true, false} {
+		part := make([]Tuple, 0, len(parts))
+		for _, p := range parts {
+			v := p.value(positive)
+			if total {
+				v = p.energy(positive)
+			}
+			part = append(part, Tuple{Timestamp: p.ts, Value: float32(v)})
+		}
+
+		// the total of a single datapoint is its energy
+		t := target
+		t.Data = target.Data.with("name", names[i])
+
+		qres, err := server.finishSeries("", t, qr, dataResponse(target.Target, part, qr))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, qres)
+	}
+
+	return res, nil
+}
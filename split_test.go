@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSplitSign(t *testing.T) {
+	null := float32(math.NaN())
+	all := func(int64) int64 { return 0 }
+
+	type part struct {
+		ts                  int64
+		pos, neg, posEnergy float64
+	}
+
+	tests := []struct {
+		name     string
+		tuples   []Tuple
+		period   func(int64) int64
+		expected []part
+	}{
+		{
+			name:   "time weighted",
+			tuples: []Tuple{{0, 100}, {1000, -50}, {2000, 200}},
+			period: all,
+			// 100 and 200 for 1s each, -50 for 1s of 3s
+			expected: []part{{0, 100, -50.0 / 3, 300000.0 / 3600000}},
+		},
+		{
+			name:     "periods",
+			tuples:   []Tuple{{0, 10}, {1000, 10}, {2000, -10}, {3000, -10}},
+			period:   func(ts int64) int64 { return ts - ts%2000 },
+			expected: []part{{0, 10, 0, 20000.0 / 3600000}, {2000, 0, -10, 0}},
+		},
+		{
+			// the value before the null has no interval
+			name:     "null",
+			tuples:   []Tuple{{0, 100}, {1000, null}, {5000, -100}, {6000, -100}},
+			period:   all,
+			expected: []part{{0, 0, -100, 0}},
+		},
+		{
+			// without time the plain values are averaged
+			name:     "single tuple",
+			tuples:   []Tuple{{0, 5}},
+			period:   all,
+			expected: []part{{0, 5, 0, 0}},
+		},
+		{
+			name:     "empty",
+			tuples:   []Tuple{},
+			period:   all,
+			expected: []part{},
+		},
+	}
+
+	for _, tc := range tests {
+		parts := splitSign(tc.tuples, tc.period)
+		if len(parts) != len(tc.expected) {
+			t.Errorf("%s: expected %d parts, got %d", tc.name, len(tc.expected), len(parts))
+			continue
+		}
+
+		for i, p := range parts {
+			e := tc.expected[i]
+			if p.ts != e.ts {
+				t.Errorf("%s: part %d: expected ts %d, got %d", tc.name, i, e.ts, p.ts)
+			}
+			for _, v := range []struct {
+				name        string
+				value, want float64
+			}{
+				{"positive", p.value(true), e.pos},
+				{"negative", p.value(false), e.neg},
+				{"energy", p.energy(true), e.posEnergy},
+			} {
+				if math.Abs(v.value-v.want) > 1e-9 {
+					t.Errorf("%s: part %d: expected %s %v, got %v", tc.name, i, v.name, v.want, v.value)
+				}
+			}
+		}
+	}
+}
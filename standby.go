@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// standbyHold is how long requests go to the standby after the primary failed
+const standbyHold = 30 * time.Second
+
+// standbyNotice is shown by Grafana on panels served from the standby
+const standbyNotice = "primary middleware unavailable, data served from read-only standby may miss recent values"
+
+type standbyKey struct{}
+
+// standbyMarker records if any request of a query was served from the standby
+type standbyMarker struct {
+	used int32
+}
+
+// withStandbyMarker attaches a new marker to ctx
+func withStandbyMarker(ctx context.Context) (context.Context, *standbyMarker) {
+	m := &standbyMarker{}
+	return context.WithValue(ctx, standbyKey{}, m), m
+}
+
+// markStandby flags the query of ctx as served from the standby
+func markStandby(ctx context.Context) {
+	if m, ok := ctx.Value(standbyKey{}).(*standbyMarker); ok {
+		atomic.StoreInt32(&m.used, 1)
+	}
+}
+
+// served checks if the standby was used
+func (m *standbyMarker) served() bool {
+	return atomic.LoadInt32(&m.used) == 1
+}
+
+// onStandby checks if the primary recently failed and requests should go to the standby
+func (api *Api) onStandby() bool {
+	return api.standby != nil && time.Now().UnixNano() < atomic.LoadInt64(&api.primaryDown)
+}
+
+// getStandby serves a read request from the standby after the primary failed with err
+func (api *Api) getStandby(ctx context.Context, endpoint string, err error) ([]byte, error) {
+	if !api.onStandby() {
+		logf(ctx, "primary middleware failed, using standby for %v: %v", standbyHold, err)
+		atomic.StoreInt64(&api.primaryDown, time.Now().Add(standbyHold).UnixNano())
+	}
+
+	body, serr := api.standby.retry.do(ctx, func() ([]byte, error) {
+		return api.standby.fetch(ctx, api.standby.url+endpoint)
+	})
+	if serr != nil {
+		logf(ctx, "standby failed: %v", serr)
+		return nil, err
+	}
+
+	markStandby(ctx)
+	return body, nil
+}
+
+// withStandbyNotice adds the standby warning to the response meta data
+func withStandbyNotice(qres QueryResponse) QueryResponse {
+	meta := ResponseMeta{}
+	if qres.Meta != nil {
+		meta = *qres.Meta
+	}
+	meta.Notices = append(meta.Notices, ResponseNotice{Severity: "warning", Text: standbyNotice})
+	qres.Meta = &meta
+	return qres
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// energyUnits are the units of range totals of power channels
+var energyUnits = map[string]string{"W": "Wh", "VA": "VAh", "var": "varh"}
+
+// energyUnit returns the unit of the energy of a power unit, e.g. kWh for kW
+func energyUnit(unit string) (string, bool) {
+	base, exp, ok := parseSIUnit(unit)
+	if !ok {
+		return "", false
+	}
+	energy, ok := energyUnits[base]
+	return prefixed(energy, exp), ok
+}
+
+// totalUnit returns the energy unit if `stat` is total of a power channel, whose
+// total is the consumption calculated by the middleware rather than a sum of values
+func (server *Server) totalUnit(kind string, target Target) (string, bool) {
+	if kind != "" || !strings.EqualFold(target.Data["stat"], "total") {
+		return "", false
+	}
+	if _, ok := server.conf.Virtual[target.Target]; ok {
+		return "", false
+	}
+	return energyUnit(server.entityUnit(target.Target))
+}
+
+// queryTotal returns the consumption of a power channel in the range
+func (server *Server) queryTotal(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	consumption, err := server.api.getConsumption(ctx, target.Target, qr.Range.From, qr.Range.To)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	return dataResponse(target.Target, []Tuple{{Timestamp: unixMS(time.Now()), Value: float32(consumption)}}, qr), nil
+}
+
+// reduceStat reduces the series to a single datapoint at now given by `stat`: the
+// latest value, the total of the range or its average, minimum or maximum
+func reduceStat(stat string, qres *QueryResponse) error {
+	stat = strings.ToLower(stat)
+	if !validStats[stat] {
+		return invalidStat(stat)
+	}
+
+	var values []float64
+	for _, dp := range qres.Datapoints {
+		if v := float64(dp.Value); !math.IsNaN(v) {
+			values = append(values, v)
+		}
+	}
+
+	res := math.NaN()
+	if len(values) > 0 {
+		res = values[0]
+		for _, v := range values[1:] {
+			switch stat {
+			case "last":
+				res = v
+			case "total", "avg":
+				res += v
+			case "min":
+				res = math.Min(res, v)
+			case "max":
+				res = math.Max(res, v)
+			}
+		}
+		if stat == "avg" {
+			res /= float64(len(values))
+		}
+	}
+
+	qres.Datapoints = []ResponseTuple{{Timestamp: unixMS(time.Now()), Value: float32(res)}}
+	return nil
+}
+
+// validStats are the values of `stat`
+var validStats = map[string]bool{"last": true, "total": true, "avg": true, "min": true, "max": true}
+
+// invalidStat is returned for unknown `stat` values
+func invalidStat(stat string) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: fmt.Sprintf("invalid stat: %s", stat),
+		Hint:    "stat is one of last, total, avg, min or max",
+	}
+}
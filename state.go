@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// stateRun is a period during which a channel reported the same state
+type stateRun struct {
+	label string
+	start int64
+	end   int64
+}
+
+// stateLabel maps value to the configured label, boolean channels default to on and off
+func stateLabel(states map[string]string, value float32) string {
+	key := strconv.FormatFloat(float64(value), 'g', -1, 32)
+	if label, ok := states[key]; ok {
+		return label
+	}
+	if len(states) > 0 {
+		return key
+	}
+	if value != 0 {
+		return "on"
+	}
+	return "off"
+}
+
+// stateRuns groups consecutive tuples of the same state. The last run ends at end.
+func stateRuns(tuples []Tuple, states map[string]string, end int64) []stateRun {
+	var res []stateRun
+	for _, tuple := range tuples {
+		if math.IsNaN(float64(tuple.Value)) {
+			continue
+		}
+
+		label := stateLabel(states, tuple.Value)
+		if len(res) > 0 && res[len(res)-1].label == label {
+			continue
+		}
+
+		if len(res) > 0 {
+			res[len(res)-1].end = tuple.Timestamp
+		}
+		res = append(res, stateRun{label: label, start: tuple.Timestamp})
+	}
+
+	if len(res) > 0 {
+		res[len(res)-1].end = end
+	}
+
+	return res
+}
+
+// channelTitle returns the entity title of uuid, defaulting to the uuid
+func (server *Server) channelTitle(ctx context.Context, uuid string) string {
+	if entity, err := server.api.getEntity(ctx, uuid); err == nil && entity.Title != "" {
+		return entity.Title
+	}
+	return uuid
+}
+
+// stateAnnotations returns the periods of each state of a state or boolean channel as
+// region annotations. With `state` only periods of the given state label are returned.
+func (server *Server) stateAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	tuples, err := server.api.getData(ctx, target.Target, ar.Range.From, ar.Range.To, target.Data["group"], "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	title := server.channelTitle(ctx, target.Target)
+	only := strings.ToLower(target.Data["state"])
+
+	res := []AnnotationResponse{}
+	for _, run := range stateRuns(tuples, server.conf.Channels[target.Target].States, unixMS(ar.Range.To)) {
+		if only != "" && strings.ToLower(run.label) != only {
+			continue
+		}
+
+		res = append(res, AnnotationResponse{
+			Annotation: ar.Annotation,
+			Time:       run.start,
+			TimeEnd:    run.end,
+			IsRegion:   true,
+			Title:      fmt.Sprintf("%s %s", title, run.label),
+			Tags:       "state," + run.label,
+			Text:       fmt.Sprintf("%s since %s", run.label, formatMS(run.start)),
+		})
+	}
+
+	return res, nil
+}
+
+// crossingAnnotations returns the times a numeric channel crosses its configured thresholds
+// or the target's `threshold` as annotations
+func (server *Server) crossingAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	thresholds := server.conf.Channels[target.Target].Thresholds
+	if _, ok := target.Data["threshold"]; ok {
+		value := target.Data.float("threshold", 0)
+		thresholds = []ThresholdConfig{{Name: strconv.FormatFloat(value, 'g', -1, 64), Value: value}}
+	}
+
+	res := []AnnotationResponse{}
+	if len(thresholds) == 0 {
+		return res, nil
+	}
+
+	tuples, err := server.api.getData(ctx, target.Target, ar.Range.From, ar.Range.To, target.Data["group"], "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	title := server.channelTitle(ctx, target.Target)
+
+	for _, t := range thresholds {
+		var prev *Tuple
+		for i := range tuples {
+			tuple := &tuples[i]
+			if math.IsNaN(float64(tuple.Value)) {
+				continue
+			}
+
+			if prev != nil {
+				above, wasAbove := float64(tuple.Value) > t.Value, float64(prev.Value) > t.Value
+				if above != wasAbove {
+					direction, text := "down", "fell below"
+					if above {
+						direction, text = "up", "exceeded"
+					}
+
+					res = append(res, AnnotationResponse{
+						Annotation: ar.Annotation,
+						Time:       tuple.Timestamp,
+						Title:      fmt.Sprintf("%s %s %s", title, text, t.Name),
+						Tags:       fmt.Sprintf("threshold,%s,%s", t.Name, direction),
+						Text:       fmt.Sprintf("%g (threshold %g)", tuple.Value, t.Value),
+					})
+				}
+			}
+			prev = tuple
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// percentile returns the p-th percentile (0..100) of values using linear interpolation
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	pos := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower < 0 {
+		return sorted[0]
+	}
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	frac := pos - float64(lower)
+	return sorted[lower] + frac*(sorted[lower+1]-sorted[lower])
+}
+
+// medianInterval returns the median of the positive intervals between tuples in ms
+func medianInterval(tuples []Tuple) int64 {
+	intervals := make([]int64, 0, len(tuples))
+	for i := 1; i < len(tuples); i++ {
+		if d := tuples[i].Timestamp - tuples[i-1].Timestamp; d > 0 {
+			intervals = append(intervals, d)
+		}
+	}
+
+	if len(intervals) == 0 {
+		return 0
+	}
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+	return intervals[len(intervals)/2]
+}
+
+// sampleInterval returns the expected interval in ms, or the median interval of
+// the tuples if none is configured
+func sampleInterval(tuples []Tuple, expected time.Duration) int64 {
+	if expected > 0 {
+		return expected.Milliseconds()
+	}
+	return medianInterval(tuples)
+}
+
+// tupleValues returns the values of tuples
+func tupleValues(tuples []Tuple) []float64 {
+	res := make([]float64, 0, len(tuples))
+	for _, tuple := range tuples {
+		if v := float64(tuple.Value); !math.IsNaN(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// querySum returns the sum of all children of a group entity as single series
+func (server *Server) querySum(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	entity, err := server.api.getEntity(ctx, target.Target)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	if entity.Type != "group" {
+		logf(ctx, "sum: %s is not a group", target.Target)
+		return dataResponse(target.Target, []Tuple{}, qr), nil
+	}
+
+	children := make([]Entity, 0)
+	server.flattenEntities(&children, entity.Children, "")
+
+	series := make([][]Tuple, len(children))
+	errs := make([]error, len(children))
+	wg := &sync.WaitGroup{}
+
+	for idx, child := range children {
+		wg.Add(1)
+
+		go func(idx int, uuid string) {
+			series[idx], errs[idx] = server.getTuples(ctx, uuid, target.Data, qr)
+			wg.Done()
+		}(idx, child.UUID)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return QueryResponse{}, err
+		}
+	}
+
+	ts, values := alignSeries(series)
+
+	tuples := make([]Tuple, 0, len(ts))
+	for i := range ts {
+		var sum float64
+		for _, v := range values {
+			sum += v[i]
+		}
+
+		// only sum where all children have data
+		if !math.IsNaN(sum) {
+			tuples = append(tuples, Tuple{Timestamp: ts[i], Value: float32(sum)})
+		}
+	}
+
+	return dataResponse(target.Target, tuples, qr), nil
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultSyncInterval    = time.Minute
+	defaultSyncMeasurement = "volkszaehler"
+)
+
+// syncRule is the parsed mirroring rule of a channel
+type syncRule struct {
+	uuid      string
+	title     string
+	group     string
+	start     time.Time
+	retention time.Duration
+}
+
+// parseSyncRule validates a channel's sync config
+func parseSyncRule(c SyncChannelConfig, loc *time.Location) (syncRule, error) {
+	rule := syncRule{uuid: c.UUID, title: c.UUID, group: c.Group}
+
+	if c.UUID == "" {
+		return rule, fmt.Errorf("sync: missing uuid")
+	}
+
+	if c.Start != "" {
+		t, err := parseTime(c.Start, loc)
+		if err != nil {
+			return rule, fmt.Errorf("sync %s: invalid start: %s", c.UUID, c.Start)
+		}
+		rule.start = t
+	}
+
+	if c.Retention != "" {
+		d, err := time.ParseDuration(c.Retention)
+		if err != nil || d <= 0 {
+			return rule, fmt.Errorf("sync %s: invalid retention: %s", c.UUID, c.Retention)
+		}
+		rule.retention = d
+	}
+
+	return rule, nil
+}
+
+// key identifies the rule's sync state
+func (r syncRule) key() string {
+	if r.group == "" {
+		return r.uuid
+	}
+	return r.uuid + "/" + r.group
+}
+
+// from returns the start of the next sync given the last synced timestamp
+func (r syncRule) from(last int64, now time.Time) time.Time {
+	from := r.start
+	if last > 0 {
+		from = time.Unix(0, (last+1)*int64(time.Millisecond))
+	}
+
+	if r.retention > 0 {
+		if oldest := now.Add(-r.retention); from.Before(oldest) {
+			from = oldest
+		}
+	}
+
+	// without start or retention only new data is synced initially
+	if from.IsZero() {
+		from = now.Add(-defaultSyncInterval)
+	}
+
+	return from
+}
+
+// escapeTag escapes influx line protocol tag keys and values
+func escapeTag(s string) string {
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+// lineProtocol encodes tuples as influx line protocol with ms precision. NaN values are skipped.
+func lineProtocol(measurement string, batch SinkBatch) []byte {
+	var buf bytes.Buffer
+	for _, tuple := range batch.Tuples {
+		if math.IsNaN(float64(tuple.Value)) {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "%s,uuid=%s,title=%s", escapeTag(measurement), escapeTag(batch.UUID), escapeTag(batch.Title))
+		if batch.Group != "" {
+			fmt.Fprintf(&buf, ",group=%s", escapeTag(batch.Group))
+		}
+		fmt.Fprintf(&buf, " value=%v %d\n", tuple.Value, tuple.Timestamp)
+	}
+	return buf.Bytes()
+}
+
+// writeURL returns the influx write endpoint for ms precision
+func (c InfluxConfig) writeURL() string {
+	base := strings.TrimRight(c.URL, "/")
+	if c.Bucket != "" {
+		return fmt.Sprintf("%s/api/v2/write?org=%s&bucket=%s&precision=ms", base, neturl.QueryEscape(c.Org), neturl.QueryEscape(c.Bucket))
+	}
+	return fmt.Sprintf("%s/write?db=%s&precision=ms", base, neturl.QueryEscape(c.Database))
+}
+
+// send posts batches as line protocol to influx
+func (c InfluxConfig) send(ctx context.Context, batches []SinkBatch) error {
+	var body []byte
+	for _, batch := range batches {
+		body = append(body, lineProtocol(c.Measurement, batch)...)
+	}
+
+	header := make(http.Header)
+	if c.Token != "" {
+		header.Set("Authorization", "Token "+c.Token)
+	}
+
+	if err := httpSend(ctx, http.MethodPost, c.writeURL(), "text/plain; charset=utf-8", body, header); err != nil {
+		return fmt.Errorf("influx write failed: %v", err)
+	}
+
+	return nil
+}
+
+// loadSyncState reads the last synced timestamp per rule
+func loadSyncState(file string) map[string]int64 {
+	state := make(map[string]int64)
+	if file == "" {
+		return state
+	}
+
+	if b, err := ioutil.ReadFile(file); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			log.Printf("invalid state file %s", file)
+		}
+	}
+
+	return state
+}
+
+// saveSyncState writes the last synced timestamp per rule
+func saveSyncState(file string, state map[string]int64) error {
+	if file == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, b, 0644)
+}
+
+// syncChannel writes new data of a channel to the sinks and returns the last synced timestamp
+func syncChannel(ctx context.Context, api *Api, sinks []*bufferedSink, rule syncRule, last int64, now time.Time) (int64, error) {
+	from := rule.from(last, now)
+	if !from.Before(now) {
+		return last, nil
+	}
+
+	tuples, err := api.getData(ctx, rule.uuid, from, now, rule.group, "", 0)
+	if err != nil {
+		return last, err
+	}
+
+	// aggregated periods are only final after they ended
+	if rule.group != "" && len(tuples) > 0 {
+		tuples = tuples[:len(tuples)-1]
+	}
+
+	if len(tuples) == 0 {
+		return last, nil
+	}
+
+	batch := SinkBatch{UUID: rule.uuid, Title: rule.title, Group: rule.group, Tuples: tuples}
+	for _, sink := range sinks {
+		if err := sink.Write(batch); err != nil {
+			return last, err
+		}
+	}
+
+	log.Printf("sync %s: %d tuples", rule.uuid, len(tuples))
+	return tuples[len(tuples)-1].Timestamp, nil
+}
+
+// flushSinks flushes all sinks and returns the errors of failed sinks
+func flushSinks(ctx context.Context, sinks []*bufferedSink) []error {
+	ctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, sink := range sinks {
+		if err := sink.Flush(ctx); err != nil {
+			log.Printf("flush failed: %v", err)
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// closeSinks flushes remaining data and closes all sinks
+func closeSinks(sinks []*bufferedSink) error {
+	var res error
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Printf("close failed: %v", err)
+			if res == nil {
+				res = err
+			}
+		}
+	}
+	return res
+}
+
+// syncCommand mirrors configured channels to the sinks
+func syncCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	configFile := fs.String("config", "", "yaml configuration file providing sync rules")
+	once := fs.Bool("once", false, "sync once and exit")
+	metrics := fs.String("metrics", "", "listen address serving metrics at /metrics, e.g. :8001")
+	fs.Parse(args)
+
+	if *configFile == "" {
+		return configError("missing config")
+	}
+
+	conf, err := loadConfig(*configFile)
+	if err != nil {
+		return configError("config %s: %v", *configFile, err)
+	}
+	if err := applySettings(fs, conf); err != nil {
+		return configError("config %s: %v", *configFile, err)
+	}
+
+	sc := conf.Sync
+	sinks, err := newSinks(sc)
+	if err != nil {
+		return &cliError{exitConfig, err}
+	}
+
+	interval := defaultSyncInterval
+	if sc.Interval != "" {
+		if interval, err = time.ParseDuration(sc.Interval); err != nil || interval <= 0 {
+			return configError("sync: invalid interval: %s", sc.Interval)
+		}
+	}
+
+	rules := []syncRule{}
+	for _, c := range sc.Channels {
+		rule, err := parseSyncRule(c, conf.location(c.UUID))
+		if err != nil {
+			return &cliError{exitConfig, err}
+		}
+		rules = append(rules, rule)
+	}
+
+	if len(rules) == 0 {
+		return configError("sync: no channels")
+	}
+
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+	api.overlay = conf.metadataOverlay()
+	api.tokens = conf.channelTokens()
+
+	if *metrics != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			gravoMetrics.write(w)
+		})
+		go func() {
+			if err := http.ListenAndServe(*metrics, mux); err != nil {
+				log.Printf("metrics: %v", err)
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for _, sink := range sinks {
+		if err := sink.Start(ctx); err != nil {
+			closeSinks(sinks)
+			return &cliError{exitConfig, fmt.Errorf("sync sink %s: %v", sink.name, err)}
+		}
+	}
+
+	for i := range rules {
+		if entity, err := api.getEntity(ctx, rules[i].uuid); err == nil && entity.Title != "" {
+			rules[i].title = entity.Title
+		}
+	}
+
+	state := loadSyncState(sc.State)
+
+	for {
+		now := time.Now()
+
+		var errs []error
+		for _, rule := range rules {
+			last, err := syncChannel(ctx, api, sinks, rule, state[rule.key()], now)
+			if err != nil {
+				log.Printf("sync %s failed: %v", rule.uuid, err)
+				errs = append(errs, err)
+				continue
+			}
+			state[rule.key()] = last
+		}
+
+		// state is only persisted once all sinks delivered or queued the data
+		sinkErrs := flushSinks(ctx, sinks)
+		if len(sinkErrs) == 0 {
+			if err := saveSyncState(sc.State, state); err != nil {
+				log.Printf("saving sync state failed: %v", err)
+			}
+		}
+
+		if *once {
+			if len(sinkErrs) > 0 {
+				closeSinks(sinks)
+				return sinkErrs[0]
+			}
+			if err := closeSinks(sinks); err != nil {
+				return err
+			}
+			return channelError(errs, len(rules))
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := closeSinks(sinks); err == nil {
+				if err := saveSyncState(sc.State, state); err != nil {
+					log.Printf("saving sync state failed: %v", err)
+				}
+			}
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tagKeys are the entity properties offered for ad-hoc filters
+var tagKeys = []string{"type", "unit", "title", "group"}
+
+// taggedEntity is a channel with the properties it can be filtered by
+type taggedEntity struct {
+	Entity
+	tags map[string]string
+}
+
+// tagEntities flattens the entity tree, tagging each channel with its properties
+// and the title of its parent group
+func tagEntities(entities []Entity, group string) []taggedEntity {
+	var res []taggedEntity
+	for _, entity := range entities {
+		if entity.Type == "group" {
+			res = append(res, tagEntities(entity.Children, entity.Title)...)
+			continue
+		}
+
+		res = append(res, taggedEntity{
+			Entity: entity,
+			tags: map[string]string{
+				"type":  entity.Type,
+				"unit":  entity.Unit,
+				"title": entity.Title,
+				"group": group,
+			},
+		})
+	}
+	return res
+}
+
+// matches checks if the entity satisfies all filters. Supported operators are =, !=, =~ and !~.
+func (e taggedEntity) matches(filters []Filter) bool {
+	for _, f := range filters {
+		value := e.tags[f.Key]
+
+		switch f.Operator {
+		case "=", "":
+			if value != f.Value {
+				return false
+			}
+		case "!=":
+			if value == f.Value {
+				return false
+			}
+		case "=~", "!~":
+			re, err := regexp.Compile(f.Value)
+			if err != nil {
+				log.Printf("invalid filter regex: %s", f.Value)
+				return false
+			}
+			if re.MatchString(value) != (f.Operator == "=~") {
+				return false
+			}
+		default:
+			log.Printf("unsupported filter operator: %s", f.Operator)
+			return false
+		}
+	}
+	return true
+}
+
+// parseFilters parses comma-separated key=value filters, e.g. type=power,group=heating
+func parseFilters(s string) ([]Filter, bool) {
+	var res []Filter
+	for _, part := range strings.Split(s, ",") {
+		for _, op := range []string{"!=", "=~", "!~", "="} {
+			if i := strings.Index(part, op); i > 0 {
+				res = append(res, Filter{Key: strings.TrimSpace(part[:i]), Operator: op, Value: strings.TrimSpace(part[i+len(op):])})
+				break
+			}
+		}
+	}
+	return res, len(res) > 0
+}
+
+// filteredEntities returns the public channels matching the filters
+func (server *Server) filteredEntities(ctx context.Context, filters []Filter) []taggedEntity {
+	var res []taggedEntity
+	tree, _ := server.cachedEntities(ctx)
+	for _, e := range tagEntities(tree, "") {
+		if e.matches(filters) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// expandFilters replaces `*` targets by a target per channel matching the ad-hoc filters.
+// Without filters `*` targets are left to the query, e.g. for all prognosis channels.
+func (server *Server) expandFilters(ctx context.Context, qr QueryRequest) QueryRequest {
+	if len(qr.AdhocFilters) == 0 {
+		return qr
+	}
+
+	var targets []Target
+	for _, target := range qr.Targets {
+		if target.Target != "*" {
+			targets = append(targets, target)
+			continue
+		}
+
+		for _, e := range server.filteredEntities(ctx, qr.AdhocFilters) {
+			t := target
+			t.Target = e.UUID
+			targets = append(targets, t)
+		}
+	}
+
+	qr.Targets = targets
+	return qr
+}
+
+func (server *Server) tagKeysHandler(w http.ResponseWriter, r *http.Request) {
+	resp := []TagKeyResponse{}
+	for _, key := range tagKeys {
+		resp = append(resp, TagKeyResponse{Type: "string", Text: key})
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (server *Server) tagValuesHandler(w http.ResponseWriter, r *http.Request) {
+	tr := TagValuesRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	values := make(map[string]bool)
+	for _, e := range server.filteredEntities(r.Context(), nil) {
+		if v := e.tags[tr.Key]; v != "" {
+			values[v] = true
+		}
+	}
+
+	resp := make([]TagValueResponse, 0, len(values))
+	for v := range values {
+		resp = append(resp, TagValueResponse{Text: v})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Text < resp[j].Text })
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("json encode failed: %v", err)
+		http.Error(w, fmt.Sprintf("json encode failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// tailChannel is a followed channel and its last printed timestamp
+type tailChannel struct {
+	uuid  string
+	title string
+	last  int64
+}
+
+// printTuples writes tuples newer than the channel's last timestamp
+func (c *tailChannel) printTuples(w io.Writer, tuples []Tuple, decimals int) {
+	for _, tuple := range tuples {
+		if tuple.Timestamp <= c.last {
+			continue
+		}
+		c.last = tuple.Timestamp
+
+		ts := time.Unix(0, tuple.Timestamp*int64(time.Millisecond))
+		fmt.Fprintf(w, "%s  %-20s %s\n", ts.Format("2006-01-02 15:04:05"), c.title, csvLocales["en"].formatValue(tuple.Value, decimals))
+	}
+}
+
+// tailCommand prints new values of channels as they arrive
+func tailCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuids := fs.String("uuid", "", "comma-separated channel uuids")
+	interval := fs.Duration("interval", 5*time.Second, "polling interval")
+	history := fs.Duration("history", 0, "print values of this period before following")
+	decimals := fs.Int("decimals", -1, "decimal places (-1 for full precision)")
+	fs.Parse(args)
+
+	if *uuids == "" {
+		return configError("missing uuid")
+	}
+	if *interval <= 0 {
+		return configError("invalid interval: %v", *interval)
+	}
+
+	ctx := context.Background()
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now().Add(-*history)
+
+	channels := []*tailChannel{}
+	for _, uuid := range strings.Split(*uuids, ",") {
+		uuid = strings.TrimSpace(uuid)
+
+		title := uuid
+		if entity, err := api.getEntity(ctx, uuid); err == nil && entity.Title != "" {
+			title = entity.Title
+		}
+
+		channels = append(channels, &tailChannel{uuid: uuid, title: title, last: unixMS(start)})
+	}
+
+	// only log failures to keep the terminal readable
+	if !*apiOptions.verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	for {
+		now := time.Now()
+		for _, c := range channels {
+			from := time.Unix(0, c.last*int64(time.Millisecond))
+
+			tuples, err := api.getData(ctx, c.uuid, from, now, "", "", 0)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", c.title, err)
+				continue
+			}
+
+			c.printTuples(os.Stdout, tuples, *decimals)
+		}
+
+		time.Sleep(*interval)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hoursPerMonth prorates monthly base fees
+const hoursPerMonth = 365.25 * 24 / 12
+
+// TariffConfig is an electricity tariff for cost simulations. Prices are per kWh,
+// the base fee per month. Dynamic tariffs read hourly prices from a channel.
+type TariffConfig struct {
+	Base    float64        `yaml:"base"`
+	Price   float64        `yaml:"price"` // flat or peak price, fallback for missing dynamic prices
+	Offpeak *OffpeakConfig `yaml:"offpeak"`
+	Prices  string         `yaml:"prices"` // channel with dynamic prices
+	Scale   float64        `yaml:"scale"`  // converts channel prices to per kWh, default 1
+	Markup  float64        `yaml:"markup"` // added to dynamic prices
+}
+
+// OffpeakConfig is the low price period of a HT/NT tariff
+type OffpeakConfig struct {
+	Price   float64 `yaml:"price"`
+	Hours   string  `yaml:"hours"`   // e.g. 22-6
+	Weekend bool    `yaml:"weekend"` // weekends and holidays are off-peak all day
+}
+
+// tariffResult is the simulated cost of a tariff
+type tariffResult struct {
+	name   string
+	energy float64 // kWh
+	cost   float64 // energy cost
+	base   float64 // prorated base fee
+	series []Tuple // cost per group period
+}
+
+// parseHours parses an hour range like 22-6
+func parseHours(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid hours: %s", s)
+	}
+
+	from, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	to, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || from < 0 || from > 24 || to < 0 || to > 24 || from == to {
+		return 0, 0, fmt.Errorf("invalid hours: %s", s)
+	}
+
+	return from, to, nil
+}
+
+// validate checks the tariff config
+func (t TariffConfig) validate() error {
+	if t.Price < 0 || t.Base < 0 {
+		return fmt.Errorf("negative price")
+	}
+	if t.Price == 0 && t.Prices == "" {
+		return fmt.Errorf("missing price or prices")
+	}
+	if t.Offpeak != nil {
+		if t.Prices != "" {
+			return fmt.Errorf("offpeak cannot be combined with prices")
+		}
+		if _, _, err := parseHours(t.Offpeak.Hours); err != nil && !t.Offpeak.Weekend {
+			return err
+		}
+	}
+	return nil
+}
+
+// offpeak checks if t is in the off-peak period
+func (o *OffpeakConfig) offpeak(t time.Time, cal *calendar) bool {
+	if o.Weekend && cal.holiday(t) {
+		return true
+	}
+
+	from, to, err := parseHours(o.Hours)
+	if err != nil {
+		return false
+	}
+
+	h := t.Hour()
+	if from < to {
+		return h >= from && h < to
+	}
+	return h >= from || h < to
+}
+
+// tariffNames returns the sorted names of the configured tariffs
+func (conf Config) tariffNames() []string {
+	res := make([]string, 0, len(conf.Tariffs))
+	for name := range conf.Tariffs {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// simulateTariff replays the power tuples against the tariff, summing cost per group
+// period in loc. Each interval is priced at its start.
+func (server *Server) simulateTariff(ctx context.Context, name string, tuples []Tuple, group string, loc *time.Location, qr *QueryRequest) (tariffResult, error) {
+	res := tariffResult{name: name, series: []Tuple{}}
+	t := server.conf.Tariffs[name]
+	cal := server.conf.holidayCalendar()
+
+	var prices []Tuple
+	if t.Prices != "" {
+		var err error
+		if prices, err = server.api.getData(ctx, t.Prices, qr.Range.From, qr.Range.To, "hour", "", 0); err != nil {
+			return res, err
+		}
+	}
+	scale := t.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	p := 0
+	for i := 1; i < len(tuples); i++ {
+		if math.IsNaN(float64(tuples[i].Value)) {
+			continue
+		}
+
+		start := tuples[i-1].Timestamp
+		dt := float64(tuples[i].Timestamp - start)
+		energy := float64(tuples[i].Value) * dt / msPerHour / 1e3
+
+		price := t.Price
+		if t.Offpeak != nil && t.Offpeak.offpeak(time.Unix(0, start*int64(time.Millisecond)).In(loc), cal) {
+			price = t.Offpeak.Price
+		}
+		if len(prices) > 0 {
+			// price tuples are valid for the hour ending at their timestamp
+			for p < len(prices) && prices[p].Timestamp <= start {
+				p++
+			}
+			if p < len(prices) {
+				price = float64(prices[p].Value)*scale + t.Markup
+			}
+		}
+
+		base := t.Base * dt / msPerHour / hoursPerMonth
+		res.energy += energy
+		res.cost += energy * price
+		res.base += base
+
+		ts := roundTimestampMS(start, group, loc)
+		if len(res.series) == 0 || res.series[len(res.series)-1].Timestamp != ts {
+			res.series = append(res.series, Tuple{Timestamp: ts})
+		}
+		res.series[len(res.series)-1].Value += float32(energy*price + base)
+	}
+
+	return res, nil
+}
+
+// simulateTariffs replays the channel's consumption against the selected tariffs,
+// defaulting to all configured tariffs
+func (server *Server) simulateTariffs(ctx context.Context, target Target, qr *QueryRequest) ([]tariffResult, error) {
+	names := server.conf.tariffNames()
+	if s, ok := target.Data["tariffs"]; ok {
+		names = strings.Split(s, ",")
+	}
+	if s, ok := target.Data["tariff"]; ok {
+		names = []string{s}
+	}
+
+	for _, name := range names {
+		if _, ok := server.conf.Tariffs[name]; !ok {
+			return nil, &QueryError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_request",
+				Message: fmt.Sprintf("unknown tariff: %s", name),
+				Hint:    "tariffs are defined in the tariffs section of the config file",
+			}
+		}
+	}
+
+	group := "day"
+	if grp, ok := target.Data["group"]; ok {
+		group = strings.ToLower(grp)
+	}
+
+	tuples, err := server.api.getData(ctx, target.Target, qr.Range.From, qr.Range.To, "hour", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := server.conf.location(target.Target)
+	res := make([]tariffResult, 0, len(names))
+	for _, name := range names {
+		r, err := server.simulateTariff(ctx, name, tuples, group, loc, qr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+
+	return res, nil
+}
+
+// queryTariff returns the simulated cost per group period of the channel's
+// consumption under the tariff given by `tariff`
+func (server *Server) queryTariff(ctx context.Context, target Target, qr *QueryRequest) (QueryResponse, error) {
+	if target.Data["tariff"] == "" {
+		return QueryResponse{}, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: "missing tariff",
+			Hint:    `e.g. {"context": "tariff", "tariff": "dynamic"}, use a table target to compare all tariffs`,
+		}
+	}
+
+	res, err := server.simulateTariffs(ctx, target, qr)
+	if err != nil {
+		return QueryResponse{}, err
+	}
+
+	series := res[0].series
+	if target.Data["total"] == "true" {
+		series = []Tuple{Tuple{
+			Timestamp: unixMS(qr.Range.To),
+			Value:     float32(res[0].cost + res[0].base),
+		}}
+	}
+
+	return dataResponse(target.Target, series, qr), nil
+}
+
+// tariffTable compares the simulated totals of the tariffs, cheapest first
+func (server *Server) tariffTable(ctx context.Context, target Target, qr *QueryRequest) (TableResponse, error) {
+	table := TableResponse{
+		Columns: []TableColumn{
+			TableColumn{Text: "Tariff", Type: "string"},
+			TableColumn{Text: "Energy (kWh)", Type: "number"},
+			TableColumn{Text: "Energy cost", Type: "number"},
+			TableColumn{Text: "Base fee", Type: "number"},
+			TableColumn{Text: "Total", Type: "number"},
+			TableColumn{Text: "Average price", Type: "number"},
+		},
+		Rows: [][]interface{}{},
+		Type: "table",
+	}
+
+	res, err := server.simulateTariffs(ctx, target, qr)
+	if err != nil {
+		return table, err
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].cost+res[i].base < res[j].cost+res[j].base
+	})
+
+	for _, r := range res {
+		var avg float64
+		if r.energy != 0 {
+			avg = (r.cost + r.base) / r.energy
+		}
+		table.Rows = append(table.Rows, []interface{}{r.name, r.energy, r.cost, r.base, r.cost + r.base, avg})
+	}
+
+	return table, nil
+}
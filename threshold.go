@@ -0,0 +1,42 @@
+package main
+
+import "fmt"
+
+// thresholdSeries returns the channel's configured thresholds as constant series over the
+// query range named after the target's series. Thresholds are omitted with `thresholds` false.
+func (server *Server) thresholdSeries(target Target, qres QueryResponse, qr *QueryRequest) []QueryResponse {
+	if target.Data["thresholds"] == "false" {
+		return nil
+	}
+
+	thresholds := server.conf.Channels[target.Target].Thresholds
+	res := make([]QueryResponse, 0, len(thresholds))
+
+	// thresholds are given in the entity unit, follow converted series
+	c := conversion{factor: 1}
+	if unit, ok := qres.Meta.unit(); ok {
+		if uc, ok := server.conversion(target.Target, server.entityUnit(target.Target), unit); ok {
+			c = uc
+		}
+	}
+
+	for _, t := range thresholds {
+		value := float32(c.apply(t.Value))
+		tuples := []Tuple{
+			{Timestamp: unixMS(qr.Range.From), Value: value},
+			{Timestamp: unixMS(qr.Range.To), Value: value},
+		}
+
+		tres := dataResponse(fmt.Sprintf("%v %s", qres.Target, t.Name), tuples, &QueryRequest{})
+
+		custom := map[string]interface{}{"threshold": t.Name}
+		if t.Display != (DisplayConfig{}) {
+			custom["display"] = t.Display
+		}
+		tres.Meta = &ResponseMeta{Custom: custom}
+
+		res = append(res, tres)
+	}
+
+	return res
+}
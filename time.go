@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// unixMS returns t in milliseconds since epoch
+func unixMS(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// formatMS formats epoch milliseconds as RFC3339
+func formatMS(ts int64) string {
+	return time.Unix(0, ts*int64(time.Millisecond)).Format(time.RFC3339)
+}
+
+// isoLayouts are the accepted ISO 8601 layouts. Layouts without zone are times in loc.
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseISOTime parses an ISO 8601 timestamp, times without zone in loc
+func parseISOTime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range isoLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time: %s", s)
+}
+
+// parseTime parses epoch milliseconds, ISO 8601, now or a duration relative to now like -24h
+func parseTime(s string, loc *time.Location) (time.Time, error) {
+	if s == "" || s == "now" {
+		return time.Now(), nil
+	}
+
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)), nil
+	}
+
+	if t, err := parseISOTime(s, loc); err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", s)
+	}
+
+	return time.Now().Add(d), nil
+}
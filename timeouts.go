@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// requestTimeouts are the timeouts of single middleware requests by endpoint.
+// Unlike the query budget they apply to each attempt, queue time excluded.
+type requestTimeouts struct {
+	def       time.Duration // all other requests
+	entities  time.Duration
+	data      time.Duration
+	prognosis time.Duration
+}
+
+// timeout returns the timeout of requests to url
+func (t requestTimeouts) timeout(url string) time.Duration {
+	var d time.Duration
+	switch upstreamEndpoint(url) {
+	case "entity":
+		d = t.entities
+	case "data":
+		d = t.data
+	case "prognosis":
+		d = t.prognosis
+	}
+	if d <= 0 {
+		d = t.def
+	}
+	return d
+}
+
+// requestContext limits a request to url to its timeout. The request is still
+// cancelled with ctx, e.g. if Grafana cancels the query.
+func (api *Api) requestContext(ctx context.Context, url string) (context.Context, context.CancelFunc, time.Duration) {
+	d := api.timeouts.timeout(url)
+	if d <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return ctx, cancel, d
+}
+
+// timeoutError is a middleware request exceeding its timeout. It is a net.Error
+// and retried unlike an exceeded query budget.
+type timeoutError struct {
+	url     string
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("%s: no response within %v", e.url, e.timeout)
+}
+
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// requestError converts err to a timeoutError if the request timed out while the
+// query it belongs to was still running
+func requestError(ctx, rctx context.Context, url string, timeout time.Duration, err error) error {
+	if ctx.Err() == nil && rctx.Err() == context.DeadlineExceeded {
+		return &timeoutError{url: url, timeout: timeout}
+	}
+	return err
+}
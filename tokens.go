@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// channelTokenKey is the context key of the access token of the requested channel
+type channelTokenKey struct{}
+
+// channelTokens collects the access tokens of channels that are not public
+func (conf Config) channelTokens() map[string]string {
+	res := make(map[string]string)
+	for uuid, c := range conf.Channels {
+		if c.Token != "" {
+			res[uuid] = c.Token
+		}
+	}
+	return res
+}
+
+// withChannelToken adds the channel's access token to ctx if configured
+func (api *Api) withChannelToken(ctx context.Context, uuid string) context.Context {
+	if token, ok := api.tokens[uuid]; ok {
+		return context.WithValue(ctx, channelTokenKey{}, token)
+	}
+	return ctx
+}
+
+// setChannelToken authorizes req with the channel token of ctx instead of the middleware credentials
+func setChannelToken(ctx context.Context, req *http.Request) {
+	if token, ok := ctx.Value(channelTokenKey{}).(string); ok {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// validTransforms are the `transform` modes of raw series
+var validTransforms = map[string]bool{"rate": true, "integrate": true}
+
+// invalidTransform is the error of unknown transforms or periods
+func invalidTransform(format string, a ...interface{}) *QueryError {
+	return &QueryError{
+		Status:  http.StatusBadRequest,
+		Code:    "invalid_request",
+		Message: fmt.Sprintf(format, a...),
+		Hint:    "transform is rate or integrate, per is a duration like 1h",
+	}
+}
+
+// transformPeriod returns the period rates refer to given by `per`, default an hour
+func transformPeriod(data TargetData) (time.Duration, error) {
+	s, ok := data["per"]
+	if !ok {
+		return time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, invalidTransform("invalid per: %s", s)
+	}
+	return d, nil
+}
+
+// transformTuples applies `transform` to the tuples: rate differentiates a counter,
+// e.g. a meter reading in kWh to kW, treating a decreasing reading as counter reset
+// from zero. integrate accumulates power into energy using the trapezoidal rule,
+// starting at zero. Rates and integrals refer to the period `per`.
+func transformTuples(data TargetData, tuples []Tuple) ([]Tuple, error) {
+	mode := strings.ToLower(data["transform"])
+	if !validTransforms[mode] {
+		return nil, invalidTransform("invalid transform: %s", mode)
+	}
+
+	per, err := transformPeriod(data)
+	if err != nil {
+		return nil, err
+	}
+	period := float64(per.Milliseconds())
+
+	if mode == "rate" {
+		return counterRate(tuples, period), nil
+	}
+	return integrate(tuples, period), nil
+}
+
+// counterRate returns the rate of increase of the counter between consecutive
+// readings at the later reading. Nulls end the interval.
+func counterRate(tuples []Tuple, period float64) []Tuple {
+	res := make([]Tuple, 0, len(tuples))
+	null := float32(math.NaN())
+
+	var prev *Tuple
+	for i, tuple := range tuples {
+		if math.IsNaN(float64(tuple.Value)) {
+			if prev != nil {
+				res = append(res, Tuple{Timestamp: tuple.Timestamp, Value: null})
+			}
+			prev = nil
+			continue
+		}
+
+		if prev != nil && tuple.Timestamp > prev.Timestamp {
+			delta := float64(tuple.Value) - float64(prev.Value)
+			if delta < 0 {
+				// counter reset, the reading counts from zero
+				delta = float64(tuple.Value)
+			}
+			dt := float64(tuple.Timestamp - prev.Timestamp)
+			res = append(res, Tuple{Timestamp: tuple.Timestamp, Value: float32(delta * period / dt)})
+		}
+		prev = &tuples[i]
+	}
+
+	return res
+}
+
+// integrate returns the cumulative integral of the values at each tuple. Intervals
+// adjacent to nulls are skipped, the integral holds.
+func integrate(tuples []Tuple, period float64) []Tuple {
+	res := make([]Tuple, 0, len(tuples))
+
+	var sum float64
+	for i, tuple := range tuples {
+		if math.IsNaN(float64(tuple.Value)) {
+			continue
+		}
+		if i > 0 {
+			prev := tuples[i-1]
+			if !math.IsNaN(float64(prev.Value)) {
+				dt := float64(tuple.Timestamp - prev.Timestamp)
+				sum += (float64(prev.Value) + float64(tuple.Value)) / 2 * dt / period
+			}
+		}
+		res = append(res, Tuple{Timestamp: tuple.Timestamp, Value: float32(sum)})
+	}
+
+	return res
+}
+
+// transformUnit returns the unit of the transformed series if known, e.g. kW for
+// the rate of a kWh counter or kWh for integrated kW
+func (server *Server) transformUnit(kind string, target Target) (string, bool) {
+	mode := strings.ToLower(target.Data["transform"])
+	if kind != "" || !validTransforms[mode] {
+		return "", false
+	}
+	if per, err := transformPeriod(target.Data); err != nil || per != time.Hour {
+		return "", false
+	}
+
+	unit := server.entityUnit(target.Target)
+	if mode == "integrate" {
+		return energyUnit(unit)
+	}
+
+	base, exp, ok := parseSIUnit(unit)
+	if !ok {
+		return "", false
+	}
+	for power, energy := range energyUnits {
+		if energy == base {
+			return prefixed(power, exp), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTransformTuples(t *testing.T) {
+	null := float32(math.NaN())
+
+	tests := []struct {
+		name     string
+		data     TargetData
+		tuples   []Tuple
+		expected []Tuple
+	}{
+		{
+			name:     "rate",
+			data:     TargetData{"transform": "rate"},
+			tuples:   []Tuple{{0, 10}, {1800000, 11}, {3600000, 12.5}},
+			expected: []Tuple{{1800000, 2}, {3600000, 3}},
+		},
+		{
+			name:     "rate per minute",
+			data:     TargetData{"transform": "rate", "per": "1m"},
+			tuples:   []Tuple{{0, 0}, {60000, 5}},
+			expected: []Tuple{{60000, 5}},
+		},
+		{
+			name:     "counter reset",
+			data:     TargetData{"transform": "rate"},
+			tuples:   []Tuple{{0, 10}, {3600000, 2}},
+			expected: []Tuple{{3600000, 2}},
+		},
+		{
+			name:     "rate with null",
+			data:     TargetData{"transform": "rate"},
+			tuples:   []Tuple{{0, 10}, {1800000, 11}, {3600000, null}, {5400000, 1}, {7200000, 3}},
+			expected: []Tuple{{1800000, 2}, {3600000, null}, {7200000, 4}},
+		},
+		{
+			name:     "integrate",
+			data:     TargetData{"transform": "integrate"},
+			tuples:   []Tuple{{0, 1000}, {1800000, 3000}, {3600000, 3000}},
+			expected: []Tuple{{0, 0}, {1800000, 1000}, {3600000, 2500}},
+		},
+		{
+			name:     "integrate with null",
+			data:     TargetData{"transform": "INTEGRATE"},
+			tuples:   []Tuple{{0, 1000}, {1800000, 3000}, {3600000, null}, {5400000, 2000}},
+			expected: []Tuple{{0, 0}, {1800000, 1000}, {5400000, 1000}},
+		},
+	}
+
+	for _, tc := range tests {
+		res, err := transformTuples(tc.data, tc.tuples)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !equalTuples(res, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, res)
+		}
+	}
+}
+
+func TestTransformTuplesInvalid(t *testing.T) {
+	for _, data := range []TargetData{
+		{"transform": "derive"},
+		{"transform": "rate", "per": "0s"},
+		{"transform": "rate", "per": "hour"},
+	} {
+		if _, err := transformTuples(data, []Tuple{{0, 1}}); err == nil {
+			t.Errorf("%v: expected error", data)
+		}
+	}
+}
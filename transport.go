@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// parseHosts parses a comma-separated list of hostname to ip mappings, e.g. vz.local=192.168.1.10
+func parseHosts(s string) (map[string]string, error) {
+	res := make(map[string]string)
+	if s == "" {
+		return res, nil
+	}
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || net.ParseIP(strings.TrimSpace(parts[1])) == nil {
+			return nil, fmt.Errorf("invalid host mapping: %s", kv)
+		}
+
+		res[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return res, nil
+}
+
+// newTransport creates the upstream transport. If resolver is given, host names are
+// resolved using this dns server. Hosts maps host names to static ip addresses.
+func newTransport(resolver string, hosts map[string]string) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if resolver != "" {
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(resolver, "53")
+		}
+
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, resolver)
+			},
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := hosts[host]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return transport
+}
+
+// certificateError checks if err is caused by verifying the middleware certificate
+func certificateError(err error) bool {
+	var ua x509.UnknownAuthorityError
+	var ci x509.CertificateInvalidError
+	var he x509.HostnameError
+	return errors.As(err, &ua) || errors.As(err, &ci) || errors.As(err, &he)
+}
+
+// clientTLS returns the tls config of middleware requests, nil for the defaults
+func clientTLS(ca, cert, key string, insecure bool) (*tls.Config, error) {
+	if ca == "" && cert == "" && key == "" && !insecure {
+		return nil, nil
+	}
+
+	conf := &tls.Config{InsecureSkipVerify: insecure}
+	if insecure {
+		log.Print("WARNING: middleware certificates are not verified")
+	}
+
+	if ca != "" {
+		b, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("tls ca: %v", err)
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("tls ca: no certificates found in %s", ca)
+		}
+	}
+
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("tls cert: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{pair}
+	}
+
+	return conf, nil
+}
+
+// webhookClient sends webhook notifications and sink writes, a hanging receiver
+// must not block the sender
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// uploadClient sends exports to object storage and webhooks, allowing for large files
+var uploadClient = &http.Client{Timeout: 5 * time.Minute}
+
+// credentials authenticate middleware requests using basic auth or a bearer token
+type credentials struct {
+	username string
+	password string
+	token    string
+}
+
+// authTransport adds the Authorization header to every request to the middleware
+// host not carrying a channel token
+type authTransport struct {
+	base  http.RoundTripper
+	host  string
+	creds credentials
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// channel tokens take precedence, other hosts must not see the credentials
+	if req.Header.Get("Authorization") != "" || req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+
+	// requests must not be modified by round trippers
+	req = req.Clone(req.Context())
+
+	if t.creds.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.creds.token)
+	} else {
+		req.SetBasicAuth(t.creds.username, t.creds.password)
+	}
+
+	return t.base.RoundTrip(req)
+}
+
+// withCredentials wraps transport if credentials are given, sending them to the host
+// of the middleware url only. Basic auth and token both use the Authorization header
+// and can't be combined.
+func withCredentials(transport http.RoundTripper, middleware string, creds credentials) (http.RoundTripper, error) {
+	switch {
+	case creds.token != "" && creds.username != "":
+		return nil, fmt.Errorf("either username or token can be used")
+	case creds.password != "" && creds.username == "":
+		return nil, fmt.Errorf("password requires username")
+	case creds.token == "" && creds.username == "":
+		return transport, nil
+	}
+
+	u, err := neturl.Parse(middleware)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid middleware url: %s", middleware)
+	}
+
+	return &authTransport{base: transport, host: u.Host, creds: creds}, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sparkBlocks are the block characters from lowest to highest
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// resample averages tuples into width equal time buckets between from and to.
+// Buckets without data are NaN.
+func resample(tuples []Tuple, from, to int64, width int) []float64 {
+	sums := make([]float64, width)
+	counts := make([]int, width)
+
+	span := to - from
+	for _, tuple := range tuples {
+		if span <= 0 || tuple.Timestamp < from || tuple.Timestamp > to || math.IsNaN(float64(tuple.Value)) {
+			continue
+		}
+
+		i := int((tuple.Timestamp - from) * int64(width) / span)
+		if i >= width {
+			i = width - 1
+		}
+		sums[i] += float64(tuple.Value)
+		counts[i]++
+	}
+
+	res := make([]float64, width)
+	for i := range res {
+		res[i] = math.NaN()
+		if counts[i] > 0 {
+			res[i] = sums[i] / float64(counts[i])
+		}
+	}
+
+	return res
+}
+
+// sparkline renders values as block chart between min and max
+func sparkline(values []float64, min, max float64) string {
+	var sb strings.Builder
+	for _, v := range values {
+		if math.IsNaN(v) {
+			sb.WriteRune(' ')
+			continue
+		}
+
+		i := 0
+		if max > min {
+			i = int((v - min) / (max - min) * float64(len(sparkBlocks)-1))
+		}
+		sb.WriteRune(sparkBlocks[i])
+	}
+	return sb.String()
+}
+
+// tuiPanel is a single channel of the dashboard
+type tuiPanel struct {
+	uuid  string
+	title string
+}
+
+// render draws the panel's chart of the given range
+func (p tuiPanel) render(w io.Writer, tuples []Tuple, from, to time.Time, width, decimals int) {
+	values := resample(tuples, unixMS(from), unixMS(to), width)
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if !math.IsNaN(v) {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+	}
+
+	current := "-"
+	if len(tuples) > 0 {
+		current = csvLocales["en"].formatValue(tuples[len(tuples)-1].Value, decimals)
+	}
+
+	fmt.Fprintf(w, "\033[1m%s\033[0m  %s\n", p.title, current)
+	if math.IsInf(min, 0) {
+		fmt.Fprintln(w, "  no data")
+	} else {
+		fmt.Fprintln(w, sparkline(values, min, max))
+		fmt.Fprintf(w, "min %s  max %s\n", strconv.FormatFloat(min, 'f', decimals, 64), strconv.FormatFloat(max, 'f', decimals, 64))
+	}
+	fmt.Fprintln(w)
+}
+
+// terminalWidth returns the terminal width from COLUMNS or 80
+func terminalWidth() int {
+	if w, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// tuiCommand renders an auto-refreshing terminal dashboard of channels
+func tuiCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuids := fs.String("uuid", "", "comma-separated channel uuids (default channels from config)")
+	configFile := fs.String("config", "", "yaml configuration file providing channels")
+	period := fs.Duration("range", time.Hour, "displayed time range")
+	refresh := fs.Duration("refresh", 10*time.Second, "refresh interval")
+	decimals := fs.Int("decimals", 1, "decimal places")
+	fs.Parse(args)
+
+	var conf Config
+	if *configFile != "" {
+		var err error
+		if conf, err = loadConfig(*configFile); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+		if err := applySettings(fs, conf); err != nil {
+			return configError("config %s: %v", *configFile, err)
+		}
+	}
+
+	var channels []string
+	if *uuids != "" {
+		channels = strings.Split(*uuids, ",")
+	} else {
+		for uuid := range conf.Channels {
+			channels = append(channels, uuid)
+		}
+		sort.Strings(channels)
+	}
+
+	if len(channels) == 0 {
+		return configError("missing uuid")
+	}
+	if *refresh <= 0 {
+		return configError("invalid refresh: %v", *refresh)
+	}
+
+	ctx := context.Background()
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+	api.tokens = conf.channelTokens()
+
+	panels := []tuiPanel{}
+	for _, uuid := range channels {
+		uuid = strings.TrimSpace(uuid)
+
+		title := uuid
+		if entity, err := api.getEntity(ctx, uuid); err == nil && entity.Title != "" {
+			title = entity.Title
+		}
+
+		panels = append(panels, tuiPanel{uuid: uuid, title: title})
+	}
+
+	// request logs would corrupt the screen
+	log.SetOutput(io.Discard)
+
+	for {
+		width := terminalWidth()
+		to := time.Now()
+		from := to.Add(-*period)
+
+		// render off-screen to avoid flicker
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "gravo  %s  last %v, refresh %v\n\n", to.Format("15:04:05"), *period, *refresh)
+
+		for _, p := range panels {
+			tuples, err := api.getData(ctx, p.uuid, from, to, "", "", width)
+			if err != nil {
+				fmt.Fprintf(&sb, "\033[1m%s\033[0m  %v\n\n", p.title, err)
+				continue
+			}
+			p.render(&sb, tuples, from, to, width, *decimals)
+		}
+
+		fmt.Print("\033[H\033[2J" + sb.String())
+		time.Sleep(*refresh)
+	}
+}
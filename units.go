@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strings"
+)
+
+// siPrefixes are the prefixes used for scaling by their power of ten
+var siPrefixes = []struct {
+	prefix string
+	exp    int
+}{
+	{"", 0}, {"k", 3}, {"M", 6}, {"G", 9},
+}
+
+// scalableUnits are the base units values are scaled for
+var scalableUnits = map[string]bool{
+	"W": true, "Wh": true, "VA": true, "VAh": true, "var": true, "varh": true, "J": true,
+}
+
+// typeUnits are the units of entity types whose data is power, used if the middleware reports none
+var typeUnits = map[string]string{
+	"power":          "W",
+	"powersensor":    "W",
+	"electric meter": "W",
+}
+
+// entityUnit returns the unit of the channel's data
+func (server *Server) entityUnit(uuid string) string {
+	if v, ok := server.conf.Virtual[uuid]; ok {
+		return v.Unit
+	}
+
+	entity, _ := server.cachedEntity(uuid)
+	return entity.unit()
+}
+
+// unit returns the unit of the entity's data, defaulting by type
+func (e Entity) unit() string {
+	if e.Unit != "" {
+		return e.Unit
+	}
+	return typeUnits[e.Type]
+}
+
+// parseSIUnit splits unit into prefix exponent and base unit, e.g. kWh into 3 and Wh
+func parseSIUnit(unit string) (string, int, bool) {
+	if scalableUnits[unit] {
+		return unit, 0, true
+	}
+	for _, p := range siPrefixes[1:] {
+		if base := strings.TrimPrefix(unit, p.prefix); base != unit && scalableUnits[base] {
+			return base, p.exp, true
+		}
+	}
+	return "", 0, false
+}
+
+// autoExp returns the exponent of the largest prefix keeping max at least 1
+func autoExp(max float64, exp int) int {
+	res := siPrefixes[0].exp
+	for _, p := range siPrefixes {
+		if max*math.Pow10(exp-p.exp) >= 1 {
+			res = p.exp
+		}
+	}
+	return res
+}
+
+// prefixed returns the unit of base with the exponent's prefix
+func prefixed(base string, exp int) string {
+	for _, p := range siPrefixes {
+		if p.exp == exp {
+			return p.prefix + base
+		}
+	}
+	return base
+}
+
+// unit returns the unit passed to Grafana in the custom meta data
+func (m *ResponseMeta) unit() (string, bool) {
+	if m == nil {
+		return "", false
+	}
+	custom, ok := m.Custom.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	unit, ok := custom["unit"].(string)
+	return unit, ok
+}
+
+// scaleSeries converts the series to the unit given by `unit`, or with `auto` (default
+// with -autoscale) to a prefix based on the largest value. The series' unit is the
+// entity unit or, for derived queries, `baseunit`. Explicit units may also be converted
+// to other base units or by the channel's conversions. Returns the unit and the number
+// of digits the values were shifted by if scaled.
+func (server *Server) scaleSeries(kind string, target Target, qres *QueryResponse) (string, int, bool) {
+	want, ok := target.Data["unit"]
+	if !ok && server.autoscale {
+		want = "auto"
+	}
+	if want == "" || want == "none" {
+		return "", 0, false
+	}
+
+	unit := target.Data["baseunit"]
+	if unit == "" && kind == "" {
+		unit = server.entityUnit(target.Target)
+	}
+
+	if strings.ToLower(want) != "auto" {
+		c, ok := server.conversion(target.Target, unit, want)
+		if !ok {
+			log.Printf("unit: cannot convert %s of %s to %s", unit, target.Target, want)
+			return "", 0, false
+		}
+		for i, dp := range qres.Datapoints {
+			qres.Datapoints[i].Value = float32(c.apply(float64(dp.Value)))
+		}
+		return want, c.decimalShift(), true
+	}
+
+	base, exp, ok := parseSIUnit(unit)
+	if !ok {
+		if _, explicit := target.Data["unit"]; explicit {
+			log.Printf("unit: cannot scale %s of %s", unit, target.Target)
+		}
+		return "", 0, false
+	}
+
+	var max float64
+	for _, dp := range qres.Datapoints {
+		if v := math.Abs(float64(dp.Value)); !math.IsNaN(v) && v > max {
+			max = v
+		}
+	}
+	to := autoExp(max, exp)
+
+	factor := float32(math.Pow10(exp - to))
+	for i := range qres.Datapoints {
+		qres.Datapoints[i].Value *= factor
+	}
+
+	return prefixed(base, to), to - exp, true
+}
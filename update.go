@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// releaseKey is the base64 ed25519 public key of the project verifying release
+// signatures, set at build time with -ldflags "-X main.releaseKey=..."
+var releaseKey = ""
+
+// checksumsAsset lists the sha256 sums of the release binaries, signed by checksumsAsset.sig
+const checksumsAsset = "checksums.txt"
+
+// download size limits of release metadata and binaries
+const (
+	maxReleaseInfo   = 1 << 20
+	maxSignature     = 4 << 10
+	maxReleaseBinary = 256 << 20
+)
+
+// release is a GitHub release
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download url of the named asset
+func (r release) asset(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// binaryAsset is the release binary of the running platform, e.g. gravo_linux_arm64
+func binaryAsset() string {
+	name := fmt.Sprintf("gravo_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// download returns the body of url, failing if it exceeds max bytes
+func download(client *http.Client, url string, max int64) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, &cliError{exitUnreachable, err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: unexpected status %s", url, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err == nil && int64(len(b)) > max {
+		err = fmt.Errorf("%s: exceeds %d bytes", url, max)
+	}
+	return b, err
+}
+
+// latestRelease returns the latest release of repo
+func latestRelease(client *http.Client, api, repo string) (release, error) {
+	var r release
+	b, err := download(client, fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimRight(api, "/"), repo), maxReleaseInfo)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, fmt.Errorf("invalid release: %v", err)
+	}
+	return r, nil
+}
+
+// checksum returns the sha256 of name listed in sha256sum format
+func checksum(sums []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], true
+		}
+	}
+	return "", false
+}
+
+// verifySignature checks the ed25519 signature of the checksums with the base64 public key
+func verifySignature(key string, sums, sig []byte) error {
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return configError("invalid public key")
+	}
+
+	sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil || !ed25519.Verify(pub, sums, sig) {
+		return fmt.Errorf("invalid signature of %s", checksumsAsset)
+	}
+	return nil
+}
+
+// replaceBinary replaces exe by the new binary, keeping the old one until the new
+// binary reports its version and restoring it otherwise
+func replaceBinary(exe string, b []byte) error {
+	dir := filepath.Dir(exe)
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(exe)+".new*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	old := exe + ".old"
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+
+	out, err := exec.Command(exe, "version").Output()
+	if err != nil {
+		if rerr := os.Rename(old, exe); rerr != nil {
+			return fmt.Errorf("new binary failed: %v, rollback failed: %v, previous binary is %s", err, rerr, old)
+		}
+		return fmt.Errorf("new binary failed: %v, rolled back", err)
+	}
+
+	log.Printf("installed %s", strings.TrimSpace(string(out)))
+	return os.Remove(old)
+}
+
+// versionCommand prints the version
+func versionCommand(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	fmt.Printf("gravo %s %s/%s\n", version, runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+// updateCommand replaces the running binary by the latest release after verifying
+// the signature of the checksums and its checksum
+func updateCommand(fs *flag.FlagSet, args []string) error {
+	repo := fs.String("repo", "andig/gravo", "GitHub repository publishing the releases")
+	github := fs.String("github", "https://api.github.com", "GitHub api url")
+	key := fs.String("key", releaseKey, "base64 ed25519 public key verifying the signature of "+checksumsAsset+" (default the project's key)")
+	unsigned := fs.Bool("insecure-skip-signature", false, "install releases verified by checksum only")
+	check := fs.Bool("check", false, "only report if an update is available")
+	force := fs.Bool("force", false, "install even if the version is current")
+	timeout := fs.Duration("timeout", 5*time.Minute, "download timeout")
+	fs.Parse(args)
+
+	client := &http.Client{Timeout: *timeout}
+
+	r, err := latestRelease(client, *github, *repo)
+	if err != nil {
+		return err
+	}
+
+	if r.Tag == version && !*force {
+		fmt.Printf("gravo %s is up to date\n", version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("gravo %s is available, running %s\n", r.Tag, version)
+		return nil
+	}
+
+	if *key == "" && !*unsigned {
+		return configError("no public key to verify releases, set -key or -insecure-skip-signature")
+	}
+
+	name := binaryAsset()
+	binURL, ok := r.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no binary %s", r.Tag, name)
+	}
+	sumsURL, ok := r.asset(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s", r.Tag, checksumsAsset)
+	}
+
+	sums, err := download(client, sumsURL, maxReleaseInfo)
+	if err != nil {
+		return err
+	}
+
+	if !*unsigned {
+		sigURL, ok := r.asset(checksumsAsset + ".sig")
+		if !ok {
+			return fmt.Errorf("release %s is not signed", r.Tag)
+		}
+		sig, err := download(client, sigURL, maxSignature)
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(*key, sums, sig); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("signature not verified, verifying checksum only")
+	}
+
+	want, ok := checksum(sums, name)
+	if !ok {
+		return fmt.Errorf("%s lists no checksum of %s", checksumsAsset, name)
+	}
+
+	b, err := download(client, binURL, maxReleaseBinary)
+	if err != nil {
+		return err
+	}
+
+	if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != strings.ToLower(want) {
+		return fmt.Errorf("checksum mismatch of %s", name)
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := replaceBinary(exe, b); err != nil {
+		return err
+	}
+
+	fmt.Printf("updated %s from %s to %s, restart gravo to use it\n", exe, version, r.Tag)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// VirtualConfig is a channel computed from an expression over other channels, e.g.
+// consumption - pv. Variables are bound to channel uuids or aliases by channels,
+// unbound variables are resolved as alias or channel name.
+type VirtualConfig struct {
+	Expression string            `yaml:"expression"`
+	Channels   map[string]string `yaml:"channels"` // variable to uuid or alias
+	Unit       string            `yaml:"unit"`
+
+	expr expr
+	vars []string
+}
+
+// channel returns the channel bound to the variable
+func (v VirtualConfig) channel(name string) string {
+	if ch, ok := v.Channels[name]; ok {
+		return ch
+	}
+	return name
+}
+
+// validateVirtual parses the expressions and rejects virtual channels depending on themselves
+func (conf Config) validateVirtual() error {
+	for name, v := range conf.Virtual {
+		if name == "*" || strings.HasPrefix(name, savedPrefix) {
+			return fmt.Errorf("virtual %s: invalid name", name)
+		}
+
+		var err error
+		if v.expr, v.vars, err = parseExpr(v.Expression); err != nil {
+			return fmt.Errorf("virtual %s: %v", name, err)
+		}
+		conf.Virtual[name] = v
+	}
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		for _, p := range path {
+			if p == name {
+				return fmt.Errorf("virtual %s: circular reference: %s", path[0], strings.Join(append(path, name), " -> "))
+			}
+		}
+
+		v := conf.Virtual[name]
+		for _, variable := range v.vars {
+			if ch := v.channel(variable); conf.Virtual[ch].expr != nil {
+				if err := visit(ch, append(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, name := range conf.virtualNames() {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// virtualNames returns the sorted names of the virtual channels
+func (conf Config) virtualNames() []string {
+	res := make([]string, 0, len(conf.Virtual))
+	for name := range conf.Virtual {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// virtualTuples fetches the channels of the virtual channel's variables with the
+// target's settings and evaluates the expression where all channels have data
+func (server *Server) virtualTuples(ctx context.Context, v VirtualConfig, data TargetData, qr *QueryRequest) ([]Tuple, error) {
+	uuids := make([]string, 0, len(v.vars))
+	index := make(map[string]int)
+	for _, variable := range v.vars {
+		if _, ok := index[variable]; ok {
+			continue
+		}
+
+		uuid := v.channel(variable)
+		if resolved, ok := server.resolveAlias(ctx, uuid); ok {
+			uuid = resolved
+		}
+		index[variable] = len(uuids)
+		uuids = append(uuids, uuid)
+	}
+
+	series := make([][]Tuple, len(uuids))
+	errs := make([]error, len(uuids))
+	wg := &sync.WaitGroup{}
+
+	for idx, uuid := range uuids {
+		wg.Add(1)
+
+		go func(idx int, uuid string) {
+			series[idx], errs[idx] = server.getTuples(ctx, uuid, data, qr)
+			wg.Done()
+		}(idx, uuid)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ts, values := alignSeries(series)
+
+	tuples := make([]Tuple, 0, len(ts))
+	vars := make(map[string]float64, len(index))
+	for i := range ts {
+		for variable, idx := range index {
+			vars[variable] = values[idx][i]
+		}
+
+		// NaN inputs and division by zero yield no value
+		if res := v.expr.eval(vars); !math.IsNaN(res) && !math.IsInf(res, 0) {
+			tuples = append(tuples, Tuple{Timestamp: ts[i], Value: float32(res)})
+		}
+	}
+
+	return tuples, nil
+}
@@ -1,16 +1,16 @@
 package main
 
-import "encoding/json"
-
 type EntityResponse struct {
 	Version  string   `json:"version"`
 	Entities []Entity `json:"entities"`
+	Entity   Entity   `json:"entity"`
 }
 
 type Entity struct {
 	UUID     string   `json:"uuid"`
 	Type     string   `json:"type"`
 	Title    string   `json:"title"`
+	Unit     string   `json:"unit,omitempty"`
 	Children []Entity `json:"children"`
 }
 
@@ -21,7 +21,8 @@ type DataResponse struct {
 }
 
 type DataStruct struct {
-	Tuples []Tuple `json:"tuples"`
+	Consumption float64 `json:"consumption"`
+	Tuples      []Tuple `json:"tuples"`
 }
 
 type Tuple struct {
@@ -38,21 +39,3 @@ type PrognosisStruct struct {
 	Consumption float32 `json:"consumption"`
 	Fator       float32 `json:"factor"`
 }
-
-// UnmarshalJSON converts volkszaehler tuple into Tuple struct
-func (t *Tuple) UnmarshalJSON(b []byte) error {
-	var a []*json.RawMessage
-	if err := json.Unmarshal(b, &a); err != nil {
-		return err
-	}
-
-	if err := json.Unmarshal(*a[0], &t.Timestamp); err != nil {
-		return err
-	}
-
-	if err := json.Unmarshal(*a[1], &t.Value); err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWatchdogInterval = 5 * time.Minute
+	defaultWatchdogStale    = 5
+	defaultWatchdogWindow   = 24 * time.Hour
+	maxWatchdogEvents       = 1000
+)
+
+// WatchdogEvent is a detected dead sensor or stuck value. End is zero while the problem persists.
+type WatchdogEvent struct {
+	UUID    string  `json:"uuid"`
+	Problem string  `json:"problem"`
+	Start   int64   `json:"start"`
+	End     int64   `json:"end,omitempty"`
+	Value   float32 `json:"value"`
+}
+
+// watchdog monitors channels for staleness and stuck values
+type watchdog struct {
+	api      *Api
+	interval time.Duration
+	webhook  string
+	channels []watchdogChannel
+
+	mu     sync.Mutex
+	events []*WatchdogEvent
+	active map[string]*WatchdogEvent
+}
+
+type watchdogChannel struct {
+	uuid     string
+	stale    float64
+	stuck    time.Duration
+	window   time.Duration
+	expected time.Duration
+}
+
+// newWatchdog validates the config. Webhook is used unless configured separately,
+// staleness refers to the channels' configured sampling intervals.
+func newWatchdog(api *Api, conf WatchdogConfig, intervals map[string]time.Duration, webhook string) (*watchdog, error) {
+	wd := &watchdog{
+		api:      api,
+		interval: defaultWatchdogInterval,
+		webhook:  webhook,
+		active:   make(map[string]*WatchdogEvent),
+	}
+
+	if conf.Interval != "" {
+		d, err := time.ParseDuration(conf.Interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("watchdog: invalid interval: %s", conf.Interval)
+		}
+		wd.interval = d
+	}
+
+	if conf.Webhook != "" {
+		wd.webhook = conf.Webhook
+	}
+
+	for _, c := range conf.Channels {
+		wc := watchdogChannel{uuid: c.UUID, stale: c.Stale, window: defaultWatchdogWindow, expected: intervals[c.UUID]}
+		if wc.stale <= 0 {
+			wc.stale = defaultWatchdogStale
+		}
+
+		if c.Stuck != "" {
+			d, err := time.ParseDuration(c.Stuck)
+			if err != nil {
+				return nil, fmt.Errorf("watchdog %s: invalid stuck: %s", c.UUID, c.Stuck)
+			}
+			wc.stuck = d
+		}
+
+		if c.Window != "" {
+			d, err := time.ParseDuration(c.Window)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("watchdog %s: invalid window: %s", c.UUID, c.Window)
+			}
+			wc.window = d
+		}
+
+		wd.channels = append(wd.channels, wc)
+	}
+
+	return wd, nil
+}
+
+// checkStale returns the last tuple if no new tuple arrived for more than factor
+// times the expected or median interval
+func checkStale(tuples []Tuple, now int64, factor float64, expected time.Duration) (Tuple, bool) {
+	if len(tuples) == 0 {
+		return Tuple{}, true
+	}
+
+	last := tuples[len(tuples)-1]
+	interval := sampleInterval(tuples, expected)
+
+	return last, interval > 0 && float64(now-last.Timestamp) > factor*float64(interval)
+}
+
+// checkStuck returns the first tuple of the trailing run of identical values if it lasts at least d
+func checkStuck(tuples []Tuple, d time.Duration) (Tuple, bool) {
+	if len(tuples) < 2 || d <= 0 {
+		return Tuple{}, false
+	}
+
+	last := tuples[len(tuples)-1]
+	i := len(tuples) - 1
+	for i > 0 && tuples[i-1].Value == last.Value {
+		i--
+	}
+
+	return tuples[i], last.Timestamp-tuples[i].Timestamp >= int64(d/time.Millisecond)
+}
+
+// check evaluates a single channel and records problem transitions
+func (wd *watchdog) check(ctx context.Context, c watchdogChannel, now time.Time) {
+	tuples, err := wd.api.getData(ctx, c.uuid, now.Add(-c.window), now, "", "", 0)
+	if err != nil {
+		log.Printf("watchdog %s: %v", c.uuid, err)
+		return
+	}
+
+	problems := make(map[string]Tuple)
+	if t, ok := checkStale(tuples, unixMS(now), c.stale, c.expected); ok {
+		problems["stale"] = t
+	}
+	if t, ok := checkStuck(tuples, c.stuck); ok {
+		problems["stuck"] = t
+	}
+
+	for _, problem := range []string{"stale", "stuck"} {
+		t, failing := problems[problem]
+		if event, changed := wd.transition(c, problem, t, failing, now); changed {
+			state := "detected"
+			if event.End > 0 {
+				state = "resolved"
+			}
+			log.Printf("watchdog %s: %s %s", c.uuid, problem, state)
+			wd.notify(event)
+		}
+	}
+}
+
+// transition records start or end of a problem and returns the changed event
+func (wd *watchdog) transition(c watchdogChannel, problem string, t Tuple, failing bool, now time.Time) (WatchdogEvent, bool) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	key := c.uuid + ":" + problem
+	event, active := wd.active[key]
+
+	switch {
+	case failing && !active:
+		event = &WatchdogEvent{UUID: c.uuid, Problem: problem, Start: t.Timestamp, Value: t.Value}
+		if event.Start == 0 {
+			event.Start = unixMS(now.Add(-c.window))
+		}
+		wd.active[key] = event
+		wd.events = append(wd.events, event)
+		if len(wd.events) > maxWatchdogEvents {
+			wd.events = wd.events[1:]
+		}
+	case !failing && active:
+		event.End = unixMS(now)
+		delete(wd.active, key)
+	default:
+		return WatchdogEvent{}, false
+	}
+
+	return *event, true
+}
+
+// notify posts the event to the webhook
+func (wd *watchdog) notify(event WatchdogEvent) {
+	if wd.webhook == "" {
+		return
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("json encode failed: %v", err)
+		return
+	}
+
+	resp, err := webhookClient.Post(wd.webhook, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("webhook failed: %v", err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// run checks all channels every interval
+func (wd *watchdog) run() {
+	for {
+		for _, c := range wd.channels {
+			ctx, cancel := context.WithTimeout(context.Background(), wd.interval)
+			wd.check(ctx, c, time.Now())
+			cancel()
+		}
+
+		time.Sleep(wd.interval)
+	}
+}
+
+// eventsBetween returns the channel's events overlapping from..to
+func (wd *watchdog) eventsBetween(uuid string, from, to int64) []WatchdogEvent {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	res := []WatchdogEvent{}
+	for _, e := range wd.events {
+		if (uuid == "" || e.UUID == uuid) && e.Start <= to && (e.End == 0 || e.End >= from) {
+			res = append(res, *e)
+		}
+	}
+
+	return res
+}
+
+// watchdogAnnotations returns dead sensor and stuck value events as region annotations
+func (server *Server) watchdogAnnotations(ctx context.Context, target Target, ar *AnnotationsRequest) ([]AnnotationResponse, error) {
+	res := []AnnotationResponse{}
+	if server.watchdog == nil {
+		return res, nil
+	}
+
+	now := unixMS(time.Now())
+	for _, e := range server.watchdog.eventsBetween(target.Target, unixMS(ar.Range.From), unixMS(ar.Range.To)) {
+		end := e.End
+		if end == 0 {
+			end = now
+		}
+
+		title := "Sensor not reporting"
+		if e.Problem == "stuck" {
+			title = "Stuck value"
+		}
+
+		res = append(res, AnnotationResponse{
+			Annotation: ar.Annotation,
+			Time:       e.Start,
+			TimeEnd:    end,
+			IsRegion:   true,
+			Title:      title,
+			Text:       fmt.Sprintf("%s since %s, last value %g", e.UUID, formatMS(e.Start), e.Value),
+		})
+	}
+
+	return res, nil
+}
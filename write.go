@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteRequest is the body of POST /write
+type WriteRequest struct {
+	UUID   string  `json:"uuid"`
+	Tuples []Tuple `json:"tuples"`
+}
+
+// WriteResponse is returned after the tuples were written
+type WriteResponse struct {
+	UUID     string           `json:"uuid"`
+	Written  int              `json:"written"`
+	Rejected []WriteRejection `json:"rejected,omitempty"`
+}
+
+// writeHandler forwards tuples to the middleware. Tuples without timestamp are written at the current time.
+func (server *Server) writeHandler(w http.ResponseWriter, r *http.Request) {
+	if server.writeToken != "" && !authorized(r, server.writeToken) {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusUnauthorized,
+			Code:    "unauthorized",
+			Message: "invalid token",
+		})
+		return
+	}
+
+	wr := WriteRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&wr); err != nil {
+		log.Printf("json decode failed: %v", err)
+		writeQueryError(w, invalidRequest(err))
+		return
+	}
+
+	if wr.UUID == "" || len(wr.Tuples) == 0 {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: "missing uuid or tuples",
+			Target:  wr.UUID,
+		})
+		return
+	}
+
+	// only known channels are written, the write checks keep state per channel
+	if !server.knownChannel(r.Context(), wr.UUID) {
+		writeQueryError(w, &QueryError{
+			Status:  http.StatusBadRequest,
+			Code:    "invalid_request",
+			Message: "unknown channel",
+			Target:  wr.UUID,
+		})
+		return
+	}
+
+	// tuples without timestamp are a millisecond apart in request order, the last
+	// at the current time, so they are not rejected as duplicates
+	now := unixMS(time.Now())
+	var untimed int64
+	for _, t := range wr.Tuples {
+		if t.Timestamp == 0 {
+			untimed++
+		}
+	}
+
+	for i := range wr.Tuples {
+		if v := float64(wr.Tuples[i].Value); math.IsNaN(v) || math.IsInf(v, 0) {
+			writeQueryError(w, &QueryError{
+				Status:  http.StatusBadRequest,
+				Code:    "invalid_request",
+				Message: fmt.Sprintf("tuple %d: invalid value", i+1),
+				Target:  wr.UUID,
+			})
+			return
+		}
+		if wr.Tuples[i].Timestamp == 0 {
+			untimed--
+			wr.Tuples[i].Timestamp = now - untimed
+		}
+	}
+
+	// rejected tuples are reported but don't fail the request to avoid endless retries
+	tuples, rejected := server.writes.check(wr.UUID, wr.Tuples)
+	logRejected(r.Context(), wr.UUID, rejected)
+
+	if len(tuples) > 0 {
+		if err := server.api.postData(r.Context(), wr.UUID, tuples); err != nil {
+			logf(r.Context(), "write %s failed: %v", wr.UUID, err)
+			writeQueryError(w, queryError(Target{Target: wr.UUID}, err))
+			return
+		}
+		server.writes.written(wr.UUID, tuples)
+	}
+
+	logf(r.Context(), "write %s: %d tuples, %d rejected", wr.UUID, len(tuples), len(rejected))
+
+	if err := json.NewEncoder(w).Encode(WriteResponse{UUID: wr.UUID, Written: len(tuples), Rejected: rejected}); err != nil {
+		log.Printf("json encode failed: %v", err)
+	}
+}
+
+// knownChannel checks that the uuid is a channel of the entity list or configured
+func (server *Server) knownChannel(ctx context.Context, uuid string) bool {
+	if _, ok := server.conf.Channels[uuid]; ok {
+		return true
+	}
+	if _, ok := server.cachedEntity(uuid); ok {
+		return true
+	}
+
+	// the entity list is fetched on first use
+	_, entities := server.cachedEntities(ctx)
+	for _, entity := range entities {
+		if entity.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePushLine parses a `<value>` or `<time> <value>` line, times without zone in loc
+func parsePushLine(line string, loc *time.Location) (Tuple, error) {
+	fields := strings.Fields(line)
+
+	ts := time.Now()
+	switch len(fields) {
+	case 1:
+	case 2:
+		var err error
+		if ts, err = parseTime(fields[0], loc); err != nil {
+			return Tuple{}, err
+		}
+		fields = fields[1:]
+	default:
+		return Tuple{}, fmt.Errorf("invalid line: %s", line)
+	}
+
+	v, err := strconv.ParseFloat(fields[0], 32)
+	if err != nil {
+		return Tuple{}, fmt.Errorf("invalid value: %s", fields[0])
+	}
+
+	return Tuple{Timestamp: unixMS(ts), Value: float32(v)}, nil
+}
+
+// readPushLines reads tuples from r, one `<value>` or `<time> <value>` per line
+func readPushLines(r io.Reader, loc *time.Location) ([]Tuple, error) {
+	var tuples []Tuple
+
+	scanner := bufio.NewScanner(r)
+	for i := 1; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		tuple, err := parsePushLine(line, loc)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i, err)
+		}
+		tuples = append(tuples, tuple)
+	}
+
+	return tuples, scanner.Err()
+}
+
+// pushCommand writes a single value or values read from stdin to a channel
+func pushCommand(fs *flag.FlagSet, args []string) error {
+	apiOptions := registerAPIFlags(fs)
+	uuid := fs.String("uuid", "", "channel uuid")
+	value := fs.String("value", "", "value to write (default read `<value>` or `<time> <value>` lines from stdin)")
+	at := fs.String("time", "now", "time of -value (epoch ms, ISO 8601, now or relative duration)")
+	fs.Parse(args)
+
+	if *uuid == "" {
+		return configError("missing uuid")
+	}
+
+	var tuples []Tuple
+	if *value != "" {
+		tuple, err := parsePushLine(*at+" "+*value, time.Local)
+		if err != nil {
+			return configError("%v", err)
+		}
+		tuples = append(tuples, tuple)
+	} else {
+		var err error
+		if tuples, err = readPushLines(os.Stdin, time.Local); err != nil {
+			return err
+		}
+	}
+
+	if len(tuples) == 0 {
+		return configError("no values")
+	}
+
+	api, err := apiOptions.api()
+	if err != nil {
+		return err
+	}
+
+	if err := api.postData(context.Background(), *uuid, tuples); err != nil {
+		return err
+	}
+
+	log.Printf("pushed %d tuples to %s", len(tuples), *uuid)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	defaultWriteBackInterval = 15 * time.Minute
+	defaultWriteBackRange    = 24 * time.Hour
+)
+
+// writeBack periodically persists the output of a computed query to a middleware channel
+type writeBack struct {
+	server   *Server
+	target   Target
+	channel  string
+	interval time.Duration
+	window   time.Duration
+	last     int64
+}
+
+// newWriteBacks validates the write-back config
+func newWriteBacks(server *Server, conf []WriteBackConfig) ([]*writeBack, error) {
+	res := make([]*writeBack, 0, len(conf))
+
+	for _, c := range conf {
+		if c.Target == "" || c.Channel == "" {
+			return nil, fmt.Errorf("writeback: missing target or channel")
+		}
+		if c.Target == c.Channel {
+			return nil, fmt.Errorf("writeback %s: channel must differ from target", c.Channel)
+		}
+
+		wb := &writeBack{
+			server:   server,
+			target:   Target{Target: c.Target, Data: TargetData(c.Data)},
+			channel:  c.Channel,
+			interval: defaultWriteBackInterval,
+			window:   defaultWriteBackRange,
+		}
+
+		if c.Interval != "" {
+			d, err := time.ParseDuration(c.Interval)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("writeback %s: invalid interval: %s", c.Channel, c.Interval)
+			}
+			wb.interval = d
+		}
+
+		if c.Range != "" {
+			d, err := time.ParseDuration(c.Range)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("writeback %s: invalid range: %s", c.Channel, c.Range)
+			}
+			wb.window = d
+		}
+
+		res = append(res, wb)
+	}
+
+	return res, nil
+}
+
+// resume continues after the newest tuple already written to the channel
+func (wb *writeBack) resume(ctx context.Context, now time.Time) error {
+	tuples, err := wb.server.api.getData(ctx, wb.channel, now.Add(-wb.window), now, "", "", 0)
+	if err != nil {
+		return err
+	}
+
+	if len(tuples) > 0 {
+		wb.last = tuples[len(tuples)-1].Timestamp
+	}
+
+	return nil
+}
+
+// once computes the query over the range and writes tuples newer than the last written
+func (wb *writeBack) once(ctx context.Context, now time.Time) (int, error) {
+	qr := &QueryRequest{Range: Range{From: now.Add(-wb.window), To: now}}
+
+	qres, err := wb.server.querySeries(ctx, wb.target.Data["context"], wb.target, qr)
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := qres.Datapoints
+
+	// aggregated periods are only final after they ended
+	if wb.target.Data["group"] != "" && len(datapoints) > 0 {
+		datapoints = datapoints[:len(datapoints)-1]
+	}
+
+	tuples := []Tuple{}
+	for _, dp := range datapoints {
+		if dp.Timestamp > wb.last && !math.IsNaN(float64(dp.Value)) {
+			tuples = append(tuples, Tuple{Timestamp: dp.Timestamp, Value: dp.Value})
+		}
+	}
+
+	if len(tuples) == 0 {
+		return 0, nil
+	}
+
+	if err := wb.server.api.postData(ctx, wb.channel, tuples); err != nil {
+		return 0, err
+	}
+
+	wb.last = tuples[len(tuples)-1].Timestamp
+	return len(tuples), nil
+}
+
+// run writes back on every interval
+func (wb *writeBack) run() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), wb.interval)
+		now := time.Now()
+
+		err := wb.resume(ctx, now)
+		if err == nil {
+			var n int
+			if n, err = wb.once(ctx, now); err == nil && n > 0 {
+				log.Printf("writeback %s: %d tuples", wb.channel, n)
+			}
+		}
+		cancel()
+
+		if err != nil {
+			log.Printf("writeback %s failed: %v", wb.channel, err)
+		}
+
+		time.Sleep(wb.interval)
+	}
+}
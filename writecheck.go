@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// WriteCheckConfig rejects implausible tuples written to the channel
+type WriteCheckConfig struct {
+	Min     *float64 `yaml:"min"`
+	Max     *float64 `yaml:"max"`
+	Counter bool     `yaml:"counter"` // meter reading that must not decrease
+}
+
+// WriteRejection is a tuple not forwarded to the middleware
+type WriteRejection struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float32 `json:"value"`
+	Reason    string  `json:"reason"` // duplicate, outdated, range or counter
+}
+
+// writeChecker remembers the last tuple written per channel to detect duplicates
+// and decreasing counters across requests
+type writeChecker struct {
+	mu     sync.Mutex
+	checks map[string]WriteCheckConfig
+	last   map[string]Tuple
+}
+
+func newWriteChecker(conf Config) *writeChecker {
+	checks := make(map[string]WriteCheckConfig)
+	for uuid, ch := range conf.Channels {
+		if ch.Write != (WriteCheckConfig{}) {
+			checks[uuid] = ch.Write
+		}
+	}
+	return &writeChecker{checks: checks, last: make(map[string]Tuple)}
+}
+
+// check sorts the tuples by time and returns the tuples to write and the rejected
+// ones. Tuples with the timestamp of an earlier tuple are duplicates, older than the
+// last written tuple outdated, values outside min and max or below the previous
+// counter reading are implausible.
+func (c *writeChecker) check(uuid string, tuples []Tuple) ([]Tuple, []WriteRejection) {
+	sorted := make([]Tuple, len(tuples))
+	copy(sorted, tuples)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conf := c.checks[uuid]
+	last, seen := c.last[uuid]
+
+	var accepted []Tuple
+	var rejected []WriteRejection
+	for _, t := range sorted {
+		var reason string
+		switch {
+		case seen && t.Timestamp == last.Timestamp:
+			reason = "duplicate"
+		case seen && t.Timestamp < last.Timestamp:
+			reason = "outdated"
+		case conf.Min != nil && float64(t.Value) < *conf.Min, conf.Max != nil && float64(t.Value) > *conf.Max:
+			reason = "range"
+		case conf.Counter && seen && t.Value < last.Value:
+			reason = "counter"
+		}
+
+		if reason != "" {
+			rejected = append(rejected, WriteRejection{Timestamp: t.Timestamp, Value: t.Value, Reason: reason})
+			continue
+		}
+
+		accepted = append(accepted, t)
+		last, seen = t, true
+	}
+
+	return accepted, rejected
+}
+
+// written records the newest tuple forwarded to the middleware
+func (c *writeChecker) written(uuid string, tuples []Tuple) {
+	if len(tuples) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t := tuples[len(tuples)-1]; t.Timestamp >= c.last[uuid].Timestamp {
+		c.last[uuid] = t
+	}
+}
+
+// logRejected logs and counts the rejected tuples
+func logRejected(ctx context.Context, uuid string, rejected []WriteRejection) {
+	for _, r := range rejected {
+		logf(ctx, "write %s: rejected %s tuple at %s: %s", uuid, r.Reason, formatMS(r.Timestamp),
+			strconv.FormatFloat(float64(r.Value), 'g', -1, 32))
+		gravoMetrics.observeRejected(uuid, r.Reason)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWriteCheck(t *testing.T) {
+	min, max := 0.0, 100.0
+	conf := Config{Channels: map[string]ChannelConfig{
+		"counter": {Write: WriteCheckConfig{Counter: true}},
+		"range":   {Write: WriteCheckConfig{Min: &min, Max: &max}},
+	}}
+
+	tests := []struct {
+		name     string
+		uuid     string
+		last     []Tuple // written before
+		tuples   []Tuple
+		accepted []Tuple
+		reasons  []string
+	}{
+		{
+			name:     "sorted",
+			uuid:     "plain",
+			tuples:   []Tuple{{2, 1}, {1, 2}},
+			accepted: []Tuple{{1, 2}, {2, 1}},
+		},
+		{
+			name:     "duplicate in request",
+			uuid:     "plain",
+			tuples:   []Tuple{{1, 1}, {1, 2}, {2, 3}},
+			accepted: []Tuple{{1, 1}, {2, 3}},
+			reasons:  []string{"duplicate"},
+		},
+		{
+			name:     "duplicate of last written",
+			uuid:     "plain",
+			last:     []Tuple{{2, 1}},
+			tuples:   []Tuple{{2, 1}, {3, 1}},
+			accepted: []Tuple{{3, 1}},
+			reasons:  []string{"duplicate"},
+		},
+		{
+			name:     "older than last written",
+			uuid:     "plain",
+			last:     []Tuple{{5, 1}},
+			tuples:   []Tuple{{3, 1}, {6, 1}},
+			accepted: []Tuple{{6, 1}},
+			reasons:  []string{"outdated"},
+		},
+		{
+			name:     "range",
+			uuid:     "range",
+			tuples:   []Tuple{{1, -1}, {2, 50}, {3, 101}},
+			accepted: []Tuple{{2, 50}},
+			reasons:  []string{"range", "range"},
+		},
+		{
+			name:     "counter",
+			uuid:     "counter",
+			last:     []Tuple{{1, 10}},
+			tuples:   []Tuple{{2, 9}, {3, 10}, {4, 11}},
+			accepted: []Tuple{{3, 10}, {4, 11}},
+			reasons:  []string{"counter"},
+		},
+		{
+			name:     "counter in request",
+			uuid:     "counter",
+			tuples:   []Tuple{{1, 10}, {2, 12}, {3, 11}},
+			accepted: []Tuple{{1, 10}, {2, 12}},
+			reasons:  []string{"counter"},
+		},
+	}
+
+	for _, tc := range tests {
+		c := newWriteChecker(conf)
+		c.written(tc.uuid, tc.last)
+
+		accepted, rejected := c.check(tc.uuid, tc.tuples)
+		if !reflect.DeepEqual(accepted, tc.accepted) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.accepted, accepted)
+		}
+
+		var reasons []string
+		for _, r := range rejected {
+			reasons = append(reasons, r.Reason)
+		}
+		if !reflect.DeepEqual(reasons, tc.reasons) {
+			t.Errorf("%s: expected rejections %v, got %v", tc.name, tc.reasons, reasons)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxEpoch is day zero of spreadsheet date serials
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// xlsxParts are the static parts of a workbook with a single sheet. Style 1
+// formats dates, style 2 the bold header.
+var xlsxParts = map[string]string{
+	"[Content_Types].xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/></Types>`,
+	"_rels/.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`,
+	"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Data" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+	"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`,
+	"xl/styles.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/></numFmts><fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts><fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills><borders count="1"><border/></borders><cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs><cellXfs count="3"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs></styleSheet>`,
+}
+
+// xlsxString returns an inline string cell
+func xlsxString(s string, style int) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return fmt.Sprintf(`<c t="inlineStr" s="%d"><is><t>%s</t></is></c>`, style, b.String())
+}
+
+// xlsxSerial converts t to a spreadsheet date serial in its location
+func xlsxSerial(t time.Time) float64 {
+	_, offset := t.Zone()
+	local := t.UTC().Add(time.Duration(offset) * time.Second)
+	return local.Sub(xlsxEpoch).Hours() / 24
+}
+
+// writeXLSX writes series as a workbook with rows of channel, time, value and unit.
+// Times are dates in the channel's location, values numbers with given decimals.
+func writeXLSX(w io.Writer, series []exportSeries, header []string, decimals int) error {
+	zw := zip.NewWriter(w)
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xlsxParts[name]); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+
+	io.WriteString(f, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n")
+	io.WriteString(f, `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><cols><col min="1" max="1" width="30" customWidth="1"/><col min="2" max="2" width="20" customWidth="1"/></cols><sheetData>`)
+
+	io.WriteString(f, "<row>")
+	for _, h := range header {
+		io.WriteString(f, xlsxString(h, 2))
+	}
+	io.WriteString(f, "</row>")
+
+	for _, s := range series {
+		title := s.Title
+		if title == "" {
+			title = s.UUID
+		}
+
+		loc := s.Location
+		if loc == nil {
+			loc = time.Local
+		}
+
+		for _, tuple := range s.Tuples {
+			if math.IsNaN(float64(tuple.Value)) || math.IsInf(float64(tuple.Value), 0) {
+				continue
+			}
+
+			ts := time.Unix(0, tuple.Timestamp*int64(time.Millisecond)).In(loc)
+			value := strconv.FormatFloat(float64(tuple.Value), 'f', decimals, 32)
+
+			_, err := fmt.Fprintf(f, `<row>%s<c s="1"><v>%s</v></c><c><v>%s</v></c>%s</row>`,
+				xlsxString(title, 0), strconv.FormatFloat(xlsxSerial(ts), 'f', 8, 64), value, xlsxString(s.Unit, 0))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := io.WriteString(f, "</sheetData></worksheet>"); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}